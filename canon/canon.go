@@ -2,16 +2,15 @@ package canon
 
 import (
 	"bytes"
-	"encoding/binary"
 	"errors"
-	"fmt"
-	"image"
+	"io"
 	"log"
 	"os"
 	"strings"
+	"sync"
 
-	bitstream "github.com/dgryski/go-bitstream"
 	"github.com/enricod/rawmgr/common"
+	"github.com/enricod/rawmgr/ljpeg"
 )
 
 // Tags name
@@ -34,14 +33,24 @@ var Tags = []map[uint16]string{
 	{
 		0x0001: "canonCameraSettings",
 		0x0002: "canonFocalLength",
+		0x0004: "canonShotInfo",
+		0x0006: "canonImageType",
+		0x0007: "canonFirmwareVersion",
+		0x000c: "serialNumber",
+		0x0010: "canonModelID",
+		0x0093: "canonFileInfo",
+		0x0095: "lensModel",
+		0x0096: "internalSerialNumber",
+		0x00e0: "sensorInfo",
+		0x4001: "colorData",
 	},
 }
 
-func check(e error) {
-	if e != nil {
-		panic(e)
-	}
-}
+// format name used in the errors
+const format = "CR2"
+
+// maxFrameSamples limit of the samples of the raw frame, 1 GB of raster
+const maxFrameSamples = 1 << 29
 
 // Header for canon file
 type Header struct {
@@ -74,6 +83,8 @@ type IFD struct {
 	Level         int
 	SubIFDs       IFDs
 	RawSlice      rawSlice
+	// Entry as read by the TIFF reader, with the decoded value
+	Entry *common.IfdEntry
 }
 
 // IFDs Image File Directory
@@ -82,131 +93,115 @@ type IFDs struct {
 	Offset        int64
 	Ifds          []IFD
 	NextIfdOffset int64
-}
-
-// DHTHeader -
-type DHTHeader struct {
-	Marker      uint16
-	Length      uint16
-	TableClass0 uint8
-	TableIndex0 uint8
-	TableClass1 uint8
-	TableIndex1 uint8
-}
-
-// LosslessJPG data for lossless image
-type LosslessJPG struct {
-	DHTHeader  DHTHeader
-	SOF3Header SOF3Header
-	SOSHeader  SOSHeader
-	//HuffmanCodes    [][]common.HuffMapping // da eliminare
-	HuffmanCodesMap []map[common.HuffMappingKey]common.HuffMapping
+	// Dir as read by the TIFF reader
+	Dir *common.Ifd
 }
 
 func readHeader(data []byte) (Header, error) {
 	var start int64
 	var result = Header{}
 
+	if err := common.CheckRange(format, data, 0, 16, "header"); err != nil {
+		return result, err
+	}
+
 	// "II" or 0x4949 (18761) means Intel byte order (little endian)
 	// "MM" or 0x4d4d means Motorola byte order (big endian)
 	result.ByteOrder, start = common.ReadUint16(data, start)
-	result.TiffMagicWord = uint16(data[start])
+	if result.ByteOrder != 0x4949 && result.ByteOrder != 0x4d4d {
+		return result, common.NewFormatError(format, 0, "byte order %x not valid", result.ByteOrder)
+	}
+	result.TiffMagicWord, start = common.ReadUint16Order(data, result.ByteOrder, start)
 	if result.TiffMagicWord != 0x002A {
-		return result, fmt.Errorf("TiffMagicWord not valid %d", result.TiffMagicWord)
+		return result, common.NewFormatError(format, 2, "TiffMagicWord not valid %d", result.TiffMagicWord)
 	}
 
-	var ifdOffset, rawIfdOffset uint16
-	ifdOffset, start = common.ReadUint16Order(data, result.ByteOrder, 4)
+	var ifdOffset, rawIfdOffset uint32
+	ifdOffset, start = common.ReadUint32Order(data, result.ByteOrder, start)
 
 	result.IfdOffset = int64(ifdOffset)
 	result.CR2MagicWord = string(data[8:10])
 	result.CR2MajorVersion, start = common.ReadUint8(data, 10)
 	result.CR2MinorVersion, start = common.ReadUint8(data, start)
-	rawIfdOffset, start = common.ReadUint16Order(data, result.ByteOrder, start)
+	rawIfdOffset, start = common.ReadUint32Order(data, result.ByteOrder, start)
 	result.RawIfdOffset = int64(rawIfdOffset)
 
 	return result, nil
 
 }
 
-// data is 12 bytes long
-func readIfd(data []byte) IFD {
-	var result = IFD{}
-	result.Tag = binary.LittleEndian.Uint16(data[0:2])
-	result.Typ = binary.LittleEndian.Uint16(data[2:4])
-	result.Count = binary.LittleEndian.Uint32(data[4:8])
-	result.Value = binary.LittleEndian.Uint32(data[8:12])
+// readIfd converts the entry read by the TIFF reader
+func readIfd(entry *common.IfdEntry) IFD {
+	var result = IFD{Tag: entry.Tag, Typ: entry.Type, Count: entry.Count, Value: entry.Field, Entry: entry}
+	switch entry.Type {
+	case common.TypeByte, common.TypeShort, common.TypeLong, common.TypeIFD:
+		if entry.Count == 1 {
+			result.Value = uint32(entry.Int(0))
+		}
+	case common.TypeASCII:
+		result.ValueAsString = entry.String()
+	}
 	return result
 }
 
-func loopIfds(data []byte, order uint16, offset int64, level int) IFDs {
-	ifdLength := int64(12)
-
+func loopIfds(dir *common.Ifd, level int) IFDs {
 	var result IFDs
 	var items []IFD
 
-	result.Offset = offset
+	result.Offset = dir.Offset
+	result.EntriesNr = uint16(len(dir.Entries))
 
-	entries, start := common.ReadUint16Order(data, order, offset)
-	result.EntriesNr = entries
-
-	for i := 0; i < int(entries); i++ {
-		ifdbytes := data[int(start):int(start+ifdLength)]
-		ifd := readIfd(ifdbytes)
+	for i := range dir.Entries {
+		ifd := readIfd(&dir.Entries[i])
 		ifd.Level = level
 
 		switch ifd.Tag {
-		case 0x8769:
-			// EXIF subdirectory
-			ifd.SubIFDs = loopIfds(data, order, int64(ifd.Value), level+1)
-
-		case 0x927c:
-			// maker notes
-			ifd.SubIFDs = loopIfds(data, order, int64(ifd.Value), level+1)
+		case 0x8769, 0x927c:
+			// EXIF subdirectory, maker notes
+			if len(ifd.Entry.Sub) > 0 {
+				ifd.SubIFDs = loopIfds(ifd.Entry.Sub[0], level+1)
+			}
 
 		case 0xC640:
 			// SLICES
-			var sliceCount, sliceSize, lastSliceSize uint16
-			var nextOffset int64
-			sliceCount, nextOffset = common.ReadUint16Order(data, order, int64(ifd.Value))
-			sliceSize, nextOffset = common.ReadUint16Order(data, order, nextOffset)
-			lastSliceSize, _ = common.ReadUint16Order(data, order, nextOffset)
-			var aRawSlice = rawSlice{Count: sliceCount, SliceSize: sliceSize, LastSliceSize: lastSliceSize}
-			ifd.RawSlice = aRawSlice
-			log.Printf("Slice %v \n", aRawSlice)
-
+			if v, ok := ifd.Entry.Value.([]uint16); ok && len(v) == 3 {
+				var aRawSlice = rawSlice{Count: v[0], SliceSize: v[1], LastSliceSize: v[2]}
+				ifd.RawSlice = aRawSlice
+				if *common.Verbose {
+					log.Printf("Slice %v", aRawSlice)
+				}
+			}
 		}
 
 		items = append(items, ifd)
-		start = start + ifdLength
 	}
 
-	var nextIfdOffset uint32
-	nextIfdOffset, _ = common.ReadUint32Order(data, order, start)
-	// log.Printf("nextIfdOffset=%d", nextIfdOffset)
 	result.Ifds = items
-	result.NextIfdOffset = int64(nextIfdOffset)
+	result.NextIfdOffset = dir.Next
+	result.Dir = dir
 	return result
 }
 
-func readIfds(data []byte, header *Header) []IFDs {
+func readIfds(r io.ReaderAt) ([]IFDs, error) {
+	reader, err := common.NewTiffReader(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	reader.SubIfdTags[common.TagMakerNote] = true
 
+	dirs, err := reader.ReadIfds()
 	var result []IFDs
-	var ifds IFDs
-	var nextIfdOffset = header.IfdOffset
-
-	for nextIfdOffset > 0 {
-		ifds = loopIfds(data, header.ByteOrder, nextIfdOffset, 0)
-		result = append(result, ifds)
-		nextIfdOffset = ifds.NextIfdOffset
-		//log.Printf("ifds:%v, nextOffset=%d", ifds, nextIfdOffset)
+	for _, dir := range dirs {
+		result = append(result, loopIfds(dir, 0))
 	}
-	return result
+	return result, err
 }
 
 func dumpIfd(ifd IFD) {
 	var desc string
-	if v, ok := Tags[ifd.Level][ifd.Tag]; ok {
+	if ifd.Level >= len(Tags) {
+		desc = "Tag "
+	} else if v, ok := Tags[ifd.Level][ifd.Tag]; ok {
 		desc = v
 	} else {
 		desc = "Tag "
@@ -261,15 +256,20 @@ var getStartEndIFD1 = calcStartEnd(func(aifd IFDs) (int64, int64) {
 	return start, end
 })
 
-func saveJpeg(data []byte, aifd IFDs, filename string, calc calcStartEnd) {
+func saveJpeg(data []byte, aifd IFDs, filename string, calc calcStartEnd) error {
 	start, end := calc(aifd)
+	if err := common.CheckRange(format, data, start, end-start, "JPEG preview"); err != nil {
+		return err
+	}
 	log.Printf("Saving JPEG %s", filename)
 	jpegData := data[start:end]
 	f, err := os.Create(filename)
-	_, err = f.Write(jpegData)
-	check(err)
+	if err != nil {
+		return err
+	}
 	defer f.Close()
-
+	_, err = f.Write(jpegData)
+	return err
 }
 
 /*
@@ -324,43 +324,6 @@ type huffItem struct {
 	Code uint16
 }
 
-// SOF3Component description SOF3
-type SOF3Component struct {
-	ComponentIdentifier      uint8
-	HorizontalSamplingFactor uint8
-	VerticalSamplingFactor   uint8
-	QuantizationTable        uint8
-}
-
-// SOF3Header - start of frame header
-type SOF3Header struct {
-	Marker                    uint16
-	Length                    uint16
-	SamplePrecision           uint8
-	NrLines                   uint16
-	NrSamplesPerLine          uint16
-	NrImageComponentsPerFrame uint8
-	Components                []SOF3Component
-}
-
-// SOSComponent start of stream component desc
-type SOSComponent struct {
-	Selector uint8
-	DCTable  uint8
-	ACTable  uint8
-}
-
-// SOSHeader - start of scan header
-type SOSHeader struct {
-	Marker                              uint16
-	Length                              uint16
-	NrComponents                        uint8
-	Components                          []SOSComponent
-	StartOfSpectral                     uint8
-	EndOfSpectral                       uint8
-	SuccessiveApprosimationBitPositions uint8
-}
-
 func hammingDistance(a, b []byte) (int, error) {
 	if len(a) != len(b) {
 		return 0, errors.New("a b are not the same length")
@@ -380,161 +343,6 @@ func hammingDistance(a, b []byte) (int, error) {
 	return diff, nil
 }
 
-// SOF3 start of frame
-func parseSOF3Header(data []byte, offset int64) (SOF3Header, int64, error) {
-	//log.Printf("SOF3 header offset %d", offset)
-
-	sof3Header := SOF3Header{}
-	marker, offset2 := common.ReadUint16(data, offset)
-
-	//log.Printf("SOF3 offset=%d, marker=%d", offset, marker)
-	if marker != 0xffc3 {
-		_, err := fmt.Printf("SOF3 header invalid, expected %d, found %d", 0xffc3, marker)
-		return sof3Header, offset2, err
-	}
-	sof3Header.Marker = marker
-	length, offset2 := common.ReadUint16(data, offset2)
-	sof3Header.Length = length
-
-	samplePrecision, offset2 := common.ReadUint8(data, offset2)
-	sof3Header.SamplePrecision = samplePrecision
-
-	log.Printf("SOF3 samplePrecision=%d", samplePrecision)
-
-	nrLines, offset2 := common.ReadUint16(data, offset2)
-	sof3Header.NrLines = nrLines
-
-	nrSamplePerLine, offset2 := common.ReadUint16(data, offset2)
-	sof3Header.NrSamplesPerLine = nrSamplePerLine
-
-	imageComponentsPerFrame, offset2 := common.ReadUint8(data, offset2)
-	sof3Header.NrImageComponentsPerFrame = imageComponentsPerFrame
-
-	log.Printf("nrSamplePerLine=%d, imageComponentsPerFrame=%d ", nrSamplePerLine, imageComponentsPerFrame)
-	// let's read each component
-	components := []SOF3Component{}
-	var offset3 = offset2
-	var identifier, quantizationTable uint8
-
-	for i := 0; i < int(imageComponentsPerFrame); i++ {
-		identifier, offset3 = common.ReadUint8(data, offset3)
-		samplingByte := data[offset3]
-		offset3++
-		quantizationTable, offset3 = common.ReadUint8(data, offset3)
-
-		comp := SOF3Component{ComponentIdentifier: identifier,
-			HorizontalSamplingFactor: uint8(samplingByte >> 4),
-			VerticalSamplingFactor:   uint8(samplingByte & 0x0f),
-			QuantizationTable:        quantizationTable}
-		components = append(components, comp)
-	}
-
-	sof3Header.Components = components
-
-	return sof3Header, offset3, nil
-}
-
-func parseSOSHeader(data []byte, offset int64) (SOSHeader, int64, error) {
-	sosHeader := SOSHeader{}
-	marker, offset2 := common.ReadUint16(data, offset)
-	log.Printf("SOS  offset=%d, marker=%d", offset, marker)
-	if marker != 0xffda {
-		_, err := fmt.Printf("SOS header invalid, expected %d, found %d", 0xffda, marker)
-		return sosHeader, offset2, err
-	}
-	sosHeader.Marker = marker
-
-	length, offset2 := common.ReadUint16(data, offset2)
-	sosHeader.Length = length
-
-	nrComponents, offset2 := common.ReadUint8(data, offset2)
-	sosHeader.NrComponents = nrComponents
-	log.Printf("SOS  nrComponents=%d", nrComponents)
-
-	// let's read each component
-	components := []SOSComponent{}
-	var offset3 = offset2
-	var identifier uint8
-
-	for i := 0; i < int(nrComponents); i++ {
-		identifier, offset3 = common.ReadUint8(data, offset3)
-		samplingByte := data[offset3]
-		offset3++
-
-		comp := SOSComponent{Selector: identifier,
-			DCTable: uint8(samplingByte >> 4),
-			ACTable: uint8(samplingByte & 0x0f),
-		}
-
-		// log.Printf("SOS  component # %d, table=%d", comp.Selector, comp.DCTable)
-		components = append(components, comp)
-	}
-	sosHeader.Components = components
-
-	startOfSpectral, offset3 := common.ReadUint8(data, offset3)
-	sosHeader.StartOfSpectral = startOfSpectral
-
-	endOfSpectral, offset3 := common.ReadUint8(data, offset3)
-	sosHeader.EndOfSpectral = endOfSpectral
-
-	successiveApprosimationBitPositions, offset3 := common.ReadUint8(data, offset3)
-	sosHeader.SuccessiveApprosimationBitPositions = successiveApprosimationBitPositions
-
-	return sosHeader, offset3, nil
-}
-
-func scriviHuffCodes(huffMappings []common.HuffMapping) {
-	log.Printf("HUFFMAN CODES =========")
-	log.Printf("#   \t bits \t code \t value")
-	for i, h := range huffMappings {
-		log.Printf("%d   \t %d \t  '%16b' \t %b", i, h.BitCount, h.Code, h.Value)
-	}
-}
-func parseDHTHeader(data []byte, offset int64) (LosslessJPG, int64, error) {
-	var dhtHeader = DHTHeader{}
-
-	log.Printf("parseDHTHeader, offset=%d\n", offset)
-	marker, offset2 := common.ReadUint16(data, offset)
-
-	if marker != 0xffc4 {
-		return LosslessJPG{}, offset2, fmt.Errorf("DHT Marker not valid  %d", marker)
-	}
-
-	dhtHeader.Marker = marker
-
-	// log.Printf("length offset %d", offset2)
-	length, offset2 := common.ReadUint16(data, offset2)
-	dhtHeader.Length = length
-
-	huffBytes := data[offset : offset+int64(length-2)]
-	huffMappings := common.DecodeHuffTree(huffBytes)
-
-	scriviHuffCodes(huffMappings[0])
-	scriviHuffCodes(huffMappings[1])
-
-	huffmanCodesMap := []map[common.HuffMappingKey]common.HuffMapping{}
-	for _, hm := range huffMappings {
-		huffmanCodesMap = append(huffmanCodesMap, common.HuffMappingToMap(hm))
-	}
-
-	sof3Header, offset2, err := parseSOF3Header(data, offset2+int64(dhtHeader.Length)-2)
-	if err != nil {
-		return LosslessJPG{}, offset2, err
-	}
-
-	sosHeader, offset3, err := parseSOSHeader(data, offset2)
-	if err != nil {
-		return LosslessJPG{}, offset3, err
-	}
-
-	losslessJPG := LosslessJPG{DHTHeader: dhtHeader, SOF3Header: sof3Header,
-		SOSHeader:       sosHeader,
-		HuffmanCodesMap: huffmanCodesMap,
-	}
-
-	return losslessJPG, offset3, nil
-}
-
 func getRawSlice(ifd IFDs) (rawSlice, error) {
 	for _, ifd := range ifd.Ifds {
 		if ifd.Tag == 0xc640 {
@@ -581,85 +389,6 @@ func reverseBitsIfNecessary(a uint64, bitNr int) uint64 {
 	return a
 }
 
-func scriviBit(data []byte, bitsOffset int, howmany int) {
-	bitreader := bitstream.NewReader(bytes.NewReader(data))
-	bitreader.ReadBits(bitsOffset)
-	v, err := bitreader.ReadBits(howmany)
-	if err != nil {
-		log.Printf("%v", err)
-	}
-	log.Printf("bits in esame = '%16b'", v)
-}
-
-/*
-func findHuffCodeSlow(data []byte, bitsOffset int, bitsLength int, huffMappings []common.HuffMapping) (common.HuffMapping, error) {
-
-	bitreader := bitstream.NewReader(bytes.NewReader(data))
-	bitreader.ReadBits(bitsOffset)
-	v, err := bitreader.ReadBits(bitsLength)
-	if err != nil {
-		return common.HuffMapping{}, err
-	}
-	//log.Printf("bits in esame %b", v)
-
-	h, err2 := common.HuffGetMapping(huffMappings, v, bitsLength)
-	if err2 != nil {
-		return findHuffCodeSlow(data, bitsOffset, bitsLength-1, huffMappings)
-	}
-	return h, nil
-}
-
-
-func findHuffCodeV2(data []byte, bitsOffset int, bitsLength int, huffMappings []common.HuffMapping) (common.HuffMapping, error) {
-
-	bytesOffset := int(bitsOffset / 8)
-	byte4 := data[bytesOffset : bytesOffset+4]
-
-	val4 := binary.BigEndian.Uint32(byte4)
-	val4 = (val4 << uint(bitsOffset%8)) >> 16
-	val5 := uint16(val4)
-	for i := 16; i >= 2; i-- {
-		val6 := val5 >> uint(16-i)
-		h, err2 := common.HuffGetMapping(huffMappings, uint64(val6), i)
-		if err2 == nil {
-			return h, nil
-		}
-	}
-	return common.HuffMapping{}, fmt.Errorf("not found")
-}
-*/
-
-func findHuffCodeV3(data []byte, bitsOffset int, bitsLength int, huffMappings map[common.HuffMappingKey]common.HuffMapping) (common.HuffMapping, error) {
-
-	bytesOffset := int(bitsOffset / 8)
-	byte4 := data[bytesOffset : bytesOffset+4]
-
-	val4 := binary.BigEndian.Uint32(byte4)
-	val4 = (val4 << uint(bitsOffset%8)) >> 16
-	val5 := uint16(val4)
-	for i := 16; i >= 2; i-- {
-		val6 := val5 >> uint(16-i)
-		h, ok := huffMappings[common.HuffMappingKey{Code: uint64(val6), BitCount: i}]
-		if ok {
-			return h, nil
-		}
-	}
-	return common.HuffMapping{}, fmt.Errorf("huffman code not found, bytesOffset:%d, bitsoffset:%d, %b", bytesOffset, bitsOffset, val5)
-}
-
-// 0xff 0x00 becomes 0xff
-func cleanStream(data []byte) []byte {
-	result := []byte{}
-	for i, b := range data {
-		if i > 0 && b == 0x00 && data[i-1] == 0xff {
-			continue
-		} else {
-			result = append(result, b)
-		}
-	}
-	return result
-}
-
 // sliceIndex, rowInSlice, colInSlice
 func sliceIndex(offset int, rawslice rawSlice, height int) (int, int, int) {
 	pixelsInSlice := height * int(rawslice.SliceSize)
@@ -679,185 +408,312 @@ func sliceIndex(offset int, rawslice rawSlice, height int) (int, int, int) {
 	return sliceIndex, rowInSlice, colInSlice
 }
 
-func unslice(data []uint16, rawslice rawSlice, height int) []uint16 {
-	var result = make([]uint16, len(data))
-	for i := 0; i < len(data); i++ {
-		sliceIndex, rowInSlice, colInSlice := sliceIndex(i, rawslice, height)
-		i2 := rowInSlice*rawslice.imageWidth() + sliceIndex*int(rawslice.SliceSize) + colInSlice
+// sliceWriter places the rows of the lossless JPEG frame in their final
+// position in the raster, following the rawSlice geometry: the frame
+// holds the first slice from top to bottom, then the second one and so on
+type sliceWriter struct {
+	raw    []uint16
+	width  int
+	height int
+	slices rawSlice
+}
 
-		//fmt.Printf("RAW[%d]=%d  i=%d\n", i2, data[i], i)
-		result[i2] = data[i]
+func newSliceWriter(slices rawSlice, frameSamples int) sliceWriter {
+	width := slices.imageWidth()
+	return sliceWriter{
+		raw:    make([]uint16, frameSamples),
+		width:  width,
+		height: frameSamples / width,
+		slices: slices,
 	}
-	return result
 }
 
-func prevValue(data []uint16, j int, rawslice rawSlice, componentsNr int, samplePrecision int) uint16 {
-	if j < componentsNr {
-		return uint16(common.Pow2(samplePrecision - 1))
-	} else if j%rawslice.imageWidth() < componentsNr {
-		j2 := j - rawslice.imageWidth()
-		return data[j2]
-	} else {
-		return data[j-componentsNr]
+// writeRow copies a frame row starting at sample offset of the frame
+func (w *sliceWriter) writeRow(offset int, row []uint16) {
+	if w.slices.Count == 0 {
+		// not sliced, frame and raster share the layout
+		copy(w.raw[offset:], row)
+		return
+	}
+	for len(row) > 0 {
+		slice, rowInSlice, colInSlice := sliceIndex(offset, w.slices, w.height)
+		sliceWidth := int(w.slices.SliceSize)
+		if slice == int(w.slices.Count) {
+			sliceWidth = int(w.slices.LastSliceSize)
+		}
+		n := sliceWidth - colInSlice
+		if n > len(row) {
+			n = len(row)
+		}
+		if rowInSlice < w.height {
+			i := rowInSlice*w.width + slice*int(w.slices.SliceSize) + colInSlice
+			copy(w.raw[i:i+n], row[:n])
+		}
+		row = row[n:]
+		offset += n
 	}
 }
 
-func scanRawData(data []byte, loselessJPG LosslessJPG, offset int64, canonHeader Header, aifd IFDs) ([]uint16, error) {
-
-	cleanedData := cleanStream(data[offset:])
-	// log.Printf("size %d, cleaned %d, removed %d", len(data[offset:]), len(cleanedData), len(data[offset:])-len(cleanedData))
+// frameSlices returns the slices of the raw IFD, a single slice as wide as
+// the frame if the tag is missing
+func frameSlices(decoder *ljpeg.Decoder, aifd IFDs) (rawSlice, error) {
+	frameWidth := decoder.McuRowSize()
+	frameHeight := decoder.McuRows()
 
-	// log.Printf("scanRawData | offset=%d", offset)
-	rawSlice, err := getRawSlice(aifd)
+	slices, err := getRawSlice(aifd)
 	if err != nil {
-		return nil, err
+		// no slices, the frame is the image
+		slices = rawSlice{LastSliceSize: uint16(frameWidth)}
+	}
+	// the slices size the rows of the workers, zero sizes would divide by
+	// zero in sliceIndex
+	if (slices.Count > 0 && slices.SliceSize == 0) || slices.LastSliceSize == 0 ||
+		slices.imageWidth() == 0 || frameWidth*frameHeight/slices.imageWidth() == 0 {
+		return slices, common.NewFormatError(format, 0, "image size not valid, frame %dx%d, slices %v", frameWidth, frameHeight, slices)
 	}
+	return slices, nil
+}
 
-	stripBytesCount, err := getStripBytesCount(aifd)
+// rowsBatch frame rows decoded, waiting to be placed by the workers
+type rowsBatch struct {
+	offset int
+	rows   []uint16
+}
+
+// scanRawData decodes the lossless JPEG stream in the final raster. The
+// entropy decoding is sequential, rows are handed over in batches to
+// common.WorkersCount() goroutines that write them to their position in the
+// slices
+func scanRawData(decoder *ljpeg.Decoder, aifd IFDs) ([]uint16, int, int, error) {
+
+	frameWidth := decoder.McuRowSize()
+	frameHeight := decoder.McuRows()
+
+	slices, err := frameSlices(decoder, aifd)
 	if err != nil {
-		return nil, err
+		return nil, 0, 0, err
 	}
-	log.Printf("stripBytesCount %d, imageHeight: %d", stripBytesCount, loselessJPG.SOF3Header.NrLines)
-
-	componentNr := 0
-	pixelsCount := rawSlice.imageWidth() * int(loselessJPG.SOF3Header.NrLines)
-	rawData := make([]uint16, pixelsCount)
-	// log.Printf("allocata matrice di %d elementi", cap(rawData))
-	bitsOffset := 0
-
-	bitreader := bitstream.NewReader(bytes.NewReader(cleanedData))
-
-	//huffDifferences := common.HuffDifferences()
-	running := true
-	j := 0
-	for running {
-		//log.Printf("Step %d , bitsOffset = %d / %d, %f %%", j, bitsOffset, bitsCount, 100.0*float64(bitsOffset)/float64(bitsCount))
-		dcTableIndex := int(loselessJPG.SOSHeader.Components[componentNr].DCTable)
-
-		//log.Printf("componentNr=%d, dcTableIndex = %d", componentNr, dcTableIndex)
-		//scriviBit(cleanedData, bitsOffset, 16)
-
-		//start := time.Now()
-		//huffCode, err := findHuffCodeV2(cleanedData, bitsOffset, 16, loselessJPG.HuffmanCodes[dcTableIndex])
-		huffCode, err := findHuffCodeV3(cleanedData, bitsOffset, 16, loselessJPG.HuffmanCodesMap[dcTableIndex])
-		//end := time.Now()
-		//log.Printf("ricerca codice huff %d", end.Sub(start))
-		if err != nil {
-			log.Printf(err.Error())
-			running = false
-		}
-		// log.Printf("huffCode = %v", huffCode)
 
-		// we already read huffCode.BitCount bits searching huffCode
-		_, terminatedError := bitreader.ReadBits(huffCode.BitCount)
-		if terminatedError != nil {
-			running = false
-			break
-		}
-		val2, terminatedError := bitreader.ReadBits(int(huffCode.Value))
-		if terminatedError != nil {
-			running = false
-			break
-		}
-		//log.Printf("val2=%d, %13b", val2, val2)
-
-		val3 := reverseBitsIfNecessary(val2, int(huffCode.Value))
-		/*
-			questo è più lento ...
-			huffDiff, err := huffDifferences.Find(uint8(huffCode.Value), uint16(val2))
-			//log.Printf("huffDiff=%v", huffDiff)
-			if err != nil {
-				return nil, err
+	stripBytesCount, err := getStripBytesCount(aifd)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if *common.Verbose {
+		log.Printf("stripBytesCount %d, imageHeight: %d", stripBytesCount, frameHeight)
+	}
+	// each sample takes at least one bit of the strip
+	if frameSamples := frameWidth * frameHeight; frameSamples > maxFrameSamples || frameSamples > 8*int(stripBytesCount) {
+		return nil, 0, 0, common.NewFormatError(format, 0, "frame %dx%d too large for %d bytes of raw data", frameWidth, frameHeight, stripBytesCount)
+	}
+
+	writer := newSliceWriter(slices, frameWidth*frameHeight)
+
+	const batchRows = 16
+	batches := make(chan rowsBatch, common.WorkersCount())
+	free := make(chan []uint16, 2*common.WorkersCount())
+	var wg sync.WaitGroup
+	for i := 0; i < common.WorkersCount(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range batches {
+				for r := 0; r*frameWidth < len(b.rows); r++ {
+					writer.writeRow(b.offset+r*frameWidth, b.rows[r*frameWidth:(r+1)*frameWidth])
+				}
+				select {
+				case free <- b.rows:
+				default:
+				}
 			}
-			val4 := uint16(int32(previousValues[componentNr]) + huffDiff.Diff)
-		*/
-
-		// questo forse più veloce
-		var val4 uint16
-		if val3 == val2 {
-			val4 = prevValue(rawData, j, rawSlice, int(loselessJPG.SOF3Header.NrImageComponentsPerFrame), int(loselessJPG.SOF3Header.SamplePrecision)) + uint16(val3)
-		} else {
-			val4 = prevValue(rawData, j, rawSlice, int(loselessJPG.SOF3Header.NrImageComponentsPerFrame), int(loselessJPG.SOF3Header.SamplePrecision)) - uint16(val3)
+		}()
+	}
+	newBatch := func() []uint16 {
+		select {
+		case rows := <-free:
+			return rows
+		default:
+			return make([]uint16, batchRows*frameWidth)
 		}
+	}
 
-		if j < cap(rawData) {
-			rawData[j] = val4
+	batch := newBatch()
+	batchStart := 0
+	err = decoder.DecodeRows(func(row int, samples []uint16) error {
+		copy(batch[(row-batchStart)*frameWidth:], samples)
+		if row-batchStart+1 == batchRows || row+1 == frameHeight {
+			batches <- rowsBatch{offset: batchStart * frameWidth, rows: batch[:(row-batchStart+1)*frameWidth]}
+			batch = newBatch()
+			batchStart = row + 1
 		}
+		return nil
+	})
+	close(batches)
+	wg.Wait()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if *common.Verbose {
+		log.Printf("rawData length = %d", len(writer.raw))
+	}
 
-		// prepare for next iteration
-		componentNr++
-		if componentNr >= int(loselessJPG.SOF3Header.NrImageComponentsPerFrame) {
-			componentNr = 0
-		}
+	return writer.raw, writer.width, writer.height, nil
+}
 
-		bitsOffset = bitsOffset + huffCode.BitCount + int(huffCode.Value)
-		j++
+// rawRange returns the position of the lossless JPEG stream of the raw IFD
+// in the file of size bytes
+func rawRange(aifd IFDs, size int64) (int64, int64, error) {
+	startOffset, endOffset := getStartEndIFD0(aifd)
+	if startOffset >= endOffset || endOffset > size {
+		return 0, 0, common.NewFormatError(format, startOffset, "raw data [%d, %d] outside of the file (%d bytes)", startOffset, endOffset, size)
 	}
-	log.Printf("rawData length = %d", len(rawData))
+	return startOffset, endOffset, nil
+}
 
-	if len(rawData) != int(rawSlice.imageWidth()*int(loselessJPG.SOF3Header.NrLines)) {
-		log.Panic(fmt.Sprintf("dimensione immagine non corrisponde con dati caricati, %d vs %d", len(rawData), int(rawSlice.imageWidth()*int(loselessJPG.SOF3Header.NrLines))))
+// rawDecoder parses the headers of the lossless JPEG stream of the raw IFD
+func rawDecoder(data []byte, aifd IFDs) (*ljpeg.Decoder, error) {
+	startOffset, endOffset, err := rawRange(aifd, int64(len(data)))
+	if err != nil {
+		return nil, err
 	}
-	//return rawData, nil
-	return unslice(rawData, rawSlice, int(loselessJPG.SOF3Header.NrLines)), nil
+	return ljpeg.NewDecoder(data[startOffset:endOffset])
 }
 
-func parseRaw(data []byte, canonHeader Header, aifd IFDs) ([]uint16, common.ImgMetadata, error) {
-	startOffset, _ := getStartEndIFD0(aifd)
+// parseRaw decodes the raw IFD, the sRAW and mRAW to RGB with the
+// conversion values of the maker note, nil if missing
+func parseRaw(data []byte, canonHeader Header, aifd IFDs, makerNote *MakerNote) (*common.RawImage, error) {
+	decoder, err := rawDecoder(data, aifd)
+	if err != nil {
+		return nil, err
+	}
+	sraw := isSRaw(decoder.Frame)
+	decoder.CanonSRAW = sraw
 
-	soiMarker, offset := common.ReadUint16(data, startOffset)
-	if soiMarker != 0xffd8 {
-		return nil, common.ImgMetadata{}, fmt.Errorf("SOI Marker not valid  %d", soiMarker)
+	rawData, width, height, err := scanRawData(decoder, aifd)
+	if err != nil {
+		return nil, err
 	}
+	if sraw {
+		c := decoder.Frame.Components[0]
+		return decodeSRaw(rawData, width, height, int(c.HorizontalSamplingFactor), int(c.VerticalSamplingFactor), newSRawConversion(makerNote)), nil
+	}
+	return &common.RawImage{
+		Width:      width,
+		Height:     height,
+		Pix:        rawData,
+		CFA:        common.CFARGGB,
+		WhiteLevel: uint16(1<<decoder.Frame.SamplePrecision - 1),
+	}, nil
+}
 
-	loselessJPG, loselessJPGOffset, err := parseDHTHeader(data, offset)
-	check(err)
-	//log.Printf("loselessJPG %v", loselessJPG)
+// readCR2 reads the header and the IFDs, checking the raw IFD is there
+func readCR2(r io.ReaderAt) (Header, []IFDs, error) {
+	head := make([]byte, 16)
+	if err := common.ReadFull(format, r, 0, head); err != nil {
+		return Header{}, nil, err
+	}
+	canonHeader, err := readHeader(head)
+	if err != nil {
+		return canonHeader, nil, err
+	}
+	ifds, err := readIfds(r)
+	if err != nil {
+		return canonHeader, ifds, err
+	}
+	if len(ifds) < 4 {
+		return canonHeader, ifds, common.NewFormatError(format, canonHeader.IfdOffset, "%d IFDs found, the raw data is in the fourth", len(ifds))
+	}
+	return canonHeader, ifds, nil
+}
+
+// decode reads the raw image of the CR2 file in data, together with the IFDs
+func decode(data []byte) (*common.RawImage, []IFDs, error) {
+	canonHeader, ifds, err := readCR2(bytes.NewReader(data))
+	if err != nil {
+		return nil, ifds, err
+	}
+
+	metadata, makerNote := readMetadata(ifds[0].Dir)
+	img, err := parseRaw(data, canonHeader, ifds[3], makerNote)
+	if err != nil {
+		return nil, ifds, err
+	}
 
-	rawData, err := scanRawData(data, loselessJPG, loselessJPGOffset, canonHeader, aifd)
-	rawSlice, _ := getRawSlice(aifd)
-	return rawData, common.ImgMetadata{ImageWidth: rawSlice.imageWidth(), ImageHeight: int(loselessJPG.SOF3Header.NrLines)}, err
+	applyMetadata(img, metadata, makerNote)
+	return img, ifds, nil
+}
+
+// applyMetadata sets the metadata of img and the levels, the white balance
+// and the active area of the maker note, nil if missing
+func applyMetadata(img *common.RawImage, metadata common.Metadata, makerNote *MakerNote) {
+	img.Metadata = metadata
+	if makerNote != nil {
+		img.MakerNote = makerNote
+		if makerNote.ColorData != nil {
+			applyColorData(img, makerNote.ColorData)
+		}
+		if makerNote.SensorInfo != nil {
+			applySensorInfo(img, makerNote.SensorInfo)
+		}
+	}
+	img.Orientation = img.Metadata.Orientation
+	if img.Orientation == 0 {
+		img.Orientation = 1
+	}
+}
+
+// Decode reads the raw image of the CR2 file in r, size bytes long
+func Decode(r io.ReaderAt, size int64) (*common.RawImage, error) {
+	if size < 0 {
+		return nil, common.NewFormatError(format, 0, "file size %d not valid", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(io.NewSectionReader(r, 0, size), data); err != nil {
+		return nil, common.WrapFormatError(format, 0, err, "reading %d bytes", size)
+	}
+	img, _, err := decode(data)
+	return img, err
 }
 
 func rc(j int, width int, length int) (int, int) {
 	return j / width, j % width
 }
 
-// ProcessCR2 start CR2 files
-func ProcessCR2(data []byte, rawfile string) *image.Gray16 {
-	canonHeader, err := readHeader(data)
-	check(err)
-	// log.Printf("Header %v\n", canonHeader)
-	ifds := readIfds(data, &canonHeader)
-
+// ProcessCR2 shows the informations of the CR2 file, extracts the JPEGs and
+// writes the samples to rawfile.pgm, as dcraw -D -4: cropped with the
+// margins of dcraw for the raw sizes it knows, to the active area otherwise
+func ProcessCR2(data []byte, rawfile string) error {
+	img, ifds, err := decode(data)
 	if *common.ShowInfo {
 		dumpIfds(ifds)
 	}
-
-	if *common.ExtractJpegs {
-		saveJpeg(data, ifds[0], strings.Replace(rawfile, ".CR2", "_0.jpeg", 1), getStartEndIFD0)
-		saveJpeg(data, ifds[1], strings.Replace(rawfile, ".CR2", "_1.jpeg", 1), getStartEndIFD1)
+	if err != nil {
+		return err
 	}
-
-	rawData, _, _ := parseRaw(data, canonHeader, ifds[3])
-	outputFile, err := os.Create(rawfile + ".bin") //strings.Replace(rawfile, ".CR2", ".bin", 1))
-	for _, b := range rawData {
-		bs := make([]byte, 2)
-		binary.LittleEndian.PutUint16(bs, b)
-		outputFile.Write(bs)
+	if *common.ShowInfo {
+		log.Printf("Metadata %+v", img.Metadata)
+		log.Printf("MakerNote %+v", img.MakerNote)
 	}
 
-	outputFile.Close()
-	/*
-		myImage := image.NewGray16(image.Rect(0, 0, imgMetadata.ImageWidth, imgMetadata.ImageHeight))
-		for i, b := range rawData {
-			if i < 4 {
-				fmt.Printf("%d", b)
-			}
-			myImage.SetGray16(i%imgMetadata.ImageWidth, i/imgMetadata.ImageWidth, color.Gray16{Y: 255 * b})
+	if *common.ExtractJpegs {
+		if err := saveJpeg(data, ifds[0], strings.Replace(rawfile, ".CR2", "_0.jpeg", 1), getStartEndIFD0); err != nil {
+			return err
 		}
+		if err := saveJpeg(data, ifds[1], strings.Replace(rawfile, ".CR2", "_1.jpeg", 1), getStartEndIFD1); err != nil {
+			return err
+		}
+	}
 
-		return myImage
-	*/
-	return nil
+	outputFile, err := os.Create(rawfile + ".pgm")
+	if err != nil {
+		return err
+	}
+	out := *img
+	if area, ok := dcrawArea(img.Width, img.Height); ok && img.PixelSize() == 1 {
+		out.ActiveArea = area
+	}
+	err = common.EncodeRawPNM(outputFile, out.Crop())
+	if cerr := outputFile.Close(); err == nil {
+		err = cerr
+	}
+	return err
 }
@@ -0,0 +1,205 @@
+package canon
+
+import (
+	"github.com/enricod/rawmgr/common"
+)
+
+// tagColorData maker note tag of the white balance and levels table
+const tagColorData = 0x4001
+
+// WBPreset white balance levels of a preset, in R G G B order
+type WBPreset struct {
+	Name      string
+	Levels    [4]int
+	ColorTemp int
+}
+
+// ColorData maker note tag 0x4001, the layout depends on the version,
+// known from the number of values
+type ColorData struct {
+	Version    int
+	SubVersion int
+	// AsShot white balance levels of the shot, R G G B
+	AsShot WBPreset
+	// Presets levels of the white balance presets of the camera
+	Presets []WBPreset
+	// ChannelBlackLevel black level of each channel R G G B, with the
+	// white levels zero if the version does not store them
+	ChannelBlackLevel    [4]int
+	NormalWhiteLevel     int
+	SpecularWhiteLevel   int
+	LinearityUpperMargin int
+	// SRawLevels levels applied to the colors of the sRAW, R G G B with 1024
+	// as 1. As dcraw, the first set of levels after the as shot ones with
+	// green 1170
+	SRawLevels [4]int
+}
+
+// colorDataLayout position of the values in a ColorData version, as index
+// of the int16 array
+type colorDataLayout struct {
+	version int
+	counts  []int
+	// asShot index of the as shot levels, followed by the color temperature
+	asShot int
+	// presets names of the presets following the as shot levels, 4 levels
+	// and the color temperature each
+	presets []string
+	// levels index of the black levels by sub version, -1 for any sub
+	// version. The white levels follow the black levels
+	levels map[int]int
+}
+
+var standardPresets = []string{"Auto", "Measured", "Daylight", "Shade", "Cloudy", "Tungsten", "Fluorescent", "Kelvin", "Flash"}
+
+var colorDataLayouts = []colorDataLayout{
+	// 20D, 350D
+	{version: 1, counts: []int{582}, asShot: 0x19,
+		presets: []string{"Auto", "Daylight", "Shade", "Cloudy", "Tungsten", "Fluorescent", "Flash", "Custom1", "Custom2"}},
+	// 1D Mark II, 1Ds Mark II
+	{version: 2, counts: []int{653}, asShot: 0x22,
+		presets: []string{"Daylight", "Shade", "Cloudy", "Tungsten", "Fluorescent", "Kelvin", "Flash"}},
+	// 1D Mark II N, 5D, 30D, 400D
+	{version: 3, counts: []int{796}, asShot: 0x3f, presets: standardPresets, levels: map[int]int{-1: 0xc4}},
+	// 1D Mark III, 1Ds Mark III, 1D Mark IV, 5D Mark II, 7D, 40D, 50D, 60D, 450D, 500D, 550D, 1000D
+	{version: 4, counts: []int{674, 692, 702, 1227, 1250, 1251, 1337, 1338, 1346}, asShot: 0x3f, presets: standardPresets,
+		levels: map[int]int{4: 0x2b4, 5: 0x2b4, 6: 0x2cb, 7: 0x2cb, 9: 0x2cf}},
+	// PowerShot
+	{version: 5, counts: []int{5120}, asShot: 0x47, presets: []string{"Auto"}},
+	// 600D, 1100D
+	{version: 6, counts: []int{1273, 1275}, asShot: 0x3f, presets: standardPresets, levels: map[int]int{-1: 0x1df}},
+	// 1D X, 5D Mark III, 6D, 70D, 100D, 650D, 700D, M
+	{version: 7, counts: []int{1312, 1313, 1316, 1506}, asShot: 0x3f, presets: standardPresets,
+		levels: map[int]int{10: 0x1f8, 11: 0x2d8}},
+	// 5DS, 1D X Mark II, 5D Mark IV, 80D, 750D, 760D, 1300D
+	{version: 8, counts: []int{1353, 1560, 1592, 1602}, asShot: 0x3f, presets: standardPresets,
+		levels: map[int]int{14: 0x22c, -1: 0x30f}},
+	// 6D Mark II, 77D, 200D, 800D, M6
+	{version: 9, counts: []int{1816, 1820, 1824}, asShot: 0x47, presets: standardPresets, levels: map[int]int{-1: 0x231}},
+	// M50, R, RP
+	{version: 10, counts: []int{2024, 3656}, asShot: 0x55, presets: []string{"Auto"}, levels: map[int]int{-1: 0x238}},
+	// R5, R6, 1D X Mark III. The position of the levels of versions 11 and
+	// 12 is not known: the levels are left zero and the ones of the file apply
+	{version: 11, counts: []int{3778, 3973}, asShot: 0x69, presets: []string{"Auto"}},
+	// R3, R7, R10
+	{version: 12, counts: []int{4528}, asShot: 0x69, presets: []string{"Auto"}},
+}
+
+// findColorDataLayout returns the layout of the ColorData with count values
+func findColorDataLayout(count int) *colorDataLayout {
+	for i := range colorDataLayouts {
+		for _, c := range colorDataLayouts[i].counts {
+			if c == count {
+				return &colorDataLayouts[i]
+			}
+		}
+	}
+	return nil
+}
+
+// readPreset reads 4 levels and the color temperature at index i
+func readPreset(v []int16, i int, name string) WBPreset {
+	p := WBPreset{Name: name, ColorTemp: int(uint16(at(v, i+4)))}
+	for c := 0; c < 4; c++ {
+		p.Levels[c] = int(uint16(at(v, i+c)))
+	}
+	return p
+}
+
+// readColorData decodes the int16 array of tag 0x4001, nil if the version is
+// not known
+func readColorData(v []int16) *ColorData {
+	layout := findColorDataLayout(len(v))
+	if layout == nil {
+		return nil
+	}
+	cd := &ColorData{Version: layout.version, SubVersion: at(v, 0)}
+	cd.AsShot = readPreset(v, layout.asShot, "AsShot")
+	for i, name := range layout.presets {
+		cd.Presets = append(cd.Presets, readPreset(v, layout.asShot+5*(i+1), name))
+	}
+	cd.SRawLevels = [4]int{1024, 1024, 1024, 1024}
+	for i := layout.asShot + 5; i+4 <= len(v); i += 5 {
+		if at(v, i+1) == 1170 {
+			cd.SRawLevels = readPreset(v, i, "").Levels
+			break
+		}
+	}
+
+	index, ok := layout.levels[cd.SubVersion]
+	if !ok {
+		index, ok = layout.levels[-1]
+	}
+	if ok && index+7 <= len(v) {
+		var black [4]int
+		for c := range black {
+			black[c] = int(uint16(at(v, index+c)))
+		}
+		normal, specular := int(uint16(at(v, index+4))), int(uint16(at(v, index+5)))
+		if validLevels(black, normal, specular) {
+			cd.ChannelBlackLevel = black
+			cd.NormalWhiteLevel = normal
+			cd.SpecularWhiteLevel = specular
+			cd.LinearityUpperMargin = int(uint16(at(v, index+6)))
+		}
+	}
+	return cd
+}
+
+// validLevels checks the black and white levels read are sensible, the
+// position of the levels is not known for every sub version
+func validLevels(black [4]int, normal, specular int) bool {
+	min, max := black[0], black[0]
+	for _, b := range black {
+		if b < min {
+			min = b
+		}
+		if b > max {
+			max = b
+		}
+	}
+	return min > 0 && max-min < 256 && normal > max+256 && specular >= normal && specular < 1<<16
+}
+
+// Multipliers returns the white balance multipliers of the levels, R G G B
+// with green 1. Zero if the levels are not valid
+func (p WBPreset) Multipliers() [4]float64 {
+	var result [4]float64
+	green := float64(p.Levels[1]+p.Levels[2]) / 2
+	if green <= 0 {
+		return result
+	}
+	for c := range result {
+		result[c] = float64(p.Levels[c]) / green
+	}
+	return result
+}
+
+// Preset returns the preset with name, false if not in the ColorData
+func (cd *ColorData) Preset(name string) (WBPreset, bool) {
+	for _, p := range cd.Presets {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return WBPreset{}, false
+}
+
+// applyColorData sets white balance and levels of img from the ColorData
+func applyColorData(img *common.RawImage, cd *ColorData) {
+	img.WBMultipliers = cd.AsShot.Multipliers()
+	img.ColorTemperature = cd.AsShot.ColorTemp
+	// the levels are of the sensor, not of the sRAW colors
+	if cd.SpecularWhiteLevel == 0 || img.PixelSize() != 1 {
+		return
+	}
+	sum := 0
+	for c, b := range cd.ChannelBlackLevel {
+		img.ChannelBlackLevel[c] = uint16(b)
+		sum += b
+	}
+	img.BlackLevel = uint16((sum + 2) / 4)
+	if cd.SpecularWhiteLevel < int(img.WhiteLevel) {
+		img.WhiteLevel = uint16(cd.SpecularWhiteLevel)
+	}
+}
@@ -0,0 +1,80 @@
+package canon
+
+import (
+	"testing"
+
+	"github.com/enricod/rawmgr/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testColorData(count int, subVersion int16) []int16 {
+	v := make([]int16, count)
+	v[0] = subVersion
+	copy(v[0x3f:], []int16{2048, 1024, 1024, 1536, 5200})
+	// Daylight
+	copy(v[0x4e:], []int16{2000, 1024, 1024, 1500, 5500})
+	copy(v[0x2cb:], []int16{2047, 2049, 2048, 2046, 15000, 15300, 1000})
+	return v
+}
+
+func TestReadColorData(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cd := readColorData(testColorData(1250, 6))
+	require.NotNil(cd)
+	assert.Equal(4, cd.Version)
+	assert.Equal(6, cd.SubVersion)
+	assert.Equal([4]int{2048, 1024, 1024, 1536}, cd.AsShot.Levels)
+	assert.Equal(5200, cd.AsShot.ColorTemp)
+	assert.Equal([4]float64{2, 1, 1, 1.5}, cd.AsShot.Multipliers())
+
+	daylight, ok := cd.Preset("Daylight")
+	assert.True(ok)
+	assert.Equal(5500, daylight.ColorTemp)
+	_, ok = cd.Preset("Custom1")
+	assert.False(ok)
+
+	assert.Equal([4]int{2047, 2049, 2048, 2046}, cd.ChannelBlackLevel)
+	assert.Equal(15000, cd.NormalWhiteLevel)
+	assert.Equal(15300, cd.SpecularWhiteLevel)
+
+	img := &common.RawImage{WhiteLevel: 16383}
+	applyColorData(img, cd)
+	assert.Equal(uint16(2048), img.BlackLevel)
+	assert.Equal([4]uint16{2047, 2049, 2048, 2046}, img.ChannelBlackLevel)
+	assert.Equal(uint16(15300), img.WhiteLevel)
+	assert.Equal(5200, img.ColorTemperature)
+	assert.Equal(2.0, img.WBMultipliers[0])
+
+	// levels of another sub version are not where expected
+	cd = readColorData(testColorData(1250, 4))
+	require.NotNil(cd)
+	assert.Equal([4]int{}, cd.ChannelBlackLevel)
+	assert.Equal(0, cd.SpecularWhiteLevel)
+
+	assert.Equal([4]int{1024, 1024, 1024, 1024}, cd.SRawLevels)
+	v := testColorData(1250, 6)
+	copy(v[0x3f+5*4:], []int16{1800, 1170, 1170, 1300})
+	assert.Equal([4]int{1800, 1170, 1170, 1300}, readColorData(v).SRawLevels)
+
+	// versions 11 and 12 have no levels, the ones of the file are kept
+	for _, count := range []int{3778, 3973, 4528} {
+		v := make([]int16, count)
+		copy(v[0x69:], []int16{2048, 1024, 1024, 1536, 5200})
+		cd = readColorData(v)
+		require.NotNil(cd)
+		assert.Equal([4]int{}, cd.ChannelBlackLevel)
+		assert.Equal(0, cd.SpecularWhiteLevel)
+		img := &common.RawImage{WhiteLevel: 16383, BlackLevel: 512, ChannelBlackLevel: [4]uint16{511, 512, 513, 512}}
+		applyColorData(img, cd)
+		assert.Equal(uint16(16383), img.WhiteLevel)
+		assert.Equal(uint16(512), img.BlackLevel)
+		assert.Equal([4]uint16{511, 512, 513, 512}, img.ChannelBlackLevel)
+		assert.Equal(2.0, img.WBMultipliers[0])
+	}
+
+	assert.Nil(readColorData(testColorData(1000, 1)))
+	assert.Nil(readColorData(nil))
+}
@@ -0,0 +1,418 @@
+package canon
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/enricod/rawmgr/common"
+)
+
+// formatCR3 format name used in the errors of the CR3 files
+const formatCR3 = "CR3"
+
+var (
+	// cr3CanonUUID box in moov with the metadata boxes
+	cr3CanonUUID = [16]byte{0x85, 0xc0, 0xb6, 0x87, 0x82, 0x0f, 0x11, 0xe0, 0x81, 0x11, 0xf4, 0xce, 0x46, 0x2b, 0x6a, 0x48}
+	// cr3PreviewUUID top level box with the PRVW preview
+	cr3PreviewUUID = [16]byte{0xea, 0xf4, 0x2b, 0x5e, 0x1c, 0x98, 0x4b, 0x88, 0xb9, 0xfb, 0xb7, 0xdc, 0x40, 0x6e, 0x4d, 0x16}
+)
+
+// Jpeg position in the file of an embedded JPEG image
+type Jpeg struct {
+	Offset int64
+	Length int64
+	Width  int
+	Height int
+}
+
+// CR3 boxes of a CR3 file, an ISO base media file
+type CR3 struct {
+	// Boxes top level boxes
+	Boxes []common.Box
+	// Moov boxes in moov, the tracks and the Canon box
+	Moov []common.Box
+	// Canon boxes in the Canon uuid box of moov
+	Canon []common.Box
+	// Ifd0 IFD0 of CMT1, with the EXIF (CMT2) and the GPS (CMT4) as sub IFDs
+	// and the maker note (CMT3) as sub IFD of the EXIF, as in a CR2
+	Ifd0 *common.Ifd
+	// Thumbnail from the THMB box, 160x120
+	Thumbnail Jpeg
+	// Preview from the PRVW box, 1620x1080. Zero if missing
+	Preview Jpeg
+	// Tracks of moov: the full JPEG, the small and the full raw, the
+	// timed metadata
+	Tracks []CR3Track
+}
+
+// CR3Track a track of moov, with a single sample
+type CR3Track struct {
+	// Type of the sample entry, CRAW for the JPEG and the raw images
+	Type   string
+	Width  int
+	Height int
+	// Offset and Size of the sample in the file
+	Offset int64
+	Size   int64
+	// crx header of the raw images, nil for the other tracks
+	crx *crxHeader
+}
+
+// IsRaw reports whether the track holds a raw image
+func (t *CR3Track) IsRaw() bool {
+	return t.crx != nil && t.crx.planes == 4
+}
+
+// IsCR3 reports whether head, the first bytes of a file, is of a CR3
+func IsCR3(head []byte) bool {
+	return len(head) >= 12 && string(head[4:12]) == "ftypcrx "
+}
+
+// ReadCR3 reads the boxes and the IFDs of the CR3 file in r, size bytes
+// long. If size is not known, math.MaxInt64 reads up to the end of r
+func ReadCR3(r io.ReaderAt, size int64) (*CR3, error) {
+	boxes, err := common.ReadBoxes(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+	c := &CR3{Boxes: boxes}
+	if len(boxes) == 0 || boxes[0].Type != "ftyp" {
+		return nil, common.NewFormatError(formatCR3, 0, "ftyp box not found")
+	}
+	moov := common.FindBox(boxes, "moov")
+	if moov == nil {
+		return nil, common.NewFormatError(formatCR3, 0, "moov box not found")
+	}
+	if c.Moov, err = common.ReadBoxes(r, moov.DataOffset, moov.End()); err != nil {
+		return nil, err
+	}
+	canonBox := findUUID(c.Moov, cr3CanonUUID)
+	if canonBox == nil {
+		return nil, common.NewFormatError(formatCR3, moov.Offset, "Canon box not found in moov")
+	}
+	if c.Canon, err = common.ReadBoxes(r, canonBox.DataOffset, canonBox.End()); err != nil {
+		return nil, err
+	}
+
+	if c.Ifd0, err = readCMTs(r, c.Canon); err != nil {
+		return nil, err
+	}
+	for i := range c.Moov {
+		if c.Moov[i].Type != "trak" {
+			continue
+		}
+		track, err := readTrack(r, &c.Moov[i])
+		if err != nil {
+			return nil, err
+		}
+		if track != nil {
+			c.Tracks = append(c.Tracks, *track)
+		}
+	}
+	if thmb := common.FindBox(c.Canon, "THMB"); thmb != nil {
+		if c.Thumbnail, err = readCR3Jpeg(r, thmb, 4, 8); err != nil {
+			return nil, err
+		}
+	}
+	if preview := findUUID(boxes, cr3PreviewUUID); preview != nil {
+		// 8 bytes before the PRVW box
+		inner, err := common.ReadBoxes(r, preview.DataOffset+8, preview.End())
+		if err != nil {
+			return nil, err
+		}
+		if prvw := common.FindBox(inner, "PRVW"); prvw != nil {
+			if c.Preview, err = readCR3Jpeg(r, prvw, 6, 12); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c, nil
+}
+
+// RawTrack returns the track of the largest raw image, nil if not found
+func (c *CR3) RawTrack() *CR3Track {
+	var raw *CR3Track
+	for i := range c.Tracks {
+		t := &c.Tracks[i]
+		if t.IsRaw() && (raw == nil || t.crx.width > raw.crx.width) {
+			raw = t
+		}
+	}
+	return raw
+}
+
+// findPath returns the box at the path of types from the boxes in parent,
+// nil if missing
+func findPath(r io.ReaderAt, parent *common.Box, path ...string) (*common.Box, error) {
+	for _, t := range path {
+		boxes, err := common.ReadBoxes(r, parent.DataOffset, parent.End())
+		if err != nil {
+			return nil, err
+		}
+		if parent = common.FindBox(boxes, t); parent == nil {
+			return nil, nil
+		}
+	}
+	return parent, nil
+}
+
+// readTrack reads the sample entry and the position of the sample of a
+// trak box, nil if the track has no sample table
+func readTrack(r io.ReaderAt, trak *common.Box) (*CR3Track, error) {
+	stbl, err := findPath(r, trak, "mdia", "minf", "stbl")
+	if err != nil || stbl == nil {
+		return nil, err
+	}
+	boxes, err := common.ReadBoxes(r, stbl.DataOffset, stbl.End())
+	if err != nil {
+		return nil, err
+	}
+	stsd, stsz := common.FindBox(boxes, "stsd"), common.FindBox(boxes, "stsz")
+	co64, stco := common.FindBox(boxes, "co64"), common.FindBox(boxes, "stco")
+	if stsd == nil || stsz == nil || (co64 == nil && stco == nil) {
+		return nil, nil
+	}
+
+	// version, flags and number of entries before the sample entries
+	entries, err := common.ReadBoxes(r, stsd.DataOffset+8, stsd.End())
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, common.NewFormatError(formatCR3, stsd.Offset, "track without sample entries")
+	}
+	entry := entries[0]
+	t := &CR3Track{Type: entry.Type}
+	if entry.Type == "CRAW" {
+		// a visual sample entry, the boxes after 82 bytes
+		head := make([]byte, 28)
+		if err := common.ReadFull(formatCR3, r, entry.DataOffset, head); err != nil {
+			return nil, err
+		}
+		t.Width, t.Height = int(binary.BigEndian.Uint16(head[24:])), int(binary.BigEndian.Uint16(head[26:]))
+		inner, err := common.ReadBoxes(r, entry.DataOffset+82, entry.End())
+		if err != nil {
+			return nil, err
+		}
+		if cmp1 := common.FindBox(inner, "CMP1"); cmp1 != nil {
+			content := make([]byte, cmp1.DataSize())
+			if err := common.ReadFull(formatCR3, r, cmp1.DataOffset, content); err != nil {
+				return nil, err
+			}
+			h, err := parseCrxHeader(content, cmp1.DataOffset)
+			if err != nil {
+				return nil, err
+			}
+			t.crx = &h
+		}
+	}
+
+	// sample size, 0 if in the table of sizes that follows the count
+	sizes := make([]byte, 16)
+	if err := common.ReadFull(formatCR3, r, stsz.DataOffset, sizes[:12]); err != nil {
+		return nil, err
+	}
+	t.Size = int64(binary.BigEndian.Uint32(sizes[4:]))
+	if t.Size == 0 {
+		if err := common.ReadFull(formatCR3, r, stsz.DataOffset+12, sizes[12:]); err != nil {
+			return nil, err
+		}
+		t.Size = int64(binary.BigEndian.Uint32(sizes[12:]))
+	}
+	if co64 != nil {
+		offset := make([]byte, 16)
+		if err := common.ReadFull(formatCR3, r, co64.DataOffset, offset); err != nil {
+			return nil, err
+		}
+		t.Offset = int64(binary.BigEndian.Uint64(offset[8:]))
+	} else {
+		offset := make([]byte, 12)
+		if err := common.ReadFull(formatCR3, r, stco.DataOffset, offset); err != nil {
+			return nil, err
+		}
+		t.Offset = int64(binary.BigEndian.Uint32(offset[8:]))
+	}
+	return t, nil
+}
+
+// DecodeCR3 reads the raw image of the CR3 file in r, size bytes long
+func DecodeCR3(r io.ReaderAt, size int64) (*common.RawImage, error) {
+	c, err := ReadCR3(r, size)
+	if err != nil {
+		return nil, err
+	}
+	track := c.RawTrack()
+	if track == nil {
+		return nil, common.NewFormatError(formatCR3, 0, "raw track not found")
+	}
+	data := make([]byte, track.Size)
+	if err := common.ReadFull(formatCR3, r, track.Offset, data); err != nil {
+		return nil, err
+	}
+	img, err := decodeCrx(*track.crx, data, track.Offset)
+	if err != nil {
+		return nil, err
+	}
+	metadata, makerNote := readMetadata(c.Ifd0)
+	applyMetadata(img, metadata, makerNote)
+	return img, nil
+}
+
+// DecodeCR3Config returns the size of the raw image of the CR3 file in r
+func DecodeCR3Config(r io.ReaderAt, size int64) (image.Config, error) {
+	c, err := ReadCR3(r, size)
+	if err != nil {
+		return image.Config{}, err
+	}
+	track := c.RawTrack()
+	if track == nil {
+		return image.Config{}, common.NewFormatError(formatCR3, 0, "raw track not found")
+	}
+	return image.Config{
+		ColorModel: color.Gray16Model,
+		Width:      track.crx.width,
+		Height:     track.crx.height,
+	}, nil
+}
+
+// findUUID returns the uuid box with the extended type, nil if not found
+func findUUID(boxes []common.Box, uuid [16]byte) *common.Box {
+	for i := range boxes {
+		if boxes[i].Type == "uuid" && boxes[i].UUID == uuid {
+			return &boxes[i]
+		}
+	}
+	return nil
+}
+
+// readCMT reads the first IFD of the TIFF in the box, nil if the box is
+// missing
+func readCMT(r io.ReaderAt, boxes []common.Box, name string) (*common.Ifd, error) {
+	box := common.FindBox(boxes, name)
+	if box == nil {
+		return nil, nil
+	}
+	reader, err := common.NewTiffReader(r, box.DataOffset)
+	if err != nil {
+		return nil, err
+	}
+	return reader.ReadIfd(reader.First)
+}
+
+// readCMTs reads the IFDs of the boxes CMT1-CMT4 and links them as in a CR2,
+// so that they can be read with readMetadata
+func readCMTs(r io.ReaderAt, boxes []common.Box) (*common.Ifd, error) {
+	var ifds [4]*common.Ifd
+	for i, name := range []string{"CMT1", "CMT2", "CMT3", "CMT4"} {
+		ifd, err := readCMT(r, boxes, name)
+		if err != nil {
+			return nil, err
+		}
+		ifds[i] = ifd
+	}
+	ifd0, exif, makerNote, gps := ifds[0], ifds[1], ifds[2], ifds[3]
+	if ifd0 == nil {
+		return nil, common.NewFormatError(formatCR3, 0, "CMT1 box not found")
+	}
+	if exif != nil {
+		if makerNote != nil {
+			exif.Entries = append(exif.Entries, common.IfdEntry{Tag: common.TagMakerNote, Type: common.TypeUndefined,
+				ValueOffset: makerNote.Offset, Value: []byte{}, Sub: []*common.Ifd{makerNote}})
+		}
+		ifd0.Entries = append(ifd0.Entries, common.IfdEntry{Tag: common.TagExifIFD, Type: common.TypeLong, Count: 1,
+			ValueOffset: exif.Offset, Value: []uint32{uint32(exif.Offset)}, Sub: []*common.Ifd{exif}})
+	}
+	if gps != nil {
+		ifd0.Entries = append(ifd0.Entries, common.IfdEntry{Tag: common.TagGPSIFD, Type: common.TypeLong, Count: 1,
+			ValueOffset: gps.Offset, Value: []uint32{uint32(gps.Offset)}, Sub: []*common.Ifd{gps}})
+	}
+	return ifd0, nil
+}
+
+// readCR3Jpeg reads the position of the JPEG in a THMB or PRVW box: width and
+// height at sizeAt, the length at lengthAt, then the JPEG data
+func readCR3Jpeg(r io.ReaderAt, box *common.Box, sizeAt, lengthAt int64) (Jpeg, error) {
+	header := make([]byte, lengthAt+4)
+	if err := common.ReadFull(formatCR3, r, box.DataOffset, header); err != nil {
+		return Jpeg{}, err
+	}
+	j := Jpeg{
+		Width:  int(binary.BigEndian.Uint16(header[sizeAt:])),
+		Height: int(binary.BigEndian.Uint16(header[sizeAt+2:])),
+		Length: int64(binary.BigEndian.Uint32(header[lengthAt:])),
+	}
+	// the JPEG starts after some bytes not known, look for the SOI marker
+	start := box.DataOffset + lengthAt + 4
+	head := make([]byte, 16)
+	if err := common.ReadFull(formatCR3, r, start, head); err != nil {
+		return Jpeg{}, err
+	}
+	for i := 0; i+1 < len(head); i++ {
+		if head[i] == 0xff && head[i+1] == 0xd8 {
+			j.Offset = start + int64(i)
+			if j.Length > box.End()-j.Offset {
+				return Jpeg{}, common.NewFormatError(formatCR3, j.Offset, "JPEG length %d outside of the %s box", j.Length, box.Type)
+			}
+			return j, nil
+		}
+	}
+	return Jpeg{}, common.NewFormatError(formatCR3, start, "JPEG not found in the %s box", box.Type)
+}
+
+// ReadCR3Metadata reads the EXIF of the CR3 file in r
+func ReadCR3Metadata(r io.ReaderAt) (common.Metadata, error) {
+	c, err := ReadCR3(r, math.MaxInt64)
+	if err != nil {
+		return common.Metadata{}, err
+	}
+	m, _ := readMetadata(c.Ifd0)
+	return m, nil
+}
+
+// saveCR3Jpeg copies the JPEG to filename
+func saveCR3Jpeg(r io.ReaderAt, j Jpeg, filename string) error {
+	data := make([]byte, j.Length)
+	if err := common.ReadFull(formatCR3, r, j.Offset, data); err != nil {
+		return err
+	}
+	log.Printf("Saving JPEG %s", filename)
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+// ProcessCR3 shows the informations of the CR3 file and extracts the JPEGs
+func ProcessCR3(r io.ReaderAt, size int64, rawfile string) error {
+	c, err := ReadCR3(r, size)
+	if err != nil {
+		return err
+	}
+	metadata, makerNote := readMetadata(c.Ifd0)
+	if *common.ShowInfo {
+		for _, b := range c.Boxes {
+			log.Printf("box %s at %d, %d bytes", b.Type, b.Offset, b.Size)
+		}
+		for _, t := range c.Tracks {
+			log.Printf("track %s %dx%d at %d, %d bytes, raw %v", t.Type, t.Width, t.Height, t.Offset, t.Size, t.IsRaw())
+		}
+		log.Printf("Metadata %+v", metadata)
+		log.Printf("MakerNote %+v", makerNote)
+	}
+	if *common.ExtractJpegs {
+		if c.Preview.Length > 0 {
+			if err := saveCR3Jpeg(r, c.Preview, strings.TrimSuffix(rawfile, ".CR3")+"_0.jpeg"); err != nil {
+				return err
+			}
+		}
+		if c.Thumbnail.Length > 0 {
+			if err := saveCR3Jpeg(r, c.Thumbnail, strings.TrimSuffix(rawfile, ".CR3")+"_1.jpeg"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package canon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/enricod/rawmgr/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func cr3Box(typ string, content ...[]byte) []byte {
+	b := make([]byte, 8)
+	copy(b[4:], typ)
+	for _, c := range content {
+		b = append(b, c...)
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+// testCR3 builds a CR3 with the metadata boxes and the JPEGs, without tracks
+func testCR3() []byte {
+	jpeg := []byte{0xff, 0xd8, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 0xff, 0xd9}
+	exposure := make([]byte, 8)
+	binary.LittleEndian.PutUint32(exposure, 1)
+	binary.LittleEndian.PutUint32(exposure[4:], 250)
+
+	cmt1 := testTiff([][]testEntry{{
+		{tag: 0x010f, typ: 2, count: 6, data: []byte("Canon\x00")},
+		{tag: 0x0110, typ: 2, count: 4, value: 0x00003552}, // "R5"
+	}})
+	cmt2 := testTiff([][]testEntry{{{tag: 0x829a, typ: 5, count: 1, data: exposure}}})
+	cmt3 := testTiff([][]testEntry{{{tag: 0x0010, typ: 4, count: 1, value: 0x80000421}}})
+	thmb := []byte{0, 0, 0, 0, 0, 160, 0, 120, 0, 0, 0, byte(len(jpeg)), 0, 1, 0, 0}
+	prvw := []byte{0, 0, 0, 0, 0, 1, 6, 84, 4, 56, 0, 1, 0, 0, 0, byte(len(jpeg))}
+
+	canonBox := cr3Box("uuid", cr3CanonUUID[:], cr3Box("CNCV", []byte("CanonCR3_001/00.09.00/00.00.00")),
+		cr3Box("CMT1", cmt1), cr3Box("CMT2", cmt2), cr3Box("CMT3", cmt3), cr3Box("THMB", thmb, jpeg))
+	data := cr3Box("ftyp", []byte("crx \x00\x00\x00\x01crx isom"))
+	data = append(data, cr3Box("moov", canonBox, cr3Box("trak"))...)
+	data = append(data, cr3Box("uuid", cr3PreviewUUID[:], make([]byte, 8), cr3Box("PRVW", prvw, jpeg))...)
+	return append(data, cr3Box("mdat", make([]byte, 32))...)
+}
+
+func TestReadCR3(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	data := testCR3()
+	assert.True(IsCR3(data))
+	assert.False(IsCR3(testCR2(nil, rawSlice{})))
+
+	c, err := ReadCR3(bytes.NewReader(data), int64(len(data)))
+	require.Nil(err)
+	assert.Len(c.Boxes, 4)
+	assert.Len(c.Moov, 2)
+	assert.Len(c.Canon, 5)
+
+	assert.Equal(160, c.Thumbnail.Width)
+	assert.Equal(120, c.Thumbnail.Height)
+	assert.Equal(1620, c.Preview.Width)
+	assert.Equal(1080, c.Preview.Height)
+	for _, j := range []Jpeg{c.Thumbnail, c.Preview} {
+		require.Equal(int64(16), j.Length)
+		assert.Equal([]byte{0xff, 0xd8}, data[j.Offset:j.Offset+2])
+		assert.Equal([]byte{0xff, 0xd9}, data[j.Offset+14:j.Offset+16])
+	}
+
+	m, mn := readMetadata(c.Ifd0)
+	assert.Equal("Canon", m.Make)
+	assert.Equal("R5", m.Model)
+	assert.Equal(0.004, m.ExposureTime)
+	require.NotNil(mn)
+	assert.Equal(uint32(0x80000421), mn.ModelID)
+
+	m, err = ReadCR3Metadata(bytes.NewReader(data))
+	require.Nil(err)
+	assert.Equal("R5", m.Model)
+
+	// the CMT1 box is needed
+	broken := bytes.Replace(data, []byte("CMT1"), []byte("CMTX"), 1)
+	_, err = ReadCR3(bytes.NewReader(broken), int64(len(broken)))
+	var fe *common.FormatError
+	assert.True(errors.As(err, &fe))
+
+	_, err = ReadCR3(bytes.NewReader(data[:100]), 100)
+	assert.NotNil(err)
+}
@@ -0,0 +1,596 @@
+package canon
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/enricod/rawmgr/common"
+)
+
+// formatCRW format name used in the errors of the CRW files
+const formatCRW = "CRW"
+
+// CIFF record types used, as in dcraw parse_ciff
+const (
+	ciffMakeModel    = 0x080a
+	ciffShotInfo     = 0x102a
+	ciffColorBalance = 0x10a9
+	ciffSensorInfo   = 0x1031
+	ciffCapturedTime = 0x180e
+	ciffImageInfo    = 0x1810
+	ciffExposureInfo = 0x1818
+	ciffDecoderTable = 0x1835
+	ciffRawData      = 0x2005
+	ciffJpegImage    = 0x2007
+	ciffFocalLength  = 0x5029
+)
+
+const (
+	// ciffMaxDepth and ciffMaxRecords limits of the heaps read
+	ciffMaxDepth   = 8
+	ciffMaxRecords = 127
+	// crwLowBitsScanEnd end of the bytes checked for the low bits, from the
+	// start of the file
+	crwLowBitsScanEnd = 0x4000
+	crwHeaderLength   = 26
+)
+
+// CiffRecord a record of a CIFF heap
+type CiffRecord struct {
+	Type uint16
+	// Offset and Length of the data in the file. The records with the data
+	// in the table (types 0x4000-0x7fff) have the 8 bytes after the type
+	Offset int64
+	Length int64
+	// Sub records of the heaps, types 0x28xx and 0x30xx
+	Sub []CiffRecord
+}
+
+// CRW records of a CRW file, the CIFF heap of the EOS D30 to 300D
+type CRW struct {
+	Order binary.ByteOrder
+	// Records of the root heap
+	Records  []CiffRecord
+	Metadata common.Metadata
+	// Preview JPEG of the record 0x2007, zero if missing
+	Preview Jpeg
+	// RawOffset and RawLength of the record 0x2005: the low bits of the
+	// pixels, if stored, then the compressed data
+	RawOffset int64
+	RawLength int64
+	// DecoderTable Huffman tables of the compressed data, 0-2
+	DecoderTable int
+	// SensorInfo size and borders of the raw image, nil if missing
+	SensorInfo *SensorInfo
+	// WB white balance levels of the shot, R G G B, zero if not known
+	WB [4]int
+}
+
+// IsCRW reports whether head, the first bytes of a file, is of a CRW
+func IsCRW(head []byte) bool {
+	return len(head) >= 14 && (string(head[:2]) == "II" || string(head[:2]) == "MM") &&
+		string(head[6:14]) == "HEAPCCDR"
+}
+
+// ReadCRW reads the records of the CRW file in r, size bytes long
+func ReadCRW(r io.ReaderAt, size int64) (*CRW, error) {
+	head := make([]byte, 14)
+	if err := common.ReadFull(formatCRW, r, 0, head); err != nil {
+		return nil, err
+	}
+	if !IsCRW(head) {
+		return nil, common.NewFormatError(formatCRW, 0, "HEAPCCDR header not found")
+	}
+	c := &CRW{Order: binary.LittleEndian}
+	if head[0] == 'M' {
+		c.Order = binary.BigEndian
+	}
+	hlen := int64(c.Order.Uint32(head[2:]))
+	if hlen < int64(len(head)) || hlen >= size {
+		return nil, common.NewFormatError(formatCRW, 2, "header length %d outside of the file", hlen)
+	}
+	var err error
+	if c.Records, err = c.readHeap(r, hlen, size-hlen, 0); err != nil {
+		return nil, err
+	}
+	wbi := -1
+	if err := c.readRecords(r, c.Records, &wbi); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readHeap reads the table of the heap at offset: the records count then
+// 10 bytes for each record, at the offset stored in the last 4 bytes
+func (c *CRW) readHeap(r io.ReaderAt, offset, length int64, depth int) ([]CiffRecord, error) {
+	if length < 4 {
+		return nil, common.NewFormatError(formatCRW, offset, "heap of %d bytes", length)
+	}
+	b := make([]byte, 4)
+	if err := common.ReadFull(formatCRW, r, offset+length-4, b); err != nil {
+		return nil, err
+	}
+	table := offset + int64(c.Order.Uint32(b))
+	if table < offset || table+2 > offset+length-4 {
+		return nil, common.NewFormatError(formatCRW, offset+length-4, "table at %d outside of the heap", table)
+	}
+	if err := common.ReadFull(formatCRW, r, table, b[:2]); err != nil {
+		return nil, err
+	}
+	count := int(c.Order.Uint16(b))
+	if count > ciffMaxRecords {
+		return nil, common.NewFormatError(formatCRW, table, "%d records", count)
+	}
+	entries := make([]byte, 10*count)
+	if err := common.ReadFull(formatCRW, r, table+2, entries); err != nil {
+		return nil, err
+	}
+	records := make([]CiffRecord, count)
+	for i := range records {
+		e := entries[10*i:]
+		rec := &records[i]
+		rec.Type = c.Order.Uint16(e)
+		if rec.Type&0xc000 == 0x4000 {
+			rec.Offset, rec.Length = table+2+int64(10*i)+2, 8
+			continue
+		}
+		rec.Length = int64(c.Order.Uint32(e[2:]))
+		rec.Offset = offset + int64(c.Order.Uint32(e[6:]))
+		if rec.Offset < offset || rec.Length > offset+length-rec.Offset {
+			return nil, common.NewFormatError(formatCRW, table+2+int64(10*i),
+				"record 0x%04x at %d, %d bytes, outside of the heap", rec.Type, rec.Offset, rec.Length)
+		}
+		if rec.Type>>8 == 0x28 || rec.Type>>8 == 0x30 {
+			if depth+1 >= ciffMaxDepth {
+				return nil, common.NewFormatError(formatCRW, rec.Offset, "heaps nested too deep")
+			}
+			sub, err := c.readHeap(r, rec.Offset, rec.Length, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			rec.Sub = sub
+		}
+	}
+	return records, nil
+}
+
+// readRecords reads the values of the records known, wbi is the white
+// balance index of the shot info, used by the color balance that follows
+func (c *CRW) readRecords(r io.ReaderAt, records []CiffRecord, wbi *int) error {
+	for i := range records {
+		rec := &records[i]
+		if rec.Sub != nil {
+			if err := c.readRecords(r, rec.Sub, wbi); err != nil {
+				return err
+			}
+			continue
+		}
+		switch rec.Type {
+		case ciffRawData:
+			c.RawOffset, c.RawLength = rec.Offset, rec.Length
+			continue
+		case ciffJpegImage:
+			c.Preview = Jpeg{Offset: rec.Offset, Length: rec.Length}
+			continue
+		case ciffMakeModel, ciffShotInfo, ciffColorBalance, ciffSensorInfo, ciffCapturedTime,
+			ciffImageInfo, ciffExposureInfo, ciffDecoderTable, ciffFocalLength:
+		default:
+			continue
+		}
+		if rec.Length > 1024 {
+			return common.NewFormatError(formatCRW, rec.Offset, "record 0x%04x of %d bytes", rec.Type, rec.Length)
+		}
+		data := make([]byte, rec.Length)
+		if err := common.ReadFull(formatCRW, r, rec.Offset, data); err != nil {
+			return err
+		}
+		c.readRecord(rec.Type, data, wbi)
+	}
+	return nil
+}
+
+// readRecord sets the values of a record, the records too short are
+// ignored
+func (c *CRW) readRecord(typ uint16, data []byte, wbi *int) {
+	m := &c.Metadata
+	u32 := func(i int) uint32 {
+		if i+4 > len(data) {
+			return 0
+		}
+		return c.Order.Uint32(data[i:])
+	}
+	f32 := func(i int) float64 { return float64(math.Float32frombits(u32(i))) }
+	shorts := make([]int16, len(data)/2)
+	for i := range shorts {
+		shorts[i] = int16(c.Order.Uint16(data[2*i:]))
+	}
+
+	switch typ {
+	case ciffMakeModel:
+		fields := strings.SplitN(string(data), "\x00", 3)
+		if len(fields) >= 2 {
+			m.Make, m.Model = strings.TrimSpace(fields[0]), strings.TrimSpace(fields[1])
+		}
+	case ciffImageInfo:
+		if len(data) >= 16 {
+			switch int32(u32(12)) {
+			case 90:
+				m.Orientation = 6
+			case 180:
+				m.Orientation = 3
+			case 270, -90:
+				m.Orientation = 8
+			default:
+				m.Orientation = 1
+			}
+		}
+	case ciffDecoderTable:
+		c.DecoderTable = int(u32(0))
+	case ciffExposureInfo:
+		if len(data) >= 12 {
+			m.ExposureTime = math.Pow(2, -f32(4))
+			m.FNumber = math.Pow(2, f32(8)/2)
+		}
+	case ciffShotInfo:
+		if len(shorts) < 8 {
+			return
+		}
+		m.ISO = int(math.Round(math.Pow(2, float64(uint16(shorts[2]))/32-4) * 50))
+		m.FNumber = math.Pow(2, float64(shorts[4])/64)
+		m.ExposureTime = math.Pow(2, -float64(shorts[5])/32)
+		if m.ExposureTime > 1e6 && len(shorts) > 24 {
+			m.ExposureTime = float64(uint16(shorts[24])) / 10
+		}
+		if *wbi = int(uint16(shorts[7])); *wbi > 17 {
+			*wbi = 0
+		}
+	case ciffColorBalance:
+		// D60, 10D and 300D: the levels of each white balance, R G G B
+		i := *wbi
+		if len(data) > 66 && i >= 0 && i < 10 {
+			i = int("0134567028"[i] - '0')
+		}
+		if i < 0 || 1+4*i+4 > len(shorts) {
+			return
+		}
+		for k := range c.WB {
+			c.WB[k] = int(uint16(shorts[1+4*i+k]))
+		}
+	case ciffSensorInfo:
+		c.SensorInfo = readSensorInfo(shorts)
+	case ciffFocalLength:
+		v := u32(0)
+		m.FocalLength = float64(v >> 16)
+		if v&0xffff == 2 {
+			m.FocalLength /= 32
+		}
+	case ciffCapturedTime:
+		m.CaptureTime = time.Unix(int64(u32(0)), 0).UTC()
+	}
+}
+
+// crwFirstTree and crwSecondTree Huffman tables of the compressed raw data,
+// the first for the DC coefficient of the blocks and the second for the
+// others. Code counts for the lengths 1-16, then the values (dcraw
+// crw_init_tables)
+var crwFirstTree = [3][29]byte{
+	{
+		0, 1, 4, 2, 3, 1, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0x04, 0x03, 0x05, 0x06, 0x02, 0x07, 0x01, 0x08, 0x09, 0x00, 0x0a, 0x0b, 0xff,
+	},
+	{
+		0, 2, 2, 3, 1, 1, 1, 1, 2, 0, 0, 0, 0, 0, 0, 0,
+		0x03, 0x02, 0x04, 0x01, 0x05, 0x00, 0x06, 0x07, 0x09, 0x08, 0x0a, 0x0b, 0xff,
+	},
+	{
+		0, 0, 6, 3, 1, 1, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0x06, 0x05, 0x07, 0x04, 0x08, 0x03, 0x09, 0x02, 0x00, 0x0a, 0x01, 0x0b, 0xff,
+	},
+}
+
+var crwSecondTree = [3][180]byte{
+	{
+		0, 2, 2, 2, 1, 4, 2, 1, 2, 5, 1, 1, 0, 0, 0, 139,
+		0x03, 0x04, 0x02, 0x05, 0x01, 0x06, 0x07, 0x08,
+		0x12, 0x13, 0x11, 0x14, 0x09, 0x15, 0x22, 0x00, 0x21, 0x16, 0x0a, 0xf0,
+		0x23, 0x17, 0x24, 0x31, 0x32, 0x18, 0x19, 0x33, 0x25, 0x41, 0x34, 0x42,
+		0x35, 0x51, 0x36, 0x37, 0x38, 0x29, 0x79, 0x26, 0x1a, 0x39, 0x56, 0x57,
+		0x28, 0x27, 0x52, 0x55, 0x58, 0x43, 0x76, 0x59, 0x77, 0x54, 0x61, 0xf9,
+		0x71, 0x78, 0x75, 0x96, 0x97, 0x49, 0xb7, 0x53, 0xd7, 0x74, 0xb6, 0x98,
+		0x47, 0x48, 0x95, 0x69, 0x99, 0x91, 0xfa, 0xb8, 0x68, 0xb5, 0xb9, 0xd6,
+		0xf7, 0xd8, 0x67, 0x46, 0x45, 0x94, 0x89, 0xf8, 0x81, 0xd5, 0xf6, 0xb4,
+		0x88, 0xb1, 0x2a, 0x44, 0x72, 0xd9, 0x87, 0x66, 0xd4, 0xf5, 0x3a, 0xa7,
+		0x73, 0xa9, 0xa8, 0x86, 0x62, 0xc7, 0x65, 0xc8, 0xc9, 0xa1, 0xf4, 0xd1,
+		0xe9, 0x5a, 0x92, 0x85, 0xa6, 0xe7, 0x93, 0xe8, 0xc1, 0xc6, 0x7a, 0x64,
+		0xe1, 0x4a, 0x6a, 0xe6, 0xb3, 0xf1, 0xd3, 0xa5, 0x8a, 0xb2, 0x9a, 0xba,
+		0x84, 0xa4, 0x63, 0xe5, 0xc5, 0xf3, 0xd2, 0xc4, 0x82, 0xaa, 0xda, 0xe4,
+		0xf2, 0xca, 0x83, 0xa3, 0xa2, 0xc3, 0xea, 0xc2, 0xe2, 0xe3, 0xff, 0xff,
+	},
+	{
+		0, 2, 2, 1, 4, 1, 4, 1, 3, 3, 1, 0, 0, 0, 0, 140,
+		0x02, 0x03, 0x01, 0x04, 0x05, 0x12, 0x11, 0x06,
+		0x13, 0x07, 0x08, 0x14, 0x22, 0x09, 0x21, 0x00, 0x23, 0x15, 0x31, 0x32,
+		0x0a, 0x16, 0xf0, 0x24, 0x33, 0x41, 0x42, 0x19, 0x17, 0x25, 0x18, 0x51,
+		0x34, 0x43, 0x52, 0x29, 0x35, 0x61, 0x39, 0x71, 0x62, 0x36, 0x53, 0x26,
+		0x38, 0x1a, 0x37, 0x81, 0x27, 0x91, 0x79, 0x55, 0x45, 0x28, 0x72, 0x59,
+		0xa1, 0xb1, 0x44, 0x69, 0x54, 0x58, 0xd1, 0xfa, 0x57, 0xe1, 0xf1, 0xb9,
+		0x49, 0x47, 0x63, 0x6a, 0xf9, 0x56, 0x46, 0xa8, 0x2a, 0x4a, 0x78, 0x99,
+		0x3a, 0x75, 0x74, 0x86, 0x65, 0xc1, 0x76, 0xb6, 0x96, 0xd6, 0x89, 0x85,
+		0xc9, 0xf5, 0x95, 0xb4, 0xc7, 0xf7, 0x8a, 0x97, 0xb8, 0x73, 0xb7, 0xd8,
+		0xd9, 0x87, 0xa7, 0x7a, 0x48, 0x82, 0x84, 0xea, 0xf4, 0xa6, 0xc5, 0x5a,
+		0x94, 0xa4, 0xc6, 0x92, 0xc3, 0x68, 0xb5, 0xc8, 0xe4, 0xe5, 0xe6, 0xe9,
+		0xa2, 0xa3, 0xe3, 0xc2, 0x66, 0x67, 0x93, 0xaa, 0xd4, 0xd5, 0xe7, 0xf8,
+		0x88, 0x9a, 0xd7, 0x77, 0xc4, 0x64, 0xe2, 0x98, 0xa5, 0xca, 0xda, 0xe8,
+		0xf3, 0xf6, 0xa9, 0xb2, 0xb3, 0xf2, 0xd2, 0x83, 0xba, 0xd3, 0xff, 0xff,
+	},
+	{
+		0, 0, 6, 2, 1, 3, 3, 2, 5, 1, 2, 2, 8, 10, 0, 117,
+		0x04, 0x05, 0x03, 0x06, 0x02, 0x07, 0x01, 0x08,
+		0x09, 0x12, 0x13, 0x14, 0x11, 0x15, 0x0a, 0x16, 0x17, 0xf0, 0x00, 0x22,
+		0x21, 0x18, 0x23, 0x19, 0x24, 0x32, 0x31, 0x25, 0x33, 0x38, 0x37, 0x34,
+		0x35, 0x36, 0x39, 0x79, 0x57, 0x58, 0x59, 0x28, 0x56, 0x78, 0x27, 0x41,
+		0x29, 0x77, 0x26, 0x42, 0x76, 0x99, 0x1a, 0x55, 0x98, 0x97, 0xf9, 0x48,
+		0x54, 0x96, 0x89, 0x47, 0xb7, 0x49, 0xfa, 0x75, 0x68, 0xb6, 0x67, 0x69,
+		0xb9, 0xb8, 0xd8, 0x52, 0xd7, 0x88, 0xb5, 0x74, 0x51, 0x46, 0xd9, 0xf8,
+		0x3a, 0xd6, 0x87, 0x45, 0x7a, 0x95, 0xd5, 0xf6, 0x86, 0xb4, 0xa9, 0x94,
+		0x53, 0x2a, 0xa8, 0x43, 0xf5, 0xf7, 0xd4, 0x66, 0xa7, 0x5a, 0x44, 0x8a,
+		0xc9, 0xe8, 0xc8, 0xe7, 0x9a, 0x6a, 0x73, 0x4a, 0x61, 0xc7, 0xf4, 0xc6,
+		0x65, 0xe9, 0x72, 0xe6, 0x71, 0x91, 0x93, 0xa6, 0xda, 0x92, 0x85, 0x62,
+		0xf3, 0xc5, 0xb2, 0xa4, 0x84, 0xba, 0x64, 0xa5, 0xb3, 0xd2, 0x81, 0xe5,
+		0xd3, 0xaa, 0xc4, 0xca, 0xf2, 0xb1, 0xe4, 0xd1, 0x83, 0x63, 0xea, 0xc3,
+		0xe2, 0x82, 0xf1, 0xa3, 0xc2, 0xa1, 0xc1, 0xe3, 0xa2, 0xe1, 0xff, 0xff,
+	},
+}
+
+// crwTables returns the Huffman tables of the decoder table
+func crwTables(table int) (first, second *common.HuffTable, err error) {
+	if table < 0 || table > 2 {
+		table = 2
+	}
+	items, _ := common.GetHuffItems(crwFirstTree[table][:], 0)
+	if first, err = common.NewHuffTable(items); err != nil {
+		return nil, nil, err
+	}
+	items, _ = common.GetHuffItems(crwSecondTree[table][:], 0)
+	if second, err = common.NewHuffTable(items); err != nil {
+		return nil, nil, err
+	}
+	return first, second, nil
+}
+
+// crwHasLowBits reports whether the raw data starts with the 2 low bits of
+// the pixels, uncompressed. In the compressed data 0xff is always followed
+// by 0x00 (dcraw canon_has_lowbits)
+func crwHasLowBits(data []byte) bool {
+	end := crwLowBitsScanEnd - crwHeaderLength
+	if end > len(data) {
+		end = len(data)
+	}
+	lowBits := true
+	for i := 514; i < end-1; i++ {
+		if data[i] == 0xff {
+			if data[i+1] != 0 {
+				return true
+			}
+			lowBits = false
+		}
+	}
+	return lowBits
+}
+
+// decodeCRWRaw decodes the raw data of the record 0x2005, returning the
+// pixels and the white level. The compressed data are blocks of 64 pixels
+// coded as differences, the first with the first tree and the others with
+// the second as the AC coefficients of a JPEG: a run of zeros in the high
+// nibble and the length of the difference in the low one
+func decodeCRWRaw(data []byte, width, height, table int, offset int64) ([]uint16, uint16, error) {
+	if width <= 0 || height <= 0 || width*height%64 != 0 {
+		return nil, 0, common.NewFormatError(formatCRW, offset, "raw size %dx%d not supported", width, height)
+	}
+	first, second, err := crwTables(table)
+	if err != nil {
+		return nil, 0, err
+	}
+	lowBits := crwHasLowBits(data)
+	white := uint16(0x3ff)
+	start := 514
+	if lowBits {
+		white = 0xfff
+		start += width * height / 4
+	}
+	if start > len(data) {
+		return nil, 0, common.NewFormatError(formatCRW, offset, "raw data of %d bytes, %d before the compressed data", len(data), start)
+	}
+	br := common.NewBitReader(data[start:])
+
+	pix := make([]uint16, width*height)
+	var base [2]int
+	carry, pnum := 0, 0
+	for row := 0; row < height; row += 8 {
+		rows := height - row
+		if rows > 8 {
+			rows = 8
+		}
+		pixel := pix[row*width : (row+rows)*width]
+		for block := 0; block < len(pixel)>>6; block++ {
+			var diffs [64]int
+			for i := 0; i < 64; i++ {
+				tree := second
+				if i == 0 {
+					tree = first
+				}
+				leaf, err := tree.Decode(br)
+				if err != nil {
+					return nil, 0, common.WrapFormatError(formatCRW, offset+int64(start+br.Pos()), err, "block %d of row %d", block, row)
+				}
+				if leaf == 0 && i > 0 {
+					break
+				}
+				if leaf == 0xff {
+					continue
+				}
+				i += int(leaf >> 4)
+				diff := int(br.ReadDiff(uint(leaf & 15)))
+				if i < 64 {
+					diffs[i] = diff
+				}
+			}
+			diffs[0] += carry
+			carry = diffs[0]
+			for i, diff := range diffs {
+				if pnum%width == 0 {
+					base[0], base[1] = 512, 512
+				}
+				pnum++
+				base[i&1] += diff
+				if base[i&1]>>10 != 0 {
+					return nil, 0, common.NewFormatError(formatCRW, offset+int64(start+br.Pos()),
+						"value %d out of range in block %d of row %d", base[i&1], block, row)
+				}
+				pixel[block<<6+i] = uint16(base[i&1])
+			}
+		}
+		if err := br.Err(); err != nil {
+			return nil, 0, common.WrapFormatError(formatCRW, offset+int64(start), err, "row %d", row)
+		}
+		if lowBits {
+			// 4 pixels for each byte, from the least significant bits
+			low := data[row*width/4 : (row+rows)*width/4]
+			for i, c := range low {
+				for k := 0; k < 4; k++ {
+					p := &pixel[4*i+k]
+					v := *p<<2 | uint16(c>>(2*k)&3)
+					if width == 2672 && v < 512 {
+						v += 2
+					}
+					*p = v
+				}
+			}
+		}
+	}
+	return pix, white, nil
+}
+
+// DecodeCRW reads the raw image of the CRW file in r, size bytes long
+func DecodeCRW(r io.ReaderAt, size int64) (*common.RawImage, error) {
+	c, err := ReadCRW(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if c.RawLength == 0 {
+		return nil, common.NewFormatError(formatCRW, 0, "raw data not found")
+	}
+	if c.SensorInfo == nil {
+		return nil, common.NewFormatError(formatCRW, 0, "sensor info not found")
+	}
+	data := make([]byte, c.RawLength)
+	if err := common.ReadFull(formatCRW, r, c.RawOffset, data); err != nil {
+		return nil, err
+	}
+	width, height := c.SensorInfo.Width, c.SensorInfo.Height
+	pix, white, err := decodeCRWRaw(data, width, height, c.DecoderTable, c.RawOffset)
+	if err != nil {
+		return nil, err
+	}
+	img := &common.RawImage{
+		Width:      width,
+		Height:     height,
+		Pix:        pix,
+		CFA:        common.CFARGGB,
+		Colors:     1,
+		WhiteLevel: white,
+	}
+	img.WBMultipliers = WBPreset{Levels: c.WB}.Multipliers()
+	applySensorInfo(img, c.SensorInfo)
+	img.Metadata = c.Metadata
+	img.Orientation = c.Metadata.Orientation
+	if img.Orientation == 0 {
+		img.Orientation = 1
+	}
+	return img, nil
+}
+
+// DecodeCRWConfig returns the size of the raw image of the CRW file in r
+func DecodeCRWConfig(r io.ReaderAt, size int64) (image.Config, error) {
+	c, err := ReadCRW(r, size)
+	if err != nil {
+		return image.Config{}, err
+	}
+	if c.SensorInfo == nil {
+		return image.Config{}, common.NewFormatError(formatCRW, 0, "sensor info not found")
+	}
+	return image.Config{
+		ColorModel: color.Gray16Model,
+		Width:      c.SensorInfo.Width,
+		Height:     c.SensorInfo.Height,
+	}, nil
+}
+
+// readerSize returns the size of r, if it knows it
+func readerSize(r io.ReaderAt) (int64, error) {
+	switch s := r.(type) {
+	case interface{ Size() int64 }:
+		return s.Size(), nil
+	case interface{ Stat() (os.FileInfo, error) }:
+		info, err := s.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+	return 0, common.NewFormatError(formatCRW, 0, "size of the file not known")
+}
+
+// ReadCRWMetadata reads the metadata of the CRW file in r, that must know
+// its size as the records table is at the end of the file
+func ReadCRWMetadata(r io.ReaderAt) (common.Metadata, error) {
+	size, err := readerSize(r)
+	if err != nil {
+		return common.Metadata{}, err
+	}
+	c, err := ReadCRW(r, size)
+	if err != nil {
+		return common.Metadata{}, err
+	}
+	return c.Metadata, nil
+}
+
+// dumpCiffRecords logs the records, indented by the heap depth
+func dumpCiffRecords(records []CiffRecord, depth int) {
+	for _, rec := range records {
+		log.Printf("%srecord 0x%04x at %d, %d bytes", common.NSpaces(depth), rec.Type, rec.Offset, rec.Length)
+		dumpCiffRecords(rec.Sub, depth+1)
+	}
+}
+
+// ProcessCRW shows the informations of the CRW file and extracts the JPEG
+func ProcessCRW(r io.ReaderAt, size int64, rawfile string) error {
+	c, err := ReadCRW(r, size)
+	if err != nil {
+		return err
+	}
+	if *common.ShowInfo {
+		dumpCiffRecords(c.Records, 0)
+		log.Printf("Metadata %+v", c.Metadata)
+		log.Printf("SensorInfo %+v", c.SensorInfo)
+		log.Printf("WB %v, decoder table %d", c.WB, c.DecoderTable)
+	}
+	if *common.ExtractJpegs && c.Preview.Length > 0 {
+		data := make([]byte, c.Preview.Length)
+		if err := common.ReadFull(formatCRW, r, c.Preview.Offset, data); err != nil {
+			return err
+		}
+		filename := strings.TrimSuffix(rawfile, ".CRW") + "_0.jpeg"
+		log.Printf("Saving JPEG %s", filename)
+		return ioutil.WriteFile(filename, data, 0644)
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package canon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/enricod/rawmgr/common/commontest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCiff struct {
+	typ  uint16
+	data []byte
+	sub  []testCiff
+}
+
+// testHeap builds a CIFF heap: the data of the records, the table and its
+// offset
+func testHeap(records []testCiff) []byte {
+	var data, table []byte
+	table = append(table, byte(len(records)), 0)
+	for _, r := range records {
+		entry := make([]byte, 10)
+		binary.LittleEndian.PutUint16(entry, r.typ)
+		if r.typ&0xc000 == 0x4000 {
+			copy(entry[2:], r.data)
+		} else {
+			content := r.data
+			if r.sub != nil {
+				content = testHeap(r.sub)
+			}
+			binary.LittleEndian.PutUint32(entry[2:], uint32(len(content)))
+			binary.LittleEndian.PutUint32(entry[6:], uint32(len(data)))
+			data = append(data, content...)
+		}
+		table = append(table, entry...)
+	}
+	offset := make([]byte, 4)
+	binary.LittleEndian.PutUint32(offset, uint32(len(data)))
+	return append(append(data, table...), offset...)
+}
+
+func testShorts(v ...int) []byte {
+	b := make([]byte, 2*len(v))
+	for i, s := range v {
+		binary.LittleEndian.PutUint16(b[2*i:], uint16(s))
+	}
+	return b
+}
+
+// crwCodes canonical Huffman codes of the values of a tree, code and length
+func crwCodes(tree []byte) map[byte][2]uint {
+	codes := map[byte][2]uint{}
+	code, k := uint(0), 16
+	for l := uint(1); l <= 16; l++ {
+		for n := 0; n < int(tree[l-1]); n++ {
+			if _, ok := codes[tree[k]]; !ok {
+				codes[tree[k]] = [2]uint{code, l}
+			}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+// encodeCRWRaw codes the 10 bits values as the camera, with table 0
+func encodeCRWRaw(hi []int, width int) []byte {
+	first, second := crwCodes(crwFirstTree[0][:]), crwCodes(crwSecondTree[0][:])
+	bw := &commontest.BitWriter{Stuff: true}
+	emit := func(codes map[byte][2]uint, leaf byte, v int) {
+		c, ok := codes[leaf]
+		if !ok {
+			panic("leaf not in the tree")
+		}
+		bw.Write(uint64(c[0]), c[1])
+		if n := uint(leaf & 15); n > 0 {
+			if v < 0 {
+				v += 1<<n - 1
+			}
+			bw.Write(uint64(v), n)
+		}
+	}
+	length := func(v int) byte {
+		if v < 0 {
+			v = -v
+		}
+		n := byte(0)
+		for ; v > 0; v >>= 1 {
+			n++
+		}
+		return n
+	}
+
+	var base [2]int
+	carry := 0
+	for start := 0; start < len(hi); start += 64 {
+		var diffs [64]int
+		for i := range diffs {
+			if (start+i)%width == 0 {
+				base = [2]int{512, 512}
+			}
+			diffs[i] = hi[start+i] - base[i&1]
+			base[i&1] = hi[start+i]
+		}
+		dc := diffs[0] - carry
+		carry = diffs[0]
+		emit(first, length(dc), dc)
+		run := 0
+		for i := 1; i < 64; i++ {
+			if diffs[i] == 0 {
+				run++
+				continue
+			}
+			for ; run >= 16; run -= 16 {
+				emit(second, 0xf0, 0)
+			}
+			emit(second, byte(run<<4)|length(diffs[i]), diffs[i])
+			run = 0
+		}
+		if run > 0 {
+			emit(second, 0, 0)
+		}
+	}
+	return append(bw.Bytes(), 0, 0, 0, 0)
+}
+
+// testCRW builds a CRW of a 10D with the raw data of width x height pixels,
+// returning the pixels
+func testCRW(width, height int) ([]byte, []uint16) {
+	hi := make([]int, width*height)
+	low := make([]byte, width*height/4)
+	pix := make([]uint16, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			hi[i] = 300 + (x*37+y*11)%300
+			if x >= 20 && x < 44 {
+				// flat areas, coded as runs
+				hi[i] = 400
+			}
+			lo := 3
+			if y%2 == 1 {
+				lo = x & 3
+			}
+			low[i/4] |= byte(lo << uint(2*(i%4)))
+			pix[i] = uint16(hi[i]<<2 | lo)
+		}
+	}
+	raw := append(append(low, make([]byte, 514)...), encodeCRWRaw(hi, width)...)
+
+	imageInfo := make([]byte, 16)
+	binary.LittleEndian.PutUint32(imageInfo[12:], 90)
+	focal := make([]byte, 8)
+	binary.LittleEndian.PutUint32(focal, 50<<16)
+	captured := make([]byte, 12)
+	binary.LittleEndian.PutUint32(captured, 1072915200)
+	wb := testShorts(0, 1, 1, 1, 1, 2000, 1000, 1000, 1500)
+	wb = append(wb, make([]byte, 60)...)
+
+	heap := testHeap([]testCiff{
+		{typ: 0x2005, data: raw},
+		{typ: 0x2007, data: []byte{0xff, 0xd8, 0xff, 0xd9}},
+		{typ: 0x300a, sub: []testCiff{
+			{typ: 0x080a, data: []byte("Canon\x00Canon EOS 10D\x00")},
+			{typ: 0x1810, data: imageInfo},
+			{typ: 0x1835, data: []byte{0, 0, 0, 0}},
+			{typ: 0x300b, sub: []testCiff{
+				{typ: 0x102a, data: testShorts(0, 0, 160, 0, 128, 224, 0, 1, 0)},
+				{typ: 0x10a9, data: wb},
+				{typ: 0x1031, data: testShorts(0, width, height, 0, 0, 4, 2, width-1, height-1)},
+				{typ: 0x5029, data: focal},
+				{typ: 0x180e, data: captured},
+			}},
+		}},
+	})
+	header := append([]byte("II\x1a\x00\x00\x00HEAPCCDR"), make([]byte, 12)...)
+	return append(header, heap...), pix
+}
+
+func TestReadCRW(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	data, _ := testCRW(128, 24)
+	c, err := ReadCRW(bytes.NewReader(data), int64(len(data)))
+	require.Nil(err)
+	assert.Len(c.Records, 3)
+	assert.Equal(int64(26), c.RawOffset)
+	assert.Equal(int64(4), c.Preview.Length)
+	assert.Equal([]byte{0xff, 0xd8}, data[c.Preview.Offset:c.Preview.Offset+2])
+
+	m := c.Metadata
+	assert.Equal("Canon", m.Make)
+	assert.Equal("Canon EOS 10D", m.Model)
+	assert.Equal(6, m.Orientation)
+	assert.Equal(100, m.ISO)
+	assert.Equal(4.0, m.FNumber)
+	assert.Equal(1.0/128, m.ExposureTime)
+	assert.Equal(50.0, m.FocalLength)
+	assert.Equal(time.Date(2004, 1, 1, 0, 0, 0, 0, time.UTC), m.CaptureTime)
+	// the white balance index 1 is the second set of levels
+	assert.Equal([4]int{2000, 1000, 1000, 1500}, c.WB)
+	require.NotNil(c.SensorInfo)
+	assert.Equal(128, c.SensorInfo.Width)
+
+	m, err = ReadCRWMetadata(bytes.NewReader(data))
+	require.Nil(err)
+	assert.Equal("Canon EOS 10D", m.Model)
+
+	data[len(data)-4] = 0xf0
+	_, err = ReadCRW(bytes.NewReader(data), int64(len(data)))
+	assert.NotNil(err)
+}
+
+func TestDecodeCRW(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	data, pix := testCRW(128, 24)
+	config, err := DecodeCRWConfig(bytes.NewReader(data), int64(len(data)))
+	require.Nil(err)
+	assert.Equal(128, config.Width)
+	assert.Equal(24, config.Height)
+
+	img, err := DecodeCRW(bytes.NewReader(data), int64(len(data)))
+	require.Nil(err)
+	assert.Equal(uint16(0xfff), img.WhiteLevel)
+	assert.Equal(pix, img.Pix)
+	assert.Equal(6, img.Orientation)
+	assert.Equal(2.0, img.WBMultipliers[0])
+	assert.Equal(4, img.ActiveArea.Min.X)
+	// red at the top left of the active area
+	assert.Equal(uint8(0), img.CFA.Color(4, 2))
+
+	assert.False(crwHasLowBits(append(make([]byte, 600), 0xff, 0x00, 0x12)))
+
+	_, _, err = decodeCRWRaw(data[26:26+2000], 128, 24, 0, 26)
+	assert.NotNil(err)
+}
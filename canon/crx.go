@@ -0,0 +1,601 @@
+package canon
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/enricod/rawmgr/common"
+)
+
+// The CRX codec of the CR3 raw data, as described by LibRaw. The image is
+// split in 4 planes R G G B, each plane in tiles, each tile plane in subbands
+// coded with an adaptive Golomb-Rice coder. The lossless raw has a single
+// subband, the lossy C-RAW the subbands of a 5/3 wavelet of 1 to 3 levels
+
+// crxHeader CMP1 box of a raw track
+type crxHeader struct {
+	version int
+	// width and height of the image
+	width  int
+	height int
+	// tileWidth and tileHeight of the tiles of the image
+	tileWidth  int
+	tileHeight int
+	bits       int
+	planes     int
+	// cfaLayout position of the red photosite: 0 RGGB, 1 GRBG, 2 GBRG, 3 BGGR
+	cfaLayout int
+	// encType 0 planes R G G B, 3 planes of a luma chroma color space
+	encType int
+	// levels of the wavelet, 0 for the lossless raw
+	levels      int
+	hasTileCols bool
+	hasTileRows bool
+	// mdatHdrSize bytes of the tile headers at the start of the media
+	mdatHdrSize int
+}
+
+// parseCrxHeader decodes the content of the CMP1 box, at offset in the file
+func parseCrxHeader(b []byte, offset int64) (crxHeader, error) {
+	if len(b) < 32 {
+		return crxHeader{}, common.NewFormatError(formatCR3, offset, "CMP1 box of %d bytes too short", len(b))
+	}
+	h := crxHeader{
+		version:     int(binary.BigEndian.Uint16(b)),
+		width:       int(binary.BigEndian.Uint32(b[4:])),
+		height:      int(binary.BigEndian.Uint32(b[8:])),
+		tileWidth:   int(binary.BigEndian.Uint32(b[12:])),
+		tileHeight:  int(binary.BigEndian.Uint32(b[16:])),
+		bits:        int(b[20]),
+		planes:      int(b[21] >> 4),
+		cfaLayout:   int(b[21] & 0xf),
+		encType:     int(b[22] >> 4),
+		levels:      int(b[22] & 0xf),
+		hasTileCols: b[23]&0x80 != 0,
+		hasTileRows: b[23]&0x40 != 0,
+		mdatHdrSize: int(binary.BigEndian.Uint32(b[24:])),
+	}
+	switch {
+	case h.version != 0x100 && h.version != 0x200:
+		return h, common.NewFormatError(formatCR3, offset, "CRX version %#x not supported", h.version)
+	case h.mdatHdrSize == 0:
+		return h, common.NewFormatError(formatCR3, offset, "CRX header without tile headers")
+	case h.encType == 1 && h.bits > 15, h.encType != 1 && h.bits > 14:
+		return h, common.NewFormatError(formatCR3, offset, "CRX of %d bits not valid", h.bits)
+	case h.encType != 0 && h.encType != 1 && h.encType != 3:
+		return h, common.NewFormatError(formatCR3, offset, "CRX encoding %d not supported", h.encType)
+	case h.planes == 1:
+		if h.cfaLayout != 0 || h.encType != 0 || h.bits != 8 {
+			return h, common.NewFormatError(formatCR3, offset, "CRX single plane not valid")
+		}
+	case h.planes != 4 || h.width&1 != 0 || h.height&1 != 0 || h.tileWidth&1 != 0 || h.tileHeight&1 != 0 || h.cfaLayout > 3 || h.bits == 8:
+		return h, common.NewFormatError(formatCR3, offset, "CRX of %d planes %dx%d not valid", h.planes, h.width, h.height)
+	}
+	if h.tileWidth > h.width || h.tileHeight > h.height || h.levels > 3 {
+		return h, common.NewFormatError(formatCR3, offset, "CRX tiles %dx%d with %d levels not valid", h.tileWidth, h.tileHeight, h.levels)
+	}
+	return h, nil
+}
+
+// crxSubband coefficients of a subband of a tile plane
+type crxSubband struct {
+	width  int
+	height int
+	// offset of the data from the start of the plane data
+	offset int64
+	// size of the coded coefficients
+	size int64
+	// partial the quantization changes at each line
+	partial bool
+	qParam  int
+}
+
+// crxComponent a plane of a tile
+type crxComponent struct {
+	// offset of the data from the start of the tile data
+	offset          int64
+	size            int64
+	partial         bool
+	roundedBitsMask int
+	bands           []crxSubband
+}
+
+type crxTile struct {
+	width  int
+	height int
+	// tiles around this one, the wavelet coefficients go beyond the tile
+	// on these sides
+	left, right, top, bottom bool
+	// offset of the data from the end of the tile headers
+	offset int64
+	size   int64
+	// qpSize and extraSize of the data before the planes
+	qpSize    int64
+	extraSize int64
+	comps     []crxComponent
+}
+
+// crxImage the tiles of a raw track, the sizes are of a plane
+type crxImage struct {
+	crxHeader
+	tileCols int
+	tileRows int
+	tiles    []crxTile
+}
+
+// readCrxImage reads the tile headers at the start of data, the media of
+// the raw track at offset in the file
+func readCrxImage(h crxHeader, data []byte, offset int64) (*crxImage, error) {
+	if h.planes == 4 {
+		h.width, h.height = h.width/2, h.height/2
+		h.tileWidth, h.tileHeight = h.tileWidth/2, h.tileHeight/2
+	}
+	if h.tileWidth < 0x16 || h.tileHeight < 0x16 || h.width > 0x7fff || h.height > 0x7fff {
+		return nil, common.NewFormatError(formatCR3, offset, "CRX tiles %dx%d of the %dx%d planes not valid", h.tileWidth, h.tileHeight, h.width, h.height)
+	}
+	img := &crxImage{
+		crxHeader: h,
+		tileCols:  (h.width + h.tileWidth - 1) / h.tileWidth,
+		tileRows:  (h.height + h.tileHeight - 1) / h.tileHeight,
+	}
+	lastWidth := h.width - h.tileWidth*(img.tileCols-1)
+	lastHeight := h.height - h.tileHeight*(img.tileRows-1)
+	if lastWidth < 0x16 || lastHeight < 0x16 {
+		return nil, common.NewFormatError(formatCR3, offset, "CRX last tile %dx%d too small", lastWidth, lastHeight)
+	}
+	if err := common.CheckRange(formatCR3, data, 0, int64(h.mdatHdrSize), "CRX tile headers"); err != nil {
+		return nil, err
+	}
+	hdr := data[:h.mdatHdrSize]
+	pos := 0
+	var tileOffset int64
+	img.tiles = make([]crxTile, img.tileCols*img.tileRows)
+	for i := range img.tiles {
+		t := &img.tiles[i]
+		col, row := i%img.tileCols, i/img.tileCols
+		t.width, t.height = h.tileWidth, h.tileHeight
+		if col == img.tileCols-1 {
+			t.width = lastWidth
+		}
+		if row == img.tileRows-1 {
+			t.height = lastHeight
+		}
+		t.left, t.right = col > 0, col < img.tileCols-1
+		t.top, t.bottom = row > 0, row < img.tileRows-1
+
+		if len(hdr)-pos < 12 {
+			return nil, common.NewFormatError(formatCR3, offset+int64(pos), "CRX tile header %d truncated", i)
+		}
+		sign, size := binary.BigEndian.Uint16(hdr[pos:]), int(binary.BigEndian.Uint16(hdr[pos+2:]))
+		if (sign != 0xff01 || size != 8) && (sign != 0xff11 || (size != 8 && size != 16)) || len(hdr)-pos < size+4 {
+			return nil, common.NewFormatError(formatCR3, offset+int64(pos), "CRX tile header %d not valid", i)
+		}
+		if int(binary.BigEndian.Uint16(hdr[pos+8:])) != i {
+			return nil, common.NewFormatError(formatCR3, offset+int64(pos), "CRX tile header %d out of order", i)
+		}
+		t.size = int64(binary.BigEndian.Uint32(hdr[pos+4:]))
+		t.offset = tileOffset
+		if size == 16 {
+			t.qpSize = int64(binary.BigEndian.Uint32(hdr[pos+12:]))
+			t.extraSize = int64(binary.BigEndian.Uint16(hdr[pos+16:]))
+		}
+		tileOffset += t.size
+		pos += size + 4
+
+		var compOffset int64
+		t.comps = make([]crxComponent, h.planes)
+		for p := range t.comps {
+			c := &t.comps[p]
+			if len(hdr)-pos < 12 {
+				return nil, common.NewFormatError(formatCR3, offset+int64(pos), "CRX plane header %d truncated", p)
+			}
+			sign, size := binary.BigEndian.Uint16(hdr[pos:]), binary.BigEndian.Uint16(hdr[pos+2:])
+			if (sign != 0xff02 && sign != 0xff12) || size != 8 || int(hdr[pos+8]>>4) != p {
+				return nil, common.NewFormatError(formatCR3, offset+int64(pos), "CRX plane header %d not valid", p)
+			}
+			c.size = int64(binary.BigEndian.Uint32(hdr[pos+4:]))
+			c.offset = compOffset
+			c.partial = hdr[pos+8]&8 != 0
+			if rounded := int(hdr[pos+8]>>1) & 3; rounded != 0 {
+				if h.levels != 0 || !c.partial {
+					return nil, common.NewFormatError(formatCR3, offset+int64(pos), "CRX rounded bits of plane %d not valid", p)
+				}
+				c.roundedBitsMask = 1 << uint(rounded-1)
+			}
+			compOffset += c.size
+			pos += 12
+			n, err := img.readSubbands(c, hdr[pos:], offset+int64(pos))
+			if err != nil {
+				return nil, err
+			}
+			pos += n
+			img.setSubbandSizes(t, c)
+		}
+	}
+	return img, nil
+}
+
+// readSubbands reads the subband headers of a tile plane, returning their
+// length
+func (img *crxImage) readSubbands(c *crxComponent, hdr []byte, offset int64) (int, error) {
+	pos := 0
+	var bandOffset int64
+	c.bands = make([]crxSubband, 3*img.levels+1)
+	for i := range c.bands {
+		b := &c.bands[i]
+		if len(hdr)-pos < 4 {
+			return 0, common.NewFormatError(formatCR3, offset+int64(pos), "CRX subband header %d truncated", i)
+		}
+		sign, size := binary.BigEndian.Uint16(hdr[pos:]), int(binary.BigEndian.Uint16(hdr[pos+2:]))
+		if (sign != 0xff03 || size != 8) && (sign != 0xff13 || size != 16) || len(hdr)-pos < size+4 || int(hdr[pos+8]>>4) != i {
+			return 0, common.NewFormatError(formatCR3, offset+int64(pos), "CRX subband header %d not valid", i)
+		}
+		bandSize := int64(binary.BigEndian.Uint32(hdr[pos+4:]))
+		b.offset = bandOffset
+		if sign == 0xff03 {
+			bits := binary.BigEndian.Uint32(hdr[pos+8:])
+			b.size = bandSize - int64(bits&0x7ffff)
+			b.partial = bits&0x8000000 != 0
+			b.qParam = int(bits>>19) & 0xff
+		} else {
+			// the quantization of the new headers comes from the QP data
+			if img.levels > 0 {
+				return 0, common.NewFormatError(formatCR3, offset+int64(pos), "CRX quantization steps not supported")
+			}
+			b.size = bandSize - int64(binary.BigEndian.Uint16(hdr[pos+16:]))
+		}
+		if b.size < 0 {
+			return 0, common.NewFormatError(formatCR3, offset+int64(pos), "CRX subband %d size %d not valid", i, b.size)
+		}
+		bandOffset += bandSize
+		pos += size + 4
+	}
+	return pos, nil
+}
+
+// setSubbandSizes sets the sizes of the subbands of a tile plane: LL, then
+// HL, LH and HH of each level from the coarsest
+func (img *crxImage) setSubbandSizes(t *crxTile, c *crxComponent) {
+	if img.levels == 0 {
+		c.bands[0].width, c.bands[0].height = t.width, t.height
+		return
+	}
+	_, lowW, highW := crxLevelSizes(t.width, img.levels, t.left, t.right)
+	_, lowH, highH := crxLevelSizes(t.height, img.levels, t.top, t.bottom)
+	c.bands[0].width, c.bands[0].height = lowW[0], lowH[0]
+	for l := 0; l < img.levels; l++ {
+		b := c.bands[3*l+1:]
+		b[0].width, b[0].height = highW[l], lowH[l]
+		b[1].width, b[1].height = lowW[l], highH[l]
+		b[2].width, b[2].height = highW[l], highH[l]
+	}
+}
+
+// crxLevelSizes returns for each level of the wavelet, from the coarsest,
+// the samples along a side of a tile of n samples and the low and high
+// coefficients that give them. With a tile before, the high coefficients
+// start one before the tile, with a tile after both go beyond it so that
+// the last samples are computed without extending the coefficients
+func crxLevelSizes(n, levels int, before, after bool) (out, low, high []int) {
+	out, low, high = make([]int, levels), make([]int, levels), make([]int, levels)
+	for l := levels - 1; l >= 0; l-- {
+		out[l] = n
+		if after {
+			low[l], high[l] = n/2+1, n/2+1
+		} else {
+			low[l], high[l] = (n+1)/2, n/2
+		}
+		if before {
+			high[l]++
+		}
+		n = low[l]
+	}
+	return out, low, high
+}
+
+// subbandData returns the coded data of a subband of a tile plane
+func (img *crxImage) subbandData(data []byte, t *crxTile, c *crxComponent, b *crxSubband) ([]byte, error) {
+	start := int64(img.mdatHdrSize) + t.offset + t.qpSize + t.extraSize + c.offset + b.offset
+	if err := common.CheckRange(formatCR3, data, start, b.size, "CRX subband"); err != nil {
+		return nil, err
+	}
+	return data[start : start+b.size], nil
+}
+
+// decodeCrx decodes the media of a raw track, data starting at offset in the
+// file, to the CFA raster of the sensor
+func decodeCrx(h crxHeader, data []byte, offset int64) (*common.RawImage, error) {
+	img, err := readCrxImage(h, data, offset)
+	if err != nil {
+		return nil, err
+	}
+	if img.planes != 4 {
+		return nil, common.NewFormatError(formatCR3, offset, "CRX of %d planes not supported", img.planes)
+	}
+	raw := &common.RawImage{
+		Width:      img.width * 2,
+		Height:     img.height * 2,
+		Pix:        make([]uint16, img.width*img.height*4),
+		CFA:        common.CFARGGB.Offset(img.cfaLayout&1, img.cfaLayout>>1),
+		WhiteLevel: uint16(1<<uint(img.bits) - 1),
+	}
+	var planes [4][]int32
+	if img.encType == 3 {
+		for p := range planes {
+			planes[p] = make([]int32, img.width*img.height)
+		}
+	}
+
+	// the planes are independent
+	var wg sync.WaitGroup
+	errs := make([]error, img.planes)
+	for p := 0; p < img.planes; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			errs[p] = img.decodePlane(data, offset, p, func(row, col int, line []int32) {
+				if planes[p] != nil {
+					copy(planes[p][row*img.width+col:], line)
+					return
+				}
+				img.convertLine(raw, p, row, col, line)
+			})
+		}(p)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	if img.encType == 3 {
+		common.ParallelRows(img.height, common.WorkersCount(), func(start, end int) {
+			for row := start; row < end; row++ {
+				img.convertColorLine(raw, planes, row)
+			}
+		})
+	}
+	return raw, nil
+}
+
+// decodePlane decodes a plane tile by tile, passing each line of a tile to
+// put with its position in the plane
+func (img *crxImage) decodePlane(data []byte, offset int64, p int, put func(row, col int, line []int32)) error {
+	row := 0
+	for tr := 0; tr < img.tileRows; tr++ {
+		col := 0
+		for tc := 0; tc < img.tileCols; tc++ {
+			t := &img.tiles[tr*img.tileCols+tc]
+			c := &t.comps[p]
+			var err error
+			if img.levels > 0 {
+				err = img.decodeWaveletTile(data, t, c, func(i int, line []int32) { put(row+i, col, line) })
+			} else {
+				err = img.decodeLosslessTile(data, t, c, func(i int, line []int32) { put(row+i, col, line) })
+			}
+			if err != nil {
+				return err
+			}
+			col += t.width
+		}
+		row += img.tiles[tr*img.tileCols].height
+	}
+	return nil
+}
+
+// decodeLosslessTile decodes the single subband of a tile plane
+func (img *crxImage) decodeLosslessTile(data []byte, t *crxTile, c *crxComponent, put func(i int, line []int32)) error {
+	b := &c.bands[0]
+	line := make([]int32, t.width)
+	if b.size == 0 {
+		for i := 0; i < t.height; i++ {
+			put(i, line)
+		}
+		return nil
+	}
+	coded, err := img.subbandData(data, t, c, b)
+	if err != nil {
+		return err
+	}
+	d := newCrxBandDecoder(coded, b, c.partial, c.roundedBitsMask)
+	for i := 0; i < t.height; i++ {
+		if err := d.decodeLine(line); err != nil {
+			return err
+		}
+		put(i, line)
+	}
+	return nil
+}
+
+// qStepTable quantization steps of a qParam%6, in 1/64
+var qStepTable = [6]int32{0x28, 0x2d, 0x33, 0x39, 0x40, 0x48}
+
+// decodeSubband decodes and dequantizes all the lines of a subband
+func (img *crxImage) decodeSubband(data []byte, t *crxTile, c *crxComponent, i int) ([][]int32, error) {
+	b := &c.bands[i]
+	lines := make([][]int32, b.height)
+	for y := range lines {
+		lines[y] = make([]int32, b.width)
+	}
+	if b.size == 0 {
+		return lines, nil
+	}
+	coded, err := img.subbandData(data, t, c, b)
+	if err != nil {
+		return nil, err
+	}
+	// only the LL subband is predicted from the line above
+	d := newCrxBandDecoder(coded, b, c.partial && i == 0, c.roundedBitsMask)
+	for _, line := range lines {
+		if b.partial {
+			d.updateQParam()
+		}
+		if err := d.decodeLine(line); err != nil {
+			return nil, err
+		}
+		q := d.qParam
+		scale := qStepTable[q%6] >> uint(6-q/6)
+		if q/6 >= 6 {
+			scale = qStepTable[q%6] << uint(q/6-6)
+		}
+		if scale != 1 {
+			for x := range line {
+				line[x] *= scale
+			}
+		}
+	}
+	return lines, nil
+}
+
+// decodeWaveletTile decodes the subbands of a tile plane and computes the
+// inverse 5/3 wavelet, level by level from the coarsest
+func (img *crxImage) decodeWaveletTile(data []byte, t *crxTile, c *crxComponent, put func(i int, line []int32)) error {
+	bands := make([][][]int32, len(c.bands))
+	for i := range bands {
+		var err error
+		if bands[i], err = img.decodeSubband(data, t, c, i); err != nil {
+			return err
+		}
+	}
+	outW, _, _ := crxLevelSizes(t.width, img.levels, t.left, t.right)
+	outH, _, _ := crxLevelSizes(t.height, img.levels, t.top, t.bottom)
+	ll := bands[0]
+	for l := 0; l < img.levels; l++ {
+		hl, lh, hh := bands[3*l+1], bands[3*l+2], bands[3*l+3]
+		// the rows first, then the columns
+		low := make([][]int32, len(ll))
+		for y := range low {
+			low[y] = make([]int32, outW[l])
+			idwt53(low[y], ll[y], hl[y], t.left, t.right)
+		}
+		high := make([][]int32, len(lh))
+		for y := range high {
+			high[y] = make([]int32, outW[l])
+			idwt53(high[y], lh[y], hh[y], t.left, t.right)
+		}
+		out := make([][]int32, outH[l])
+		for y := range out {
+			out[y] = make([]int32, outW[l])
+		}
+		lcol, hcol, ocol := make([]int32, len(low)), make([]int32, len(high)), make([]int32, len(out))
+		for x := 0; x < outW[l]; x++ {
+			for y := range low {
+				lcol[y] = low[y][x]
+			}
+			for y := range high {
+				hcol[y] = high[y][x]
+			}
+			idwt53(ocol, lcol, hcol, t.top, t.bottom)
+			for y := range out {
+				out[y][x] = ocol[y]
+			}
+		}
+		ll = out
+	}
+	for i := 0; i < t.height; i++ {
+		put(i, ll[i])
+	}
+	return nil
+}
+
+// idwt53 computes the inverse 5/3 wavelet of the low coefficients l and the
+// high h, the even and the odd samples of out. Without a tile before or
+// after the coefficients are extended symmetrically
+func idwt53(out, l, h []int32, before, after bool) {
+	w := len(out)
+	if w == 0 {
+		return
+	}
+	if w == 1 {
+		out[0] = l[0]
+		return
+	}
+	hi := 0
+	if before {
+		out[0] = l[0] - ((h[0] + h[1] + 2) >> 2)
+		hi = 1
+	} else {
+		out[0] = l[0] - ((h[0] + 1) >> 1)
+	}
+	li, j := 1, 0
+	for i := 0; i < w-3; i += 2 {
+		even := l[li] - ((h[hi] + h[hi+1] + 2) >> 2)
+		out[j+1] = h[hi] + ((even + out[j]) >> 1)
+		out[j+2] = even
+		li++
+		hi++
+		j += 2
+	}
+	switch {
+	case after:
+		even := l[li] - ((h[hi] + h[hi+1] + 2) >> 2)
+		out[j+1] = h[hi] + ((even + out[j]) >> 1)
+		if w&1 == 1 {
+			out[j+2] = even
+		}
+	case w&1 == 1:
+		even := l[li] - ((h[hi] + 1) >> 1)
+		out[j+1] = h[hi] + ((even + out[j]) >> 1)
+		out[j+2] = even
+	default:
+		out[j+1] = h[hi] + out[j]
+	}
+}
+
+// convertLine puts a line of a plane in the raster, the samples are centered
+// on zero
+func (img *crxImage) convertLine(raw *common.RawImage, p, row, col int, line []int32) {
+	// position of the plane in the 2x2 block
+	pos := p ^ img.cfaLayout
+	i := (row*2+pos>>1)*raw.Width + col*2 + pos&1
+	if img.encType == 1 {
+		max := int32(1)<<uint(img.bits-1) - 1
+		for _, v := range line {
+			raw.Pix[i] = uint16(int16(constrain(v, -max-1, max)))
+			i += 2
+		}
+		return
+	}
+	median := int32(1) << uint(img.bits-1)
+	max := int32(1)<<uint(img.bits) - 1
+	for _, v := range line {
+		raw.Pix[i] = uint16(constrain(median+v, 0, max))
+		i += 2
+	}
+}
+
+// convertColorLine converts a line of the planes of encoding 3, a luma and
+// three chroma differences, to R G G B
+func (img *crxImage) convertColorLine(raw *common.RawImage, planes [4][]int32, row int) {
+	median := int32(1) << uint(img.bits-1) << 10
+	max := int32(1)<<uint(img.bits) - 1
+	base := row * img.width
+	var rggb [4]int32
+	for x := 0; x < img.width; x++ {
+		p0, p1, p2, p3 := planes[0][base+x], planes[1][base+x], planes[2][base+x], planes[3][base+x]
+		gr := median + p0<<10 - 168*p1 - 585*p3
+		if gr < 0 {
+			gr = -(((-gr + 512) >> 9) &^ 1)
+		} else {
+			gr = ((gr + 512) >> 9) &^ 1
+		}
+		rggb[0] = (median + p0<<10 + 1510*p3 + 512) >> 10
+		rggb[1] = (p2 + gr + 1) >> 1
+		rggb[2] = (gr - p2 + 1) >> 1
+		rggb[3] = (median + p0<<10 + 1927*p1 + 512) >> 10
+		for p, v := range rggb {
+			pos := p ^ img.cfaLayout
+			raw.Pix[(row*2+pos>>1)*raw.Width+x*2+pos&1] = uint16(constrain(v, 0, max))
+		}
+	}
+}
+
+func constrain(v, min, max int32) int32 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,288 @@
+package canon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/enricod/rawmgr/common/commontest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCrxCode writes code as the adaptive Golomb-Rice codes of the CRX
+// bands, with parameter k
+func writeCrxCode(w *commontest.BitWriter, code uint32, k int) {
+	if code>>uint(k) >= 41 {
+		// escape, 41 zeros then the code
+		w.Write(0, 32)
+		w.Write(0, 9)
+		w.Write(1, 1)
+		w.Write(uint64(code), 21)
+		return
+	}
+	for q := code >> uint(k); q > 0; q-- {
+		w.Write(0, 1)
+	}
+	w.Write(1, 1)
+	w.Write(uint64(code), uint(k))
+}
+
+func zigzag(v int32) uint32 {
+	if v < 0 {
+		return uint32(-2*v - 1)
+	}
+	return uint32(2 * v)
+}
+
+// encodeCrxBand codes the lines of a predicted subband as the decoder
+// expects them, without runs
+func encodeCrxBand(lines [][]int32) []byte {
+	w := len(lines[0])
+	bw := &commontest.BitWriter{}
+	k := 0
+	prev := make([]int32, w+2)
+	for y, line := range lines {
+		cur := make([]int32, w+2)
+		if y > 0 {
+			cur[0] = prev[1]
+		}
+		for x, v := range line {
+			last := x == w-1
+			var pred int32
+			kcode := func(code uint32) uint32 { return code }
+			switch {
+			case y == 0:
+				pred = cur[x]
+				if !last && pred == 0 {
+					bw.Write(0, 1)
+				}
+			case !last && cur[x] == prev[x+1] && cur[x] == prev[x+2]:
+				bw.Write(0, 1)
+				pred = prev[x+1]
+			default:
+				delta := prev[x+1] - prev[x]
+				left := cur[x]
+				symb := [4]int32{delta + left, delta + left, left, prev[x+1]}
+				idx := 0
+				if (prev[x] < left) != (delta < 0) {
+					idx += 2
+				}
+				if (left < prev[x+1]) != (delta < 0) {
+					idx++
+				}
+				pred = symb[idx]
+			}
+			if y > 0 && !last {
+				next := crxAbs((prev[x+2] - prev[x+1]) << 1)
+				kcode = func(code uint32) uint32 { return (code + uint32(next)) >> 1 }
+			}
+			code := zigzag(v - pred)
+			writeCrxCode(bw, code, k)
+			k = crxPredictK(k, kcode(code), 15)
+			cur[x+1] = v
+		}
+		cur[w+1] = cur[w] + 1
+		prev = cur
+	}
+	return append(bw.Bytes(), 0, 0, 0, 0)
+}
+
+// testCrx builds the CMP1 content and the media of a lossless image of a
+// single tile with the planes, each w x h
+func testCrx(planes [4][][]int32, layout int) ([]byte, []byte) {
+	h, w := len(planes[0]), len(planes[0][0])
+	var coded [4][]byte
+	for p := range planes {
+		coded[p] = encodeCrxBand(planes[p])
+	}
+	hdr := []byte{0xff, 0x01, 0, 8, 0, 0, 0, 0, 0, 0, 0, 0}
+	var data []byte
+	for p := range coded {
+		plane := []byte{0xff, 0x02, 0, 8, 0, 0, 0, 0, byte(p<<4 | 8), 0, 0, 0}
+		binary.BigEndian.PutUint32(plane[4:], uint32(len(coded[p])))
+		band := []byte{0xff, 0x03, 0, 8, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(band[4:], uint32(len(coded[p])))
+		hdr = append(hdr, append(plane, band...)...)
+		data = append(data, coded[p]...)
+	}
+	binary.BigEndian.PutUint32(hdr[4:], uint32(len(data)))
+
+	cmp1 := make([]byte, 32)
+	binary.BigEndian.PutUint16(cmp1, 0x100)
+	binary.BigEndian.PutUint32(cmp1[4:], uint32(w*2))
+	binary.BigEndian.PutUint32(cmp1[8:], uint32(h*2))
+	binary.BigEndian.PutUint32(cmp1[12:], uint32(w*2))
+	binary.BigEndian.PutUint32(cmp1[16:], uint32(h*2))
+	cmp1[20] = 14
+	cmp1[21] = byte(4<<4 | layout)
+	binary.BigEndian.PutUint32(cmp1[24:], uint32(len(hdr)))
+	return cmp1, append(hdr, data...)
+}
+
+func testPlanes(w, h int) [4][][]int32 {
+	var planes [4][][]int32
+	for p := range planes {
+		planes[p] = make([][]int32, h)
+		for y := range planes[p] {
+			planes[p][y] = make([]int32, w)
+			for x := range planes[p][y] {
+				planes[p][y][x] = int32((x*7+y*13+p*100)%300) - 150
+				if x > 10 && x < 16 {
+					// flat areas
+					planes[p][y][x] = 5
+				}
+			}
+		}
+	}
+	return planes
+}
+
+func TestDecodeCrx(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	planes := testPlanes(24, 22)
+	cmp1, media := testCrx(planes, 1)
+	h, err := parseCrxHeader(cmp1, 0)
+	require.Nil(err)
+	assert.Equal(48, h.width)
+	assert.Equal(14, h.bits)
+
+	img, err := decodeCrx(h, media, 0)
+	require.Nil(err)
+	assert.Equal(48, img.Width)
+	assert.Equal(44, img.Height)
+	assert.Equal(uint16(0x3fff), img.WhiteLevel)
+	// GRBG, the red plane is at the right of the green
+	assert.Equal(uint8(1), img.CFA.Color(0, 0))
+	assert.Equal(uint8(0), img.CFA.Color(0, 1))
+	for y := 0; y < 22; y++ {
+		for x := 0; x < 24; x++ {
+			require.Equal(uint16(8192+planes[0][y][x]), img.Pix[(2*y)*48+2*x+1], "%d %d", x, y)
+			require.Equal(uint16(8192+planes[1][y][x]), img.Pix[(2*y)*48+2*x])
+			require.Equal(uint16(8192+planes[2][y][x]), img.Pix[(2*y+1)*48+2*x+1])
+			require.Equal(uint16(8192+planes[3][y][x]), img.Pix[(2*y+1)*48+2*x])
+		}
+	}
+
+	_, err = decodeCrx(h, media[:len(media)-20], 0)
+	assert.NotNil(err)
+	cmp1[0] = 3
+	_, err = parseCrxHeader(cmp1, 0)
+	assert.NotNil(err)
+}
+
+// fdwt53 forward 5/3 wavelet with the coefficients extended symmetrically
+func fdwt53(s []int32) (l, h []int32) {
+	n := len(s)
+	at := func(i int) int32 {
+		if i >= n {
+			i = 2*(n-1) - i
+		}
+		return s[i]
+	}
+	h = make([]int32, n/2)
+	for i := range h {
+		h[i] = s[2*i+1] - ((at(2*i) + at(2*i+2)) >> 1)
+	}
+	hAt := func(i int) int32 {
+		if i < 0 {
+			i = 0
+		}
+		if i >= len(h) {
+			i = len(h) - 1
+		}
+		return h[i]
+	}
+	l = make([]int32, (n+1)/2)
+	for i := range l {
+		l[i] = s[2*i] + ((hAt(i-1) + hAt(i) + 2) >> 2)
+	}
+	return l, h
+}
+
+func TestIdwt53Tiles(t *testing.T) {
+	assert := assert.New(t)
+
+	const levels = 3
+	signal := make([]int32, 80)
+	for i := range signal {
+		signal[i] = int32(i*i%97) - 40
+	}
+	// the coefficients of the whole signal, from the finest level
+	var highs [][]int32
+	low := signal
+	for l := 0; l < levels; l++ {
+		var high []int32
+		low, high = fdwt53(low)
+		highs = append(highs, high)
+	}
+
+	// two tiles, split at 48, decoded with the coefficients around them
+	for _, tile := range []struct{ start, n int }{{0, 48}, {48, 32}} {
+		before, after := tile.start > 0, tile.start+tile.n < len(signal)
+		out, lows, hs := crxLevelSizes(tile.n, levels, before, after)
+		start := tile.start >> levels
+		ll := low[start : start+lows[0]]
+		for l := 0; l < levels; l++ {
+			high := highs[levels-1-l]
+			hstart := tile.start >> uint(levels-l)
+			if before {
+				hstart--
+			}
+			res := make([]int32, out[l])
+			idwt53(res, ll, high[hstart:hstart+hs[l]], before, after)
+			ll = res
+		}
+		assert.Equal(signal[tile.start:tile.start+tile.n], ll)
+	}
+}
+
+// testCR3Raw builds a CR3 with a raw track of the media
+func testCR3Raw(cmp1, media []byte) []byte {
+	cmt1 := testTiff([][]testEntry{{{tag: 0x010f, typ: 2, count: 6, data: []byte("Canon\x00")}}})
+	canonBox := cr3Box("uuid", cr3CanonUUID[:], cr3Box("CMT1", cmt1))
+	ftyp := cr3Box("ftyp", []byte("crx \x00\x00\x00\x01crx isom"))
+	moov := func(offset int) []byte {
+		craw := make([]byte, 82)
+		binary.BigEndian.PutUint16(craw[24:], 6000)
+		binary.BigEndian.PutUint16(craw[26:], 4000)
+		stsd := cr3Box("stsd", []byte{0, 0, 0, 0, 0, 0, 0, 1}, cr3Box("CRAW", craw, cr3Box("CMP1", cmp1)))
+		stsz := make([]byte, 12)
+		binary.BigEndian.PutUint32(stsz[4:], uint32(len(media)))
+		co64 := make([]byte, 16)
+		binary.BigEndian.PutUint32(co64[4:], 1)
+		binary.BigEndian.PutUint64(co64[8:], uint64(offset))
+		stbl := cr3Box("stbl", stsd, cr3Box("stsz", stsz), cr3Box("co64", co64))
+		return cr3Box("moov", canonBox, cr3Box("trak", cr3Box("mdia", cr3Box("minf", stbl))))
+	}
+	offset := len(ftyp) + len(moov(0)) + 8
+	data := append(ftyp, moov(offset)...)
+	return append(data, cr3Box("mdat", media)...)
+}
+
+func TestDecodeCR3(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	planes := testPlanes(22, 22)
+	data := testCR3Raw(testCrx(planes, 0))
+	c, err := ReadCR3(bytes.NewReader(data), int64(len(data)))
+	require.Nil(err)
+	require.Len(c.Tracks, 1)
+	assert.Equal("CRAW", c.Tracks[0].Type)
+	assert.Equal(6000, c.Tracks[0].Width)
+	require.NotNil(c.RawTrack())
+
+	config, err := DecodeCR3Config(bytes.NewReader(data), int64(len(data)))
+	require.Nil(err)
+	assert.Equal(44, config.Width)
+	assert.Equal(44, config.Height)
+
+	img, err := DecodeCR3(bytes.NewReader(data), int64(len(data)))
+	require.Nil(err)
+	assert.Equal("Canon", img.Metadata.Make)
+	assert.Equal(1, img.Orientation)
+	assert.Equal(uint16(8192+planes[3][5][7]), img.Pix[11*44+15])
+}
@@ -0,0 +1,546 @@
+package canon
+
+import "github.com/enricod/rawmgr/common"
+
+// errCrxRun run of symbols longer than the line
+var errCrxRun = common.NewFormatError(formatCR3, 0, "CRX run beyond the end of the line")
+
+// crxRunBits and crxRunLength bits of the end of a run and length of each
+// step for the run state
+var (
+	crxRunBits   = [32]uint{0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	crxRunLength = [32]int{1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 8, 8, 8, 8, 16, 16, 32, 32, 64, 64, 128, 128,
+		256, 512, 1024, 2048, 4096, 8192, 16384, 32768}
+)
+
+// crxBandDecoder decodes the lines of a subband. The symbols are coded with
+// an adaptive Golomb-Rice code, with runs of equal symbols where the line
+// above is flat. A subband is predicted from the previous line, partial,
+// or holds the residuals coded in the context of the line above
+type crxBandDecoder struct {
+	br     *common.BitReader
+	width  int
+	height int
+	line   int
+	// k of the Golomb-Rice code, s of the run length
+	k int
+	s int
+	// qParam quantization of the subband, changed at each line by partial
+	// subbands with its own k
+	qParam  int
+	qK      int
+	partial bool
+	// roundedBitsMask and roundedBits the low bits dropped by the lossless
+	// planes with rounded bits
+	roundedBitsMask int32
+	roundedBits     uint
+	// buf two lines with a sample before and after, the previous one and
+	// the current one
+	buf [2][]int32
+	// kParams k of each column in the previous line, for the residuals
+	kParams []int32
+
+	// the lines and the positions in them of the sample before the
+	// current one, as the pointers of LibRaw
+	l0, l1, l2 []int32
+	i0, i1, i2 int
+}
+
+func newCrxBandDecoder(data []byte, b *crxSubband, partial bool, roundedBitsMask int) *crxBandDecoder {
+	d := &crxBandDecoder{
+		br:              common.NewPlainBitReader(data),
+		width:           b.width,
+		height:          b.height,
+		qParam:          b.qParam,
+		partial:         partial,
+		roundedBitsMask: int32(roundedBitsMask),
+		buf:             [2][]int32{make([]int32, b.width+2), make([]int32, b.width+2)},
+		kParams:         make([]int32, b.width),
+	}
+	if d.roundedBitsMask > 0 {
+		d.roundedBits = 1
+		for d.roundedBitsMask>>d.roundedBits != 0 {
+			d.roundedBits++
+		}
+	}
+	return d
+}
+
+// decodeLine decodes the next line of the subband in line
+func (d *crxBandDecoder) decodeLine(line []int32) error {
+	if d.line >= d.height {
+		return common.NewFormatError(formatCR3, 0, "CRX subband of %d lines exhausted", d.height)
+	}
+	// the lines swap at each line
+	d.l0, d.l1 = d.buf[d.line&1], d.buf[(d.line+1)&1]
+	d.i0, d.i1 = 0, 0
+	d.l2, d.i2 = d.kParams, 0
+	var err error
+	switch {
+	case d.line == 0 && !d.partial:
+		err = d.decodeTopLineResiduals()
+	case d.line == 0 && d.roundedBitsMask > 0:
+		err = d.decodeTopLineRounded()
+	case d.line == 0:
+		err = d.decodeTopLine()
+	case !d.partial:
+		err = d.decodeLineResiduals()
+	case d.roundedBitsMask > 0:
+		err = d.decodeLineRounded()
+	default:
+		err = d.decodeLinePredicted()
+	}
+	if err != nil {
+		return err
+	}
+	if err := d.br.Err(); err != nil {
+		return common.WrapFormatError(formatCR3, 0, err, "CRX subband line %d", d.line)
+	}
+	copy(line, d.buf[(d.line+1)&1][1:d.width+1])
+	d.line++
+	return nil
+}
+
+// updateQParam reads the change of the quantization before a line
+func (d *crxBandDecoder) updateQParam() {
+	code := uint32(d.br.ReadZeros())
+	if code >= 23 {
+		code = d.br.ReadBits(8)
+	} else if d.qK > 0 {
+		code = d.br.ReadBits(uint(d.qK)) | code<<uint(d.qK)
+	}
+	d.qParam += int(crxSigned(code))
+	d.qK = crxPredictK(d.qK, code, 0)
+}
+
+// readCode reads a Golomb-Rice code with the current k
+func (d *crxBandDecoder) readCode() uint32 {
+	code := uint32(d.br.ReadZeros())
+	if code >= 41 {
+		return d.br.ReadBits(21)
+	}
+	if d.k > 0 {
+		code = d.br.ReadBits(uint(d.k)) | code<<uint(d.k)
+	}
+	return code
+}
+
+// readRun reads the length of a run of at most length symbols, after its
+// first bit
+func (d *crxBandDecoder) readRun(length int) (int, error) {
+	n := 1
+	for d.br.ReadBits(1) == 1 {
+		n += crxRunLength[d.s]
+		if n > length {
+			n = length
+			break
+		}
+		if d.s < 31 {
+			d.s++
+		}
+		if n == length {
+			break
+		}
+	}
+	if n < length {
+		if crxRunBits[d.s] > 0 {
+			n += int(d.br.ReadBits(crxRunBits[d.s]))
+		}
+		if d.s > 0 {
+			d.s--
+		}
+		if n > length {
+			return 0, errCrxRun
+		}
+	}
+	return n, nil
+}
+
+// crxSigned maps the codes 0, 1, 2, 3... to 0, -1, 1, -2...
+func crxSigned(code uint32) int32 {
+	return -int32(code&1) ^ int32(code>>1)
+}
+
+// crxPredictK adapts k to the last code, max 0 is no limit
+func crxPredictK(k int, code uint32, max int) int {
+	next := k
+	if code < (1<<uint(k))>>1 {
+		next--
+	}
+	if code>>uint(k) > 2 {
+		next++
+	}
+	if code>>uint(k) > 5 {
+		next++
+	}
+	if max != 0 && next > max {
+		return max
+	}
+	return next
+}
+
+func crxAbs(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// decodeTopLine decodes the first line of a predicted subband, each sample
+// predicted from the one before
+func (d *crxBandDecoder) decodeTopLine() error {
+	l1 := d.l1
+	l1[0] = 0
+	length := d.width
+	for ; length > 1; length-- {
+		if l1[d.i1] != 0 {
+			l1[d.i1+1] = l1[d.i1]
+		} else {
+			n := 0
+			if d.br.ReadBits(1) == 1 {
+				var err error
+				if n, err = d.readRun(length); err != nil {
+					return err
+				}
+			}
+			length -= n
+			for ; n > 0; n-- {
+				l1[d.i1+1] = l1[d.i1]
+				d.i1++
+			}
+			if length <= 0 {
+				break
+			}
+			l1[d.i1+1] = 0
+		}
+		code := d.readCode()
+		l1[d.i1+1] += crxSigned(code)
+		d.k = crxPredictK(d.k, code, 15)
+		d.i1++
+	}
+	if length == 1 {
+		l1[d.i1+1] = l1[d.i1]
+		code := d.readCode()
+		l1[d.i1+1] += crxSigned(code)
+		d.k = crxPredictK(d.k, code, 15)
+		d.i1++
+	}
+	l1[d.i1+1] = l1[d.i1] + 1
+	return nil
+}
+
+// symbolL1 decodes a sample of a predicted line, from the median of the
+// samples around or from the one above. Before the end of the line the
+// next sample above is used for k too
+func (d *crxBandDecoder) symbolL1(median, notEOL bool) {
+	l0, l1, i0, i1 := d.l0, d.l1, d.i0, d.i1
+	if median {
+		delta := l0[i0+1] - l0[i0]
+		left := l1[i1]
+		symb := [4]int32{delta + left, delta + left, left, l0[i0+1]}
+		idx := 0
+		if (l0[i0] < left) != (delta < 0) {
+			idx += 2
+		}
+		if (left < l0[i0+1]) != (delta < 0) {
+			idx++
+		}
+		l1[i1+1] = symb[idx]
+	} else {
+		l1[i1+1] = l0[i0+1]
+	}
+	code := d.readCode()
+	l1[i1+1] += crxSigned(code)
+	if notEOL {
+		next := (l0[i0+2] - l0[i0+1]) << 1
+		code = (code + uint32(crxAbs(next))) >> 1
+		d.i0++
+	}
+	d.k = crxPredictK(d.k, code, 15)
+	d.i1++
+}
+
+// decodeLinePredicted decodes a line predicted from the one above
+func (d *crxBandDecoder) decodeLinePredicted() error {
+	l0, l1 := d.l0, d.l1
+	l1[0] = l0[1]
+	length := d.width
+	for ; length > 1; length-- {
+		if l1[d.i1] != l0[d.i0+1] || l1[d.i1] != l0[d.i0+2] {
+			d.symbolL1(true, true)
+			continue
+		}
+		n := 0
+		if d.br.ReadBits(1) == 1 {
+			var err error
+			if n, err = d.readRun(length); err != nil {
+				return err
+			}
+		}
+		length -= n
+		d.i0 += n
+		for ; n > 0; n-- {
+			l1[d.i1+1] = l1[d.i1]
+			d.i1++
+		}
+		if length > 0 {
+			d.symbolL1(false, length > 1)
+		}
+	}
+	if length == 1 {
+		d.symbolL1(true, false)
+	}
+	l1[d.i1+1] = l1[d.i1] + 1
+	return nil
+}
+
+// roundedValue adds the code of a plane with rounded bits to the prediction
+func (d *crxBandDecoder) roundedValue(code uint32) int32 {
+	v := crxSigned(code)
+	return d.roundedBitsMask*2*v + v>>31
+}
+
+// decodeTopLineRounded decodes the first line of a predicted subband with
+// rounded bits
+func (d *crxBandDecoder) decodeTopLineRounded() error {
+	l1 := d.l1
+	l1[0] = 0
+	length := d.width
+	for ; length > 1; length-- {
+		if crxAbs(l1[d.i1]) > d.roundedBitsMask {
+			l1[d.i1+1] = l1[d.i1]
+		} else {
+			n := 0
+			if d.br.ReadBits(1) == 1 {
+				var err error
+				if n, err = d.readRun(length); err != nil {
+					return err
+				}
+			}
+			length -= n
+			for ; n > 0; n-- {
+				l1[d.i1+1] = l1[d.i1]
+				d.i1++
+			}
+			if length <= 0 {
+				break
+			}
+			l1[d.i1+1] = 0
+		}
+		code := d.readCode()
+		l1[d.i1+1] += d.roundedValue(code)
+		d.k = crxPredictK(d.k, code, 15)
+		d.i1++
+	}
+	if length == 1 {
+		l1[d.i1+1] = l1[d.i1]
+		code := d.readCode()
+		l1[d.i1+1] += d.roundedValue(code)
+		d.k = crxPredictK(d.k, code, 15)
+		d.i1++
+	}
+	l1[d.i1+1] = l1[d.i1] + 1
+	return nil
+}
+
+// symbolRounded decodes a sample of a predicted line with rounded bits,
+// from the median or from the sample above
+func (d *crxBandDecoder) symbolRounded(median, nextK bool) {
+	l0, l1, i0, i1 := d.l0, d.l1, d.i0, d.i1
+	sym := l0[i0+1]
+	if median {
+		delta := l0[i0+1] - l0[i0]
+		left := l1[i1]
+		symb := [4]int32{delta + left, delta + left, left, l0[i0+1]}
+		idx := 0
+		if (l0[i0] < left) != (delta < 0) {
+			idx += 2
+		}
+		if (left < l0[i0+1]) != (delta < 0) {
+			idx++
+		}
+		sym = symb[idx]
+	}
+	code := d.readCode()
+	l1[i1+1] = d.roundedValue(code) + sym
+	if nextK {
+		var next int32
+		if l0[i0+2] > l0[i0+1] {
+			next = (l0[i0+2] - l0[i0+1] + d.roundedBitsMask - 1) >> d.roundedBits
+		} else {
+			next = -((l0[i0+1] - l0[i0+2] + d.roundedBitsMask) >> d.roundedBits)
+		}
+		d.k = crxPredictK(d.k, (code+2*uint32(crxAbs(next)))>>1, 15)
+	} else {
+		d.k = crxPredictK(d.k, code, 15)
+	}
+	d.i1++
+}
+
+// decodeLineRounded decodes a line with rounded bits predicted from the
+// one above
+func (d *crxBandDecoder) decodeLineRounded() error {
+	l0, l1 := d.l0, d.l1
+	l0[0] = l0[1]
+	l1[0] = l0[1]
+	reached := false
+	length := d.width
+	for ; length > 1; length-- {
+		if crxAbs(l0[d.i0+2]-l0[d.i0+1]) > d.roundedBitsMask {
+			d.symbolRounded(true, true)
+			d.i0++
+			reached = true
+			continue
+		}
+		if reached || crxAbs(l0[d.i0]-l1[d.i1]) > d.roundedBitsMask {
+			d.symbolRounded(true, true)
+			d.i0++
+			reached = false
+			continue
+		}
+		n := 0
+		if d.br.ReadBits(1) == 1 {
+			var err error
+			if n, err = d.readRun(length); err != nil {
+				return err
+			}
+		}
+		length -= n
+		d.i0 += n
+		for ; n > 0; n-- {
+			l1[d.i1+1] = l1[d.i1]
+			d.i1++
+		}
+		if length <= 0 {
+			break
+		}
+		d.symbolRounded(false, true)
+		d.i0++
+		reached = false
+	}
+	if length == 1 {
+		d.symbolRounded(true, false)
+	}
+	l1[d.i1+1] = l1[d.i1] + 1
+	return nil
+}
+
+// residual reads a sample of a line of residuals. After a run of zeros the
+// sample is not zero, the code is shifted by one
+func (d *crxBandDecoder) residual(afterRun bool) (int32, uint32) {
+	code := d.readCode()
+	if afterRun {
+		return crxSigned(code + 1), code
+	}
+	return crxSigned(code), code
+}
+
+// decodeTopLineResiduals decodes the first line of a subband of residuals,
+// runs of zeros are coded after a zero
+func (d *crxBandDecoder) decodeTopLineResiduals() error {
+	l0, l1, l2 := d.l0, d.l1, d.l2
+	l0[0], l1[0] = 0, 0
+	length := d.width
+	for ; length > 1; length-- {
+		if l1[d.i1] != 0 {
+			var code uint32
+			l1[d.i1+1], code = d.residual(false)
+			d.k = crxPredictK(d.k, code, 15)
+		} else {
+			n := 0
+			if d.br.ReadBits(1) == 1 {
+				var err error
+				if n, err = d.readRun(length); err != nil {
+					return err
+				}
+			}
+			length -= n
+			for ; n > 0; n-- {
+				l2[d.i2] = 0
+				l1[d.i1+1] = 0
+				d.i1++
+				d.i2++
+			}
+			if length <= 0 {
+				break
+			}
+			var code uint32
+			l1[d.i1+1], code = d.residual(true)
+			d.k = crxPredictK(d.k, code, 15)
+		}
+		l2[d.i2] = int32(d.k)
+		d.i2++
+		d.i1++
+	}
+	if length == 1 {
+		var code uint32
+		l1[d.i1+1], code = d.residual(false)
+		d.k = crxPredictK(d.k, code, 15)
+		l2[d.i2] = int32(d.k)
+		d.i1++
+	}
+	l1[d.i1+1] = 0
+	return nil
+}
+
+// nextResidualK adapts k to the code and to the k of the column above on
+// the right
+func (d *crxBandDecoder) nextResidualK(code uint32, i int) {
+	d.k = crxPredictK(d.k, code, 0)
+	if d.l2[i+1]-int32(d.k) <= 1 {
+		if d.k >= 15 {
+			d.k = 15
+		}
+	} else {
+		d.k++
+	}
+}
+
+// decodeLineResiduals decodes a line of residuals, coded in the context of
+// the line above
+func (d *crxBandDecoder) decodeLineResiduals() error {
+	l0, l1, l2 := d.l0, d.l1, d.l2
+	w := d.width
+	i := 0
+	for ; i < w-1; i++ {
+		if l0[i+2]|l0[i+1]|l1[i] != 0 {
+			var code uint32
+			l1[i+1], code = d.residual(false)
+			d.nextResidualK(code, i)
+			l2[i] = int32(d.k)
+			continue
+		}
+		n := 0
+		if d.br.ReadBits(1) == 1 {
+			var err error
+			if n, err = d.readRun(w - i); err != nil {
+				return err
+			}
+		}
+		for j := 0; j < n; j++ {
+			l1[i+1+j] = 0
+			l2[i+j] = 0
+		}
+		i += n
+		if i >= w-1 {
+			if i == w-1 {
+				var code uint32
+				l1[i+1], code = d.residual(true)
+				d.k = crxPredictK(d.k, code, 15)
+				l2[i] = int32(d.k)
+			}
+			continue
+		}
+		var code uint32
+		l1[i+1], code = d.residual(true)
+		d.nextResidualK(code, i)
+		l2[i] = int32(d.k)
+	}
+	if i == w-1 {
+		var code uint32
+		l1[i+1], code = d.residual(false)
+		d.k = crxPredictK(d.k, code, 15)
+		l2[i] = int32(d.k)
+	}
+	return nil
+}
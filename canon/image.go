@@ -0,0 +1,133 @@
+package canon
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+
+	"github.com/enricod/rawmgr/common"
+	"github.com/enricod/rawmgr/ljpeg"
+)
+
+func init() {
+	image.RegisterFormat("cr2", "II*\x00????CR", decodeReader, decodeConfigReader)
+	image.RegisterFormat("cr2", "MM\x00*????CR", decodeReader, decodeConfigReader)
+	image.RegisterFormat("cr3", "????ftypcrx ", decodeCR3Reader, decodeCR3ConfigReader)
+	image.RegisterFormat("crw", "II\x1a\x00\x00\x00HEAPCCDR", decodeCRWReader, decodeCRWConfigReader)
+}
+
+// maxHeadersSize bytes of the lossless JPEG stream read for its headers
+const maxHeadersSize = 1 << 16
+
+// DecodeConfig returns the size of the raw image of the CR2 file in r,
+// reading only the IFDs and the headers of the lossless JPEG stream
+func DecodeConfig(r io.ReaderAt, size int64) (image.Config, error) {
+	if size < 0 {
+		return image.Config{}, common.NewFormatError(format, 0, "file size %d not valid", size)
+	}
+	r = io.NewSectionReader(r, 0, size)
+	_, ifds, err := readCR2(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	start, end, err := rawRange(ifds[3], size)
+	if err != nil {
+		return image.Config{}, err
+	}
+	if end-start > maxHeadersSize {
+		end = start + maxHeadersSize
+	}
+	headers := make([]byte, end-start)
+	if err := common.ReadFull(format, r, start, headers); err != nil {
+		return image.Config{}, err
+	}
+	decoder, err := ljpeg.NewDecoder(headers)
+	if err != nil {
+		return image.Config{}, err
+	}
+	slices, err := frameSlices(decoder, ifds[3])
+	if err != nil {
+		return image.Config{}, err
+	}
+	width := slices.imageWidth()
+	height := decoder.McuRowSize() * decoder.McuRows() / width
+	if isSRaw(decoder.Frame) {
+		// width samples are MCUs of h*v luma samples, Cb and Cr
+		c := decoder.Frame.Components[0]
+		h, v := int(c.HorizontalSamplingFactor), int(c.VerticalSamplingFactor)
+		return image.Config{
+			ColorModel: color.RGBA64Model,
+			Width:      width / (h*v + 2) * h,
+			Height:     height * v,
+		}, nil
+	}
+	return image.Config{
+		ColorModel: color.Gray16Model,
+		Width:      width,
+		Height:     height,
+	}, nil
+}
+
+// decodeReader and decodeConfigReader are used by image.Decode, the whole
+// file is read as the IFDs point anywhere in it
+func decodeReader(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+func decodeConfigReader(r io.Reader) (image.Config, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return DecodeConfig(bytes.NewReader(data), int64(len(data)))
+}
+
+func decodeCR3Reader(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	img, err := DecodeCR3(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+func decodeCR3ConfigReader(r io.Reader) (image.Config, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return DecodeCR3Config(bytes.NewReader(data), int64(len(data)))
+}
+
+func decodeCRWReader(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	img, err := DecodeCRW(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+func decodeCRWConfigReader(r io.Reader) (image.Config, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return DecodeCRWConfig(bytes.NewReader(data), int64(len(data)))
+}
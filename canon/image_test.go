@@ -0,0 +1,62 @@
+package canon
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageDecode(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	slices := rawSlice{2, 4, 3}
+	frame := make([]uint16, slices.imageWidth()*6)
+	for i := range frame {
+		frame[i] = uint16(i % 4096)
+	}
+	data := testCR2(losslessJPEG(frame, 22, 2, 12), slices)
+
+	config, name, err := image.DecodeConfig(bytes.NewReader(data))
+	require.Nil(err)
+	assert.Equal("cr2", name)
+	assert.Equal(11, config.Width)
+	assert.Equal(6, config.Height)
+
+	// the scan is not decoded
+	data = testCR2(losslessJPEG(frame, 22, 2, 12)[:80], slices)
+	config, err = DecodeConfig(bytes.NewReader(data), int64(len(data)))
+	require.Nil(err)
+	assert.Equal(11, config.Width)
+
+	_, err = DecodeConfig(bytes.NewReader(data), -1)
+	assert.NotNil(err)
+
+	// the rest of the file is not read
+	r := &countingReader{r: bytes.NewReader(append(data, make([]byte, 1<<20)...))}
+	_, err = DecodeConfig(r, int64(len(data))+1<<20)
+	require.Nil(err)
+	assert.True(r.n < 1024, "%d bytes read", r.n)
+	data = testCR2(losslessJPEG(frame, 22, 2, 12), slices)
+
+	img, name, err := image.Decode(bytes.NewReader(data))
+	require.Nil(err)
+	assert.Equal("cr2", name)
+	assert.Equal(image.Rect(0, 0, 11, 6), img.Bounds())
+}
+
+// countingReader counts the bytes read
+type countingReader struct {
+	r io.ReaderAt
+	n int64
+}
+
+func (c *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.n += int64(n)
+	return n, err
+}
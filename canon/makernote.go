@@ -0,0 +1,370 @@
+package canon
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/enricod/rawmgr/common"
+)
+
+// Canon maker note tags
+const (
+	tagCameraSettings       = 0x0001
+	tagShotInfo             = 0x0004
+	tagImageType            = 0x0006
+	tagFirmwareVersion      = 0x0007
+	tagSerialNumber         = 0x000c
+	tagModelID              = 0x0010
+	tagFileInfo             = 0x0093
+	tagLensModel            = 0x0095
+	tagInternalSerialNumber = 0x0096
+)
+
+// MacroMode CameraSettings macro mode
+type MacroMode int
+
+// Quality CameraSettings image quality
+type Quality int
+
+// DriveMode CameraSettings continuous drive
+type DriveMode int
+
+// FocusMode CameraSettings focus mode
+type FocusMode int
+
+// BracketMode FileInfo bracketing
+type BracketMode int
+
+var macroModeNames = map[MacroMode]string{1: "Macro", 2: "Normal"}
+
+var qualityNames = map[Quality]string{
+	-1: "n/a", 0: "unknown", 1: "Economy", 2: "Normal", 3: "Fine", 4: "RAW", 5: "Superfine",
+	7: "CRAW", 130: "Light (RAW)", 131: "Standard (RAW)",
+}
+
+var driveModeNames = map[DriveMode]string{
+	0: "Single", 1: "Continuous", 2: "Movie", 3: "Continuous, Speed Priority",
+	4: "Continuous, Low", 5: "Continuous, High", 6: "Silent Single",
+	8: "Continuous, High+", 9: "Single, Silent", 10: "Continuous, Silent",
+}
+
+var focusModeNames = map[FocusMode]string{
+	0: "One-shot AF", 1: "AI Servo AF", 2: "AI Focus AF", 3: "Manual Focus", 4: "Single",
+	5: "Continuous", 6: "Manual Focus", 16: "Pan Focus", 256: "One-shot AF (Live View)",
+	257: "AI Servo AF (Live View)", 258: "AI Focus AF (Live View)", 512: "Movie Snap Focus",
+	519: "Movie Servo AF",
+}
+
+var bracketModeNames = map[BracketMode]string{0: "Off", 1: "AEB", 2: "FEB", 3: "ISO", 4: "WB"}
+
+func (m MacroMode) String() string   { return name(macroModeNames[m], int(m)) }
+func (q Quality) String() string     { return name(qualityNames[q], int(q)) }
+func (d DriveMode) String() string   { return name(driveModeNames[d], int(d)) }
+func (f FocusMode) String() string   { return name(focusModeNames[f], int(f)) }
+func (b BracketMode) String() string { return name(bracketModeNames[b], int(b)) }
+
+func name(s string, v int) string {
+	if s == "" {
+		return fmt.Sprintf("unknown (%d)", v)
+	}
+	return s
+}
+
+// CameraSettings maker note tag 0x0001
+type CameraSettings struct {
+	MacroMode MacroMode
+	// SelfTimer in tenths of second, 0 if off
+	SelfTimer int
+	Quality   Quality
+	FlashMode int
+	DriveMode DriveMode
+	FocusMode FocusMode
+	// MeteringMode Canon values: 0 default, 1 spot, 2 average, 3 evaluative, 4 partial, 5 center weighted
+	MeteringMode int
+	FocusRange   int
+	// ExposureMode Canon values: 0 easy, 1 program, 2 Tv, 3 Av, 4 manual, 5 A-DEP, 6 M-DEP, 7 bulb
+	ExposureMode int
+	LensType     int
+	// MinFocalLength and MaxFocalLength of the lens in mm
+	MinFocalLength float64
+	MaxFocalLength float64
+	// MaxAperture and MinAperture of the lens as f-number
+	MaxAperture float64
+	MinAperture float64
+}
+
+// ShotInfo maker note tag 0x0004
+type ShotInfo struct {
+	AutoISO              float64
+	BaseISO              float64
+	MeasuredEV           float64
+	TargetAperture       float64
+	TargetExposureTime   float64
+	ExposureCompensation float64
+	WhiteBalance         int
+	SequenceNumber       int
+	// CameraTemperature in °C, 0 if not known
+	CameraTemperature      int
+	FlashExposureComp      float64
+	AutoExposureBracketing int
+	AEBBracketValue        float64
+	// FocusDistanceUpper and FocusDistanceLower in m, +Inf for infinity
+	FocusDistanceUpper float64
+	FocusDistanceLower float64
+	FNumber            float64
+	ExposureTime       float64
+	// BulbDuration in seconds
+	BulbDuration int
+}
+
+// FileInfo maker note tag 0x0093
+type FileInfo struct {
+	// FileNumber directory and file number, as 100-1234
+	FileNumber uint32
+	// ShutterCount stored in place of the file number by the EOS-1D bodies
+	ShutterCount      uint32
+	BracketMode       BracketMode
+	BracketValue      int
+	BracketShotNumber int
+	// WBBracketMode 0 off, 1 blue/amber, 2 magenta/green
+	WBBracketMode    int
+	WBBracketValueAB int
+	WBBracketValueGM int
+	LiveViewShooting bool
+}
+
+// MakerNote values decoded from the Canon maker note
+type MakerNote struct {
+	CameraSettings       CameraSettings
+	ShotInfo             ShotInfo
+	FileInfo             FileInfo
+	ImageType            string
+	FirmwareVersion      string
+	SerialNumber         string
+	InternalSerialNumber string
+	LensModel            string
+	ModelID              uint32
+	// ColorData nil if missing or of a version not known
+	ColorData *ColorData
+	// SensorInfo nil if missing
+	SensorInfo *SensorInfo
+}
+
+// shorts returns the values of an int16s array of the maker note, nil if
+// the entry is missing or of another type
+func shorts(ifd *common.Ifd, tag uint16) []int16 {
+	e := ifd.Entry(tag)
+	if e == nil {
+		return nil
+	}
+	switch v := e.Value.(type) {
+	case []uint16:
+		result := make([]int16, len(v))
+		for i := range v {
+			result[i] = int16(v[i])
+		}
+		return result
+	case []int16:
+		return v
+	}
+	return nil
+}
+
+// at returns v[i], 0 if out of the array
+func at(v []int16, i int) int {
+	if i < len(v) {
+		return int(v[i])
+	}
+	return 0
+}
+
+// canonEv converts a Canon EV value, 1/32 EV with the thirds stored as 0x0c and 0x14
+func canonEv(val int) float64 {
+	sign := 1.0
+	if val < 0 {
+		sign, val = -1, -val
+	}
+	frac := float64(val & 0x1f)
+	v := float64(val) - frac
+	switch frac {
+	case 0x0c:
+		frac = 32.0 / 3
+	case 0x14:
+		frac = 64.0 / 3
+	}
+	return sign * (v + frac) / 32
+}
+
+// aperture converts a Canon EV aperture to the f-number
+func aperture(val int) float64 {
+	return math.Exp(canonEv(val) * math.Ln2 / 2)
+}
+
+// focusDistance converts a distance in cm, 0xffff means infinity
+func focusDistance(val int) float64 {
+	if uint16(val) == 0xffff {
+		return math.Inf(1)
+	}
+	return float64(uint16(val)) / 100
+}
+
+// readCameraSettings decodes the int16s array of tag 0x0001
+func readCameraSettings(v []int16) CameraSettings {
+	cs := CameraSettings{
+		MacroMode:    MacroMode(at(v, 1)),
+		SelfTimer:    at(v, 2),
+		Quality:      Quality(at(v, 3)),
+		FlashMode:    at(v, 4),
+		DriveMode:    DriveMode(at(v, 5)),
+		FocusMode:    FocusMode(at(v, 7)),
+		MeteringMode: at(v, 17),
+		FocusRange:   at(v, 18),
+		ExposureMode: at(v, 20),
+		LensType:     int(uint16(at(v, 22))),
+	}
+	if focalUnits := at(v, 25); focalUnits > 0 {
+		cs.MaxFocalLength = float64(uint16(at(v, 23))) / float64(focalUnits)
+		cs.MinFocalLength = float64(uint16(at(v, 24))) / float64(focalUnits)
+	}
+	if at(v, 26) != 0 {
+		cs.MaxAperture = aperture(at(v, 26))
+	}
+	if at(v, 27) != 0 {
+		cs.MinAperture = aperture(at(v, 27))
+	}
+	return cs
+}
+
+// readShotInfo decodes the int16s array of tag 0x0004
+func readShotInfo(v []int16) ShotInfo {
+	si := ShotInfo{
+		AutoISO:                math.Exp(float64(at(v, 1))/32*math.Ln2) * 100,
+		BaseISO:                math.Exp(float64(at(v, 2))/32*math.Ln2) * 100 / 32,
+		MeasuredEV:             float64(at(v, 3))/32 + 5,
+		ExposureCompensation:   canonEv(at(v, 6)),
+		WhiteBalance:           at(v, 7),
+		SequenceNumber:         at(v, 9),
+		FlashExposureComp:      canonEv(at(v, 15)),
+		AutoExposureBracketing: at(v, 16),
+		AEBBracketValue:        canonEv(at(v, 17)),
+		FocusDistanceUpper:     focusDistance(at(v, 19)),
+		FocusDistanceLower:     focusDistance(at(v, 20)),
+		BulbDuration:           at(v, 24),
+	}
+	if at(v, 4) != 0 {
+		si.TargetAperture = aperture(at(v, 4))
+	}
+	if at(v, 5) != 0 {
+		si.TargetExposureTime = math.Exp(-canonEv(at(v, 5)) * math.Ln2)
+	}
+	if t := at(v, 12); t != 0 {
+		si.CameraTemperature = t - 128
+	}
+	if at(v, 21) != 0 {
+		si.FNumber = aperture(at(v, 21))
+	}
+	if at(v, 22) != 0 {
+		si.ExposureTime = math.Exp(-canonEv(at(v, 22)) * math.Ln2)
+	}
+	return si
+}
+
+// readFileInfo decodes the int16s array of tag 0x0093
+func readFileInfo(v []int16, model string) FileInfo {
+	fi := FileInfo{
+		BracketMode:       BracketMode(at(v, 3)),
+		BracketValue:      at(v, 4),
+		BracketShotNumber: at(v, 5),
+		WBBracketMode:     at(v, 9),
+		WBBracketValueAB:  at(v, 12),
+		WBBracketValueGM:  at(v, 13),
+		LiveViewShooting:  at(v, 19) == 1,
+	}
+	// int32u in the words 1 and 2, high word first
+	n := uint32(uint16(at(v, 1)))<<16 | uint32(uint16(at(v, 2)))
+	if strings.Contains(model, "EOS-1D") {
+		fi.ShutterCount = n
+	} else {
+		fi.FileNumber = n
+	}
+	return fi
+}
+
+// ReadMakerNote decodes the Canon maker note IFD, model is the EXIF model
+func ReadMakerNote(ifd *common.Ifd, model string) *MakerNote {
+	mn := &MakerNote{
+		CameraSettings: readCameraSettings(shorts(ifd, tagCameraSettings)),
+		ShotInfo:       readShotInfo(shorts(ifd, tagShotInfo)),
+		FileInfo:       readFileInfo(shorts(ifd, tagFileInfo), model),
+		ColorData:      readColorData(shorts(ifd, tagColorData)),
+		SensorInfo:     readSensorInfo(shorts(ifd, tagSensorInfo)),
+	}
+	if e := ifd.Entry(tagImageType); e != nil {
+		mn.ImageType = e.String()
+	}
+	if e := ifd.Entry(tagFirmwareVersion); e != nil {
+		mn.FirmwareVersion = strings.TrimPrefix(e.String(), "Firmware Version ")
+	}
+	if e := ifd.Entry(tagSerialNumber); e != nil && e.Len() > 0 {
+		if strings.Contains(model, "EOS-1D") {
+			mn.SerialNumber = fmt.Sprintf("%06d", e.Int(0))
+		} else {
+			mn.SerialNumber = fmt.Sprintf("%010d", e.Int(0))
+		}
+	}
+	if e := ifd.Entry(tagModelID); e != nil && e.Len() > 0 {
+		mn.ModelID = uint32(e.Int(0))
+	}
+	if e := ifd.Entry(tagLensModel); e != nil {
+		mn.LensModel = strings.TrimSpace(e.String())
+	}
+	if e := ifd.Entry(tagInternalSerialNumber); e != nil {
+		mn.InternalSerialNumber = strings.TrimSpace(e.String())
+	}
+	return mn
+}
+
+// makerNoteDir returns the maker note IFD, in the EXIF IFD of ifd0
+func makerNoteDir(ifd0 *common.Ifd) *common.Ifd {
+	exif := ifd0.Entry(common.TagExifIFD)
+	if exif == nil || len(exif.Sub) == 0 {
+		return nil
+	}
+	if mn := exif.Sub[0].Entry(common.TagMakerNote); mn != nil && len(mn.Sub) > 0 {
+		return mn.Sub[0]
+	}
+	return nil
+}
+
+// readMetadata reads the EXIF and the maker note of IFD0, the lens and the
+// serial number missing in the EXIF are taken from the maker note
+func readMetadata(ifd0 *common.Ifd) (common.Metadata, *MakerNote) {
+	m := common.ReadMetadata(ifd0)
+	dir := makerNoteDir(ifd0)
+	if dir == nil {
+		return m, nil
+	}
+	mn := ReadMakerNote(dir, m.Model)
+	if m.Lens == "" {
+		m.Lens = mn.LensModel
+	}
+	if m.Serial == "" {
+		m.Serial = mn.SerialNumber
+	}
+	return m, mn
+}
+
+// ReadMakerNoteFile reads the maker note of the CR2 file in r
+func ReadMakerNoteFile(r io.ReaderAt) (*MakerNote, error) {
+	ifd0, err := readIfd0(r)
+	if err != nil {
+		return nil, err
+	}
+	_, mn := readMetadata(ifd0)
+	if mn == nil {
+		return nil, common.NewFormatError(format, ifd0.Offset, "maker note not found")
+	}
+	return mn, nil
+}
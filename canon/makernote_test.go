@@ -0,0 +1,109 @@
+package canon
+
+import (
+	"math"
+	"testing"
+
+	"github.com/enricod/rawmgr/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func testMakerNote() *common.Ifd {
+	cameraSettings := make([]uint16, 49)
+	cameraSettings[1] = 2
+	cameraSettings[3] = 4
+	cameraSettings[5] = 1
+	cameraSettings[7] = 1
+	cameraSettings[22] = 61
+	cameraSettings[23] = 105
+	cameraSettings[24] = 24
+	cameraSettings[25] = 1
+	cameraSettings[26] = 128
+	cameraSettings[27] = 320
+
+	shotInfo := make([]uint16, 34)
+	shotInfo[2] = 160
+	shotInfo[6] = 0xfff4 // -1/3 EV
+	shotInfo[12] = 153
+	shotInfo[19] = 0xffff
+	shotInfo[20] = 250
+	shotInfo[21] = 128
+	shotInfo[22] = 0xac
+
+	fileInfo := make([]uint16, 20)
+	fileInfo[1] = 0x0001
+	fileInfo[2] = 0x86a0
+	fileInfo[3] = 1
+	fileInfo[5] = 2
+
+	return &common.Ifd{Entries: []common.IfdEntry{
+		{Tag: tagCameraSettings, Type: common.TypeShort, Value: cameraSettings},
+		{Tag: tagShotInfo, Type: common.TypeShort, Value: shotInfo},
+		{Tag: tagFirmwareVersion, Type: common.TypeASCII, Value: "Firmware Version 1.0.4"},
+		{Tag: tagSerialNumber, Type: common.TypeLong, Value: []uint32{123456}},
+		{Tag: tagFileInfo, Type: common.TypeShort, Value: fileInfo},
+		{Tag: tagLensModel, Type: common.TypeASCII, Value: "EF24-105mm f/4L IS USM"},
+		{Tag: tagInternalSerialNumber, Type: common.TypeASCII, Value: "VB0123456"},
+	}}
+}
+
+func TestReadMakerNote(t *testing.T) {
+	assert := assert.New(t)
+
+	mn := ReadMakerNote(testMakerNote(), "Canon EOS 5D Mark III")
+	cs := mn.CameraSettings
+	assert.Equal("Normal", cs.MacroMode.String())
+	assert.Equal("RAW", cs.Quality.String())
+	assert.Equal("Continuous", cs.DriveMode.String())
+	assert.Equal("AI Servo AF", cs.FocusMode.String())
+	assert.Equal(61, cs.LensType)
+	assert.Equal(24.0, cs.MinFocalLength)
+	assert.Equal(105.0, cs.MaxFocalLength)
+	assert.InDelta(4.0, cs.MaxAperture, 1e-9)
+	assert.InDelta(32.0, cs.MinAperture, 1e-9)
+
+	si := mn.ShotInfo
+	assert.InDelta(100.0, si.BaseISO, 1e-9)
+	assert.InDelta(-1.0/3, si.ExposureCompensation, 1e-9)
+	assert.Equal(25, si.CameraTemperature)
+	assert.True(math.IsInf(si.FocusDistanceUpper, 1))
+	assert.Equal(2.5, si.FocusDistanceLower)
+	assert.InDelta(4.0, si.FNumber, 1e-9)
+	assert.InDelta(math.Pow(2, -(5+1.0/3)), si.ExposureTime, 1e-9)
+
+	fi := mn.FileInfo
+	assert.Equal(uint32(100000), fi.FileNumber)
+	assert.Equal(uint32(0), fi.ShutterCount)
+	assert.Equal("AEB", fi.BracketMode.String())
+	assert.Equal(2, fi.BracketShotNumber)
+
+	assert.Equal("1.0.4", mn.FirmwareVersion)
+	assert.Equal("0000123456", mn.SerialNumber)
+	assert.Equal("EF24-105mm f/4L IS USM", mn.LensModel)
+	assert.Equal("VB0123456", mn.InternalSerialNumber)
+
+	mn = ReadMakerNote(testMakerNote(), "Canon EOS-1D Mark IV")
+	assert.Equal(uint32(100000), mn.FileInfo.ShutterCount)
+	assert.Equal("123456", mn.SerialNumber)
+	assert.Equal("unknown (42)", DriveMode(42).String())
+
+	// missing arrays
+	mn = ReadMakerNote(&common.Ifd{}, "")
+	assert.Equal(CameraSettings{}.DriveMode, mn.CameraSettings.DriveMode)
+}
+
+func TestReadMetadataMakerNote(t *testing.T) {
+	assert := assert.New(t)
+
+	exif := &common.Ifd{Entries: []common.IfdEntry{
+		{Tag: common.TagMakerNote, Type: common.TypeUndefined, Value: []byte{}, Sub: []*common.Ifd{testMakerNote()}},
+	}}
+	ifd0 := &common.Ifd{Entries: []common.IfdEntry{
+		{Tag: common.TagModel, Type: common.TypeASCII, Value: "Canon EOS 5D Mark III"},
+		{Tag: common.TagExifIFD, Type: common.TypeLong, Value: []uint32{0}, Sub: []*common.Ifd{exif}},
+	}}
+	m, mn := readMetadata(ifd0)
+	assert.NotNil(mn)
+	assert.Equal("EF24-105mm f/4L IS USM", m.Lens)
+	assert.Equal("0000123456", m.Serial)
+}
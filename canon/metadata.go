@@ -0,0 +1,41 @@
+package canon
+
+import (
+	"io"
+
+	"github.com/enricod/rawmgr/common"
+)
+
+// readIfd0 reads IFD0 of the CR2 file in r, with the EXIF and the maker note
+func readIfd0(r io.ReaderAt) (*common.Ifd, error) {
+	reader, err := common.NewTiffReader(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	reader.SubIfdTags[common.TagMakerNote] = true
+	return reader.ReadIfd(reader.First)
+}
+
+// ReadMetadata reads the EXIF of the CR2 file in r, without reading the
+// raw data
+func ReadMetadata(r io.ReaderAt) (common.Metadata, error) {
+	ifd0, err := readIfd0(r)
+	if err != nil {
+		return common.Metadata{}, err
+	}
+	m, _ := readMetadata(ifd0)
+	return m, nil
+}
+
+// ReadExif reads the EXIF IFD of the CR2 file in r, with the maker note
+func ReadExif(r io.ReaderAt) (*common.Exif, error) {
+	reader, err := common.NewTiffReader(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	ifd0, err := reader.ReadIfd(reader.First)
+	if err != nil {
+		return nil, err
+	}
+	return reader.Exif(ifd0)
+}
@@ -0,0 +1,144 @@
+package canon
+
+import (
+	"image"
+
+	"github.com/enricod/rawmgr/common"
+)
+
+// tagSensorInfo maker note tag of the sensor size and borders
+const tagSensorInfo = 0x00e0
+
+// SensorInfo maker note tag 0x00e0, the borders are inclusive coordinates of
+// the raw image
+type SensorInfo struct {
+	Width        int
+	Height       int
+	LeftBorder   int
+	TopBorder    int
+	RightBorder  int
+	BottomBorder int
+	// BlackMask area of the masked pixels used by the camera for the black
+	// level, inclusive coordinates
+	BlackMaskLeft   int
+	BlackMaskTop    int
+	BlackMaskRight  int
+	BlackMaskBottom int
+}
+
+// readSensorInfo decodes the int16 array of tag 0x00e0, nil if too short
+func readSensorInfo(v []int16) *SensorInfo {
+	if len(v) < 9 {
+		return nil
+	}
+	u := func(i int) int { return int(uint16(at(v, i))) }
+	return &SensorInfo{
+		Width:           u(1),
+		Height:          u(2),
+		LeftBorder:      u(5),
+		TopBorder:       u(6),
+		RightBorder:     u(7),
+		BottomBorder:    u(8),
+		BlackMaskLeft:   u(9),
+		BlackMaskTop:    u(10),
+		BlackMaskRight:  u(11),
+		BlackMaskBottom: u(12),
+	}
+}
+
+// ActiveArea returns the visible part of the sensor
+func (s *SensorInfo) ActiveArea() image.Rectangle {
+	return image.Rect(s.LeftBorder, s.TopBorder, s.RightBorder+1, s.BottomBorder+1)
+}
+
+// applySensorInfo sets the active area of img and the black levels measured
+// on the masked border, left and top. The CFA starts with red at the top
+// left corner of the active area
+func applySensorInfo(img *common.RawImage, s *SensorInfo) {
+	area := s.ActiveArea()
+	if img.PixelSize() != 1 || area.Empty() || !area.In(img.Bounds()) || area == img.Bounds() {
+		return
+	}
+	img.ActiveArea = area
+	img.CFA = common.CFARGGB.Offset(-area.Min.X, -area.Min.Y)
+
+	masked := []image.Rectangle{image.Rect(area.Min.X, 0, area.Max.X, area.Min.Y)}
+	// as dcraw, the first two columns are often not black
+	if area.Min.X > 2 {
+		masked = append(masked, image.Rect(2, 0, area.Min.X, img.Height))
+	}
+	black, ok := img.MaskedBlackLevels(masked)
+	if !ok {
+		return
+	}
+	sum := 0
+	for c, b := range black {
+		img.ChannelBlackLevel[c] = b
+		sum += int(b)
+	}
+	img.BlackLevel = uint16((sum + 2) / 4)
+}
+
+// dcrawMargins the margins of dcraw for the raw sizes of the Canon cameras:
+// raw width and height, left and top margins, and the columns and rows
+// removed on the right and at the bottom (dcraw.c canon[])
+var dcrawMargins = [][6]int{
+	{1944, 1416, 0, 0, 48, 0},
+	{2144, 1560, 4, 8, 52, 2},
+	{2224, 1456, 48, 6, 0, 2},
+	{2376, 1728, 12, 6, 52, 2},
+	{2672, 1968, 12, 6, 44, 2},
+	{3152, 2068, 64, 12, 0, 0},
+	{3160, 2344, 44, 12, 4, 4},
+	{3344, 2484, 4, 6, 52, 6},
+	{3516, 2328, 42, 14, 0, 0},
+	{3596, 2360, 74, 12, 0, 0},
+	{3744, 2784, 52, 12, 8, 12},
+	{3944, 2622, 30, 18, 6, 2},
+	{3948, 2622, 42, 18, 0, 2},
+	{3984, 2622, 76, 20, 0, 2},
+	{4104, 3048, 48, 12, 24, 12},
+	{4116, 2178, 4, 2, 0, 0},
+	{4152, 2772, 192, 12, 0, 0},
+	{4160, 3124, 104, 11, 8, 65},
+	{4176, 3062, 96, 17, 8, 0},
+	{4192, 3062, 96, 17, 24, 0},
+	{4312, 2876, 22, 18, 0, 2},
+	{4352, 2874, 62, 18, 0, 0},
+	{4476, 2954, 90, 34, 0, 0},
+	{4480, 3348, 12, 10, 36, 12},
+	{4480, 3366, 80, 50, 0, 0},
+	{4496, 3366, 80, 50, 12, 0},
+	{4768, 3516, 96, 16, 0, 0},
+	{4832, 3204, 62, 26, 0, 0},
+	{4832, 3228, 62, 51, 0, 0},
+	{5108, 3349, 98, 13, 0, 0},
+	{5120, 3318, 142, 45, 62, 0},
+	{5280, 3528, 72, 52, 0, 0},
+	{5344, 3516, 142, 51, 0, 0},
+	{5344, 3584, 126, 100, 0, 2},
+	{5360, 3516, 158, 51, 0, 0},
+	{5568, 3708, 72, 38, 0, 0},
+	{5632, 3710, 96, 17, 0, 0},
+	{5712, 3774, 62, 20, 10, 2},
+	{5792, 3804, 158, 51, 0, 0},
+	{5920, 3950, 122, 80, 2, 0},
+	{6096, 4051, 76, 35, 0, 0},
+	{6096, 4056, 72, 34, 0, 0},
+	{6288, 4056, 264, 36, 0, 0},
+	{6384, 4224, 120, 44, 0, 0},
+	{6880, 4544, 136, 42, 0, 0},
+	{8896, 5920, 160, 64, 0, 0},
+}
+
+// dcrawArea returns the part of the raw image of width x height written by
+// dcraw -D, cropped with the margins of its table and not with the borders
+// of the sensor info. ok is false for the sizes not in the table
+func dcrawArea(width, height int) (area image.Rectangle, ok bool) {
+	for _, m := range dcrawMargins {
+		if m[0] == width && m[1] == height {
+			return image.Rect(m[2], m[3], width-m[4], height-m[5]), true
+		}
+	}
+	return image.Rectangle{}, false
+}
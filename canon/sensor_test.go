@@ -0,0 +1,91 @@
+package canon
+
+import (
+	"image"
+	"testing"
+
+	"github.com/enricod/rawmgr/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplySensorInfo(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// 12x8 raster, active area from column 5 and row 3
+	s := readSensorInfo([]int16{26, 12, 8, 0, 0, 5, 3, 10, 6, 0, 0, 4, 2})
+	require.NotNil(s)
+	assert.Equal(image.Rect(5, 3, 11, 7), s.ActiveArea())
+	assert.Equal(4, s.BlackMaskRight)
+
+	img := &common.RawImage{Width: 12, Height: 8, Pix: make([]uint16, 12*8), CFA: common.CFARGGB, BlackLevel: 1}
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			img.Pix[y*img.Width+x] = 1000
+			if x < 2 {
+				// not black, not used
+				img.Pix[y*img.Width+x] = 5000
+			}
+		}
+	}
+	// black of each channel in the masked border, red and blue higher
+	for y := 0; y < img.Height; y++ {
+		for x := 2; x < img.Width; x++ {
+			if x < 5 || y < 3 {
+				img.Pix[y*img.Width+x] = []uint16{130, 128, 128, 126}[2*((y-3)&1)+(x-5)&1]
+			}
+		}
+	}
+	applySensorInfo(img, s)
+	assert.Equal(image.Rect(5, 3, 11, 7), img.ActiveArea)
+	assert.Equal(uint8(common.Red), img.CFA.Color(3, 5))
+	assert.Equal(uint8(common.Blue), img.CFA.Color(4, 6))
+	assert.Equal([4]uint16{130, 128, 128, 126}, img.ChannelBlackLevel)
+	assert.Equal(uint16(128), img.BlackLevel)
+
+	crop := img.Crop()
+	assert.Equal(6, crop.Width)
+	assert.Equal(4, crop.Height)
+	assert.Equal(uint16(1000), crop.Sample(0, 0))
+	assert.Equal(uint8(common.Red), crop.CFA.Color(0, 0))
+
+	// active area from column 1, only the top rows are masked
+	s = readSensorInfo([]int16{26, 12, 8, 0, 0, 1, 2, 10, 6, 0, 0, 4, 2})
+	require.NotNil(s)
+	img = &common.RawImage{Width: 12, Height: 8, Pix: make([]uint16, 12*8), CFA: common.CFARGGB}
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			switch {
+			case x == 0:
+				img.Pix[y*img.Width+x] = 5000
+			case y < 2:
+				img.Pix[y*img.Width+x] = []uint16{130, 128, 128, 126}[2*(y&1)+(x-1)&1]
+			default:
+				img.Pix[y*img.Width+x] = 1000
+			}
+		}
+	}
+	applySensorInfo(img, s)
+	assert.Equal(image.Rect(1, 2, 11, 7), img.ActiveArea)
+	assert.Equal([4]uint16{130, 128, 128, 126}, img.ChannelBlackLevel)
+
+	// area outside the raster is ignored
+	img = &common.RawImage{Width: 4, Height: 4, Pix: make([]uint16, 16), CFA: common.CFARGGB}
+	applySensorInfo(img, s)
+	assert.True(img.ActiveArea.Empty())
+
+	assert.Nil(readSensorInfo([]int16{1, 2}))
+}
+
+func TestDcrawArea(t *testing.T) {
+	assert := assert.New(t)
+
+	area, ok := dcrawArea(5920, 3950)
+	assert.True(ok)
+	assert.Equal(image.Rect(122, 80, 5918, 3950), area)
+	assert.Equal(image.Pt(5796, 3870), area.Size())
+
+	_, ok = dcrawArea(5921, 3950)
+	assert.False(ok)
+}
@@ -0,0 +1,158 @@
+package canon
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/enricod/rawmgr/common"
+	"github.com/enricod/rawmgr/ljpeg"
+)
+
+// isSRaw reports whether the lossless JPEG frame is a sRAW or mRAW: YCbCr
+// with the luma sampled 2x1 or 2x2
+func isSRaw(frame ljpeg.Frame) bool {
+	if len(frame.Components) != 3 {
+		return false
+	}
+	c := frame.Components[0]
+	return c.HorizontalSamplingFactor == 2 && (c.VerticalSamplingFactor == 1 || c.VerticalSamplingFactor == 2)
+}
+
+// sRawConversion camera values of the YCbCr to RGB conversion
+type sRawConversion struct {
+	modelID uint32
+	// firmware version as major*1000000 + minor*1000 + patch
+	firmware int
+	// levels R G B, 1024 is 1
+	levels [3]int
+}
+
+// newSRawConversion reads the conversion values from the maker note
+func newSRawConversion(mn *MakerNote) sRawConversion {
+	conv := sRawConversion{levels: [3]int{1024, 1024, 1024}}
+	if mn == nil {
+		return conv
+	}
+	conv.modelID = mn.ModelID
+	conv.firmware = parseFirmware(mn.FirmwareVersion)
+	if mn.ColorData != nil {
+		l := mn.ColorData.SRawLevels
+		conv.levels = [3]int{l[0], l[1], l[3]}
+	}
+	return conv
+}
+
+// parseFirmware converts a version as "1.0.6", the text before is ignored
+func parseFirmware(s string) int {
+	var v [3]int
+	s = strings.TrimLeftFunc(s, func(c rune) bool { return !unicode.IsDigit(c) })
+	fmt.Sscanf(s, "%d.%d.%d", &v[0], &v[1], &v[2])
+	return (v[0]*1000+v[1])*1000 + v[2]
+}
+
+// decodeSRaw converts the MCUs of a sRAW to RGB. raster has the MCUs in
+// their final position, h*v luma samples then Cb and Cr, width samples for
+// each of the height MCU rows. The chroma is interpolated and converted as
+// dcraw does
+func decodeSRaw(raster []uint16, width, height, h, v int, conv sRawConversion) *common.RawImage {
+	luma := h * v
+	n := luma + 2
+	w, ht := width/n*h, height*v
+	ycc := make([]int32, w*ht*3)
+	common.ParallelRows(height, common.WorkersCount(), func(start, end int) {
+		for my := start; my < end; my++ {
+			for mx := 0; mx < width/n; mx++ {
+				mcu := raster[my*width+mx*n:]
+				for k := 0; k < luma; k++ {
+					ycc[((my*v+k/h)*w+mx*h+k%h)*3] = int32(mcu[k])
+				}
+				i := (my*v*w + mx*h) * 3
+				ycc[i+1] = int32(mcu[luma]) - 16384
+				ycc[i+2] = int32(mcu[luma+1]) - 16384
+			}
+		}
+	})
+
+	// the chroma is only at the top left pixel of the MCU: the odd rows
+	// are interpolated from the rows above and below, then the odd columns
+	// from the left and the right
+	common.ParallelRows(ht, common.WorkersCount(), func(start, end int) {
+		for y := start; y < end; y++ {
+			row := ycc[y*w*3 : (y+1)*w*3]
+			if v == 2 && y&1 == 1 {
+				for x := 0; x < w; x += 2 {
+					for c := 1; c < 3; c++ {
+						above := ycc[((y-1)*w+x)*3+c]
+						if y == ht-1 {
+							row[x*3+c] = above
+						} else {
+							row[x*3+c] = (above + ycc[((y+1)*w+x)*3+c] + 1) >> 1
+						}
+					}
+				}
+			}
+			for x := 1; x < w; x += 2 {
+				for c := 1; c < 3; c++ {
+					if x == w-1 {
+						row[x*3+c] = row[(x-1)*3+c]
+					} else {
+						row[x*3+c] = (row[(x-1)*3+c] + row[(x+1)*3+c] + 1) >> 1
+					}
+				}
+			}
+		}
+	})
+
+	img := &common.RawImage{
+		Width:      w,
+		Height:     ht,
+		Pix:        make([]uint16, w*ht*3),
+		Colors:     3,
+		WhiteLevel: 0x3fff,
+	}
+	common.ParallelRows(ht, common.WorkersCount(), func(start, end int) {
+		for i := start * w * 3; i < end*w*3; i += 3 {
+			rgb := conv.rgb(ycc[i], ycc[i+1], ycc[i+2], luma-1)
+			for c := range rgb {
+				img.Pix[i+c] = uint16(clip(rgb[c] * int32(conv.levels[c]) >> 10))
+			}
+		}
+	})
+	return img
+}
+
+// oldSRawModels bodies converting the colors with the first formula
+var oldSRawModels = map[uint32]bool{0x80000218: true, 0x80000250: true, 0x80000261: true, 0x80000281: true, 0x80000287: true}
+
+// rgb converts a pixel, sraw is the number of luma samples in the MCU less
+// one. The values are truncated to 16 bits as dcraw does
+func (conv sRawConversion) rgb(y, cb, cr int32, sraw int) [3]int32 {
+	if oldSRawModels[conv.modelID] {
+		hue := int32(sraw+1) << 2
+		if conv.modelID >= 0x80000281 || (conv.modelID == 0x80000218 && conv.firmware > 1000006) {
+			hue = int32(sraw) << 1
+		}
+		cb = int32(int16(cb<<2 + hue))
+		cr = int32(int16(cr<<2 + hue))
+		return [3]int32{
+			y + ((50*cb + 22929*cr) >> 14),
+			y + ((-5640*cb - 11751*cr) >> 14),
+			y + ((29040*cb - 101*cr) >> 14),
+		}
+	}
+	if conv.modelID < 0x80000218 {
+		y = int32(int16(y - 512))
+	}
+	return [3]int32{y + cr, y + ((-778*cb - (cr << 11)) >> 12), y + cb}
+}
+
+func clip(v int32) int32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 0xffff {
+		return 0xffff
+	}
+	return v
+}
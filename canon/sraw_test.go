@@ -0,0 +1,90 @@
+package canon
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sRawJPEG encodes a frame of MCUs with 2x1 sampled luma, Cb and Cr,
+// predicted as the Canon sRAW
+func sRawJPEG(samples []uint16, frameWidth int) []byte {
+	const n, luma = 4, 2
+	frameHeight := len(samples) / frameWidth
+	width := frameWidth / n * 2
+
+	out := []byte{0xff, 0xd8, 0xff, 0xc4, 0x00, 0x24, 0x00, 0, 0, 0, 0, 17, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	for v := 0; v <= 16; v++ {
+		out = append(out, byte(v))
+	}
+	out = append(out, 0xff, 0xc3, 0x00, 17, 15, byte(frameHeight>>8), byte(frameHeight), byte(width>>8), byte(width), 3,
+		1, 0x21, 0, 2, 0x11, 0, 3, 0x11, 0)
+	out = append(out, 0xff, 0xda, 0x00, 12, 3, 1, 0, 2, 0, 3, 0, 1, 0, 0)
+	return append(out, encodeDiffs(samples, func(i int) int {
+		col, k := i%frameWidth, i%n
+		switch {
+		case k > 0 && k < luma:
+			return int(samples[i-1])
+		case k == 0 && col > 0:
+			return int(samples[i-n+luma-1])
+		case col >= n:
+			return int(samples[i-n])
+		case i >= frameWidth:
+			return int(samples[i-frameWidth])
+		default:
+			return 1 << 14
+		}
+	})...)
+}
+
+func TestDecodeSRaw(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// 2 MCUs for each of the 2 rows: Y Y Cb Cr
+	frame := []uint16{
+		4000, 4000, 16384 + 100, 16384, 4100, 4100, 16384 + 300, 16384,
+		4000, 4000, 16384, 16384 - 200, 4000, 4000, 16384, 16384 - 200,
+	}
+	data := testCR2(sRawJPEG(frame, 8), rawSlice{LastSliceSize: 8})
+
+	config, err := DecodeConfig(bytes.NewReader(data), int64(len(data)))
+	require.Nil(err)
+	assert.Equal(image.Config{ColorModel: color.RGBA64Model, Width: 4, Height: 2}, config)
+
+	img, err := Decode(bytes.NewReader(data), int64(len(data)))
+	require.Nil(err)
+	assert.Equal(3, img.PixelSize())
+	assert.Equal(image.Rect(0, 0, 4, 2), img.Bounds())
+	y := 4000 - 512
+	// R = Y + Cr, G = Y - (778 Cb + 2048 Cr) / 4096, B = Y + Cb
+	assert.Equal([]uint16{uint16(y), uint16(y - 19), uint16(y + 100)}, img.Pix[0:3])
+	// Cb interpolated between the MCUs
+	assert.Equal(uint16(y+200), img.Pix[5])
+	assert.Equal(uint16(y+100+300), img.Pix[8])
+	// last column as the one on the left
+	assert.Equal(img.Pix[8], img.Pix[11])
+	assert.Equal([]uint16{uint16(y - 200), uint16(y + 100), uint16(y)}, img.Pix[12:15])
+	assert.Equal(uint16(0x3fff), img.WhiteLevel)
+	assert.Equal(color.RGBA64Model, img.ColorModel())
+}
+
+func TestSRawConversion(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(1000006, parseFirmware("Firmware Version 1.0.6"))
+	assert.Equal(2001000, parseFirmware("2.1"))
+
+	// 5D: older formula, hue from the firmware
+	conv := sRawConversion{modelID: 0x80000218, firmware: 1000006}
+	assert.Equal([3]int32{1000, 1000, 1000}, conv.rgb(1000, -2, -2, 1))
+	conv.firmware = 1000007
+	assert.Equal([3]int32{1002, 997, 1003}, conv.rgb(1000, 0, 0, 1))
+
+	conv = sRawConversion{modelID: 0x80000270}
+	assert.Equal([3]int32{1050, 975, 1000}, conv.rgb(1000, 0, 50, 1))
+}
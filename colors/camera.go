@@ -0,0 +1,136 @@
+package colors
+
+import (
+	"strings"
+
+	"github.com/enricod/rawmgr/common"
+)
+
+type adobeCoeff struct {
+	prefix  string
+	black   uint16
+	maximum uint16
+	trans   [12]int16
+}
+
+// corps the names of the makers, as dcraw
+var corps = []string{
+	"AgfaPhoto", "Canon", "Casio", "Epson", "Fujifilm",
+	"Mamiya", "Minolta", "Motorola", "Kodak", "Konica", "Leica",
+	"Nikon", "Nokia", "Olympus", "Ricoh", "Pentax", "Phase One",
+	"Samsung", "Sigma", "Sinar", "Sony", "YI",
+}
+
+// Camera the colors of a camera model
+type Camera struct {
+	// Name the make and the model matched in the table
+	Name string
+	// Black and Maximum the levels the model needs, 0 when the ones of the
+	// file apply
+	Black   uint16
+	Maximum uint16
+	// XYZToCamera from XYZ D65 to the camera RGB, as a DNG ColorMatrix
+	XYZToCamera Matrix
+}
+
+// NormalizeName returns make and model as in the table: the make is the
+// short name of the company and the model does not repeat it, e.g.
+// "NIKON CORPORATION", "NIKON D850" are "Nikon", "D850"
+func NormalizeName(make, model string) (string, string) {
+	make, model = strings.TrimSpace(make), strings.TrimSpace(model)
+	for _, c := range corps {
+		if strings.Contains(strings.ToLower(make), strings.ToLower(c)) {
+			make = c
+			break
+		}
+	}
+	if len(model) >= 6 && strings.EqualFold(model[:6], "PENTAX") {
+		make = "Pentax"
+	}
+	if len(model) > len(make) && strings.EqualFold(model[:len(make)], make) && model[len(make)] == ' ' {
+		model = model[len(make)+1:]
+	}
+	model = strings.TrimPrefix(model, "FinePix ")
+	model = strings.TrimPrefix(model, "Digital Camera ")
+	return make, model
+}
+
+// LookupCamera returns the colors of the camera of make and model, as in
+// the EXIF. ok is false if the model is not known or it is a camera of 4
+// colors
+func LookupCamera(make, model string) (c *Camera, ok bool) {
+	make, model = NormalizeName(make, model)
+	name := make + " " + model
+	for _, e := range adobeCoeffs {
+		if !strings.HasPrefix(name, e.prefix) {
+			continue
+		}
+		if e.trans[0] == 0 || e.trans[9] != 0 || e.trans[10] != 0 || e.trans[11] != 0 {
+			return nil, false
+		}
+		c = &Camera{Name: e.prefix, Black: e.black, Maximum: e.maximum}
+		for i := range c.XYZToCamera {
+			for j := range c.XYZToCamera[i] {
+				c.XYZToCamera[i][j] = float64(e.trans[i*3+j]) / 10000
+			}
+		}
+		return c, true
+	}
+	return nil, false
+}
+
+// CameraToRGB returns the matrix from the camera RGB to linear sRGB, and
+// the multipliers that balance the white of daylight, as dcraw
+// cam_xyz_coeff. The rows of the camera are normalized so that the white
+// balanced camera white is the sRGB white
+func (c *Camera) CameraToRGB() (Matrix, [3]float64) {
+	camRGB := c.XYZToCamera.Mul(Matrix(xyzRGB))
+	var preMul [3]float64
+	for i := range camRGB {
+		sum := camRGB[i][0] + camRGB[i][1] + camRGB[i][2]
+		for j := range camRGB[i] {
+			camRGB[i][j] /= sum
+		}
+		preMul[i] = 1 / sum
+	}
+	return camRGB.Inverse(), preMul
+}
+
+// ImageToRGB returns the matrix from the camera RGB of img to linear sRGB,
+// as CameraToRGB, nil if the camera of img is not known
+func ImageToRGB(img *common.RawImage) *[3][3]float64 {
+	cam, ok := LookupCamera(img.Metadata.Make, img.Metadata.Model)
+	if !ok {
+		return nil
+	}
+	rgbCam, _ := cam.CameraToRGB()
+	m := [3][3]float64(rgbCam)
+	return &m
+}
+
+// Levels returns the black level of each channel, R G G B, and the white
+// level of img. The table of the camera sets the levels the files do not
+// have, and the white level of the models that saturate below it. cam is
+// nil for the models not in the table
+func Levels(img *common.RawImage, cam *Camera) ([4]int, int) {
+	var black [4]int
+	for c := range black {
+		black[c] = int(img.BlackLevel)
+		if img.ChannelBlackLevel != [4]uint16{} {
+			black[c] = int(img.ChannelBlackLevel[c])
+		}
+	}
+	white := int(img.WhiteLevel)
+	if cam != nil {
+		if cam.Black != 0 && black == [4]int{} {
+			black = [4]int{int(cam.Black), int(cam.Black), int(cam.Black), int(cam.Black)}
+		}
+		if cam.Maximum != 0 {
+			white = int(cam.Maximum)
+		}
+	}
+	if white == 0 {
+		white = 0xffff
+	}
+	return black, white
+}
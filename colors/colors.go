@@ -0,0 +1,164 @@
+// Package colors converts the colors of the cameras, with the matrices of
+// dcraw, to the color spaces of the output
+package colors
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/enricod/rawmgr/common"
+)
+
+var xyzRGB = common.XYZRGB
+
+// Matrix a 3x3 matrix, rows by columns
+type Matrix [3][3]float64
+
+// Identity the identity matrix
+var Identity = Matrix{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+// Mul returns m times n
+func (m Matrix) Mul(n Matrix) Matrix {
+	var r Matrix
+	for i := range r {
+		for j := range r[i] {
+			for k := range n {
+				r[i][j] += m[i][k] * n[k][j]
+			}
+		}
+	}
+	return r
+}
+
+// Inverse returns the inverse of m, by Gauss-Jordan elimination as dcraw
+// pseudoinverse
+func (m Matrix) Inverse() Matrix {
+	var work [3][6]float64
+	for i := range work {
+		work[i][i+3] = 1
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				work[i][j] += m[k][i] * m[k][j]
+			}
+		}
+	}
+	for i := range work {
+		num := work[i][i]
+		for j := range work[i] {
+			work[i][j] /= num
+		}
+		for k := range work {
+			if k == i {
+				continue
+			}
+			num := work[k][i]
+			for j := range work[k] {
+				work[k][j] -= work[i][j] * num
+			}
+		}
+	}
+	var r Matrix
+	for i := range r {
+		for j := range r[i] {
+			for k := 0; k < 3; k++ {
+				r[j][i] += work[j][k+3] * m[i][k]
+			}
+		}
+	}
+	return r
+}
+
+// Apply returns m times the column v
+func (m Matrix) Apply(v [3]float64) [3]float64 {
+	var r [3]float64
+	for i := range r {
+		r[i] = m[i][0]*v[0] + m[i][1]*v[1] + m[i][2]*v[2]
+	}
+	return r
+}
+
+// Space a color space of the output, with the primaries of each
+type Space int
+
+// Spaces, all with the white of D65 as dcraw -o
+const (
+	SRGB Space = iota
+	AdobeRGB
+	ProPhoto
+	XYZ
+)
+
+var spaceNames = []string{"sRGB", "Adobe RGB (1998)", "ProPhoto D65", "XYZ"}
+
+// fromSRGB the matrices from linear sRGB to each space
+var fromSRGB = []Matrix{
+	Identity,
+	{
+		{0.715146, 0.284856, 0.000000},
+		{0.000000, 1.000000, 0.000000},
+		{0.000000, 0.041166, 0.958839},
+	},
+	{
+		{0.529317, 0.330092, 0.140588},
+		{0.098368, 0.873465, 0.028169},
+		{0.016879, 0.117663, 0.865457},
+	},
+	Matrix(xyzRGB),
+}
+
+func (s Space) String() string {
+	if s < 0 || int(s) >= len(spaceNames) {
+		return fmt.Sprintf("Space(%d)", int(s))
+	}
+	return spaceNames[s]
+}
+
+// FromSRGB returns the matrix from linear sRGB to s
+func (s Space) FromSRGB() Matrix {
+	if s < 0 || int(s) >= len(fromSRGB) {
+		return Identity
+	}
+	return fromSRGB[s]
+}
+
+// Transform returns the matrix from the white balanced camera RGB of c to
+// the linear colors of space
+func (c *Camera) Transform(space Space) Matrix {
+	rgbCam, _ := c.CameraToRGB()
+	return space.FromSRGB().Mul(rgbCam)
+}
+
+// Convert applies m to the colors of the RGB image img, whose samples are
+// black subtracted and white balanced, clipping the results to 16 bits. The
+// pixels are converted by rows in parallel, in place
+func Convert(img *common.RawImage, m Matrix) error {
+	if img.Colors != 3 {
+		return errors.New("colors: not a RGB image")
+	}
+	var f [3][3]float32
+	for i := range f {
+		for j := range f[i] {
+			f[i][j] = float32(m[i][j])
+		}
+	}
+	common.ParallelRows(img.Height, common.WorkersCount(), func(start, end int) {
+		pix := img.Pix[start*img.Width*3 : end*img.Width*3]
+		for i := 0; i < len(pix); i += 3 {
+			r, g, b := float32(pix[i]), float32(pix[i+1]), float32(pix[i+2])
+			for c := range f {
+				pix[i+c] = clip(f[c][0]*r + f[c][1]*g + f[c][2]*b)
+			}
+		}
+	})
+	return nil
+}
+
+func clip(v float32) uint16 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 0xffff {
+		return 0xffff
+	}
+	return uint16(v)
+}
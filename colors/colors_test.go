@@ -0,0 +1,129 @@
+package colors
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/enricod/rawmgr/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func assertMatrix(t *testing.T, expected, actual Matrix) {
+	for i := range expected {
+		assert.InDeltaSlice(t, expected[i][:], actual[i][:], 1e-6)
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	assert := assert.New(t)
+
+	make, model := NormalizeName("NIKON CORPORATION", "NIKON D850 ")
+	assert.Equal("Nikon", make)
+	assert.Equal("D850", model)
+	make, model = NormalizeName("Canon", "Canon EOS 5D Mark III")
+	assert.Equal("Canon", make)
+	assert.Equal("EOS 5D Mark III", model)
+	make, model = NormalizeName("FUJIFILM", "FinePix S5Pro")
+	assert.Equal("Fujifilm", make)
+	assert.Equal("S5Pro", model)
+	make, _ = NormalizeName("RICOH IMAGING COMPANY, LTD.", "PENTAX K-1")
+	assert.Equal("Pentax", make)
+}
+
+func TestLookupCamera(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, ok := LookupCamera("Canon", "Canon EOS 5D Mark III")
+	require.True(ok)
+	assert.Equal("Canon EOS 5D Mark III", c.Name)
+	assert.Equal(uint16(0x3c80), c.Maximum)
+	assert.Equal(0.6722, c.XYZToCamera[0][0])
+	assert.Equal(0.5668, c.XYZToCamera[2][2])
+
+	// the longer names come first
+	c, ok = LookupCamera("Canon", "Canon EOS 5D Mark II")
+	require.True(ok)
+	assert.Equal("Canon EOS 5D Mark II", c.Name)
+	c, ok = LookupCamera("Canon", "Canon EOS 5D")
+	require.True(ok)
+	assert.Equal("Canon EOS 5D", c.Name)
+
+	_, ok = LookupCamera("Canon", "Canon PowerShot G1")
+	assert.False(ok, "4 colors")
+	_, ok = LookupCamera("Canon", "Canon PowerShot A530")
+	assert.False(ok, "no matrix")
+	_, ok = LookupCamera("Unknown", "Camera")
+	assert.False(ok)
+}
+
+func TestCameraToRGB(t *testing.T) {
+	assert := assert.New(t)
+
+	m := Matrix{{2, 1, 0}, {0, 1, 3}, {1, 0, 1}}
+	assertMatrix(t, Identity, m.Mul(m.Inverse()))
+
+	c, _ := LookupCamera("FUJIFILM", "X-T2")
+	rgbCam, preMul := c.CameraToRGB()
+	// the white balanced white of the camera is white
+	white := rgbCam.Apply([3]float64{1, 1, 1})
+	assert.InDeltaSlice([]float64{1, 1, 1}, white[:], 1e-6)
+	// the camera sees the sRGB white as the inverse of the multipliers
+	cam := c.XYZToCamera.Apply(Matrix(common.XYZRGB).Apply([3]float64{1, 1, 1}))
+	for i := range cam {
+		assert.InDelta(1, cam[i]*preMul[i], 1e-6)
+	}
+
+	assertMatrix(t, rgbCam, c.Transform(SRGB))
+	img := &common.RawImage{Metadata: common.Metadata{Make: "FUJIFILM", Model: "X-T2"}}
+	assertMatrix(t, rgbCam, Matrix(*ImageToRGB(img)))
+	img.Metadata.Model = "Unknown"
+	assert.Nil(ImageToRGB(img))
+	xyz := c.Transform(XYZ).Apply([3]float64{1, 1, 1})
+	assert.InDeltaSlice(common.D65White[:], xyz[:], 1e-5)
+	assert.Equal("ProPhoto D65", ProPhoto.String())
+}
+
+func TestConvert(t *testing.T) {
+	assert := assert.New(t)
+
+	img := &common.RawImage{Width: 2, Height: 1, Colors: 3, Pix: []uint16{100, 200, 300, 60000, 1000, 0}}
+	m := Matrix{{1.5, 0, 0}, {0.5, 0.5, 0}, {-1, 0, 2}}
+	assert.Nil(Convert(img, m))
+	assert.Equal([]uint16{150, 150, 500, 0xffff, 30500, 0}, img.Pix)
+
+	img.Colors = 1
+	assert.NotNil(Convert(img, m))
+}
+
+func TestICCProfile(t *testing.T) {
+	assert := assert.New(t)
+
+	p := AdobeRGB.ICCProfile(2.2)
+	assert.Len(p, 476)
+	assert.Equal(uint32(476), binary.BigEndian.Uint32(p))
+	assert.Equal("mntrRGB XYZ ", string(p[12:24]))
+	assert.Equal("acsp", string(p[36:40]))
+	assert.Equal(uint32(10), binary.BigEndian.Uint32(p[128:]))
+	// the tags: signature, offset and size
+	tags := map[string][]byte{}
+	for i := 0; i < 10; i++ {
+		e := p[132+12*i:]
+		offset, size := binary.BigEndian.Uint32(e[4:]), binary.BigEndian.Uint32(e[8:])
+		tags[string(e[:4])] = p[offset : offset+size]
+	}
+	assert.Contains(string(tags["desc"]), "Adobe RGB (1998)")
+	assert.Equal([]byte{'c', 'u', 'r', 'v', 0, 0, 0, 0, 0, 0, 0, 1, 2, 0x33}, tags["gTRC"])
+	// the primaries sum to the D50 white
+	var white [3]float64
+	for _, tag := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		assert.Equal("XYZ ", string(tags[tag][:4]))
+		for i := range white {
+			white[i] += float64(int32(binary.BigEndian.Uint32(tags[tag][8+4*i:]))) / 0x10000
+		}
+	}
+	assert.InDeltaSlice([]float64{0.9642, 1, 0.8249}, white[:], 0.001)
+
+	assert.Equal("XYZ ", string(XYZ.ICCProfile(1)[16:20]))
+}
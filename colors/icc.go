@@ -0,0 +1,76 @@
+package colors
+
+import (
+	"encoding/binary"
+)
+
+// xyzD50SRGB XYZ D50 of the sRGB primaries
+var xyzD50SRGB = Matrix{
+	{0.436083, 0.385083, 0.143055},
+	{0.222507, 0.716888, 0.060608},
+	{0.013930, 0.097097, 0.714022},
+}
+
+// ICCProfile returns the ICC profile of the space, with the gamma of the
+// curves of the primaries, as the profiles dcraw embeds in the TIFF
+func (s Space) ICCProfile(gamma float64) []byte {
+	head := []uint32{
+		1024, 0, 0x2100000, 0x6d6e7472, 0x52474220, 0x58595a20, 0, 0, 0,
+		0x61637370, 0, 0, 0x6e6f6e65, 0, 0, 0, 0, 0xf6d6, 0x10000, 0xd32d,
+	}
+	// count, then signature, offset and size of the tags
+	body := []uint32{
+		10, 0x63707274, 0, 36, // cprt
+		0x64657363, 0, 40, // desc
+		0x77747074, 0, 20, // wtpt
+		0x626b7074, 0, 20, // bkpt
+		0x72545243, 0, 14, // rTRC
+		0x67545243, 0, 14, // gTRC
+		0x62545243, 0, 14, // bTRC
+		0x7258595a, 0, 20, // rXYZ
+		0x6758595a, 0, 20, // gXYZ
+		0x6258595a, 0, 20, // bXYZ
+	}
+	white := []uint32{0xf351, 0x10000, 0x116cc}
+	curve := []uint32{0x63757276, 0, 1, uint32(uint16(256*gamma+0.5)) << 16}
+
+	prof := make([]uint32, 256)
+	copy(prof, head)
+	if s == XYZ {
+		prof[4] = prof[5]
+	}
+	prof[0] = 132 + 12*body[0]
+	for i := uint32(0); i < body[0]; i++ {
+		switch i {
+		case 0:
+			prof[prof[0]/4] = 0x74657874 // text
+		case 1:
+			prof[prof[0]/4] = 0x64657363 // desc
+		default:
+			prof[prof[0]/4] = 0x58595a20 // XYZ
+		}
+		body[i*3+2] = prof[0]
+		prof[0] += (body[i*3+3] + 3) &^ 3
+	}
+	copy(prof[32:], body)
+	name := s.String()
+	prof[body[5]/4+2] = uint32(len(name) + 1)
+	copy(prof[body[8]/4+2:], white)
+	for i := 4; i < 7; i++ {
+		copy(prof[body[i*3+2]/4:], curve)
+	}
+	primaries := xyzD50SRGB.Mul(s.FromSRGB().Inverse())
+	for j := 0; j < 3; j++ {
+		for i := 0; i < 3; i++ {
+			prof[body[j*3+23]/4+uint32(i)+2] = uint32(int32(primaries[i][j]*0x10000 + 0.5))
+		}
+	}
+
+	b := make([]byte, 4*len(prof))
+	for i, v := range prof {
+		binary.BigEndian.PutUint32(b[4*i:], v)
+	}
+	copy(b[body[2]+8:], "auto-generated by rawmgr")
+	copy(b[body[5]+12:], name)
+	return b[:prof[0]]
+}
@@ -0,0 +1,175 @@
+package common
+
+import (
+	"errors"
+	"math/bits"
+)
+
+// ErrBitStreamExhausted is returned when more bits are requested than the
+// entropy coded segment contains
+var ErrBitStreamExhausted = errors.New("bit stream exhausted")
+
+// BitReader reads an entropy coded JPEG segment MSB first.
+// The 0x00 stuffed after every 0xff byte is dropped while reading, so the
+// data can be passed as it is in the file, without cleaning it first.
+// When a marker (0xff followed by a non zero byte) is found the reader
+// stops consuming bytes and feeds zeros, as libjpeg does.
+// NewPlainBitReader reads the data as it is, without stuffed bytes nor
+// markers.
+type BitReader struct {
+	data   []byte
+	pos    int
+	acc    uint64
+	nbits  uint
+	marker bool
+	plain  bool
+	// zeros counts the bytes fed after the end of the data or a marker
+	zeros int
+}
+
+// NewBitReader creates a reader starting at the first byte of data
+func NewBitReader(data []byte) *BitReader {
+	return &BitReader{data: data}
+}
+
+// NewPlainBitReader creates a reader of data with no JPEG byte stuffing, as
+// the CRX and the Fuji compressed streams
+func NewPlainBitReader(data []byte) *BitReader {
+	return &BitReader{data: data, plain: true}
+}
+
+// fill loads bytes in the accumulator until it holds at least 57 bits
+func (b *BitReader) fill() {
+	// fast path, no 0xff in the bytes to load
+	for b.nbits <= 56 && !b.marker && b.pos < len(b.data) && (b.plain || b.data[b.pos] != 0xff) {
+		b.acc |= uint64(b.data[b.pos]) << (56 - b.nbits)
+		b.nbits += 8
+		b.pos++
+	}
+	for b.nbits <= 56 {
+		var c byte
+		if b.marker || b.pos >= len(b.data) {
+			b.zeros++
+		} else {
+			c = b.data[b.pos]
+			if c == 0xff && !b.plain {
+				if b.pos+1 < len(b.data) && b.data[b.pos+1] == 0x00 {
+					b.pos += 2
+				} else {
+					b.marker = true
+					c = 0
+					b.zeros++
+				}
+			} else {
+				b.pos++
+			}
+		}
+		b.acc |= uint64(c) << (56 - b.nbits)
+		b.nbits += 8
+	}
+}
+
+// Peek returns the next n bits (n <= 32) without consuming them
+func (b *BitReader) Peek(n uint) uint32 {
+	if b.nbits < n {
+		b.fill()
+	}
+	return uint32(b.acc >> (64 - n))
+}
+
+// Skip consumes n bits, previously returned by Peek
+func (b *BitReader) Skip(n uint) {
+	if b.nbits < n {
+		b.fill()
+	}
+	b.acc <<= n
+	b.nbits -= n
+}
+
+// ReadBits reads n bits (n <= 32) as an unsigned number
+func (b *BitReader) ReadBits(n uint) uint32 {
+	if n == 0 {
+		return 0
+	}
+	v := b.Peek(n)
+	b.Skip(n)
+	return v
+}
+
+// ReadZeros counts the 0 bits before the next 1, consuming the 1 too. It
+// stops at the end of the data
+func (b *BitReader) ReadZeros() int {
+	n := 0
+	for {
+		if z := bits.LeadingZeros32(b.Peek(32)); z < 32 {
+			b.Skip(uint(z) + 1)
+			return n + z
+		}
+		b.Skip(32)
+		n += 32
+		if b.Err() != nil {
+			return n
+		}
+	}
+}
+
+// ReadDiff reads the n additional bits following a Huffman coded length and
+// extends them to a signed difference (ITU T.81, F.2.2.1 and H.1.2.2)
+func (b *BitReader) ReadDiff(n uint) int32 {
+	switch n {
+	case 0:
+		return 0
+	case 16:
+		// lossless only: no additional bits are stored
+		return -32768
+	}
+	v := int32(b.ReadBits(n))
+	if v < 1<<(n-1) {
+		v -= 1<<n - 1
+	}
+	return v
+}
+
+// Reset discards the buffered bits and skips the next marker (a restart
+// marker, usually), so decoding can continue from the following byte.
+// Returns the marker skipped, 0 if the data ended before a marker
+func (b *BitReader) Reset() uint16 {
+	for !b.marker && b.pos+1 < len(b.data) {
+		if b.data[b.pos] == 0xff && b.data[b.pos+1] != 0x00 {
+			b.marker = true
+		} else {
+			b.pos++
+		}
+	}
+	marker := b.Marker()
+	b.acc = 0
+	b.nbits = 0
+	b.zeros = 0
+	if b.marker {
+		b.pos += 2
+		b.marker = false
+	}
+	return marker
+}
+
+// Marker returns the marker that stopped the reader, 0 if none was found
+func (b *BitReader) Marker() uint16 {
+	if !b.marker {
+		return 0
+	}
+	return 0xff00 | uint16(b.data[b.pos+1])
+}
+
+// Pos returns the offset of the first byte not yet loaded in the accumulator
+func (b *BitReader) Pos() int {
+	return b.pos
+}
+
+// Err returns ErrBitStreamExhausted if the bits consumed so far go past the
+// end of the data (or the first marker found)
+func (b *BitReader) Err() error {
+	if b.zeros*8 > int(b.nbits) {
+		return ErrBitStreamExhausted
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package common
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+const (
+	bmffFormatName = "BMFF"
+	// maxBoxes containers with more boxes are not valid
+	maxBoxes = 4096
+)
+
+// Box of an ISO base media file (ISO/IEC 14496-12), as MP4 and CR3
+type Box struct {
+	Type string
+	// UUID extended type of the "uuid" boxes
+	UUID [16]byte
+	// Offset position of the box header in the file
+	Offset int64
+	// Size of the box, with the header
+	Size int64
+	// DataOffset position of the content, after the header and the UUID
+	DataOffset int64
+}
+
+// DataSize returns the size of the content of the box
+func (b *Box) DataSize() int64 {
+	return b.Offset + b.Size - b.DataOffset
+}
+
+// End returns the position in the file after the box
+func (b *Box) End() int64 {
+	return b.Offset + b.Size
+}
+
+// ReadBoxes reads the boxes between offset and end, the content of a box or
+// the whole file. With end math.MaxInt64 the boxes are read up to the end
+// of the file
+func ReadBoxes(r io.ReaderAt, offset, end int64) ([]Box, error) {
+	var boxes []Box
+	for offset+8 <= end {
+		if len(boxes) == maxBoxes {
+			return boxes, NewFormatError(bmffFormatName, offset, "more than %d boxes", maxBoxes)
+		}
+		header := make([]byte, 8)
+		if n, err := r.ReadAt(header[:1], offset); n == 0 && err == io.EOF {
+			// end of the file
+			return boxes, nil
+		}
+		if err := ReadFull(bmffFormatName, r, offset, header); err != nil {
+			return boxes, err
+		}
+		b := Box{
+			Type:       string(header[4:8]),
+			Offset:     offset,
+			Size:       int64(binary.BigEndian.Uint32(header)),
+			DataOffset: offset + 8,
+		}
+		switch b.Size {
+		case 0:
+			// up to the end of the file, Size stays 0 if end is not known
+			if end == math.MaxInt64 {
+				return append(boxes, b), nil
+			}
+			b.Size = end - offset
+		case 1:
+			large, err := readFromFileBytes(r, offset+8, 8)
+			if err != nil {
+				return boxes, err
+			}
+			b.Size = int64(binary.BigEndian.Uint64(large))
+			b.DataOffset += 8
+		}
+		if b.Type == "uuid" {
+			uuid, err := readFromFileBytes(r, b.DataOffset, 16)
+			if err != nil {
+				return boxes, err
+			}
+			copy(b.UUID[:], uuid)
+			b.DataOffset += 16
+		}
+		if b.Size < b.DataOffset-offset || b.Size > end-offset {
+			return boxes, NewFormatError(bmffFormatName, offset, "size %d of box %q not valid", b.Size, b.Type)
+		}
+		boxes = append(boxes, b)
+		offset = b.End()
+	}
+	return boxes, nil
+}
+
+// FindBox returns the first box of type t, nil if not found
+func FindBox(boxes []Box, t string) *Box {
+	for i := range boxes {
+		if boxes[i].Type == t {
+			return &boxes[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testBox(typ string, content ...[]byte) []byte {
+	b := make([]byte, 8)
+	copy(b[4:], typ)
+	for _, c := range content {
+		b = append(b, c...)
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+func TestReadBoxes(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	uuid := bytes.Repeat([]byte{0xab}, 16)
+	large := []byte{0, 0, 0, 1, 'm', 'd', 'a', 't', 0, 0, 0, 0, 0, 0, 0, 20, 1, 2, 3, 4}
+	data := append(testBox("ftyp", []byte("crx ")), testBox("moov", testBox("trak"), testBox("uuid", uuid, []byte{1, 2}))...)
+	data = append(data, large...)
+
+	boxes, err := ReadBoxes(bytes.NewReader(data), 0, int64(len(data)))
+	require.Nil(err)
+	require.Len(boxes, 3)
+	assert.Equal("ftyp", boxes[0].Type)
+	assert.Equal(int64(12), boxes[0].Size)
+	assert.Equal(int64(4), boxes[0].DataSize())
+	assert.Equal(int64(16), boxes[2].DataOffset-boxes[2].Offset)
+	assert.Equal(int64(4), boxes[2].DataSize())
+
+	moov := FindBox(boxes, "moov")
+	require.NotNil(moov)
+	inner, err := ReadBoxes(bytes.NewReader(data), moov.DataOffset, moov.End())
+	require.Nil(err)
+	require.Len(inner, 2)
+	assert.Equal("uuid", inner[1].Type)
+	assert.Equal(byte(0xab), inner[1].UUID[15])
+	assert.Equal(int64(2), inner[1].DataSize())
+	assert.Nil(FindBox(inner, "mdat"))
+
+	// up to the end of the file
+	boxes, err = ReadBoxes(bytes.NewReader(data), 0, math.MaxInt64)
+	require.Nil(err)
+	assert.Len(boxes, 3)
+
+	// box longer than the container
+	data[3] = 100
+	_, err = ReadBoxes(bytes.NewReader(data), 0, int64(len(data)))
+	var fe *FormatError
+	assert.True(errors.As(err, &fe))
+	data[3] = 12
+
+	_, err = ReadBoxes(bytes.NewReader(data[:14]), 0, math.MaxInt64)
+	assert.True(errors.Is(err, ErrTruncated))
+}
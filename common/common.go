@@ -4,17 +4,18 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"math"
-	"os"
 )
 
 // Verbose true if you want more output
-var Verbose *bool
+var Verbose = new(bool)
 
-var ShowInfo *bool
+var ShowInfo = new(bool)
 
-var ExtractJpegs *bool
+var ExtractJpegs = new(bool)
 
+// ImgMetadata informations about the image read from the raw file
 type ImgMetadata struct {
 	ImageWidth  int
 	ImageHeight int
@@ -23,12 +24,6 @@ type ImgMetadata struct {
 // LittleEndian value
 const LittleEndian = 0x4949
 
-func check(e error) {
-	if e != nil {
-		panic(e)
-	}
-}
-
 // ReadUint16 reads 2 byte in offset index and converts in uint16 (BigEndian), returning also new array index
 func ReadUint16(data []byte, offset int64) (uint16, int64) {
 	var b1 = data[offset : offset+2]
@@ -65,63 +60,64 @@ func ReadUint32(data []byte, order uint16, offset int64) (uint32, int64) {
 }
 
 // GetUint16 a partire da offset legge un int uint16 e torna la nuova posizione
-func GetUint16(f *os.File, offset int64) (uint16, int64) {
-	value := binary.BigEndian.Uint16(readFromFileBytes(f, offset, 2))
-	return value, offset + 2
+func GetUint16(f io.ReaderAt, offset int64) (uint16, int64, error) {
+	b, err := readFromFileBytes(f, offset, 2)
+	if err != nil {
+		return 0, offset, err
+	}
+	return binary.BigEndian.Uint16(b), offset + 2, nil
 }
 
 // GetUint32 reads 4 bytes, coverts to uint32
-func GetUint32(f *os.File, offset int64) (uint32, int64) {
-	value := binary.BigEndian.Uint32(readFromFileBytes(f, offset, 4))
-	return value, offset + 4
+func GetUint32(f io.ReaderAt, offset int64) (uint32, int64, error) {
+	b, err := readFromFileBytes(f, offset, 4)
+	if err != nil {
+		return 0, offset, err
+	}
+	return binary.BigEndian.Uint32(b), offset + 4, nil
 }
 
-func GetUint16WithOrder(f *os.File, order uint16, offset int64) (uint16, int64) {
-	var value uint16
+func GetUint16WithOrder(f io.ReaderAt, order uint16, offset int64) (uint16, int64, error) {
+	b, err := readFromFileBytes(f, offset, 2)
+	if err != nil {
+		return 0, offset, err
+	}
 	if order == 0x4949 {
 		// little endian
-		value = binary.LittleEndian.Uint16(readFromFileBytes(f, offset, 2))
-	} else {
-		value = binary.BigEndian.Uint16(readFromFileBytes(f, offset, 2))
+		return binary.LittleEndian.Uint16(b), offset + 2, nil
 	}
-	return value, offset + 2
+	return binary.BigEndian.Uint16(b), offset + 2, nil
 }
 
-func GetUint32WithOrder(f *os.File, order uint16, offset int64) (uint32, int64) {
-	var value uint32
+func GetUint32WithOrder(f io.ReaderAt, order uint16, offset int64) (uint32, int64, error) {
+	b, err := readFromFileBytes(f, offset, 4)
+	if err != nil {
+		return 0, offset, err
+	}
 	if order == 0x4949 {
 		// little endian
-		value = binary.LittleEndian.Uint32(readFromFileBytes(f, offset, 4))
-	} else {
-		value = binary.BigEndian.Uint32(readFromFileBytes(f, offset, 4))
+		return binary.LittleEndian.Uint32(b), offset + 4, nil
 	}
-	return value, offset + 4
+	return binary.BigEndian.Uint32(b), offset + 4, nil
 }
 
 // Get1Byte reads from file 1 byte and returns an uint16
-func Get1Byte(f *os.File, offset int64) (uint16, int64) {
-	mybyte := readFromFileBytes(f, offset, 1)[0]
-	return uint16(mybyte), offset + 1
-}
-
-// GetInt reads from the file 2 bytes ... incomplete and undocumented, to be deleted
-func GetInt(f *os.File, order uint16, offset int64, typ uint16) (int64, int64) {
-	if typ == 3 {
-		v, start := GetUint16WithOrder(f, order, offset)
-		return int64(v), start
+func Get1Byte(f io.ReaderAt, offset int64) (uint16, int64, error) {
+	b, err := readFromFileBytes(f, offset, 1)
+	if err != nil {
+		return 0, offset, err
 	}
-	v, start := GetUint16WithOrder(f, order, offset)
-	return int64(v), start
+	return uint16(b[0]), offset + 1, nil
 }
 
-func readFromFileBytes(f *os.File, start int64, howmany int64) []byte {
-	_, err := f.Seek(start, 0)
-	check(err)
+// readFromFileBytes reads howmany bytes at start, a FormatError wrapping
+// ErrTruncated is returned if the file ends before
+func readFromFileBytes(f io.ReaderAt, start int64, howmany int64) ([]byte, error) {
 	retBytes := make([]byte, howmany)
-	f.Read(retBytes)
-	check(err)
-	//log.Printf("lettura di %d bytes @ %d: %s\n", n2, o2, string(retBytes))
-	return retBytes
+	if err := ReadFull("file", f, start, retBytes); err != nil {
+		return nil, err
+	}
+	return retBytes, nil
 }
 
 // HuffItem item in huffman tree
@@ -125,28 +125,28 @@ func TestHuffFindMapping(t *testing.T) {
 	huffMappings := DecodeHuffTree(data)
 	huffMapping0 := huffMappings[0]
 
-	m, err := HuffGetMapping(huffMapping0, 1022)
+	m, err := HuffGetMapping(huffMapping0, 1022, 10)
 	assert.Nil(err)
 	assert.Equal(0x0C, int(m.Value), "")
 
-	m, err = HuffGetMapping(huffMapping0, 2)
+	m, err = HuffGetMapping(huffMapping0, 2, 3)
 	assert.Nil(err)
 	assert.Equal(0x01, int(m.Value), "")
 
-	m, err = HuffGetMapping(huffMapping0, 6)
+	m, err = HuffGetMapping(huffMapping0, 6, 3)
 	assert.Nil(err)
 	assert.Equal(0x05, int(m.Value), "")
 
-	m, err = HuffGetMapping(huffMapping0, 30)
+	m, err = HuffGetMapping(huffMapping0, 30, 5)
 	assert.Nil(err)
 	assert.Equal(0x07, int(m.Value), "")
 
-	m, err = HuffGetMapping(huffMapping0, 8190)
+	m, err = HuffGetMapping(huffMapping0, 8190, 13)
 	assert.Nil(err)
 	assert.Equal(0x0f, int(m.Value), "")
 
 	// not found in mapping table
-	m, err = HuffGetMapping(huffMapping0, 8062)
+	m, err = HuffGetMapping(huffMapping0, 8062, 13)
 	assert.NotNil(err)
 
 }
@@ -0,0 +1,51 @@
+// Package commontest has the helpers shared by the tests of the decoders
+package commontest
+
+// BitWriter packs bits MSB first, as the bit readers of the decoders
+type BitWriter struct {
+	// Stuff appends 0x00 after each 0xff byte and pads with ones, as the
+	// entropy coded data of JPEG
+	Stuff bool
+
+	out   []byte
+	acc   uint64
+	nbits uint
+}
+
+// Write appends the n low bits of v
+func (w *BitWriter) Write(v uint64, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		w.acc = w.acc<<1 | (v>>uint(i))&1
+		w.nbits++
+		if w.nbits == 8 {
+			w.out = append(w.out, byte(w.acc))
+			if w.Stuff && byte(w.acc) == 0xff {
+				w.out = append(w.out, 0x00)
+			}
+			w.acc, w.nbits = 0, 0
+		}
+	}
+}
+
+// Flush pads the last byte, with ones if Stuff and zeros otherwise
+func (w *BitWriter) Flush() {
+	var pad uint64
+	if w.Stuff {
+		pad = 1
+	}
+	for w.nbits != 0 {
+		w.Write(pad, 1)
+	}
+}
+
+// Append flushes the bits and appends b as it is, as the JPEG markers
+func (w *BitWriter) Append(b ...byte) {
+	w.Flush()
+	w.out = append(w.out, b...)
+}
+
+// Bytes flushes the bits and returns the data written
+func (w *BitWriter) Bytes() []byte {
+	w.Flush()
+	return w.out
+}
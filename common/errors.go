@@ -0,0 +1,67 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrTruncated the data ends before the structure being read
+var ErrTruncated = errors.New("unexpected end of data")
+
+// FormatError the input is not a valid file of the format, or uses a
+// feature not supported. Offset is the position in the file (or in the
+// segment being decoded) where the problem was found
+type FormatError struct {
+	Format string
+	Offset int64
+	Msg    string
+	Err    error
+}
+
+func (e *FormatError) Error() string {
+	s := fmt.Sprintf("%s: %s at offset %d", e.Format, e.Msg, e.Offset)
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+// Unwrap returns the error causing the FormatError, if any
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// NewFormatError builds a FormatError, msg is formatted with args as fmt.Sprintf
+func NewFormatError(format string, offset int64, msg string, args ...interface{}) *FormatError {
+	return &FormatError{Format: format, Offset: offset, Msg: fmt.Sprintf(msg, args...)}
+}
+
+// WrapFormatError builds a FormatError caused by err
+func WrapFormatError(format string, offset int64, err error, msg string, args ...interface{}) *FormatError {
+	return &FormatError{Format: format, Offset: offset, Msg: fmt.Sprintf(msg, args...), Err: err}
+}
+
+// CheckRange returns a FormatError wrapping ErrTruncated if data does not
+// hold n bytes starting at offset. what describes the structure being read
+func CheckRange(format string, data []byte, offset int64, n int64, what string) error {
+	if offset < 0 || n < 0 || offset > int64(len(data)) || n > int64(len(data))-offset {
+		return WrapFormatError(format, offset, ErrTruncated, "%s (%d bytes) outside of data (%d bytes)", what, n, len(data))
+	}
+	return nil
+}
+
+// ReadFull reads len(b) bytes at offset of r. If r ends before, the error is
+// a FormatError of format wrapping ErrTruncated
+func ReadFull(format string, r io.ReaderAt, offset int64, b []byte) error {
+	if offset < 0 {
+		return NewFormatError(format, offset, "offset not valid")
+	}
+	if n, err := r.ReadAt(b, offset); n < len(b) {
+		if err == nil || err == io.EOF {
+			err = ErrTruncated
+		}
+		return WrapFormatError(format, offset, err, "reading %d bytes", len(b))
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package common
+
+import "fmt"
+
+// HuffLookupBits is the number of bits resolved with a single access in the
+// HuffTable lookup table, longer codes use the slow path
+const HuffLookupBits = 9
+
+// HuffTable table driven Huffman decoder, built once for each DHT table.
+// Codes up to HuffLookupBits bits are resolved by indexing lookup with the
+// next HuffLookupBits bits of the stream, longer codes are searched length
+// by length comparing with the greatest code of each length (ITU T.81, F.2.2.3)
+type HuffTable struct {
+	// lookup holds bitCount<<8 | value, 0 if the code is longer than HuffLookupBits
+	lookup  [1 << HuffLookupBits]uint16
+	minCode [17]int32
+	maxCode [17]int32
+	valPtr  [17]int32
+	values  []byte
+}
+
+// NewHuffTable builds the decoder from the items returned by GetHuffItems
+func NewHuffTable(huffItems []HuffItem) (*HuffTable, error) {
+	h := &HuffTable{}
+	for l := range h.maxCode {
+		h.maxCode[l] = -1
+	}
+	code := int32(0)
+	for _, item := range huffItems {
+		l := item.BitLength
+		if l < 1 || l > 16 {
+			return nil, fmt.Errorf("huffman code length %d not valid", l)
+		}
+		h.valPtr[l] = int32(len(h.values))
+		h.minCode[l] = code
+		for _, v := range item.Codes {
+			if code >= 1<<uint(l) {
+				return nil, fmt.Errorf("huffman table not valid, too many codes of %d bits", l)
+			}
+			if l <= HuffLookupBits {
+				shift := uint(HuffLookupBits - l)
+				first := code << shift
+				for j := int32(0); j < 1<<shift; j++ {
+					h.lookup[first+j] = uint16(l)<<8 | uint16(v)
+				}
+			}
+			h.values = append(h.values, v)
+			h.maxCode[l] = code
+			code++
+		}
+		code <<= 1
+	}
+	return h, nil
+}
+
+// Decode reads the next Huffman coded value from the stream
+func (h *HuffTable) Decode(br *BitReader) (uint8, error) {
+	if e := h.lookup[br.Peek(HuffLookupBits)]; e != 0 {
+		br.Skip(uint(e >> 8))
+		return uint8(e), nil
+	}
+	bits := int32(br.Peek(16))
+	for l := uint(HuffLookupBits + 1); l <= 16; l++ {
+		code := bits >> (16 - l)
+		if code <= h.maxCode[l] {
+			br.Skip(l)
+			return h.values[h.valPtr[l]+code-h.minCode[l]], nil
+		}
+	}
+	return 0, fmt.Errorf("huffman code not found, bits:%016b", bits)
+}
+
+// DecodeDiff reads the next Huffman coded difference length and the
+// difference bits following it, returning the signed difference. It is
+// Decode followed by ReadDiff, filling the reader only once
+func (h *HuffTable) DecodeDiff(br *BitReader) (int32, error) {
+	if br.nbits < 32 {
+		br.fill()
+	}
+	var bitCount, n uint
+	if e := h.lookup[br.acc>>(64-HuffLookupBits)]; e != 0 {
+		bitCount, n = uint(e>>8), uint(uint8(e))
+	} else {
+		bits := int32(br.acc >> 48)
+		for l := uint(HuffLookupBits + 1); l <= 17; l++ {
+			if l == 17 {
+				return 0, fmt.Errorf("huffman code not found, bits:%016b", bits)
+			}
+			code := bits >> (16 - l)
+			if code <= h.maxCode[l] {
+				bitCount, n = l, uint(h.values[h.valPtr[l]+code-h.minCode[l]])
+				break
+			}
+		}
+	}
+	br.acc <<= bitCount
+	br.nbits -= bitCount
+
+	switch {
+	case n == 0:
+		return 0, nil
+	case n >= 16:
+		// lossless only: no additional bits are stored
+		return -32768, nil
+	}
+	v := int32(br.acc >> (64 - n))
+	br.acc <<= n
+	br.nbits -= n
+	if v < 1<<(n-1) {
+		v -= 1<<n - 1
+	}
+	return v, nil
+}
@@ -0,0 +1,158 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/enricod/rawmgr/common/commontest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testHuffTables builds the decoders of the two tables of the DHT segment
+func testHuffTables(t testing.TB, data []byte) []*HuffTable {
+	huffItems0, offset := GetHuffItems(data, 5)
+	huffItems1, _ := GetHuffItems(data, offset+1)
+
+	result := []*HuffTable{}
+	for _, huffItems := range [][]HuffItem{huffItems0, huffItems1} {
+		h, err := NewHuffTable(huffItems)
+		require.Nil(t, err)
+		result = append(result, h)
+	}
+	return result
+}
+
+func TestHuffTableDecode(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	data := data1()
+	huffMappings := DecodeHuffTree(data)
+	huffTables := testHuffTables(t, data)
+
+	for i := range huffTables {
+		w := &commontest.BitWriter{Stuff: true}
+		for _, m := range huffMappings[i] {
+			w.Write(m.Code, uint(m.BitCount))
+		}
+		br := NewBitReader(w.Bytes())
+		for _, m := range huffMappings[i] {
+			v, err := huffTables[i].Decode(br)
+			require.Nil(err)
+			assert.Equal(m.Value, v, "table %d, code %b", i, m.Code)
+		}
+		assert.Nil(br.Err())
+	}
+}
+
+func TestHuffTableNotValid(t *testing.T) {
+	assert := assert.New(t)
+
+	// three codes of 1 bit
+	huffItems := []HuffItem{{BitLength: 1, Count: 3, Codes: []byte{0, 1, 2}}}
+	_, err := NewHuffTable(huffItems)
+	assert.NotNil(err)
+}
+
+func TestBitReaderDiff(t *testing.T) {
+	assert := assert.New(t)
+
+	w := &commontest.BitWriter{Stuff: true}
+	w.Write(0x3f, 13)   // top bit 0: negative
+	w.Write(0x1fff, 13) // all ones
+	w.Write(0xff, 8)    // 0xff, stuffed
+	w.Write(0, 1)
+	br := NewBitReader(w.Bytes())
+
+	assert.Equal(int32(-8128), br.ReadDiff(13))
+	assert.Equal(int32(8191), br.ReadDiff(13))
+	assert.Equal(uint32(0xff), br.ReadBits(8))
+	assert.Equal(int32(-1), br.ReadDiff(1))
+	assert.Equal(int32(0), br.ReadDiff(0))
+	assert.Equal(int32(-32768), br.ReadDiff(16))
+	assert.Nil(br.Err())
+
+	br.ReadBits(32)
+	assert.Equal(ErrBitStreamExhausted, br.Err())
+}
+
+func TestPlainBitReader(t *testing.T) {
+	assert := assert.New(t)
+
+	// no stuffing nor markers
+	br := NewPlainBitReader([]byte{0xff, 0xd9, 0x00, 0x01, 0x80})
+	assert.Equal(uint32(0xffd9), br.ReadBits(16))
+	assert.Equal(15, br.ReadZeros())
+	assert.Equal(0, br.ReadZeros())
+	assert.Nil(br.Err())
+	br.ReadZeros()
+	assert.Equal(ErrBitStreamExhausted, br.Err())
+}
+
+func TestBitReaderMarker(t *testing.T) {
+	assert := assert.New(t)
+
+	br := NewBitReader([]byte{0xa5, 0xff, 0xd0, 0x5a})
+	assert.Equal(uint32(0xa5), br.ReadBits(8))
+	assert.Equal(uint16(0xffd0), br.Marker())
+	assert.Equal(uint16(0xffd0), br.Reset())
+	assert.Equal(uint16(0), br.Marker())
+	assert.Equal(uint32(0x5a), br.ReadBits(8))
+	assert.Nil(br.Err())
+}
+
+func BenchmarkHuffTableDecode(b *testing.B) {
+	data := data1()
+	huffMappings := DecodeHuffTree(data)
+	huffTables := testHuffTables(b, data)
+
+	w := &commontest.BitWriter{Stuff: true}
+	for i := 0; i < 1<<16; i++ {
+		m := huffMappings[0][i%len(huffMappings[0])]
+		w.Write(m.Code, uint(m.BitCount))
+		w.Write(uint64(i), uint(m.Value))
+	}
+	stream := w.Bytes()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		br := NewBitReader(stream)
+		for i := 0; i < 1<<16; i++ {
+			v, _ := huffTables[0].Decode(br)
+			br.ReadDiff(uint(v))
+		}
+	}
+}
+
+func BenchmarkHuffMappingMap(b *testing.B) {
+	data := data1()
+	huffMappings := DecodeHuffTree(data)
+	huffMap := HuffMappingToMap(huffMappings[0])
+
+	codes := make([]HuffMapping, 1<<16)
+	for i := range codes {
+		codes[i] = huffMappings[0][i%len(huffMappings[0])]
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, c := range codes {
+			// probe every length from the longest, as the map based decoder did
+			code := c.Code << uint(16-c.BitCount)
+			for l := 16; l >= 2; l-- {
+				if _, ok := huffMap[HuffMappingKey{Code: code >> uint(16-l), BitCount: l}]; ok {
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestBitReaderResetSearch(t *testing.T) {
+	assert := assert.New(t)
+
+	br := NewBitReader([]byte{0x12, 0xff, 0x00, 0x34, 0xff, 0xd3, 0x5a})
+	assert.Equal(uint32(0x1), br.ReadBits(4))
+	assert.Equal(uint16(0xffd3), br.Reset())
+	assert.Equal(uint32(0x5a), br.ReadBits(8))
+}
@@ -0,0 +1,462 @@
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// TIFF field types
+const (
+	TypeByte      = 1
+	TypeASCII     = 2
+	TypeShort     = 3
+	TypeLong      = 4
+	TypeRational  = 5
+	TypeSByte     = 6
+	TypeUndefined = 7
+	TypeSShort    = 8
+	TypeSLong     = 9
+	TypeSRational = 10
+	TypeFloat     = 11
+	TypeDouble    = 12
+	TypeIFD       = 13
+)
+
+// typeSizes bytes of a value of each field type, 0 if the type is not known
+var typeSizes = [...]int{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8, 4}
+
+// Tags of the entries pointing to other IFDs
+const (
+	TagSubIFDs    = 0x014a
+	TagExifIFD    = 0x8769
+	TagGPSIFD     = 0x8825
+	TagInteropIFD = 0xa005
+	TagMakerNote  = 0x927c
+)
+
+// metadataIfdTags the IFDs of these entries hold only metadata, an error
+// reading them is recorded in the entry instead of failing its IFD
+var metadataIfdTags = map[uint16]bool{
+	TagExifIFD: true, TagGPSIFD: true, TagInteropIFD: true, TagMakerNote: true,
+}
+
+const (
+	tiffFormatName = "TIFF"
+	// maxValueSize values longer than this are not valid
+	maxValueSize = 1 << 24
+	// maxIfdEntries IFDs with more entries are not valid
+	maxIfdEntries = 512
+)
+
+// Rational unsigned fraction, RATIONAL type
+type Rational struct {
+	Num uint32
+	Den uint32
+}
+
+// SRational signed fraction, SRATIONAL type
+type SRational struct {
+	Num int32
+	Den int32
+}
+
+// IfdEntry an entry of an IFD, with its value decoded according to the type
+type IfdEntry struct {
+	Tag   uint16
+	Type  uint16
+	Count uint32
+	// Field the last 4 bytes of the entry, the offset of the value (relative
+	// to the TIFF base) when it does not fit in them
+	Field uint32
+	// ValueOffset position of the value in the file
+	ValueOffset int64
+	// Value decoded value: []uint8 (BYTE), string (ASCII), []uint16,
+	// []uint32 (LONG and IFD), []Rational, []int8, []byte (UNDEFINED),
+	// []int16, []int32, []SRational, []float32, []float64. nil if the
+	// type is not known
+	Value interface{}
+	// Sub IFDs pointed by the entry, for the tags in TiffReader.SubIfdTags
+	Sub []*Ifd
+	// SubErr error reading the IFDs of the metadata, Sub is nil then
+	SubErr error
+}
+
+// Ifd image file directory
+type Ifd struct {
+	// Offset position of the IFD in the file
+	Offset  int64
+	Entries []IfdEntry
+	// Next position in the file of the next IFD of the chain, 0 if last
+	Next int64
+	// Strips data of the image, written after the IFD by WriteTiff, which
+	// sets the StripOffsets and StripByteCounts entries, TileOffsets and
+	// TileByteCounts if the IFD has TileWidth. Not read
+	Strips [][]byte
+}
+
+// Entry returns the entry with tag, nil if not found
+func (d *Ifd) Entry(tag uint16) *IfdEntry {
+	for i := range d.Entries {
+		if d.Entries[i].Tag == tag {
+			return &d.Entries[i]
+		}
+	}
+	return nil
+}
+
+// Len returns the number of values of the entry, the length for ASCII
+func (e *IfdEntry) Len() int {
+	switch v := e.Value.(type) {
+	case []uint8:
+		return len(v)
+	case string:
+		return len(v)
+	case []uint16:
+		return len(v)
+	case []uint32:
+		return len(v)
+	case []Rational:
+		return len(v)
+	case []int8:
+		return len(v)
+	case []int16:
+		return len(v)
+	case []int32:
+		return len(v)
+	case []SRational:
+		return len(v)
+	case []float32:
+		return len(v)
+	case []float64:
+		return len(v)
+	}
+	return 0
+}
+
+// Int returns the i-th value as an integer, rationals and floats are truncated
+func (e *IfdEntry) Int(i int) int64 {
+	switch v := e.Value.(type) {
+	case []uint8:
+		return int64(v[i])
+	case []uint16:
+		return int64(v[i])
+	case []uint32:
+		return int64(v[i])
+	case []int8:
+		return int64(v[i])
+	case []int16:
+		return int64(v[i])
+	case []int32:
+		return int64(v[i])
+	}
+	return int64(e.Float(i))
+}
+
+// Float returns the i-th value as a float64, 0 for a rational with zero denominator
+func (e *IfdEntry) Float(i int) float64 {
+	switch v := e.Value.(type) {
+	case []Rational:
+		if v[i].Den == 0 {
+			return 0
+		}
+		return float64(v[i].Num) / float64(v[i].Den)
+	case []SRational:
+		if v[i].Den == 0 {
+			return 0
+		}
+		return float64(v[i].Num) / float64(v[i].Den)
+	case []float32:
+		return float64(v[i])
+	case []float64:
+		return v[i]
+	case string:
+		return 0
+	}
+	return float64(e.Int(i))
+}
+
+// String returns the value of an ASCII entry, the values separated by
+// spaces for the other types
+func (e *IfdEntry) String() string {
+	if s, ok := e.Value.(string); ok {
+		return s
+	}
+	if b, ok := e.Value.([]byte); ok && e.Type == TypeUndefined {
+		return strings.TrimRight(string(b), "\x00")
+	}
+	return strings.Trim(fmt.Sprint(e.Value), "[]")
+}
+
+// TiffReader reads the IFDs of a TIFF structure, as found in TIFF, DNG, CR2
+// and in the EXIF of the other raw files
+type TiffReader struct {
+	r     io.ReaderAt
+	Order binary.ByteOrder
+	// Base offsets in the IFDs are relative to Base
+	Base int64
+	// First offset of the first IFD, relative to Base
+	First int64
+	// SubIfdTags tags whose values are offsets of other IFDs, followed while
+	// reading. An UNDEFINED entry (as a maker note) is an IFD itself
+	SubIfdTags map[uint16]bool
+}
+
+// NewTiffReader reads the TIFF header at base: byte order, 42 and the
+// offset of the first IFD
+func NewTiffReader(r io.ReaderAt, base int64) (*TiffReader, error) {
+	header, err := readTiffBytes(r, base, 8)
+	if err != nil {
+		return nil, err
+	}
+	t := &TiffReader{r: r, Base: base, SubIfdTags: map[uint16]bool{
+		TagSubIFDs: true, TagExifIFD: true, TagGPSIFD: true, TagInteropIFD: true,
+	}}
+	switch string(header[:2]) {
+	case "II":
+		t.Order = binary.LittleEndian
+	case "MM":
+		t.Order = binary.BigEndian
+	default:
+		return nil, NewFormatError(tiffFormatName, base, "byte order %x not valid", header[:2])
+	}
+	if magic := t.Order.Uint16(header[2:]); magic != 42 {
+		return nil, NewFormatError(tiffFormatName, base+2, "magic number %d not valid", magic)
+	}
+	t.First = int64(t.Order.Uint32(header[4:]))
+	return t, nil
+}
+
+// NewTiffReaderOrder reader of IFDs without a TIFF header, as the maker notes
+func NewTiffReaderOrder(r io.ReaderAt, base int64, order binary.ByteOrder) *TiffReader {
+	return &TiffReader{r: r, Order: order, Base: base, SubIfdTags: map[uint16]bool{}}
+}
+
+// Exif the EXIF IFD of a file, with the byte order and the base of the TIFF
+// structure holding it, that the offsets in the maker note refer to
+type Exif struct {
+	Ifd   *Ifd
+	Order binary.ByteOrder
+	Base  int64
+}
+
+// Exif returns the EXIF IFD of ifd0, read by t
+func (t *TiffReader) Exif(ifd0 *Ifd) (*Exif, error) {
+	e := ifd0.Entry(TagExifIFD)
+	if e != nil && e.SubErr != nil {
+		return nil, e.SubErr
+	}
+	if e == nil || len(e.Sub) == 0 {
+		return nil, NewFormatError(tiffFormatName, ifd0.Offset, "EXIF IFD not found")
+	}
+	return &Exif{Ifd: e.Sub[0], Order: t.Order, Base: t.Base}, nil
+}
+
+// ReadIfds reads the chain of IFDs starting from First, with their sub IFDs
+func (t *TiffReader) ReadIfds() ([]*Ifd, error) {
+	visited := map[int64]bool{}
+	var result []*Ifd
+	for offset := t.First; offset != 0; {
+		if len(result) >= maxIfds {
+			return result, NewFormatError(tiffFormatName, t.Base+offset, "more than %d IFDs", maxIfds)
+		}
+		ifd, err := t.readIfd(offset, 0, visited)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, ifd)
+		if ifd.Next == 0 {
+			break
+		}
+		offset = ifd.Next - t.Base
+	}
+	return result, nil
+}
+
+// ReadIfd reads the IFD at offset (relative to Base) and its sub IFDs, the
+// next IFDs of the chain are not read
+func (t *TiffReader) ReadIfd(offset int64) (*Ifd, error) {
+	return t.readIfd(offset, 0, map[int64]bool{})
+}
+
+func (t *TiffReader) readIfd(offset int64, depth int, visited map[int64]bool) (*Ifd, error) {
+	pos := t.Base + offset
+	if depth > maxIfdDepth {
+		return nil, NewFormatError(tiffFormatName, pos, "IFDs nested more than %d levels", maxIfdDepth)
+	}
+	if visited[pos] {
+		return nil, NewFormatError(tiffFormatName, pos, "IFD already read, loop in the IFDs")
+	}
+	visited[pos] = true
+
+	b, err := readTiffBytes(t.r, pos, 2)
+	if err != nil {
+		return nil, err
+	}
+	entries := int(t.Order.Uint16(b))
+	if entries > maxIfdEntries {
+		return nil, NewFormatError(tiffFormatName, pos, "%d IFD entries not valid", entries)
+	}
+	b, err = readTiffBytes(t.r, pos+2, int64(12*entries+4))
+	if err != nil {
+		return nil, err
+	}
+
+	ifd := &Ifd{Offset: pos, Entries: make([]IfdEntry, entries)}
+	for i := range ifd.Entries {
+		e := &ifd.Entries[i]
+		if err := t.readEntry(e, b[12*i:12*i+12], pos+2+int64(12*i)); err != nil {
+			return nil, err
+		}
+		if t.SubIfdTags[e.Tag] {
+			if e.Sub, err = t.readSubIfds(e, depth, visited); err != nil {
+				if !metadataIfdTags[e.Tag] {
+					return nil, err
+				}
+				// the image can be decoded without the metadata
+				e.Sub, e.SubErr = nil, err
+			}
+		}
+	}
+	if next := t.Order.Uint32(b[12*entries:]); next != 0 {
+		ifd.Next = t.Base + int64(next)
+	}
+	return ifd, nil
+}
+
+// readEntry decodes the 12 bytes of the entry at pos, reading the value
+func (t *TiffReader) readEntry(e *IfdEntry, b []byte, pos int64) error {
+	e.Tag = t.Order.Uint16(b[0:])
+	e.Type = t.Order.Uint16(b[2:])
+	e.Count = t.Order.Uint32(b[4:])
+	e.Field = t.Order.Uint32(b[8:])
+	e.ValueOffset = pos + 8
+
+	if int(e.Type) >= len(typeSizes) || typeSizes[e.Type] == 0 {
+		// type not known, the value is not decoded
+		return nil
+	}
+	size := int64(e.Count) * int64(typeSizes[e.Type])
+	if size > maxValueSize {
+		return NewFormatError(tiffFormatName, pos, "tag %x value of %d bytes not valid", e.Tag, size)
+	}
+	var raw []byte
+	if size > 4 {
+		e.ValueOffset = t.Base + int64(e.Field)
+		var err error
+		if raw, err = readTiffBytes(t.r, e.ValueOffset, size); err != nil {
+			return err
+		}
+	} else {
+		raw = b[8 : 8+size]
+	}
+	e.Value = decodeValue(t.Order, e.Type, int(e.Count), raw)
+	return nil
+}
+
+// readSubIfds reads the IFDs pointed by the entry
+func (t *TiffReader) readSubIfds(e *IfdEntry, depth int, visited map[int64]bool) ([]*Ifd, error) {
+	var offsets []int64
+	switch v := e.Value.(type) {
+	case []uint32:
+		for _, o := range v {
+			offsets = append(offsets, int64(o))
+		}
+	case []byte:
+		// the value is the IFD
+		offsets = append(offsets, e.ValueOffset-t.Base)
+	}
+
+	var result []*Ifd
+	for _, o := range offsets {
+		sub, err := t.readIfd(o, depth+1, visited)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, sub)
+	}
+	return result, nil
+}
+
+// decodeValue converts count values of the type from raw
+func decodeValue(order binary.ByteOrder, typ uint16, count int, raw []byte) interface{} {
+	switch typ {
+	case TypeByte:
+		return append([]uint8{}, raw...)
+	case TypeUndefined:
+		return append([]byte{}, raw...)
+	case TypeASCII:
+		s := string(raw)
+		if i := strings.IndexByte(s, 0); i >= 0 {
+			s = s[:i]
+		}
+		return strings.TrimRight(s, " ")
+	case TypeSByte:
+		v := make([]int8, count)
+		for i := range v {
+			v[i] = int8(raw[i])
+		}
+		return v
+	case TypeShort:
+		v := make([]uint16, count)
+		for i := range v {
+			v[i] = order.Uint16(raw[2*i:])
+		}
+		return v
+	case TypeSShort:
+		v := make([]int16, count)
+		for i := range v {
+			v[i] = int16(order.Uint16(raw[2*i:]))
+		}
+		return v
+	case TypeLong, TypeIFD:
+		v := make([]uint32, count)
+		for i := range v {
+			v[i] = order.Uint32(raw[4*i:])
+		}
+		return v
+	case TypeSLong:
+		v := make([]int32, count)
+		for i := range v {
+			v[i] = int32(order.Uint32(raw[4*i:]))
+		}
+		return v
+	case TypeRational:
+		v := make([]Rational, count)
+		for i := range v {
+			v[i] = Rational{order.Uint32(raw[8*i:]), order.Uint32(raw[8*i+4:])}
+		}
+		return v
+	case TypeSRational:
+		v := make([]SRational, count)
+		for i := range v {
+			v[i] = SRational{int32(order.Uint32(raw[8*i:])), int32(order.Uint32(raw[8*i+4:]))}
+		}
+		return v
+	case TypeFloat:
+		v := make([]float32, count)
+		for i := range v {
+			v[i] = math.Float32frombits(order.Uint32(raw[4*i:]))
+		}
+		return v
+	case TypeDouble:
+		v := make([]float64, count)
+		for i := range v {
+			v[i] = math.Float64frombits(order.Uint64(raw[8*i:]))
+		}
+		return v
+	}
+	return nil
+}
+
+// readTiffBytes reads n bytes at offset, a FormatError wrapping ErrTruncated
+// is returned if the file ends before
+func readTiffBytes(r io.ReaderAt, offset int64, n int64) ([]byte, error) {
+	b := make([]byte, n)
+	if err := ReadFull(tiffFormatName, r, offset, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,221 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testIfdEntry entry of the test TIFF, value holds the bytes of the value
+type testIfdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// testTiffFile builds a TIFF with the IFDs chained, the values longer than
+// 4 bytes follow each IFD. sub maps an entry index of an IFD to the IFD
+// it points to
+func testTiffFile(order binary.ByteOrder, ifds [][]testIfdEntry, sub map[[2]int]int) []byte {
+	sizes := make([]int, len(ifds))
+	offsets := make([]int, len(ifds))
+	end := 8
+	for i, entries := range ifds {
+		offsets[i] = end
+		sizes[i] = 2 + 12*len(entries) + 4
+		for _, e := range entries {
+			if len(e.value) > 4 {
+				sizes[i] += len(e.value)
+			}
+		}
+		end += sizes[i]
+	}
+
+	data := make([]byte, 8)
+	if order == binary.LittleEndian {
+		copy(data, "II")
+	} else {
+		copy(data, "MM")
+	}
+	order.PutUint16(data[2:], 42)
+	order.PutUint32(data[4:], uint32(offsets[0]))
+	for i, entries := range ifds {
+		extra := offsets[i] + 2 + 12*len(entries) + 4
+		var values []byte
+		b := make([]byte, 2)
+		order.PutUint16(b, uint16(len(entries)))
+		data = append(data, b...)
+		for j, e := range entries {
+			b := make([]byte, 12)
+			order.PutUint16(b[0:], e.tag)
+			order.PutUint16(b[2:], e.typ)
+			order.PutUint32(b[4:], e.count)
+			if target, ok := sub[[2]int{i, j}]; ok {
+				order.PutUint32(b[8:], uint32(offsets[target]))
+			} else if len(e.value) > 4 {
+				order.PutUint32(b[8:], uint32(extra+len(values)))
+				values = append(values, e.value...)
+			} else {
+				copy(b[8:], e.value)
+			}
+			data = append(data, b...)
+		}
+		next := make([]byte, 4)
+		if i == 0 && len(ifds) > 1 && sub == nil {
+			order.PutUint32(next, uint32(offsets[1]))
+		}
+		data = append(data, next...)
+		data = append(data, values...)
+	}
+	return data
+}
+
+func TestTiffReaderTypes(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		u16 := func(v ...uint16) []byte {
+			b := make([]byte, 2*len(v))
+			for i := range v {
+				order.PutUint16(b[2*i:], v[i])
+			}
+			return b
+		}
+		u32 := func(v ...uint32) []byte {
+			b := make([]byte, 4*len(v))
+			for i := range v {
+				order.PutUint32(b[4*i:], v[i])
+			}
+			return b
+		}
+		u64 := func(v uint64) []byte {
+			b := make([]byte, 8)
+			order.PutUint64(b, v)
+			return b
+		}
+
+		data := testTiffFile(order, [][]testIfdEntry{
+			{
+				{0x010f, TypeASCII, 6, []byte("Canon\x00")},
+				{0x0100, TypeShort, 1, u16(5184)},
+				{0x0102, TypeShort, 3, u16(8, 8, 8)},
+				{0x011a, TypeRational, 1, u32(72, 1)},
+				{0x9204, TypeSRational, 1, u32(0xffffffff, 3)},
+				{0x0001, TypeByte, 2, []byte{1, 2}},
+				{0x0002, TypeSShort, 1, u16(0xfffe)},
+				{0x0003, TypeSLong, 1, u32(0xfffffffd)},
+				{0x0004, TypeFloat, 1, u32(math.Float32bits(1.5))},
+				{0x0005, TypeDouble, 1, u64(math.Float64bits(-2.25))},
+				{0x0006, TypeUndefined, 5, []byte("0231\x00")},
+				{0x0007, TypeSByte, 1, []byte{0xff}},
+				{TagExifIFD, TypeLong, 1, nil},
+			},
+			{
+				{0x829a, TypeRational, 1, u32(1, 250)},
+			},
+		}, map[[2]int]int{{0, 12}: 1})
+
+		reader, err := NewTiffReader(bytes.NewReader(data), 0)
+		require.Nil(err)
+		assert.Equal(order, reader.Order)
+		ifds, err := reader.ReadIfds()
+		require.Nil(err)
+		require.Len(ifds, 1)
+		ifd := ifds[0]
+
+		assert.Equal("Canon", ifd.Entry(0x010f).String())
+		assert.Equal(int64(5184), ifd.Entry(0x0100).Int(0))
+		assert.Equal([]uint16{8, 8, 8}, ifd.Entry(0x0102).Value)
+		assert.Equal(3, ifd.Entry(0x0102).Len())
+		assert.Equal(72.0, ifd.Entry(0x011a).Float(0))
+		assert.InDelta(-1.0/3, ifd.Entry(0x9204).Float(0), 1e-9)
+		assert.Equal([]uint8{1, 2}, ifd.Entry(0x0001).Value)
+		assert.Equal(int64(-2), ifd.Entry(0x0002).Int(0))
+		assert.Equal(int64(-3), ifd.Entry(0x0003).Int(0))
+		assert.Equal(1.5, ifd.Entry(0x0004).Float(0))
+		assert.Equal(-2.25, ifd.Entry(0x0005).Float(0))
+		assert.Equal("0231", ifd.Entry(0x0006).String())
+		assert.Equal(int64(-1), ifd.Entry(0x0007).Int(0))
+		assert.Nil(ifd.Entry(0x0008))
+
+		exif := ifd.Entry(TagExifIFD)
+		require.Len(exif.Sub, 1)
+		assert.Equal(0.004, exif.Sub[0].Entry(0x829a).Float(0))
+	}
+}
+
+func TestTiffReaderLoop(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// IFD1 SubIFDs pointing to IFD0
+	data := testTiffFile(binary.BigEndian, [][]testIfdEntry{
+		{{TagSubIFDs, TypeLong, 1, nil}},
+		{{TagSubIFDs, TypeLong, 1, nil}},
+	}, map[[2]int]int{{0, 0}: 1, {1, 0}: 0})
+	reader, err := NewTiffReader(bytes.NewReader(data), 0)
+	require.Nil(err)
+	_, err = reader.ReadIfds()
+	var formatErr *FormatError
+	assert.True(errors.As(err, &formatErr))
+
+	// next IFD pointing to itself
+	data = testTiffFile(binary.LittleEndian, [][]testIfdEntry{{{0x0100, TypeShort, 1, []byte{1, 0}}}}, nil)
+	binary.LittleEndian.PutUint32(data[len(data)-4:], 8)
+	reader, err = NewTiffReader(bytes.NewReader(data), 0)
+	require.Nil(err)
+	ifds, err := reader.ReadIfds()
+	assert.NotNil(err)
+	assert.Len(ifds, 1)
+
+	// truncated at every length
+	for n := 0; n < len(data)-4; n++ {
+		reader, err := NewTiffReader(bytes.NewReader(data[:n]), 0)
+		if err == nil {
+			_, err = reader.ReadIfds()
+		}
+		assert.True(errors.Is(err, ErrTruncated), "length %d: %v", n, err)
+	}
+
+	_, err = NewTiffReader(bytes.NewReader([]byte("XX*\x00\x08\x00\x00\x00")), 0)
+	assert.NotNil(err)
+}
+
+func TestTiffReaderBrokenMetadata(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// GPS and EXIF IFDs outside of the file, the IFD0 is read anyway
+	data := testTiffFile(binary.LittleEndian, [][]testIfdEntry{{
+		{0x0100, TypeShort, 1, []byte{1, 0}},
+		{TagExifIFD, TypeLong, 1, []byte{0, 0, 1, 0}},
+		{TagGPSIFD, TypeLong, 1, []byte{0xff, 0, 0, 0}},
+	}}, nil)
+	reader, err := NewTiffReader(bytes.NewReader(data), 0)
+	require.Nil(err)
+	ifds, err := reader.ReadIfds()
+	require.Nil(err)
+	require.Len(ifds, 1)
+	assert.Equal(int64(1), ifds[0].Entry(0x0100).Int(0))
+	for _, tag := range []uint16{TagExifIFD, TagGPSIFD} {
+		e := ifds[0].Entry(tag)
+		assert.Nil(e.Sub)
+		assert.True(errors.Is(e.SubErr, ErrTruncated), "tag %x: %v", tag, e.SubErr)
+	}
+
+	// the SubIFDs of the raw data are not optional
+	data = testTiffFile(binary.LittleEndian, [][]testIfdEntry{{
+		{TagSubIFDs, TypeLong, 1, []byte{0, 0, 1, 0}},
+	}}, nil)
+	reader, err = NewTiffReader(bytes.NewReader(data), 0)
+	require.Nil(err)
+	_, err = reader.ReadIfds()
+	assert.True(errors.Is(err, ErrTruncated))
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"math"
+	"sync"
+)
+
+// XYZRGB XYZ from linear sRGB
+var XYZRGB = [3][3]float64{
+	{0.412453, 0.357580, 0.180423},
+	{0.212671, 0.715160, 0.072169},
+	{0.019334, 0.119193, 0.950227},
+}
+
+// D65White XYZ of the D65 white
+var D65White = [3]float64{0.950456, 1, 1.088754}
+
+var (
+	cbrtOnce  sync.Once
+	cbrtTable []float32
+)
+
+// LabConverter converts the colors of a camera to CIELab, as dcraw cielab
+type LabConverter struct {
+	xyzCam [3][3]float32
+}
+
+// NewLabConverter converts the samples in [0, white] of a camera, rgbCam
+// the matrix from the camera RGB to linear sRGB. A nil rgbCam is a camera
+// with the primaries of sRGB, as the identity rgb_cam of dcraw
+func NewLabConverter(white uint16, rgbCam *[3][3]float64) *LabConverter {
+	scale := 1.0
+	if white > 0 {
+		scale = 65535 / float64(white)
+	}
+	cam := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	if rgbCam != nil {
+		cam = *rgbCam
+	}
+	l := &LabConverter{}
+	for i := range l.xyzCam {
+		for j := range l.xyzCam[i] {
+			var v float64
+			for k := range cam {
+				v += XYZRGB[i][k] * cam[k][j]
+			}
+			l.xyzCam[i][j] = float32(v * scale / D65White[i])
+		}
+	}
+	cbrtOnce.Do(func() {
+		cbrtTable = make([]float32, 0x10000)
+		for i := range cbrtTable {
+			r := float64(i) / 65535
+			if r > 0.008856 {
+				cbrtTable[i] = float32(math.Pow(r, 1/3.0))
+			} else {
+				cbrtTable[i] = float32(7.787*r + 16/116.0)
+			}
+		}
+	})
+	return l
+}
+
+// Lab returns L, a and b of the color, scaled by 64
+func (l *LabConverter) Lab(r, g, b uint16) (int16, int16, int16) {
+	var xyz [3]float32
+	for i := range xyz {
+		v := int(0.5 + l.xyzCam[i][0]*float32(r) + l.xyzCam[i][1]*float32(g) + l.xyzCam[i][2]*float32(b))
+		if v < 0 {
+			v = 0
+		} else if v > 0xffff {
+			v = 0xffff
+		}
+		xyz[i] = cbrtTable[v]
+	}
+	return int16(64 * (116*xyz[1] - 16)), int16(64 * 500 * (xyz[0] - xyz[1])), int16(64 * 200 * (xyz[1] - xyz[2]))
+}
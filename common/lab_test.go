@@ -0,0 +1,24 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabConverter(t *testing.T) {
+	assert := assert.New(t)
+
+	// the white of sRGB
+	l, a, b := NewLabConverter(1000, nil).Lab(1000, 1000, 1000)
+	assert.InDelta(64*100, int(l), 2)
+	assert.InDelta(0, int(a), 2)
+	assert.InDelta(0, int(b), 2)
+
+	// the camera red is the sRGB green
+	rgbCam := [3][3]float64{{0, 0, 0}, {1, 0, 0}, {0, 0, 0}}
+	l, a, b = NewLabConverter(1000, &rgbCam).Lab(1000, 0, 0)
+	gl, ga, gb := NewLabConverter(1000, nil).Lab(0, 1000, 0)
+	assert.Equal([3]int16{gl, ga, gb}, [3]int16{l, a, b})
+	assert.True(a < 0, "green")
+}
@@ -0,0 +1,231 @@
+package common
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// EXIF tags read in Metadata
+const (
+	TagMake                 = 0x010f
+	TagModel                = 0x0110
+	TagOrientation          = 0x0112
+	TagDateTime             = 0x0132
+	TagExposureTime         = 0x829a
+	TagFNumber              = 0x829d
+	TagExposureProgram      = 0x8822
+	TagISO                  = 0x8827
+	TagDateTimeOriginal     = 0x9003
+	TagOffsetTimeOriginal   = 0x9011
+	TagExposureCompensation = 0x9204
+	TagMeteringMode         = 0x9207
+	TagFlash                = 0x9209
+	TagFocalLength          = 0x920a
+	TagSubSecTimeOriginal   = 0x9291
+	TagExposureMode         = 0xa402
+	TagBodySerialNumber     = 0xa431
+	TagLensModel            = 0xa434
+)
+
+// exifTimeLayout layout of the EXIF dates
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// Metadata shooting informations of the image, from the EXIF. Numeric fields
+// are 0 and strings are empty when the file does not have them
+type Metadata struct {
+	Make   string
+	Model  string
+	Serial string
+	Lens   string
+	// ExposureTime in seconds
+	ExposureTime float64
+	FNumber      float64
+	ISO          int
+	// FocalLength in mm
+	FocalLength float64
+	// ExposureCompensation in EV
+	ExposureCompensation float64
+	// MeteringMode as in EXIF: 1 average, 2 center weighted, 3 spot, 5 pattern ...
+	MeteringMode int
+	// ExposureProgram as in EXIF: 1 manual, 2 program, 3 aperture priority, 4 shutter priority ...
+	ExposureProgram int
+	// ExposureMode as in EXIF: 0 auto, 1 manual, 2 auto bracket
+	ExposureMode int
+	// Flash as in EXIF, bit 0 set if the flash fired
+	Flash int
+	// CaptureTime date of the shot, with the sub seconds. The location is UTC
+	// when the file does not have the offset time, see HasOffsetTime
+	CaptureTime   time.Time
+	HasOffsetTime bool
+	// Orientation as in EXIF, 1 is top left
+	Orientation int
+}
+
+// ReadMetadata fills the metadata from IFD0 of a TIFF and its EXIF IFD
+func ReadMetadata(ifd0 *Ifd) Metadata {
+	var m Metadata
+	m.Read(ifd0)
+	if e := ifd0.Entry(TagExifIFD); e != nil {
+		for _, exif := range e.Sub {
+			m.Read(exif)
+		}
+	}
+	return m
+}
+
+// Read sets the fields found in ifd, the ones missing are left untouched
+func (m *Metadata) Read(ifd *Ifd) {
+	var date, subSec, offset string
+	for i := range ifd.Entries {
+		e := &ifd.Entries[i]
+		if e.Len() == 0 {
+			continue
+		}
+		switch e.Tag {
+		case TagMake:
+			m.Make = strings.TrimSpace(e.String())
+		case TagModel:
+			m.Model = strings.TrimSpace(e.String())
+		case TagOrientation:
+			m.Orientation = int(e.Int(0))
+		case TagDateTime:
+			if m.CaptureTime.IsZero() {
+				date = e.String()
+			}
+		case TagDateTimeOriginal:
+			date = e.String()
+		case TagSubSecTimeOriginal:
+			subSec = strings.TrimSpace(e.String())
+		case TagOffsetTimeOriginal:
+			offset = strings.TrimSpace(e.String())
+		case TagExposureTime:
+			m.ExposureTime = e.Float(0)
+		case TagFNumber:
+			m.FNumber = e.Float(0)
+		case TagExposureProgram:
+			m.ExposureProgram = int(e.Int(0))
+		case TagISO:
+			m.ISO = int(e.Int(0))
+		case TagExposureCompensation:
+			m.ExposureCompensation = e.Float(0)
+		case TagMeteringMode:
+			m.MeteringMode = int(e.Int(0))
+		case TagFlash:
+			m.Flash = int(e.Int(0))
+		case TagFocalLength:
+			m.FocalLength = e.Float(0)
+		case TagExposureMode:
+			m.ExposureMode = int(e.Int(0))
+		case TagBodySerialNumber:
+			m.Serial = strings.TrimSpace(e.String())
+		case TagLensModel:
+			m.Lens = strings.TrimSpace(e.String())
+		}
+	}
+	if date != "" {
+		if t, hasOffset, ok := ParseExifTime(date, subSec, offset); ok {
+			m.CaptureTime, m.HasOffsetTime = t, hasOffset
+		}
+	}
+}
+
+// ParseExifTime parses an EXIF date "2006:01:02 15:04:05", with the digits
+// of the sub seconds and the offset time "+01:00". ok is false if the date
+// is not valid, hasOffset is false if offset is empty or not valid
+func ParseExifTime(date, subSec, offset string) (t time.Time, hasOffset bool, ok bool) {
+	loc := time.UTC
+	if len(offset) == 6 && (offset[0] == '+' || offset[0] == '-') {
+		if o, err := time.Parse("-07:00", offset); err == nil {
+			_, seconds := o.Zone()
+			loc = time.FixedZone(offset, seconds)
+			hasOffset = true
+		}
+	}
+	t, err := time.ParseInLocation(exifTimeLayout, strings.TrimSpace(date), loc)
+	if err != nil {
+		return time.Time{}, false, false
+	}
+	var nsec, digits int
+	for _, c := range subSec {
+		if c < '0' || c > '9' || digits == 9 {
+			break
+		}
+		nsec = nsec*10 + int(c-'0')
+		digits++
+	}
+	for ; digits > 0 && digits < 9; digits++ {
+		nsec *= 10
+	}
+	return t.Add(time.Duration(nsec)), hasOffset, true
+}
+
+// rationalOf returns v as a fraction, 1/n for the exposure times
+func rationalOf(v float64) Rational {
+	if v > 0 && v < 1 {
+		if n := math.Round(1 / v); math.Abs(1/n-v) < v*1e-6 {
+			return Rational{1, uint32(n)}
+		}
+	}
+	num, den := uint32(math.Round(v*1000)), uint32(1000)
+	for a, b := num, den; ; {
+		if b == 0 {
+			return Rational{num / a, den / a}
+		}
+		a, b = b, a%b
+	}
+}
+
+// TiffEntries returns the entries of IFD0 and of the EXIF IFD with the
+// fields set, as in Read
+func (m *Metadata) TiffEntries() (ifd0 []IfdEntry, exif []IfdEntry) {
+	ascii := func(entries []IfdEntry, tag uint16, s string) []IfdEntry {
+		if s == "" {
+			return entries
+		}
+		return append(entries, IfdEntry{Tag: tag, Type: TypeASCII, Value: s})
+	}
+	short := func(entries []IfdEntry, tag uint16, v int) []IfdEntry {
+		if v == 0 {
+			return entries
+		}
+		return append(entries, IfdEntry{Tag: tag, Type: TypeShort, Value: []uint16{uint16(v)}})
+	}
+	rational := func(entries []IfdEntry, tag uint16, v float64) []IfdEntry {
+		if v <= 0 {
+			return entries
+		}
+		return append(entries, IfdEntry{Tag: tag, Type: TypeRational, Value: []Rational{rationalOf(v)}})
+	}
+
+	ifd0 = ascii(ifd0, TagMake, m.Make)
+	ifd0 = ascii(ifd0, TagModel, m.Model)
+	ifd0 = short(ifd0, TagOrientation, m.Orientation)
+	exif = rational(exif, TagExposureTime, m.ExposureTime)
+	exif = rational(exif, TagFNumber, m.FNumber)
+	exif = short(exif, TagExposureProgram, m.ExposureProgram)
+	exif = short(exif, TagISO, m.ISO)
+	if !m.CaptureTime.IsZero() {
+		date := m.CaptureTime.Format(exifTimeLayout)
+		ifd0 = ascii(ifd0, TagDateTime, date)
+		exif = ascii(exif, TagDateTimeOriginal, date)
+		if m.HasOffsetTime {
+			exif = ascii(exif, TagOffsetTimeOriginal, m.CaptureTime.Format("-07:00"))
+		}
+		if ms := m.CaptureTime.Nanosecond() / 1e7; ms > 0 {
+			exif = ascii(exif, TagSubSecTimeOriginal, fmt.Sprintf("%02d", ms))
+		}
+	}
+	if c := m.ExposureCompensation; c != 0 {
+		exif = append(exif, IfdEntry{Tag: TagExposureCompensation, Type: TypeSRational,
+			Value: []SRational{{int32(math.Round(c * 100)), 100}}})
+	}
+	exif = short(exif, TagMeteringMode, m.MeteringMode)
+	exif = short(exif, TagFlash, m.Flash)
+	exif = rational(exif, TagFocalLength, m.FocalLength)
+	exif = short(exif, TagExposureMode, m.ExposureMode)
+	exif = ascii(exif, TagBodySerialNumber, m.Serial)
+	exif = ascii(exif, TagLensModel, m.Lens)
+	return ifd0, exif
+}
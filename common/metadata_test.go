@@ -0,0 +1,91 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadMetadata(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	order := binary.LittleEndian
+	rational := func(num, den uint32) []byte {
+		b := make([]byte, 8)
+		order.PutUint32(b, num)
+		order.PutUint32(b[4:], den)
+		return b
+	}
+	data := testTiffFile(order, [][]testIfdEntry{
+		{
+			{TagMake, TypeASCII, 6, []byte("Canon\x00")},
+			{TagModel, TypeASCII, 20, []byte("Canon EOS 5D Mark IV")},
+			{TagOrientation, TypeShort, 1, []byte{8, 0}},
+			{TagDateTime, TypeASCII, 20, []byte("2019:05:04 10:00:00\x00")},
+			{TagExifIFD, TypeLong, 1, nil},
+		},
+		{
+			{TagExposureTime, TypeRational, 1, rational(1, 200)},
+			{TagFNumber, TypeRational, 1, rational(56, 10)},
+			{TagExposureProgram, TypeShort, 1, []byte{3, 0}},
+			{TagISO, TypeShort, 1, []byte{0x90, 0x01}},
+			{TagDateTimeOriginal, TypeASCII, 20, []byte("2019:05:04 09:59:58\x00")},
+			{TagOffsetTimeOriginal, TypeASCII, 7, []byte("+02:00\x00")},
+			{TagExposureCompensation, TypeSRational, 1, rational(0xfffffffd, 3)},
+			{TagMeteringMode, TypeShort, 1, []byte{5, 0}},
+			{TagFlash, TypeShort, 1, []byte{0x10, 0}},
+			{TagFocalLength, TypeRational, 1, rational(50, 1)},
+			{TagSubSecTimeOriginal, TypeASCII, 3, []byte("25\x00")},
+			{TagExposureMode, TypeShort, 1, []byte{1, 0}},
+			{TagBodySerialNumber, TypeASCII, 13, []byte("012345678901\x00")},
+			{TagLensModel, TypeASCII, 14, []byte("EF50mm f/1.4\x00\x00")},
+		},
+	}, map[[2]int]int{{0, 4}: 1})
+
+	reader, err := NewTiffReader(bytes.NewReader(data), 0)
+	require.Nil(err)
+	ifd0, err := reader.ReadIfd(reader.First)
+	require.Nil(err)
+
+	m := ReadMetadata(ifd0)
+	assert.Equal("Canon", m.Make)
+	assert.Equal("Canon EOS 5D Mark IV", m.Model)
+	assert.Equal("012345678901", m.Serial)
+	assert.Equal("EF50mm f/1.4", m.Lens)
+	assert.Equal(0.005, m.ExposureTime)
+	assert.Equal(5.6, m.FNumber)
+	assert.Equal(400, m.ISO)
+	assert.Equal(50.0, m.FocalLength)
+	assert.Equal(-1.0, m.ExposureCompensation)
+	assert.Equal(5, m.MeteringMode)
+	assert.Equal(3, m.ExposureProgram)
+	assert.Equal(1, m.ExposureMode)
+	assert.Equal(0x10, m.Flash)
+	assert.Equal(8, m.Orientation)
+	assert.True(m.HasOffsetTime)
+	assert.True(time.Date(2019, 5, 4, 7, 59, 58, 250000000, time.UTC).Equal(m.CaptureTime), m.CaptureTime.String())
+}
+
+func TestParseExifTime(t *testing.T) {
+	assert := assert.New(t)
+
+	tm, hasOffset, ok := ParseExifTime("2020:01:31 23:59:59", "007", "-05:30")
+	assert.True(ok)
+	assert.True(hasOffset)
+	assert.Equal(7000000, tm.Nanosecond())
+	_, offset := tm.Zone()
+	assert.Equal(-(5*3600 + 30*60), offset)
+
+	tm, hasOffset, ok = ParseExifTime("2020:01:31 23:59:59", "", "")
+	assert.True(ok)
+	assert.False(hasOffset)
+	assert.Equal(time.UTC, tm.Location())
+
+	_, _, ok = ParseExifTime("    :  :     :  :  ", "", "")
+	assert.False(ok)
+}
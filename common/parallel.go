@@ -0,0 +1,49 @@
+package common
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Workers number of goroutines used by the parallel stages, 0 means one for each CPU
+var Workers = new(int)
+
+// WorkersCount returns the number of goroutines to use, as set in Workers
+func WorkersCount() int {
+	if Workers == nil || *Workers <= 0 {
+		return runtime.NumCPU()
+	}
+	return *Workers
+}
+
+// ParallelRows splits the rows [0, rows) in contiguous bands, one for each
+// worker, and calls fn on every band, waiting for all of them to complete
+func ParallelRows(rows int, workers int, fn func(start, end int)) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > rows {
+		workers = rows
+	}
+	if workers <= 1 {
+		if rows > 0 {
+			fn(0, rows)
+		}
+		return
+	}
+
+	band := (rows + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < rows; start += band {
+		end := start + band
+		if end > rows {
+			end = rows
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			fn(start, end)
+		}(start, end)
+	}
+	wg.Wait()
+}
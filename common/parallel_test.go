@@ -0,0 +1,33 @@
+package common
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParallelRows(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, workers := range []int{0, 1, 3, 64} {
+		visits := make([]int32, 50)
+		var calls int32
+		ParallelRows(len(visits), workers, func(start, end int) {
+			atomic.AddInt32(&calls, 1)
+			for i := start; i < end; i++ {
+				atomic.AddInt32(&visits[i], 1)
+			}
+		})
+		for i, v := range visits {
+			assert.Equal(int32(1), v, "workers %d, row %d", workers, i)
+		}
+		if workers > 0 {
+			assert.True(int(calls) <= workers)
+		}
+	}
+
+	ParallelRows(0, 4, func(start, end int) {
+		t.Error("no rows, fn must not be called")
+	})
+}
@@ -0,0 +1,83 @@
+package common
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+)
+
+// writePNM writes the header of a binary PGM (1 sample) or PPM (3 samples)
+// and the rows, maxval 255 or 65535 with the samples of 16 bits big endian
+// as dcraw
+func writePNM(w io.Writer, width, height, samples, maxval int, row func(y int, b []byte)) error {
+	if width <= 0 || height <= 0 {
+		return errors.New("pnm: empty image")
+	}
+	magic := "P5"
+	if samples == 3 {
+		magic = "P6"
+	}
+	bw := bufio.NewWriter(w)
+	if _, err := fmt.Fprintf(bw, "%s\n%d %d\n%d\n", magic, width, height, maxval); err != nil {
+		return err
+	}
+	size := 1
+	if maxval > 255 {
+		size = 2
+	}
+	b := make([]byte, width*samples*size)
+	for y := 0; y < height; y++ {
+		row(y, b)
+		if _, err := bw.Write(b); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// EncodePNM writes img as a PGM, *image.Gray or *image.Gray16, or as a PPM
+// without the alpha, *image.NRGBA or *image.NRGBA64
+func EncodePNM(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	switch m := img.(type) {
+	case *image.Gray:
+		return writePNM(w, b.Dx(), b.Dy(), 1, 0xff, func(y int, row []byte) {
+			copy(row, m.Pix[y*m.Stride:])
+		})
+	case *image.Gray16:
+		return writePNM(w, b.Dx(), b.Dy(), 1, 0xffff, func(y int, row []byte) {
+			copy(row, m.Pix[y*m.Stride:])
+		})
+	case *image.NRGBA:
+		return writePNM(w, b.Dx(), b.Dy(), 3, 0xff, func(y int, row []byte) {
+			src := m.Pix[y*m.Stride:]
+			for i := 0; i < len(row); i += 3 {
+				copy(row[i:i+3], src[i/3*4:])
+			}
+		})
+	case *image.NRGBA64:
+		return writePNM(w, b.Dx(), b.Dy(), 3, 0xffff, func(y int, row []byte) {
+			src := m.Pix[y*m.Stride:]
+			for i := 0; i < len(row); i += 6 {
+				copy(row[i:i+6], src[i/6*8:])
+			}
+		})
+	}
+	return errors.New("pnm: image not Gray, Gray16, NRGBA or NRGBA64")
+}
+
+// EncodeRawPNM writes the samples of img as they are, as a PGM of 16 bits,
+// or a PPM for the RGB images, as dcraw -D -4
+func EncodeRawPNM(w io.Writer, img *RawImage) error {
+	size := img.PixelSize()
+	if len(img.Pix) < img.Width*img.Height*size {
+		return errors.New("pnm: samples missing")
+	}
+	return writePNM(w, img.Width, img.Height, size, 0xffff, func(y int, row []byte) {
+		for i, v := range img.Pix[y*img.Width*size : (y+1)*img.Width*size] {
+			row[2*i], row[2*i+1] = uint8(v>>8), uint8(v)
+		}
+	})
+}
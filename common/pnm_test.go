@@ -0,0 +1,54 @@
+package common
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodePNM(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var buf bytes.Buffer
+	gray := image.NewGray16(image.Rect(0, 0, 2, 1))
+	copy(gray.Pix, []uint8{1, 2, 3, 4})
+	require.Nil(EncodePNM(&buf, gray))
+	assert.Equal("P5\n2 1\n65535\n\x01\x02\x03\x04", buf.String())
+
+	buf.Reset()
+	rgba := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	copy(rgba.Pix, []uint8{1, 2, 3, 255, 4, 5, 6, 255})
+	require.Nil(EncodePNM(&buf, rgba))
+	assert.Equal("P6\n2 1\n255\n\x01\x02\x03\x04\x05\x06", buf.String())
+
+	buf.Reset()
+	rgba64 := image.NewNRGBA64(image.Rect(0, 0, 1, 1))
+	copy(rgba64.Pix, []uint8{1, 2, 3, 4, 5, 6, 0xff, 0xff})
+	require.Nil(EncodePNM(&buf, rgba64))
+	assert.Equal("P6\n1 1\n65535\n\x01\x02\x03\x04\x05\x06", buf.String())
+
+	assert.NotNil(EncodePNM(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1))))
+	assert.NotNil(EncodePNM(&buf, image.NewGray(image.Rect(0, 0, 0, 0))))
+}
+
+func TestEncodeRawPNM(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var buf bytes.Buffer
+	img := &RawImage{Width: 3, Height: 1, Pix: []uint16{0x0102, 0x0304, 0xfffe}, CFA: CFARGGB}
+	require.Nil(EncodeRawPNM(&buf, img))
+	assert.Equal("P5\n3 1\n65535\n\x01\x02\x03\x04\xff\xfe", buf.String())
+
+	buf.Reset()
+	img = &RawImage{Width: 1, Height: 1, Pix: []uint16{1, 2, 3}, Colors: 3}
+	require.Nil(EncodeRawPNM(&buf, img))
+	assert.Equal("P6\n1 1\n65535\n\x00\x01\x00\x02\x00\x03", buf.String())
+
+	img.Pix = img.Pix[:2]
+	assert.NotNil(EncodeRawPNM(&buf, img))
+}
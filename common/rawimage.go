@@ -0,0 +1,235 @@
+package common
+
+import (
+	"image"
+	"image/color"
+	"sync"
+)
+
+// Colors of the CFA filters
+const (
+	Red   = 0
+	Green = 1
+	Blue  = 2
+)
+
+// CFA color filter array, Pattern holds the filter color of each photosite
+// of a Width x Height tile, row by row, repeated on the whole sensor
+type CFA struct {
+	Width   int
+	Height  int
+	Pattern []uint8
+}
+
+// CFARGGB Bayer pattern with red in the top left corner
+var CFARGGB = CFA{Width: 2, Height: 2, Pattern: []uint8{Red, Green, Green, Blue}}
+
+// Color returns the filter color of the photosite at row, col
+func (c CFA) Color(row, col int) uint8 {
+	if len(c.Pattern) == 0 {
+		return Green
+	}
+	row, col = row%c.Height, col%c.Width
+	if row < 0 {
+		row += c.Height
+	}
+	if col < 0 {
+		col += c.Width
+	}
+	return c.Pattern[row*c.Width+col]
+}
+
+// String returns the colors of the pattern row by row, as "RGGB"
+func (c CFA) String() string {
+	names := make([]byte, len(c.Pattern))
+	for i, color := range c.Pattern {
+		names[i] = "RGB"[color%3]
+	}
+	return string(names)
+}
+
+// Offset returns the pattern of the image starting at column x, row y
+func (c CFA) Offset(x, y int) CFA {
+	result := CFA{Width: c.Width, Height: c.Height, Pattern: make([]uint8, len(c.Pattern))}
+	for row := 0; row < c.Height; row++ {
+		for col := 0; col < c.Width; col++ {
+			result.Pattern[row*c.Width+col] = c.Color(row+y, col+x)
+		}
+	}
+	return result
+}
+
+// RawImage samples of the sensor as stored in the raw file, one for each
+// photosite, before any processing
+type RawImage struct {
+	Width  int
+	Height int
+	// Pix samples row by row, Width*Height*PixelSize()
+	Pix []uint16
+	CFA CFA
+	// Colors samples of each pixel, 0 or 1 for a CFA sensor, 3 for the
+	// interleaved RGB of the Canon sRAW and mRAW, that have no CFA
+	Colors int
+	// ActiveArea the part of the sensor exposed to light, the rest is the
+	// masked border. Empty if not known
+	ActiveArea image.Rectangle
+	// BlackLevel value of a photosite with no light
+	BlackLevel uint16
+	// WhiteLevel value of a saturated photosite
+	WhiteLevel uint16
+	// ChannelBlackLevel black level of each channel in R G G B order, zero
+	// if the file does not store them
+	ChannelBlackLevel [4]uint16
+	// WBMultipliers white balance of the shot, R G G B with green 1, zero
+	// if not known
+	WBMultipliers [4]float64
+	// ColorTemperature of the shot in K, 0 if not known
+	ColorTemperature int
+	// Orientation as in the EXIF tag 0x0112, 1 is top left
+	Orientation int
+	Metadata    Metadata
+	// MakerNote values of the vendor maker note, *canon.MakerNote for CR2
+	MakerNote interface{}
+}
+
+// PixelSize returns the number of samples of each pixel
+func (r *RawImage) PixelSize() int {
+	if r.Colors > 1 {
+		return r.Colors
+	}
+	return 1
+}
+
+// Sample returns the sample at x, y, the first color of a RGB image
+func (r *RawImage) Sample(x, y int) uint16 {
+	return r.Pix[(y*r.Width+x)*r.PixelSize()]
+}
+
+// Channel returns the index in R G G B order of the photosite at x, y of a
+// Bayer sensor, the green in the rows of red is 1
+func (r *RawImage) Channel(x, y int) int {
+	switch r.CFA.Color(y, x) {
+	case Red:
+		return 0
+	case Blue:
+		return 3
+	}
+	if r.CFA.Color(y, x+1) == Red || r.CFA.Color(y, x-1) == Red {
+		return 1
+	}
+	return 2
+}
+
+// Crop returns a copy of the image reduced to the active area, the image
+// itself if the active area is not known
+func (r *RawImage) Crop() *RawImage {
+	area := r.ActiveArea.Intersect(r.Bounds())
+	if area.Empty() || area == r.Bounds() {
+		return r
+	}
+	size := r.PixelSize()
+	result := *r
+	result.Width, result.Height = area.Dx(), area.Dy()
+	result.Pix = make([]uint16, result.Width*result.Height*size)
+	stride := result.Width * size
+	for y := 0; y < result.Height; y++ {
+		start := ((area.Min.Y+y)*r.Width + area.Min.X) * size
+		copy(result.Pix[y*stride:(y+1)*stride], r.Pix[start:start+stride])
+	}
+	result.CFA = r.CFA.Offset(area.Min.X, area.Min.Y)
+	result.ActiveArea = result.Bounds()
+	return &result
+}
+
+// MaskedBlackLevels returns the mean of the samples in the masked areas for
+// each channel, R G G B. ok is false if a channel has no samples or the
+// areas are mostly zero
+func (r *RawImage) MaskedBlackLevels(areas []image.Rectangle) (black [4]uint16, ok bool) {
+	if r.PixelSize() != 1 {
+		return black, false
+	}
+	var sum, count [4]int64
+	var zero int64
+	var mutex sync.Mutex
+	for _, area := range areas {
+		area = area.Intersect(r.Bounds())
+		if area.Empty() {
+			continue
+		}
+		ParallelRows(area.Dy(), WorkersCount(), func(start, end int) {
+			var s, n [4]int64
+			var z int64
+			for y := area.Min.Y + start; y < area.Min.Y+end; y++ {
+				for x := area.Min.X; x < area.Max.X; x++ {
+					v := r.Pix[y*r.Width+x]
+					c := r.Channel(x, y)
+					s[c] += int64(v)
+					n[c]++
+					if v == 0 {
+						z++
+					}
+				}
+			}
+			mutex.Lock()
+			for c := range s {
+				sum[c] += s[c]
+				count[c] += n[c]
+			}
+			zero += z
+			mutex.Unlock()
+		})
+	}
+	if zero*2 > count[0]+count[1]+count[2]+count[3] {
+		return [4]uint16{}, false
+	}
+	for c := range black {
+		if count[c] == 0 {
+			return [4]uint16{}, false
+		}
+		black[c] = uint16((sum[c] + count[c]/2) / count[c])
+	}
+	return black, true
+}
+
+// ColorModel the samples are seen as gray levels, RGB images as RGBA64
+func (r *RawImage) ColorModel() color.Model {
+	if r.PixelSize() == 3 {
+		return color.RGBA64Model
+	}
+	return color.Gray16Model
+}
+
+// Bounds returns the size of the sensor
+func (r *RawImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, r.Width, r.Height)
+}
+
+// At returns the sample at x, y as a gray level, scaled from
+// [BlackLevel, WhiteLevel] to the 16 bit range. RGB images give the scaled
+// colors
+func (r *RawImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(r.Bounds())) {
+		if r.PixelSize() == 3 {
+			return color.RGBA64{}
+		}
+		return color.Gray16{}
+	}
+	if r.PixelSize() == 3 {
+		i := (y*r.Width + x) * 3
+		return color.RGBA64{R: r.scale(r.Pix[i]), G: r.scale(r.Pix[i+1]), B: r.scale(r.Pix[i+2]), A: 0xffff}
+	}
+	return color.Gray16{Y: r.scale(r.Sample(x, y))}
+}
+
+// scale maps a sample from [BlackLevel, WhiteLevel] to the 16 bit range
+func (r *RawImage) scale(sample uint16) uint16 {
+	v := int(sample) - int(r.BlackLevel)
+	if v <= 0 {
+		return 0
+	}
+	white := int(r.WhiteLevel) - int(r.BlackLevel)
+	if white <= 0 || v >= white {
+		return 0xffff
+	}
+	return uint16(v * 0xffff / white)
+}
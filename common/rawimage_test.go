@@ -0,0 +1,45 @@
+package common
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCFAOffset(t *testing.T) {
+	assert := assert.New(t)
+
+	cfa := CFARGGB.Offset(1, 0)
+	assert.Equal([]uint8{Green, Red, Blue, Green}, cfa.Pattern)
+	assert.Equal(CFARGGB.Pattern, cfa.Offset(-1, 0).Pattern)
+	assert.Equal([]uint8{Blue, Green, Green, Red}, CFARGGB.Offset(-3, 5).Pattern)
+	assert.Equal(uint8(Red), CFARGGB.Color(-2, -4))
+	assert.Equal("GBRG", CFARGGB.Offset(0, 1).String())
+}
+
+func TestMaskedBlackLevels(t *testing.T) {
+	assert := assert.New(t)
+
+	img := &RawImage{Width: 4, Height: 4, Pix: make([]uint16, 16), CFA: CFARGGB.Offset(1, 0)}
+	for i := range img.Pix {
+		img.Pix[i] = uint16(10 + img.Channel(i%4, i/4))
+	}
+	assert.Equal(1, img.Channel(0, 0))
+	assert.Equal(0, img.Channel(1, 0))
+	assert.Equal(2, img.Channel(1, 1))
+
+	black, ok := img.MaskedBlackLevels([]image.Rectangle{image.Rect(0, 0, 2, 4), image.Rect(-2, 0, 8, 1)})
+	assert.True(ok)
+	assert.Equal([4]uint16{10, 11, 12, 13}, black)
+
+	// a single row has no red or blue
+	_, ok = img.MaskedBlackLevels([]image.Rectangle{image.Rect(0, 0, 1, 4)})
+	assert.False(ok)
+
+	for i := range img.Pix {
+		img.Pix[i] = 0
+	}
+	_, ok = img.MaskedBlackLevels([]image.Rectangle{img.Bounds()})
+	assert.False(ok)
+}
@@ -1,18 +1,9 @@
 package common
 
 import (
-	"log"
-	"os"
+	"io"
 )
 
-// TiffInfo info about tiff image
-type TiffInfo struct {
-	Tag  uint16
-	Typ  uint16
-	Len  uint32
-	Save int64
-}
-
 type TiffIfd struct {
 	Width      int64
 	Height     int64
@@ -28,86 +19,76 @@ type TiffIfd struct {
 	Shutter    float32
 }
 
-/*
-    *tag  = get2();
-    *type = get2();
-	*len  = get4();
-    *save = ftell(ifp) + 4;
-    if (*len * ("11124811248484"[*type < 14 ? *type:0]-'0') > 4)
-		fseek (ifp, get4()+base, SEEK_SET);
-
-*/
+// ParseTiff reads the IFDs of the TIFF at base, appending to tiffIfdArray
+// one TiffIfd for each IFD and sub IFD, as dcraw parse_tiff does
+func ParseTiff(f io.ReaderAt, base int64, tiffIfdArray []TiffIfd) ([]TiffIfd, error) {
+	ret := make([]TiffIfd, len(tiffIfdArray))
+	copy(ret, tiffIfdArray)
 
-// GetTiff  legge da file info su tiff
-func GetTiff(f *os.File, order uint16, base int64) (TiffInfo, int64) {
-
-	var start, nextPos, save int64
-	var tag, typ uint16
-	var len uint32
-	tag, start = GetUint16WithOrder(f, order, base)
-	typ, start = GetUint16WithOrder(f, order, start)
-	len, start = GetUint32WithOrder(f, order, start)
-	save = start + 4
-
-	var idx uint16
-	if typ < 14 {
-		idx = typ
-	} else {
-		idx = 0
+	t, err := NewTiffReader(f, base)
+	if err != nil {
+		return ret, err
 	}
-	c := int("11124811248484"[idx]) - int('0')
-
-	if len*uint32(c) > 4 {
-		var v uint32
-		v, _ = GetUint32(f, start)
-		nextPos = base + int64(v)
-	} else {
-		nextPos = start
+	// Fuji HS10 table
+	t.SubIfdTags[61440] = true
+	ifds, err := t.ReadIfds()
+	for _, ifd := range ifds {
+		ret = appendTiffIfd(ret, ifd)
 	}
-
-	return TiffInfo{Tag: tag, Typ: typ, Len: len, Save: save}, nextPos
+	return ret, err
 }
 
-// ParseTiff elaborazione TIFF?
-func ParseTiff(f *os.File, base int64, tiffIfdArray []TiffIfd) []TiffIfd {
-	/*
-		int CLASS parse_tiff (int base) {
-		    int doff;
-
-		    fseek (ifp, base, SEEK_SET);
-		    order = get2();
-		    if (order != 0x4949 && order != 0x4d4d) return 0;
-		    get2();
-		    while ((doff = get4()))
-		    {
-		        fseek (ifp, doff+base, SEEK_SET);
-		        if (parse_tiff_ifd (base)) break;
-		    }
-		    return 1;
+// appendTiffIfd appends the TiffIfd of ifd and of its sub IFDs, the EXIF
+// values are stored in the TiffIfd of the parent
+func appendTiffIfd(tiffIfdArray []TiffIfd, ifd *Ifd) []TiffIfd {
+	var tiffIfd TiffIfd
+	var subs []*Ifd
+	for i := range ifd.Entries {
+		e := &ifd.Entries[i]
+		if e.Len() == 0 {
+			continue
 		}
-	*/
-	var order uint16
-	var doff uint32
-	var start int64
-
-	ret2 := make([]TiffIfd, len(tiffIfdArray))
-	copy(ret2, tiffIfdArray)
-
-	var ret bool
-	order, start = GetUint16(f, base)
-
-	if order == 0x4949 || order == 0x4d4d {
-		_, start = GetUint16(f, start)
-		doff, start = GetUint32WithOrder(f, order, start)
-		for doff > 0 {
-			ret, ret2 = parseTiffIfd(f, order, base+int64(doff), base, ret2)
-			if ret == true {
-				return ret2
+		switch e.Tag {
+		case 256, 61441:
+			tiffIfd.Width = e.Int(0)
+		case 257, 61442:
+			tiffIfd.Height = e.Int(0)
+		case 258, 61443:
+			tiffIfd.Samples = int(e.Count & 7)
+			if tiffIfd.Bps = e.Int(0); tiffIfd.Bps > 32 {
+				tiffIfd.Bps = 8
+			}
+		case 259:
+			tiffIfd.Comp = int(e.Int(0))
+		case 262:
+			tiffIfd.Phint = int(e.Int(0))
+		case 273, 61447:
+			tiffIfd.Offset = int(e.Int(0))
+		case 274:
+			tiffIfd.Flip = int(e.Int(0))
+		case 277:
+			tiffIfd.Samples = int(e.Int(0))
+		case 279, 61448:
+			tiffIfd.Bytes = uint32(e.Int(0))
+		case 322:
+			tiffIfd.TileWidth = int(e.Int(0))
+		case 323:
+			tiffIfd.TileLength = int(e.Int(0))
+		case TagExifIFD:
+			for _, exif := range e.Sub {
+				if exposure := exif.Entry(33434); exposure != nil && exposure.Len() > 0 {
+					tiffIfd.Shutter = float32(exposure.Float(0))
+				}
 			}
-			doff, start = GetUint32(f, start)
+		case TagSubIFDs, 61440:
+			subs = append(subs, e.Sub...)
 		}
 	}
-	return ret2
+	tiffIfdArray = append(tiffIfdArray, tiffIfd)
+	for _, sub := range subs {
+		tiffIfdArray = appendTiffIfd(tiffIfdArray, sub)
+	}
+	return tiffIfdArray
 }
 
 // MinInt64 min between two numbers
@@ -124,106 +105,8 @@ func MaxInt64(a int64, b int64) int64 {
 	return MinInt64(b, a)
 }
 
-func parseMakernote(f *os.File, base int64) {
-
-}
-func parseExif(f *os.File, order uint16, filePos int64, base int64) {
-
-	var start int64
-	var entries uint16
-	var tiffInfo TiffInfo
-
-	entries, start = GetUint16WithOrder(f, order, filePos)
-	log.Printf("TIFF_PARSE_IFD  entries=%d, start=%d", entries, start)
+// maxIfdDepth IFDs pointing to other IFDs deeper than this are not valid
+const maxIfdDepth = 8
 
-	for i := 0; i < int(entries); i++ {
-
-		tiffInfo, start = GetTiff(f, order, start)
-		switch tiffInfo.Tag {
-		case 37500:
-			parseMakernote(f, base)
-		}
-	}
-}
-
-// parseTiffIfd
-func parseTiffIfd(f *os.File, order uint16, filePos int64, base int64, tiffIfdArray []TiffIfd) (bool, []TiffIfd) {
-
-	var start int64
-	var entries uint16
-	var tiffInfo TiffInfo
-	var tiffBps int64
-
-	log.Printf("TIFF_PARSE_IFD  filePos=%d, base=%d", filePos, base)
-	entries, start = GetUint16WithOrder(f, order, filePos)
-	log.Printf("TIFF_PARSE_IFD  entries=%d, start=%d", entries, start)
-
-	var tiffIfdNew = TiffIfd{}
-	//tiffIfdArray2 := append(tiffIfdArray, tiffIfdNew)
-
-	if entries > 512 {
-		return false, tiffIfdArray
-	}
-
-	for i := 0; i < int(entries); i++ {
-		tiffInfo, start = GetTiff(f, order, start)
-		switch tiffInfo.Tag {
-		case 34665: /* EXIF tag */
-			var v uint32
-			v, start = GetUint32WithOrder(f, order, start)
-			parseExif(f, order, int64(v)+base, base)
-			// fseek (ifp, get4()+base, SEEK_SET);
-			// parse_exif (base);
-			log.Printf("TIFF_PARSE_IFD  tag=%d", tiffInfo.Tag)
-
-		case 61440: // Fuji HS10 table
-			var v uint32
-			v, start = GetUint32WithOrder(f, order, start)
-			parseTiffIfd(f, order, int64(v)+base, base, append(tiffIfdArray, tiffIfdNew))
-			/*
-			   fseek (ifp, get4()+base, SEEK_SET);
-			   parse_tiff_ifd (base);
-			   break;
-			*/
-		case 61441: // image width
-			/*
-				case 61441:	/* ImageWidth
-				tiff_ifd[ifd].width = getint(type);
-				break;
-			*/
-
-			// FIXME eliminare indice 1 hardcoded
-
-			tiffIfdNew.Width, start = GetInt(f, order, start, tiffInfo.Typ)
-
-		case 61442: // image height
-			tiffIfdNew.Height, start = GetInt(f, order, start, tiffInfo.Typ)
-		case 258:
-			fallthrough
-		case 61443:
-			/*
-				tiff_ifd[ifd].samples = len & 7;
-				if ((tiff_ifd[ifd].bps = getint(type)) > 32)
-					tiff_ifd[ifd].bps = 8;
-				if (tiff_bps < tiff_ifd[ifd].bps)
-					tiff_bps = tiff_ifd[ifd].bps;
-			*/
-			tiffIfdNew.Samples = 1 & 7
-			tiffIfdNew.Bps, start = GetInt(f, order, start, tiffInfo.Typ)
-			if tiffIfdNew.Bps > 32 {
-				tiffIfdNew.Bps = 8
-			}
-			tiffBps = MaxInt64(tiffIfdNew.Bps, tiffBps)
-
-		case 61448:
-			//tiff_ifd[ifd].bytes = get4();
-			tiffIfdNew.Bytes, start = GetUint32WithOrder(f, order, start)
-
-		default:
-			// log.Printf("TIFF_PARSE_IFD  tag=%d", tiffInfo.Tag)
-		}
-		start = tiffInfo.Save
-	}
-
-	return false, append(tiffIfdArray, tiffIfdNew)
-}
+// maxIfds max number of IFDs in a chain
+const maxIfds = 256
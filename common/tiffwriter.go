@@ -0,0 +1,320 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"sort"
+)
+
+// Tags of the baseline TIFF images
+const (
+	TagNewSubfileType            = 0x00fe
+	TagImageWidth                = 0x0100
+	TagImageLength               = 0x0101
+	TagBitsPerSample             = 0x0102
+	TagCompression               = 0x0103
+	TagPhotometricInterpretation = 0x0106
+	TagStripOffsets              = 0x0111
+	TagSamplesPerPixel           = 0x0115
+	TagRowsPerStrip              = 0x0116
+	TagStripByteCounts           = 0x0117
+	TagXResolution               = 0x011a
+	TagYResolution               = 0x011b
+	TagPlanarConfiguration       = 0x011c
+	TagResolutionUnit            = 0x0128
+	TagSoftware                  = 0x0131
+	TagTileWidth                 = 0x0142
+	TagTileLength                = 0x0143
+	TagTileOffsets               = 0x0144
+	TagTileByteCounts            = 0x0145
+	TagICCProfile                = 0x8773
+)
+
+// tiffStripSize bytes of the strips of the images written
+const tiffStripSize = 1 << 16
+
+// tiffWriter lays out the IFDs and their values in buf, the offsets are
+// relative to the start of buf
+type tiffWriter struct {
+	order binary.ByteOrder
+	buf   []byte
+}
+
+// WriteTiff writes the chain of ifds as a TIFF in order: each IFD is followed
+// by its sub IFDs, its strips and the values not fitting in the entries. The
+// Count of the entries is set from the values, the sub IFDs of the entries
+// of type LONG or IFD replace their values. The Offset and Next of the IFDs
+// are not used
+func WriteTiff(w io.Writer, order binary.ByteOrder, ifds []*Ifd) error {
+	t := &tiffWriter{order: order, buf: make([]byte, 8)}
+	if order == binary.LittleEndian {
+		copy(t.buf, "II")
+	} else {
+		copy(t.buf, "MM")
+	}
+	order.PutUint16(t.buf[2:], 42)
+	next := 4
+	for _, ifd := range ifds {
+		offset, err := t.writeIfd(ifd)
+		if err != nil {
+			return err
+		}
+		order.PutUint32(t.buf[next:], uint32(offset))
+		next = offset + 2 + 12*t.entriesCount(ifd)
+	}
+	_, err := w.Write(t.buf)
+	return err
+}
+
+// stripTags returns the tags of the offsets and of the sizes of the strips
+// of ifd, the ones of the tiles if ifd has TileWidth
+func stripTags(ifd *Ifd) []uint16 {
+	if ifd.Entry(TagTileWidth) != nil {
+		return []uint16{TagTileOffsets, TagTileByteCounts}
+	}
+	return []uint16{TagStripOffsets, TagStripByteCounts}
+}
+
+// entriesCount returns the number of entries written for ifd
+func (t *tiffWriter) entriesCount(ifd *Ifd) int {
+	n := len(ifd.Entries)
+	if ifd.Strips != nil {
+		for _, tag := range stripTags(ifd) {
+			if ifd.Entry(tag) == nil {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// align pads buf to an even offset
+func (t *tiffWriter) align() {
+	if len(t.buf)%2 != 0 {
+		t.buf = append(t.buf, 0)
+	}
+}
+
+// writeIfd appends ifd, returning its offset
+func (t *tiffWriter) writeIfd(ifd *Ifd) (int, error) {
+	entries := append([]IfdEntry{}, ifd.Entries...)
+	tags := stripTags(ifd)
+	if ifd.Strips != nil {
+		for _, tag := range tags {
+			if ifd.Entry(tag) == nil {
+				entries = append(entries, IfdEntry{Tag: tag, Type: TypeLong})
+			}
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Tag < entries[j].Tag })
+
+	t.align()
+	offset := len(t.buf)
+	t.buf = append(t.buf, make([]byte, 2+12*len(entries)+4)...)
+	t.order.PutUint16(t.buf[offset:], uint16(len(entries)))
+
+	var stripOffsets, stripCounts []uint32
+	for _, s := range ifd.Strips {
+		t.align()
+		stripOffsets = append(stripOffsets, uint32(len(t.buf)))
+		stripCounts = append(stripCounts, uint32(len(s)))
+		t.buf = append(t.buf, s...)
+	}
+	for i := range entries {
+		e := &entries[i]
+		value := e.Value
+		switch {
+		case e.Tag == tags[0] && ifd.Strips != nil:
+			value = stripOffsets
+		case e.Tag == tags[1] && ifd.Strips != nil:
+			value = stripCounts
+		case len(e.Sub) > 0 && (e.Type == TypeLong || e.Type == TypeIFD):
+			subs := make([]uint32, len(e.Sub))
+			for j, sub := range e.Sub {
+				o, err := t.writeIfd(sub)
+				if err != nil {
+					return 0, err
+				}
+				subs[j] = uint32(o)
+			}
+			value = subs
+		}
+		b, count, err := encodeValue(t.order, e.Type, value)
+		if err != nil {
+			return 0, fmt.Errorf("tiff: tag 0x%04x: %v", e.Tag, err)
+		}
+		entry := t.buf[offset+2+12*i:]
+		t.order.PutUint16(entry, e.Tag)
+		t.order.PutUint16(entry[2:], e.Type)
+		t.order.PutUint32(entry[4:], uint32(count))
+		if len(b) <= 4 {
+			copy(entry[8:12], b)
+			continue
+		}
+		t.align()
+		t.order.PutUint32(entry[8:], uint32(len(t.buf)))
+		t.buf = append(t.buf, b...)
+	}
+	return offset, nil
+}
+
+// encodeValue returns the bytes and the count of value, as read by
+// decodeValue
+func encodeValue(order binary.ByteOrder, typ uint16, value interface{}) ([]byte, int, error) {
+	var b []byte
+	count := 0
+	switch v := value.(type) {
+	case []uint8:
+		b, count = append(b, v...), len(v)
+	case string:
+		b, count = append([]byte(v), 0), len(v)+1
+	case []int8:
+		for _, x := range v {
+			b = append(b, uint8(x))
+		}
+		count = len(v)
+	case []uint16:
+		b = make([]byte, 2*len(v))
+		for i, x := range v {
+			order.PutUint16(b[2*i:], x)
+		}
+		count = len(v)
+	case []int16:
+		b = make([]byte, 2*len(v))
+		for i, x := range v {
+			order.PutUint16(b[2*i:], uint16(x))
+		}
+		count = len(v)
+	case []uint32:
+		b = make([]byte, 4*len(v))
+		for i, x := range v {
+			order.PutUint32(b[4*i:], x)
+		}
+		count = len(v)
+	case []int32:
+		b = make([]byte, 4*len(v))
+		for i, x := range v {
+			order.PutUint32(b[4*i:], uint32(x))
+		}
+		count = len(v)
+	case []Rational:
+		b = make([]byte, 8*len(v))
+		for i, x := range v {
+			order.PutUint32(b[8*i:], x.Num)
+			order.PutUint32(b[8*i+4:], x.Den)
+		}
+		count = len(v)
+	case []SRational:
+		b = make([]byte, 8*len(v))
+		for i, x := range v {
+			order.PutUint32(b[8*i:], uint32(x.Num))
+			order.PutUint32(b[8*i+4:], uint32(x.Den))
+		}
+		count = len(v)
+	case []float32:
+		b = make([]byte, 4*len(v))
+		for i, x := range v {
+			order.PutUint32(b[4*i:], math.Float32bits(x))
+		}
+		count = len(v)
+	case []float64:
+		b = make([]byte, 8*len(v))
+		for i, x := range v {
+			order.PutUint64(b[8*i:], math.Float64bits(x))
+		}
+		count = len(v)
+	default:
+		return nil, 0, fmt.Errorf("value %T not supported", value)
+	}
+	if int(typ) >= len(typeSizes) || typeSizes[typ] == 0 || len(b) != count*typeSizes[typ] {
+		return nil, 0, fmt.Errorf("value %T does not match the type %d", value, typ)
+	}
+	return b, count, nil
+}
+
+// EncodeTiff writes img as a baseline TIFF of 8 or 16 bits, little endian:
+// RGB without the alpha for *image.NRGBA and *image.NRGBA64, gray for
+// *image.Gray and *image.Gray16. The entries are added to IFD0, as the ICC
+// profile and the EXIF IFD
+func EncodeTiff(w io.Writer, img image.Image, entries []IfdEntry) error {
+	var pix []uint8
+	var stride, bits, samples, srcSamples int
+	switch m := img.(type) {
+	case *image.Gray:
+		pix, stride, bits, samples, srcSamples = m.Pix, m.Stride, 8, 1, 1
+	case *image.Gray16:
+		pix, stride, bits, samples, srcSamples = m.Pix, m.Stride, 16, 1, 1
+	case *image.NRGBA:
+		pix, stride, bits, samples, srcSamples = m.Pix, m.Stride, 8, 3, 4
+	case *image.NRGBA64:
+		pix, stride, bits, samples, srcSamples = m.Pix, m.Stride, 16, 3, 4
+	default:
+		return errors.New("tiff: image not Gray, Gray16, NRGBA or NRGBA64")
+	}
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width <= 0 || height <= 0 {
+		return errors.New("tiff: empty image")
+	}
+	size := bits / 8
+	rowSize := width * samples * size
+	rows := tiffStripSize / rowSize
+	if rows < 1 {
+		rows = 1
+	}
+
+	ifd := &Ifd{}
+	for y := 0; y < height; y += rows {
+		n := rows
+		if y+n > height {
+			n = height - y
+		}
+		strip := make([]byte, 0, n*rowSize)
+		for row := y; row < y+n; row++ {
+			src := pix[row*stride : row*stride+width*srcSamples*size]
+			for i := 0; i < len(src); i += srcSamples * size {
+				if size == 1 {
+					strip = append(strip, src[i:i+samples]...)
+					continue
+				}
+				// the samples of the images of 16 bits are big endian
+				for c := 0; c < samples; c++ {
+					strip = append(strip, src[i+2*c+1], src[i+2*c])
+				}
+			}
+		}
+		ifd.Strips = append(ifd.Strips, strip)
+	}
+	bitsPerSample := make([]uint16, samples)
+	for i := range bitsPerSample {
+		bitsPerSample[i] = uint16(bits)
+	}
+	photometric := uint16(2)
+	if samples == 1 {
+		photometric = 1
+	}
+	ifd.Entries = []IfdEntry{
+		{Tag: TagNewSubfileType, Type: TypeLong, Value: []uint32{0}},
+		{Tag: TagImageWidth, Type: TypeLong, Value: []uint32{uint32(width)}},
+		{Tag: TagImageLength, Type: TypeLong, Value: []uint32{uint32(height)}},
+		{Tag: TagBitsPerSample, Type: TypeShort, Value: bitsPerSample},
+		{Tag: TagCompression, Type: TypeShort, Value: []uint16{1}},
+		{Tag: TagPhotometricInterpretation, Type: TypeShort, Value: []uint16{photometric}},
+		{Tag: TagSamplesPerPixel, Type: TypeShort, Value: []uint16{uint16(samples)}},
+		{Tag: TagRowsPerStrip, Type: TypeLong, Value: []uint32{uint32(rows)}},
+		{Tag: TagXResolution, Type: TypeRational, Value: []Rational{{300, 1}}},
+		{Tag: TagYResolution, Type: TypeRational, Value: []Rational{{300, 1}}},
+		{Tag: TagPlanarConfiguration, Type: TypeShort, Value: []uint16{1}},
+		{Tag: TagResolutionUnit, Type: TypeShort, Value: []uint16{2}},
+	}
+	for _, e := range entries {
+		if ifd.Entry(e.Tag) == nil {
+			ifd.Entries = append(ifd.Entries, e)
+		}
+	}
+	return WriteTiff(w, binary.LittleEndian, []*Ifd{ifd})
+}
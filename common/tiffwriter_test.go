@@ -0,0 +1,154 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteTiff(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	values := []IfdEntry{
+		{Tag: 0x0301, Type: TypeByte, Value: []uint8{1, 2, 3, 4, 5}},
+		{Tag: 0x0302, Type: TypeASCII, Value: "camera"},
+		{Tag: 0x0303, Type: TypeShort, Value: []uint16{7}},
+		{Tag: 0x0304, Type: TypeSShort, Value: []int16{-1, 2, -3}},
+		{Tag: 0x0305, Type: TypeLong, Value: []uint32{1 << 20, 3}},
+		{Tag: 0x0306, Type: TypeSLong, Value: []int32{-70000}},
+		{Tag: 0x0307, Type: TypeRational, Value: []Rational{{1, 200}}},
+		{Tag: 0x0308, Type: TypeSRational, Value: []SRational{{-1, 3}, {2, 3}}},
+		{Tag: 0x0309, Type: TypeFloat, Value: []float32{1.5}},
+		{Tag: 0x030a, Type: TypeDouble, Value: []float64{0.25}},
+		{Tag: 0x030b, Type: TypeUndefined, Value: []byte("abc")},
+		{Tag: 0x030c, Type: TypeSByte, Value: []int8{-5}},
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		sub := &Ifd{Entries: []IfdEntry{{Tag: TagISO, Type: TypeShort, Value: []uint16{400}}}}
+		first := &Ifd{Entries: append([]IfdEntry{
+			{Tag: TagExifIFD, Type: TypeLong, Sub: []*Ifd{sub}},
+			{Tag: TagImageWidth, Type: TypeShort, Value: []uint16{2}},
+		}, values...), Strips: [][]byte{{1, 2, 3}, {4, 5}}}
+		// a tiled image
+		second := &Ifd{Entries: []IfdEntry{
+			{Tag: TagImageWidth, Type: TypeShort, Value: []uint16{4}},
+			{Tag: TagTileWidth, Type: TypeLong, Value: []uint32{16}},
+		}, Strips: [][]byte{{9}}}
+		var buf bytes.Buffer
+		require.Nil(WriteTiff(&buf, order, []*Ifd{first, second}))
+
+		data := buf.Bytes()
+		r, err := NewTiffReader(bytes.NewReader(data), 0)
+		require.Nil(err)
+		ifds, err := r.ReadIfds()
+		require.Nil(err)
+		require.Len(ifds, 2)
+		assert.Equal(int64(4), ifds[1].Entry(TagImageWidth).Int(0))
+		assert.Nil(ifds[1].Entry(TagStripOffsets))
+		assert.Equal([]uint32{1}, ifds[1].Entry(TagTileByteCounts).Value)
+		assert.Equal(byte(9), data[ifds[1].Entry(TagTileOffsets).Int(0)])
+
+		ifd := ifds[0]
+		for i := 1; i < len(ifd.Entries); i++ {
+			assert.True(ifd.Entries[i-1].Tag < ifd.Entries[i].Tag, "sorted")
+		}
+		for _, e := range values {
+			read := ifd.Entry(e.Tag)
+			require.NotNil(read, "tag %x", e.Tag)
+			assert.Equal(e.Value, read.Value, "tag %x", e.Tag)
+		}
+		exif := ifd.Entry(TagExifIFD)
+		require.Len(exif.Sub, 1)
+		assert.Equal(int64(400), exif.Sub[0].Entry(TagISO).Int(0))
+		offsets, counts := ifd.Entry(TagStripOffsets), ifd.Entry(TagStripByteCounts)
+		assert.Equal([]uint32{3, 2}, counts.Value)
+		assert.Equal([]byte{1, 2, 3}, data[offsets.Int(0):offsets.Int(0)+3])
+		assert.Equal([]byte{4, 5}, data[offsets.Int(1):offsets.Int(1)+2])
+	}
+
+	// the value does not match the type
+	err := WriteTiff(&bytes.Buffer{}, binary.LittleEndian, []*Ifd{{Entries: []IfdEntry{{Tag: 1, Type: TypeShort, Value: []uint32{1}}}}})
+	assert.NotNil(err)
+}
+
+func TestEncodeTiff(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	img := image.NewNRGBA64(image.Rect(0, 0, 3, 2))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	var buf bytes.Buffer
+	profile := []byte("profile")
+	require.Nil(EncodeTiff(&buf, img, []IfdEntry{{Tag: TagICCProfile, Type: TypeUndefined, Value: profile}}))
+
+	data := buf.Bytes()
+	r, err := NewTiffReader(bytes.NewReader(data), 0)
+	require.Nil(err)
+	ifd, err := r.ReadIfd(r.First)
+	require.Nil(err)
+	assert.Equal(int64(3), ifd.Entry(TagImageWidth).Int(0))
+	assert.Equal(int64(2), ifd.Entry(TagImageLength).Int(0))
+	assert.Equal([]uint16{16, 16, 16}, ifd.Entry(TagBitsPerSample).Value)
+	assert.Equal(profile, ifd.Entry(TagICCProfile).Value)
+	offset := ifd.Entry(TagStripOffsets).Int(0)
+	assert.Equal([]uint32{36}, ifd.Entry(TagStripByteCounts).Value)
+	// the samples of the first pixel, little endian, without the alpha
+	assert.Equal([]byte{1, 0, 3, 2, 5, 4, 9, 8}, data[offset:offset+8])
+
+	rgba := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	copy(rgba.Pix, []uint8{10, 20, 30, 255})
+	buf.Reset()
+	require.Nil(EncodeTiff(&buf, rgba, nil))
+	r, err = NewTiffReader(bytes.NewReader(buf.Bytes()), 0)
+	require.Nil(err)
+	ifd, err = r.ReadIfd(r.First)
+	require.Nil(err)
+	offset = ifd.Entry(TagStripOffsets).Int(0)
+	assert.Equal([]byte{10, 20, 30}, buf.Bytes()[offset:offset+3])
+
+	gray := image.NewGray16(image.Rect(0, 0, 2, 1))
+	copy(gray.Pix, []uint8{1, 2, 3, 4})
+	buf.Reset()
+	require.Nil(EncodeTiff(&buf, gray, nil))
+	r, err = NewTiffReader(bytes.NewReader(buf.Bytes()), 0)
+	require.Nil(err)
+	ifd, err = r.ReadIfd(r.First)
+	require.Nil(err)
+	assert.Equal([]uint16{1}, ifd.Entry(TagPhotometricInterpretation).Value)
+	offset = ifd.Entry(TagStripOffsets).Int(0)
+	assert.Equal([]byte{2, 1, 4, 3}, buf.Bytes()[offset:offset+4])
+
+	assert.NotNil(EncodeTiff(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1)), nil))
+}
+
+func TestMetadataTiffEntries(t *testing.T) {
+	assert := assert.New(t)
+
+	m := Metadata{Make: "Canon", Model: "Canon EOS 5D Mark IV", Serial: "0123", Lens: "EF50mm f/1.4",
+		ExposureTime: 0.005, FNumber: 5.6, ISO: 400, FocalLength: 50, ExposureCompensation: -1.0 / 3,
+		MeteringMode: 5, ExposureProgram: 3, ExposureMode: 1, Flash: 16, Orientation: 8,
+		CaptureTime:   time.Date(2019, 5, 4, 9, 59, 58, 250000000, time.FixedZone("+02:00", 7200)),
+		HasOffsetTime: true}
+	ifd0, exif := m.TiffEntries()
+	var read Metadata
+	read.Read(&Ifd{Entries: ifd0})
+	read.Read(&Ifd{Entries: exif})
+	assert.Equal([]Rational{{1, 200}}, (&Ifd{Entries: exif}).Entry(TagExposureTime).Value)
+	assert.InDelta(m.ExposureCompensation, read.ExposureCompensation, 0.01)
+	read.ExposureCompensation = m.ExposureCompensation
+	assert.True(m.CaptureTime.Equal(read.CaptureTime))
+	read.CaptureTime = m.CaptureTime
+	assert.Equal(m, read)
+
+	ifd0, exif = (&Metadata{}).TiffEntries()
+	assert.Empty(ifd0)
+	assert.Empty(exif)
+}
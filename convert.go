@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/enricod/rawmgr/common"
+	"github.com/enricod/rawmgr/demosaic"
+	"github.com/enricod/rawmgr/develop"
+	"github.com/enricod/rawmgr/dng"
+	"github.com/enricod/rawmgr/raw"
+)
+
+// shrink returns img reduced by the integer factor that makes its longest
+// side at most size, each pixel the mean of the ones it replaces
+func shrink(img *image.NRGBA, size int) *image.NRGBA {
+	b := img.Bounds()
+	n := (b.Dx() + size - 1) / size
+	if m := (b.Dy() + size - 1) / size; m > n {
+		n = m
+	}
+	if n <= 1 {
+		return img
+	}
+	result := image.NewNRGBA(image.Rect(0, 0, b.Dx()/n, b.Dy()/n))
+	common.ParallelRows(result.Rect.Dy(), common.WorkersCount(), func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < result.Rect.Dx(); x++ {
+				var sum [4]int
+				for dy := 0; dy < n; dy++ {
+					p := img.Pix[img.PixOffset(b.Min.X+x*n, b.Min.Y+y*n+dy):]
+					for i := 0; i < 4*n; i++ {
+						sum[i%4] += int(p[i])
+					}
+				}
+				d := result.Pix[result.PixOffset(x, y):]
+				for c := range sum {
+					d[c] = uint8(sum[c] / (n * n))
+				}
+			}
+		}
+	})
+	return result
+}
+
+// previewJPEG develops img as a JPEG whose longest side is at most size
+func previewJPEG(img *common.RawImage, size int) ([]byte, error) {
+	p := develop.NewPipeline()
+	p.Demosaic = demosaic.Bilinear
+	p.Bits = 8
+	out, err := p.Develop(img)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, shrink(out.(*image.NRGBA), size), &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// convertCommand converts the raw file in args to another raw format, DNG
+func convertCommand(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	common.Verbose = flags.Bool("v", false, "verbose")
+	common.Workers = flags.Int("workers", 0, "number of goroutines, 0 for one for each CPU")
+	to := flags.String("to", "dng", "output format: dng")
+	uncompressed := flags.Bool("u", false, "do not compress the raw data")
+	preview := flags.Int("preview", 1024, "longest side of the JPEG preview, 0 for none")
+	output := flags.String("out", "", "output file, the raw file with the extension of the format by default")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: rawmgr convert [options] file")
+	}
+	if *to != "dng" {
+		return fmt.Errorf("unknown output format %q", *to)
+	}
+	rawfile := flags.Arg(0)
+	if *output == "" {
+		*output = rawfile + "." + *to
+	}
+
+	data, err := ioutil.ReadFile(rawfile)
+	if err != nil {
+		return err
+	}
+	img, err := raw.Decode(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	opts := &dng.Options{Compress: !*uncompressed}
+	if opts.Exif, err = raw.ReadExif(bytes.NewReader(data)); err != nil && *common.Verbose {
+		log.Printf("EXIF not copied, only the metadata: %v", err)
+	}
+	if *preview > 0 {
+		if opts.Preview, err = previewJPEG(img, *preview); err != nil {
+			return err
+		}
+	}
+	if *common.Verbose {
+		log.Printf("converting %s %s to %s", img.Metadata.Make, img.Metadata.Model, *output)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := dng.Encode(f, img, opts); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -2,4 +2,4 @@
 rm -f dcraw
 gcc -o dcraw -O4 dcraw.c -lm -DNODEPS
 
-./dcraw ~/Pictures/raws/Canon_001.CR2 > OUT
+./dcraw -D -4 ../images/Canon/Canon_001.CR2
@@ -1,20 +1,17 @@
+// compares byte by byte the PGM of dcraw -D -4 with the one of rawmgr
+def good = new File(args.length > 0 ? args[0] : "../images/Canon/Canon_001.pgm")
+def maybe = new File(args.length > 1 ? args[1] : "../images/Canon/Canon_001.CR2.pgm")
 
-def good = new File("./OUT")
-def maybe = new File("../OUT")
+byte[] bytesGood = good.bytes
+byte[] bytesMaybe = maybe.bytes
 
-
-LineNumberReader readerGood = good.newReader()
-LineNumberReader readerMaybe = maybe.newReader()
-
-while ((l1 = readerGood.readLine()) != null) {
-    l2 = readerMaybe.readLine() 
-
-    if (l2 != null) {
-        def startLine = l2.split()[0]
-        if (!l1.startsWith(startLine)) {
-            println "ERRORE in ${l2} vs ${l1}"
-            break
-        }
+if (bytesGood.length != bytesMaybe.length) {
+    println "ERRORE dimensione ${bytesMaybe.length} vs ${bytesGood.length}"
+}
+def n = Math.min(bytesGood.length, bytesMaybe.length)
+for (int i = 0; i < n; i++) {
+    if (bytesGood[i] != bytesMaybe[i]) {
+        println "ERRORE in offset ${i}: ${bytesMaybe[i]} vs ${bytesGood[i]}"
+        break
     }
-    
 }
@@ -0,0 +1,174 @@
+package demosaic
+
+import (
+	"github.com/enricod/rawmgr/common"
+)
+
+const ahdTileSize = 512
+
+// ahdBuffers the buffers of a tile, for the horizontal and the vertical
+// interpolation
+type ahdBuffers struct {
+	rgb  [2][][3]uint16
+	lab  [2][][3]int16
+	homo [2][]uint8
+}
+
+func newAHDBuffers() *ahdBuffers {
+	b := &ahdBuffers{}
+	for d := 0; d < 2; d++ {
+		b.rgb[d] = make([][3]uint16, ahdTileSize*ahdTileSize)
+		b.lab[d] = make([][3]int16, ahdTileSize*ahdTileSize)
+		b.homo[d] = make([]uint8, ahdTileSize*ahdTileSize)
+	}
+	return b
+}
+
+// ahd interpolates with the adaptive homogeneity-directed algorithm of Keigo
+// Hirakawa, Thomas Parks and Paul Lee, as dcraw ahd_interpolate: green is
+// interpolated horizontally and vertically, and each pixel takes the
+// direction with the most homogeneous colors around it, compared in CIELab
+// with rgbCam, the matrix of the camera or nil. The tiles are interpolated
+// in parallel
+func (r *raster) ahd(white uint16, rgbCam *[3][3]float64) {
+	r.borderInterpolate(5)
+	lab := common.NewLabConverter(white, rgbCam)
+	var tiles [][2]int
+	for top := 2; top < r.height-5; top += ahdTileSize - 6 {
+		for left := 2; left < r.width-5; left += ahdTileSize - 6 {
+			tiles = append(tiles, [2]int{top, left})
+		}
+	}
+	out := make([][3]uint16, len(r.pix))
+	copy(out, r.pix)
+	common.ParallelRows(len(tiles), common.WorkersCount(), func(start, end int) {
+		buf := newAHDBuffers()
+		for _, t := range tiles[start:end] {
+			r.ahdTile(buf, lab, out, t[0], t[1])
+		}
+	})
+	r.pix = out
+}
+
+func (r *raster) ahdTile(buf *ahdBuffers, lab *common.LabConverter, out [][3]uint16, top, left int) {
+	const ts = ahdTileSize
+	dir := [4]int{-1, 1, -ts, ts}
+	w := r.width
+	pix := r.pix
+
+	// interpolate green horizontally and vertically
+	for row := top; row < top+ts && row < r.height-2; row++ {
+		col := left
+		if r.fc(row, col) == common.Green {
+			col++
+		}
+		c := r.fc(row, col)
+		for ; col < left+ts && col < w-2; col += 2 {
+			i := row*w + col
+			t := (row-top)*ts + col - left
+			val := ((int(pix[i-1][1])+int(pix[i][c])+int(pix[i+1][1]))*2 - int(pix[i-2][c]) - int(pix[i+2][c])) >> 2
+			buf.rgb[0][t][1] = uint16(ulimit(val, int(pix[i-1][1]), int(pix[i+1][1])))
+			val = ((int(pix[i-w][1])+int(pix[i][c])+int(pix[i+w][1]))*2 - int(pix[i-2*w][c]) - int(pix[i+2*w][c])) >> 2
+			buf.rgb[1][t][1] = uint16(ulimit(val, int(pix[i-w][1]), int(pix[i+w][1])))
+		}
+	}
+
+	// interpolate red and blue, and convert to CIELab
+	for d := 0; d < 2; d++ {
+		rgb := buf.rgb[d]
+		for row := top + 1; row < top+ts-1 && row < r.height-3; row++ {
+			for col := left + 1; col < left+ts-1 && col < w-3; col++ {
+				i := row*w + col
+				t := (row-top)*ts + col - left
+				var val int
+				c := 2 - r.fc(row, col)
+				if c == common.Green {
+					c = r.fc(row+1, col)
+					val = int(pix[i][1]) + (int(pix[i-1][2-c])+int(pix[i+1][2-c])-int(rgb[t-1][1])-int(rgb[t+1][1]))>>1
+					rgb[t][2-c] = clip16(val)
+					val = int(pix[i][1]) + (int(pix[i-w][c])+int(pix[i+w][c])-int(rgb[t-ts][1])-int(rgb[t+ts][1]))>>1
+				} else {
+					val = int(rgb[t][1]) + (int(pix[i-w-1][c])+int(pix[i-w+1][c])+
+						int(pix[i+w-1][c])+int(pix[i+w+1][c])-
+						int(rgb[t-ts-1][1])-int(rgb[t-ts+1][1])-
+						int(rgb[t+ts-1][1])-int(rgb[t+ts+1][1])+1)>>2
+				}
+				rgb[t][c] = clip16(val)
+				c = r.fc(row, col)
+				rgb[t][c] = pix[i][c]
+				l, a, b := lab.Lab(rgb[t][0], rgb[t][1], rgb[t][2])
+				buf.lab[d][t] = [3]int16{l, a, b}
+			}
+		}
+	}
+
+	// build homogeneity maps from the CIELab images
+	for d := 0; d < 2; d++ {
+		for i := range buf.homo[d] {
+			buf.homo[d][i] = 0
+		}
+	}
+	var ldiff, abdiff [2][4]int
+	for row := top + 2; row < top+ts-2 && row < r.height-4; row++ {
+		for col := left + 2; col < left+ts-2 && col < w-4; col++ {
+			t := (row-top)*ts + col - left
+			for d := 0; d < 2; d++ {
+				lix := buf.lab[d]
+				for i, o := range dir {
+					ldiff[d][i] = abs(int(lix[t][0]) - int(lix[t+o][0]))
+					da, db := int(lix[t][1])-int(lix[t+o][1]), int(lix[t][2])-int(lix[t+o][2])
+					abdiff[d][i] = da*da + db*db
+				}
+			}
+			leps := minInt(maxInt(ldiff[0][0], ldiff[0][1]), maxInt(ldiff[1][2], ldiff[1][3]))
+			abeps := minInt(maxInt(abdiff[0][0], abdiff[0][1]), maxInt(abdiff[1][2], abdiff[1][3]))
+			for d := 0; d < 2; d++ {
+				for i := range dir {
+					if ldiff[d][i] <= leps && abdiff[d][i] <= abeps {
+						buf.homo[d][t]++
+					}
+				}
+			}
+		}
+	}
+
+	// combine the most homogeneous pixels for the final result
+	for row := top + 3; row < top+ts-3 && row < r.height-5; row++ {
+		for col := left + 3; col < left+ts-3 && col < w-5; col++ {
+			t := (row-top)*ts + col - left
+			var hm [2]int
+			for d := 0; d < 2; d++ {
+				for y := -1; y <= 1; y++ {
+					for x := -1; x <= 1; x++ {
+						hm[d] += int(buf.homo[d][t+y*ts+x])
+					}
+				}
+			}
+			o := &out[row*w+col]
+			switch {
+			case hm[0] > hm[1]:
+				*o = buf.rgb[0][t]
+			case hm[0] < hm[1]:
+				*o = buf.rgb[1][t]
+			default:
+				for c := range o {
+					o[c] = uint16((int(buf.rgb[0][t][c]) + int(buf.rgb[1][t][c])) >> 1)
+				}
+			}
+		}
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
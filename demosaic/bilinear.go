@@ -0,0 +1,70 @@
+package demosaic
+
+import "github.com/enricod/rawmgr/common"
+
+// linTerm a neighbour of a photosite, at offset pixels, weighted 1 << shift
+type linTerm struct {
+	offset int
+	shift  uint
+	color  int
+}
+
+// linCode terms of the neighbours of a photosite of the pattern, and the
+// factors of the sums of each color, scaled by 256
+type linCode struct {
+	terms  []linTerm
+	factor [3]int
+}
+
+// bilinear interpolates each color from the photosites of the color around
+// the pixel, as dcraw lin_interpolate
+func (r *raster) bilinear() {
+	r.borderInterpolate(1)
+	codes := make([]linCode, r.cfa.Width*r.cfa.Height)
+	for row := 0; row < r.cfa.Height; row++ {
+		for col := 0; col < r.cfa.Width; col++ {
+			code := &codes[row*r.cfa.Width+col]
+			f := r.fc(row, col)
+			var sum [3]int
+			for y := -1; y <= 1; y++ {
+				for x := -1; x <= 1; x++ {
+					color := r.fc(row+y, col+x)
+					if color == f {
+						continue
+					}
+					var shift uint
+					if y == 0 {
+						shift++
+					}
+					if x == 0 {
+						shift++
+					}
+					code.terms = append(code.terms, linTerm{offset: r.width*y + x, shift: shift, color: color})
+					sum[color] += 1 << shift
+				}
+			}
+			for c := range sum {
+				if c != f && sum[c] > 0 {
+					code.factor[c] = 256 / sum[c]
+				}
+			}
+		}
+	}
+	common.ParallelRows(r.height-2, common.WorkersCount(), func(start, end int) {
+		for row := start + 1; row < end+1; row++ {
+			for col := 1; col < r.width-1; col++ {
+				i := row*r.width + col
+				code := &codes[(row%r.cfa.Height)*r.cfa.Width+col%r.cfa.Width]
+				var sum [3]int
+				for _, t := range code.terms {
+					sum[t.color] += int(r.pix[i+t.offset][t.color]) << t.shift
+				}
+				for c, factor := range code.factor {
+					if factor > 0 {
+						r.pix[i][c] = uint16(sum[c] * factor >> 8)
+					}
+				}
+			}
+		}
+	})
+}
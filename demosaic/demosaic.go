@@ -0,0 +1,191 @@
+// Package demosaic interpolates the colors missing at each photosite of the
+// raw images of the CFA sensors, with the algorithms of dcraw
+package demosaic
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/enricod/rawmgr/colors"
+	"github.com/enricod/rawmgr/common"
+	"github.com/enricod/rawmgr/fuji"
+)
+
+// Method interpolation algorithm, as the quality of dcraw -q
+type Method int
+
+// Methods from the fastest to the best quality
+const (
+	Bilinear Method = iota
+	VNG
+	PPG
+	AHD
+)
+
+var methodNames = []string{"bilinear", "VNG", "PPG", "AHD"}
+
+func (m Method) String() string {
+	if m < 0 || int(m) >= len(methodNames) {
+		return fmt.Sprintf("Method(%d)", int(m))
+	}
+	return methodNames[m]
+}
+
+// Demosaic interpolates the image of a CFA sensor with method, on the
+// pattern of the image. The X-Trans images use the Markesteijn algorithm for
+// PPG (1 pass) and AHD (3 passes), as dcraw. Returns a RGB image of 16 bits
+// samples with the levels of img
+func Demosaic(img *common.RawImage, method Method) (*common.RawImage, error) {
+	cfa := img.CFA
+	if img.PixelSize() != 1 || cfa.Width <= 0 || cfa.Height <= 0 || len(cfa.Pattern) != cfa.Width*cfa.Height {
+		return nil, errors.New("demosaic: not the image of a CFA sensor")
+	}
+	for _, c := range cfa.Pattern {
+		if c > common.Blue {
+			return nil, fmt.Errorf("demosaic: color %d of the pattern not valid", c)
+		}
+	}
+	xtrans := cfa.Width == 6 && cfa.Height == 6
+	r := newRaster(img)
+	switch method {
+	case Bilinear:
+		r.bilinear()
+	case VNG:
+		r.bilinear()
+		r.vng()
+	case PPG, AHD:
+		if xtrans {
+			passes := 1
+			if method == AHD {
+				passes = 3
+			}
+			return fuji.XtransInterpolate(img, passes)
+		}
+		if !isBayer(cfa) {
+			return nil, fmt.Errorf("demosaic: %v needs a Bayer pattern, not %v", method, cfa)
+		}
+		if method == PPG {
+			r.ppg()
+		} else {
+			r.ahd(img.WhiteLevel, colors.ImageToRGB(img))
+		}
+	default:
+		return nil, fmt.Errorf("demosaic: unknown method %d", int(method))
+	}
+	out := *img
+	out.Colors = 3
+	out.CFA = common.CFA{}
+	out.Pix = make([]uint16, 3*len(r.pix))
+	for i, p := range r.pix {
+		copy(out.Pix[3*i:], p[:])
+	}
+	return &out, nil
+}
+
+// isBayer reports whether the pattern is of 2x2 photosites with the greens on
+// a diagonal
+func isBayer(cfa common.CFA) bool {
+	if cfa.Width != 2 || cfa.Height != 2 {
+		return false
+	}
+	var count [3]int
+	for _, c := range cfa.Pattern {
+		if int(c) >= len(count) {
+			return false
+		}
+		count[c]++
+	}
+	return count == [3]int{1, 2, 1} && (cfa.Color(0, 0) == cfa.Color(1, 1) || cfa.Color(0, 1) == cfa.Color(1, 0))
+}
+
+// raster the colors of the pixels: the sample at the color of the
+// photosite, the others interpolated
+type raster struct {
+	width  int
+	height int
+	cfa    common.CFA
+	pix    [][3]uint16
+}
+
+func newRaster(img *common.RawImage) *raster {
+	r := &raster{
+		width:  img.Width,
+		height: img.Height,
+		cfa:    img.CFA,
+		pix:    make([][3]uint16, img.Width*img.Height),
+	}
+	common.ParallelRows(r.height, common.WorkersCount(), func(start, end int) {
+		for row := start; row < end; row++ {
+			for col := 0; col < r.width; col++ {
+				i := row*r.width + col
+				r.pix[i][r.fc(row, col)] = img.Pix[i]
+			}
+		}
+	})
+	return r
+}
+
+// fc returns the color of the photosite at row, col
+func (r *raster) fc(row, col int) int {
+	return int(r.cfa.Color(row, col))
+}
+
+// borderInterpolate sets the colors of the pixels in the border to the mean
+// of the samples of each color around them, as dcraw border_interpolate
+func (r *raster) borderInterpolate(border int) {
+	common.ParallelRows(r.height, common.WorkersCount(), func(start, end int) {
+		for row := start; row < end; row++ {
+			for col := 0; col < r.width; col++ {
+				if col == border && row >= border && row < r.height-border {
+					col = r.width - border
+				}
+				var sum, count [3]int
+				for y := row - 1; y <= row+1; y++ {
+					for x := col - 1; x <= col+1; x++ {
+						if y >= 0 && y < r.height && x >= 0 && x < r.width {
+							f := r.fc(y, x)
+							sum[f] += int(r.pix[y*r.width+x][f])
+							count[f]++
+						}
+					}
+				}
+				f := r.fc(row, col)
+				for c := range sum {
+					if c != f && count[c] > 0 {
+						r.pix[row*r.width+col][c] = uint16(sum[c] / count[c])
+					}
+				}
+			}
+		}
+	})
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// limit returns v in [min, max], min if min > max
+func limit(v, min, max int) int {
+	if v > max {
+		v = max
+	}
+	if v < min {
+		v = min
+	}
+	return v
+}
+
+// ulimit returns v between a and b, in any order
+func ulimit(v, a, b int) int {
+	if a < b {
+		return limit(v, a, b)
+	}
+	return limit(v, b, a)
+}
+
+func clip16(v int) uint16 {
+	return uint16(limit(v, 0, 0xffff))
+}
@@ -0,0 +1,105 @@
+package demosaic
+
+import (
+	"testing"
+
+	"github.com/enricod/rawmgr/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testImage mosaic of a scene of smooth colors with the pattern, returning
+// the colors
+func testImage(width, height int, cfa common.CFA) (*common.RawImage, []uint16) {
+	img := &common.RawImage{Width: width, Height: height, Pix: make([]uint16, width*height),
+		CFA: cfa, Colors: 1, WhiteLevel: 0x3fff}
+	rgb := make([]uint16, width*height*3)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			rgb[i*3] = uint16(2000 + 5*x)
+			rgb[i*3+1] = uint16(4000 + 20*y)
+			rgb[i*3+2] = uint16(3000 + 2*x + 10*y)
+			img.Pix[i] = rgb[i*3+int(cfa.Color(y, x))]
+		}
+	}
+	return img, rgb
+}
+
+func TestMethodString(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("AHD", AHD.String())
+	assert.Equal("Method(7)", Method(7).String())
+}
+
+func TestDemosaic(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// the tiles of AHD overlap at the column 508
+	for _, cfa := range []common.CFA{common.CFARGGB, common.CFARGGB.Offset(1, 0),
+		common.CFARGGB.Offset(0, 1), common.CFARGGB.Offset(1, 1)} {
+		img, rgb := testImage(600, 40, cfa)
+		for method := Bilinear; method <= AHD; method++ {
+			out, err := Demosaic(img, method)
+			require.Nil(err)
+			assert.Equal(3, out.Colors)
+			assert.Equal(uint16(0x3fff), out.WhiteLevel)
+			require.Len(out.Pix, len(rgb))
+			far := 0
+			for y := 1; y < img.Height-1; y++ {
+				for i := (y*img.Width + 1) * 3; i < ((y+1)*img.Width-1)*3; i++ {
+					if d := int(out.Pix[i]) - int(rgb[i]); d > 40 || d < -40 {
+						far++
+					}
+				}
+			}
+			assert.Zero(far, "%v %v", method, cfa)
+			// the samples are kept
+			for i, v := range img.Pix[img.Width*20 : img.Width*21] {
+				i += img.Width * 20
+				assert.Equal(v, out.Pix[i*3+int(cfa.Color(20, i%img.Width))])
+			}
+		}
+	}
+}
+
+func TestDemosaicPattern(t *testing.T) {
+	assert := assert.New(t)
+
+	// X-Trans
+	cfa := common.CFA{Width: 6, Height: 6, Pattern: []uint8{
+		1, 1, 0, 1, 1, 2,
+		1, 1, 2, 1, 1, 0,
+		2, 0, 1, 0, 2, 1,
+		1, 1, 2, 1, 1, 0,
+		1, 1, 0, 1, 1, 2,
+		0, 2, 1, 2, 0, 1,
+	}}
+	img, _ := testImage(60, 60, cfa)
+	for method := Bilinear; method <= AHD; method++ {
+		out, err := Demosaic(img, method)
+		assert.Nil(err)
+		assert.Len(out.Pix, 60*60*3)
+	}
+
+	// not a Bayer pattern
+	img, _ = testImage(60, 60, common.CFA{Width: 2, Height: 2, Pattern: []uint8{0, 1, 1, 0}})
+	_, err := Demosaic(img, Bilinear)
+	assert.Nil(err)
+	_, err = Demosaic(img, PPG)
+	assert.NotNil(err)
+
+	// a color that is not red, green or blue
+	img.CFA = common.CFA{Width: 2, Height: 2, Pattern: []uint8{0, 1, 1, 3}}
+	_, err = Demosaic(img, Bilinear)
+	assert.NotNil(err)
+
+	// not a CFA image
+	img.Colors, img.CFA = 3, common.CFA{}
+	_, err = Demosaic(img, Bilinear)
+	assert.NotNil(err)
+	_, err = Demosaic(img, Method(4))
+	assert.NotNil(err)
+}
@@ -0,0 +1,98 @@
+package demosaic
+
+import (
+	"github.com/enricod/rawmgr/common"
+)
+
+// ppg interpolates with the patterned pixel grouping, as dcraw
+// ppg_interpolate: green first along the direction of the smallest
+// gradient, then red and blue from the color differences
+func (r *raster) ppg() {
+	r.borderInterpolate(3)
+	dir := [5]int{1, r.width, -1, -r.width, 1}
+	workers := common.WorkersCount()
+
+	// fill in the green layer with gradients and pattern recognition
+	common.ParallelRows(r.height-6, workers, func(start, end int) {
+		var diff, guess [2]int
+		for row := start + 3; row < end+3; row++ {
+			col := 3
+			if r.fc(row, col) == common.Green {
+				col++
+			}
+			c := r.fc(row, col)
+			for ; col < r.width-3; col += 2 {
+				i := row*r.width + col
+				pix := r.pix
+				for k := 0; k < 2; k++ {
+					d := dir[k]
+					guess[k] = (int(pix[i-d][1])+int(pix[i][c])+int(pix[i+d][1]))*2 -
+						int(pix[i-2*d][c]) - int(pix[i+2*d][c])
+					diff[k] = (abs(int(pix[i-2*d][c])-int(pix[i][c]))+
+						abs(int(pix[i+2*d][c])-int(pix[i][c]))+
+						abs(int(pix[i-d][1])-int(pix[i+d][1])))*3 +
+						(abs(int(pix[i+3*d][1])-int(pix[i+d][1]))+
+							abs(int(pix[i-3*d][1])-int(pix[i-d][1])))*2
+				}
+				k := 0
+				if diff[0] > diff[1] {
+					k = 1
+				}
+				d := dir[k]
+				pix[i][1] = uint16(ulimit(guess[k]>>2, int(pix[i+d][1]), int(pix[i-d][1])))
+			}
+		}
+	})
+
+	// calculate red and blue for each green pixel
+	common.ParallelRows(r.height-2, workers, func(start, end int) {
+		for row := start + 1; row < end+1; row++ {
+			col := 1
+			if r.fc(row, col) != common.Green {
+				col++
+			}
+			for ; col < r.width-1; col += 2 {
+				i := row*r.width + col
+				pix := r.pix
+				c := r.fc(row, col+1)
+				for k := 0; k < 2; k, c = k+1, 2-c {
+					d := dir[k]
+					pix[i][c] = clip16((int(pix[i-d][c]) + int(pix[i+d][c]) + 2*int(pix[i][1]) -
+						int(pix[i-d][1]) - int(pix[i+d][1])) >> 1)
+				}
+			}
+		}
+	})
+
+	// calculate blue for red pixels and vice versa
+	common.ParallelRows(r.height-2, workers, func(start, end int) {
+		var diff, guess [2]int
+		for row := start + 1; row < end+1; row++ {
+			col := 1
+			if r.fc(row, col) == common.Green {
+				col++
+			}
+			c := 2 - r.fc(row, col)
+			for ; col < r.width-1; col += 2 {
+				i := row*r.width + col
+				pix := r.pix
+				for k := 0; k < 2; k++ {
+					d := dir[k] + dir[k+1]
+					diff[k] = abs(int(pix[i-d][c])-int(pix[i+d][c])) +
+						abs(int(pix[i-d][1])-int(pix[i][1])) +
+						abs(int(pix[i+d][1])-int(pix[i][1]))
+					guess[k] = int(pix[i-d][c]) + int(pix[i+d][c]) + 2*int(pix[i][1]) -
+						int(pix[i-d][1]) - int(pix[i+d][1])
+				}
+				switch {
+				case diff[0] > diff[1]:
+					pix[i][c] = clip16(guess[1] >> 1)
+				case diff[0] < diff[1]:
+					pix[i][c] = clip16(guess[0] >> 1)
+				default:
+					pix[i][c] = clip16((guess[0] + guess[1]) >> 2)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package demosaic
+
+import (
+	"github.com/enricod/rawmgr/common"
+)
+
+// vngTerms y1, x1, y2, x2, weight and gradients of the pairs of photosites
+// compared, the gradients numbered clockwise from NW=0 to W=7 (dcraw)
+var vngTerms = [64][6]int{
+	{-2, -2, +0, -1, 0, 0x01}, {-2, -2, +0, +0, 1, 0x01}, {-2, -1, -1, +0, 0, 0x01},
+	{-2, -1, +0, -1, 0, 0x02}, {-2, -1, +0, +0, 0, 0x03}, {-2, -1, +0, +1, 1, 0x01},
+	{-2, +0, +0, -1, 0, 0x06}, {-2, +0, +0, +0, 1, 0x02}, {-2, +0, +0, +1, 0, 0x03},
+	{-2, +1, -1, +0, 0, 0x04}, {-2, +1, +0, -1, 1, 0x04}, {-2, +1, +0, +0, 0, 0x06},
+	{-2, +1, +0, +1, 0, 0x02}, {-2, +2, +0, +0, 1, 0x04}, {-2, +2, +0, +1, 0, 0x04},
+	{-1, -2, -1, +0, 0, 0x80}, {-1, -2, +0, -1, 0, 0x01}, {-1, -2, +1, -1, 0, 0x01},
+	{-1, -2, +1, +0, 1, 0x01}, {-1, -1, -1, +1, 0, 0x88}, {-1, -1, +1, -2, 0, 0x40},
+	{-1, -1, +1, -1, 0, 0x22}, {-1, -1, +1, +0, 0, 0x33}, {-1, -1, +1, +1, 1, 0x11},
+	{-1, +0, -1, +2, 0, 0x08}, {-1, +0, +0, -1, 0, 0x44}, {-1, +0, +0, +1, 0, 0x11},
+	{-1, +0, +1, -2, 1, 0x40}, {-1, +0, +1, -1, 0, 0x66}, {-1, +0, +1, +0, 1, 0x22},
+	{-1, +0, +1, +1, 0, 0x33}, {-1, +0, +1, +2, 1, 0x10}, {-1, +1, +1, -1, 1, 0x44},
+	{-1, +1, +1, +0, 0, 0x66}, {-1, +1, +1, +1, 0, 0x22}, {-1, +1, +1, +2, 0, 0x10},
+	{-1, +2, +0, +1, 0, 0x04}, {-1, +2, +1, +0, 1, 0x04}, {-1, +2, +1, +1, 0, 0x04},
+	{+0, -2, +0, +0, 1, 0x80}, {+0, -1, +0, +1, 1, 0x88}, {+0, -1, +1, -2, 0, 0x40},
+	{+0, -1, +1, +0, 0, 0x11}, {+0, -1, +2, -2, 0, 0x40}, {+0, -1, +2, -1, 0, 0x20},
+	{+0, -1, +2, +0, 0, 0x30}, {+0, -1, +2, +1, 1, 0x10}, {+0, +0, +0, +2, 1, 0x08},
+	{+0, +0, +2, -2, 1, 0x40}, {+0, +0, +2, -1, 0, 0x60}, {+0, +0, +2, +0, 1, 0x20},
+	{+0, +0, +2, +1, 0, 0x30}, {+0, +0, +2, +2, 1, 0x10}, {+0, +1, +1, +0, 0, 0x44},
+	{+0, +1, +1, +2, 0, 0x10}, {+0, +1, +2, -1, 1, 0x40}, {+0, +1, +2, +0, 0, 0x60},
+	{+0, +1, +2, +1, 0, 0x20}, {+0, +1, +2, +2, 0, 0x10}, {+1, -2, +1, +0, 0, 0x80},
+	{+1, -1, +1, +1, 0, 0x88}, {+1, +0, +1, +2, 0, 0x08}, {+1, +0, +2, -1, 0, 0x40},
+	{+1, +0, +2, +1, 0, 0x10},
+}
+
+// vngNeighbours y, x of the neighbours in the directions of the gradients
+var vngNeighbours = [8][2]int{{-1, -1}, {-1, 0}, {-1, +1}, {0, +1}, {+1, +1}, {+1, 0}, {+1, -1}, {0, -1}}
+
+// vngTerm a pair of photosites of color, at the offsets in pixels, with the
+// gradients their difference adds to
+type vngTerm struct {
+	offset1 int
+	offset2 int
+	color   int
+	shift   uint
+	grads   []int
+}
+
+// vngNeighbour a neighbour, with the photosite of the color of the pixel
+// beyond it if the neighbour has another color
+type vngNeighbour struct {
+	offset int
+	beyond bool
+}
+
+type vngCode struct {
+	terms      []vngTerm
+	neighbours [8]vngNeighbour
+}
+
+// vng interpolates each pixel from the neighbours in the directions of the
+// smallest gradients, as dcraw vng_interpolate. The image is interpolated
+// bilinear before
+func (r *raster) vng() {
+	codes := make([]vngCode, r.cfa.Width*r.cfa.Height)
+	for row := 0; row < r.cfa.Height; row++ {
+		for col := 0; col < r.cfa.Width; col++ {
+			code := &codes[row*r.cfa.Width+col]
+			for _, t := range vngTerms {
+				y1, x1, y2, x2 := t[0], t[1], t[2], t[3]
+				color := r.fc(row+y1, col+x1)
+				if r.fc(row+y2, col+x2) != color {
+					continue
+				}
+				diag := 1
+				if r.fc(row, col+1) == color && r.fc(row+1, col) == color {
+					diag = 2
+				}
+				if abs(y1-y2) == diag && abs(x1-x2) == diag {
+					continue
+				}
+				term := vngTerm{offset1: y1*r.width + x1, offset2: y2*r.width + x2, color: color, shift: uint(t[4])}
+				for g := 0; g < 8; g++ {
+					if t[5]&(1<<uint(g)) != 0 {
+						term.grads = append(term.grads, g)
+					}
+				}
+				code.terms = append(code.terms, term)
+			}
+			color := r.fc(row, col)
+			for g, n := range vngNeighbours {
+				y, x := n[0], n[1]
+				code.neighbours[g] = vngNeighbour{
+					offset: y*r.width + x,
+					beyond: r.fc(row+y, col+x) != color && r.fc(row+y*2, col+x*2) == color,
+				}
+			}
+		}
+	}
+
+	out := make([][3]uint16, len(r.pix))
+	copy(out, r.pix)
+	common.ParallelRows(r.height-4, common.WorkersCount(), func(start, end int) {
+		for row := start + 2; row < end+2; row++ {
+			for col := 2; col < r.width-2; col++ {
+				i := row*r.width + col
+				pix := &r.pix[i]
+				code := &codes[(row%r.cfa.Height)*r.cfa.Width+col%r.cfa.Width]
+				// calculate the gradients
+				var gval [8]int
+				for _, t := range code.terms {
+					diff := abs(int(r.pix[i+t.offset1][t.color])-int(r.pix[i+t.offset2][t.color])) << t.shift
+					for _, g := range t.grads {
+						gval[g] += diff
+					}
+				}
+				// choose a threshold
+				gmin, gmax := gval[0], gval[0]
+				for _, v := range gval[1:] {
+					if gmin > v {
+						gmin = v
+					}
+					if gmax < v {
+						gmax = v
+					}
+				}
+				if gmax == 0 {
+					continue
+				}
+				thold := gmin + gmax>>1
+				// average the neighbours
+				var sum [3]int
+				color := r.fc(row, col)
+				num := 0
+				for g, n := range code.neighbours {
+					if gval[g] > thold {
+						continue
+					}
+					for c := range sum {
+						if c == color && n.beyond {
+							sum[c] += (int(pix[c]) + int(r.pix[i+2*n.offset][c])) >> 1
+						} else {
+							sum[c] += int(r.pix[i+n.offset][c])
+						}
+					}
+					num++
+				}
+				for c := range sum {
+					t := int(pix[color])
+					if c != color {
+						t += (sum[c] - sum[color]) / num
+					}
+					out[i][c] = clip16(t)
+				}
+			}
+		}
+	})
+	r.pix = out
+}
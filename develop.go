@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/enricod/rawmgr/colors"
+	"github.com/enricod/rawmgr/common"
+	"github.com/enricod/rawmgr/demosaic"
+	"github.com/enricod/rawmgr/develop"
+	"github.com/enricod/rawmgr/raw"
+)
+
+var spaces = map[string]colors.Space{
+	"srgb":     colors.SRGB,
+	"adobe":    colors.AdobeRGB,
+	"prophoto": colors.ProPhoto,
+	"xyz":      colors.XYZ,
+}
+
+// parseFloats parses n comma separated numbers
+func parseFloats(s string, n int) ([]float64, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != n {
+		return nil, fmt.Errorf("%q is not %d numbers", s, n)
+	}
+	v := make([]float64, n)
+	for i, f := range fields {
+		var err error
+		if v[i], err = strconv.ParseFloat(strings.TrimSpace(f), 64); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// formats the extensions of the output files for each format
+var formats = map[string]string{
+	".pgm":  "pnm",
+	".ppm":  "pnm",
+	".pnm":  "pnm",
+	".png":  "png",
+	".tif":  "tiff",
+	".tiff": "tiff",
+	".jpg":  "jpeg",
+	".jpeg": "jpeg",
+}
+
+// writeImage writes img in the format: the TIFF has the ICC profile, if
+// any, and the EXIF of the raw file
+func writeImage(w io.Writer, img image.Image, format string, quality int, profile []byte, metadata common.Metadata) error {
+	switch format {
+	case "pnm":
+		return common.EncodePNM(w, img)
+	case "png":
+		return png.Encode(w, img)
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case "tiff":
+		ifd0, exif := metadata.TiffEntries()
+		ifd0 = append(ifd0, common.IfdEntry{Tag: common.TagSoftware, Type: common.TypeASCII, Value: "rawmgr"})
+		if profile != nil {
+			ifd0 = append(ifd0, common.IfdEntry{Tag: common.TagICCProfile, Type: common.TypeUndefined, Value: profile})
+		}
+		if len(exif) > 0 {
+			ifd0 = append(ifd0, common.IfdEntry{Tag: common.TagExifIFD, Type: common.TypeLong,
+				Sub: []*common.Ifd{{Entries: exif}}})
+		}
+		return common.EncodeTiff(w, img, ifd0)
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+// developCommand develops the raw file in args to a PNG, TIFF, JPEG or
+// PGM/PPM image, with the options of the pipeline. -D, -d, -4 and -T write
+// the files of dcraw
+func developCommand(args []string) error {
+	flags := flag.NewFlagSet("develop", flag.ExitOnError)
+	common.Verbose = flags.Bool("v", false, "verbose")
+	common.Workers = flags.Int("workers", 0, "number of goroutines, 0 for one for each CPU")
+	auto := flags.Bool("a", false, "gray world white balance")
+	mul := flags.String("r", "", "custom white balance multipliers r,g,b")
+	method := flags.Int("q", int(demosaic.AHD), "demosaic quality: 0 bilinear, 1 VNG, 2 PPG, 3 AHD")
+	highlights := flags.Int("H", int(develop.Clip), "highlights: 0 clip, 1 unclip, 2 blend")
+	space := flags.String("o", "srgb", "output colors: srgb, adobe, prophoto or xyz")
+	gamma := flags.String("g", "0.45,4.5", "gamma curve power,toe slope; 1,1 for linear")
+	bright := flags.Float64("b", 1, "brightness")
+	noAuto := flags.Bool("W", false, "do not brighten the image automatically")
+	bits := flags.Int("bits", 0, "bits of the output samples, 8 or 16; 0 for 16 in PNG and TIFF, 8 in JPEG and PGM/PPM")
+	output := flags.String("out", "", "output file, the raw file with the extension of the format by default")
+	format := flags.String("f", "", "output format: png, tiff, jpeg or pnm; by the extension of the output file, png by default")
+	quality := flags.Int("quality", 90, "JPEG quality, 1 to 100")
+	raw16 := flags.Bool("D", false, "the samples of the sensor as they are, as a PGM")
+	document := flags.Bool("d", false, "the samples of the sensor scaled and white balanced, as a PGM")
+	linear := flags.Bool("4", false, "linear 16 bits, as a PGM or PPM")
+	tiff := flags.Bool("T", false, "write a TIFF")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: rawmgr develop [options] file")
+	}
+	rawfile := flags.Arg(0)
+
+	p := develop.NewPipeline()
+	if *auto {
+		p.WhiteBalance = develop.GrayWorld
+	}
+	if *mul != "" {
+		m, err := parseFloats(*mul, 3)
+		if err != nil {
+			return err
+		}
+		p.WhiteBalance = develop.Custom
+		copy(p.Multipliers[:], m)
+	}
+	p.Demosaic = demosaic.Method(*method)
+	p.Highlights = develop.Highlights(*highlights)
+	s, ok := spaces[strings.ToLower(*space)]
+	if !ok {
+		return fmt.Errorf("unknown output colors %q", *space)
+	}
+	p.Space = s
+	g, err := parseFloats(*gamma, 2)
+	if err != nil {
+		return err
+	}
+	copy(p.Gamma[:], g)
+	p.Brightness = *bright
+	p.AutoBright = !*noAuto
+	if *linear {
+		p.Gamma, p.AutoBright, *bits = develop.GammaLinear, false, 16
+	}
+	if *tiff {
+		*format = "tiff"
+	}
+	if *format == "" {
+		if *format = formats[strings.ToLower(filepath.Ext(*output))]; *format == "" {
+			*format = "png"
+			if *raw16 || *document || *linear {
+				*format = "pnm"
+			}
+		}
+	}
+	p.Bits = *bits
+	if p.Bits == 0 {
+		p.Bits = 16
+		if *format == "jpeg" || *format == "pnm" {
+			p.Bits = 8
+		}
+	}
+	if *format == "jpeg" && p.Bits != 8 {
+		return errors.New("the JPEG images have 8 bits")
+	}
+
+	data, err := ioutil.ReadFile(rawfile)
+	if err != nil {
+		return err
+	}
+	img, err := raw.Decode(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	if *common.Verbose {
+		log.Printf("developing %s %s, %v white balance, %v, %v highlights, %v",
+			img.Metadata.Make, img.Metadata.Model, p.WhiteBalance, p.Demosaic, p.Highlights, p.Space)
+	}
+	var out image.Image
+	var profile []byte
+	if *raw16 || *document {
+		out, err = p.Document(img, *document)
+	} else {
+		out, err = p.Develop(img)
+		profile = p.ICCProfile()
+	}
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		ext := *format
+		if ext == "pnm" {
+			switch out.(type) {
+			case *image.Gray, *image.Gray16:
+				ext = "pgm"
+			default:
+				ext = "ppm"
+			}
+		}
+		*output = rawfile + "." + ext
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := writeImage(f, out, *format, *quality, profile, img.Metadata); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,172 @@
+package develop
+
+import (
+	"image"
+	"math"
+	"sync"
+
+	"github.com/enricod/rawmgr/common"
+)
+
+// gammaParams returns the parameters of the curve with the power pwr and
+// the linear toe of slope ts, as dcraw gamma_curve: g[2] and g[3] the ends
+// of the toe, g[4] the offset of the power and g[5] the mean gamma
+func gammaParams(pwr, ts float64) [6]float64 {
+	var g [6]float64
+	var bnd [2]float64
+	g[0], g[1] = pwr, ts
+	if g[1] >= 1 {
+		bnd[1] = 1
+	} else {
+		bnd[0] = 1
+	}
+	if g[1] != 0 && (g[1]-1)*(g[0]-1) <= 0 {
+		for i := 0; i < 48; i++ {
+			g[2] = (bnd[0] + bnd[1]) / 2
+			var up bool
+			if g[0] != 0 {
+				up = (math.Pow(g[2]/g[1], -g[0])-1)/g[0]-1/g[2] > -1
+			} else {
+				up = g[2]/math.Exp(1-1/g[2]) < g[1]
+			}
+			if up {
+				bnd[1] = g[2]
+			} else {
+				bnd[0] = g[2]
+			}
+		}
+		g[3] = g[2] / g[1]
+		if g[0] != 0 {
+			g[4] = g[2] * (1/g[0] - 1)
+		}
+	}
+	if g[0] != 0 {
+		g[5] = 1/(g[1]*g[3]*g[3]/2-g[4]*(1-g[3])+(1-math.Pow(g[3], 1+g[0]))*(1+g[4])/(1+g[0])) - 1
+	} else {
+		g[5] = 1/(g[1]*g[3]*g[3]/2+1-g[2]-g[3]-g[2]*g[3]*(math.Log(g[3])-1)) - 1
+	}
+	return g
+}
+
+// gammaCurve returns the curve of 0x10000 values that maps [0, imax] to
+// [0, 0xffff] with the power pwr and the linear toe of slope ts
+func gammaCurve(pwr, ts float64, imax int) []uint16 {
+	g := gammaParams(pwr, ts)
+	curve := make([]uint16, 0x10000)
+	for i := range curve {
+		curve[i] = 0xffff
+		r := float64(i) / float64(imax)
+		if r >= 1 {
+			continue
+		}
+		var v float64
+		switch {
+		case r < g[3]:
+			v = r * g[1]
+		case g[0] != 0:
+			v = math.Pow(r, g[0])*(1+g[4]) - g[4]
+		default:
+			v = math.Log(r)*g[2] + 1
+		}
+		if v = 0x10000 * v; v < 0xffff {
+			curve[i] = uint16(v)
+		}
+	}
+	return curve
+}
+
+// whitePoint returns the level of the 99th percentile of the brightest
+// color, as dcraw write_ppm_tiff
+func whitePoint(img *common.RawImage) int {
+	size := img.PixelSize()
+	histogram := make([][0x2000]int, size)
+	var mutex sync.Mutex
+	common.ParallelRows(img.Height, common.WorkersCount(), func(start, end int) {
+		h := make([][0x2000]int, size)
+		pix := img.Pix[start*img.Width*size : end*img.Width*size]
+		for i, v := range pix {
+			h[i%size][v>>3]++
+		}
+		mutex.Lock()
+		for c := range h {
+			for i, n := range h[c] {
+				histogram[c][i] += n
+			}
+		}
+		mutex.Unlock()
+	})
+	perc := img.Width * img.Height / 100
+	white := 0
+	for c := range histogram {
+		val, total := 0x2000, 0
+		for val--; val > 32; val-- {
+			if total += histogram[c][val]; total > perc {
+				break
+			}
+		}
+		if white < val {
+			white = val
+		}
+	}
+	return white
+}
+
+// output applies the curve to the linear image img, returning the image of
+// the bits of the pipeline: gray for the samples of a CFA, RGB otherwise
+func (p *Pipeline) output(img *common.RawImage) image.Image {
+	white := 0x2000
+	if p.AutoBright && p.Highlights != Unclip {
+		white = whitePoint(img)
+	}
+	imax := int(float64(white<<3) / p.Brightness)
+	if imax < 1 {
+		imax = 1
+	}
+	curve := gammaCurve(p.Gamma[0], p.Gamma[1], imax)
+	rect := image.Rect(0, 0, img.Width, img.Height)
+	size := img.PixelSize()
+	// dst the pixels, src the samples of each pixel of the image, and the
+	// bytes of each sample
+	var dst []uint8
+	var out image.Image
+	var dstSize int
+	switch {
+	case size == 1 && p.Bits == 8:
+		m := image.NewGray(rect)
+		dst, out, dstSize = m.Pix, m, 1
+	case size == 1:
+		m := image.NewGray16(rect)
+		dst, out, dstSize = m.Pix, m, 2
+	case p.Bits == 8:
+		m := image.NewNRGBA(rect)
+		dst, out, dstSize = m.Pix, m, 4
+	default:
+		m := image.NewNRGBA64(rect)
+		dst, out, dstSize = m.Pix, m, 8
+	}
+	common.ParallelRows(img.Height, common.WorkersCount(), func(start, end int) {
+		for i := start * img.Width; i < end*img.Width; i++ {
+			d := dst[i*dstSize : (i+1)*dstSize]
+			for c := 0; c < size; c++ {
+				v := curve[img.Pix[i*size+c]]
+				if p.Bits == 8 {
+					d[c] = uint8(v >> 8)
+				} else {
+					d[2*c], d[2*c+1] = uint8(v>>8), uint8(v)
+				}
+			}
+			if size == 3 {
+				for j := 3 * p.Bits / 8; j < dstSize; j++ {
+					d[j] = 0xff
+				}
+			}
+		}
+	})
+	return out
+}
+
+// ICCProfile returns the ICC profile of the images of the pipeline
+func (p *Pipeline) ICCProfile() []byte {
+	g := gammaParams(p.Gamma[0], p.Gamma[1])
+	return p.Space.ICCProfile(1 / g[5])
+}
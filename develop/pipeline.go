@@ -0,0 +1,183 @@
+// Package develop turns the raw images into RGB images, with the stages of
+// dcraw: black subtraction, scaling to the white level, white balance,
+// demosaic, color matrix, highlights and output curve
+package develop
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/enricod/rawmgr/colors"
+	"github.com/enricod/rawmgr/common"
+	"github.com/enricod/rawmgr/demosaic"
+)
+
+// WhiteBalance source of the white balance multipliers
+type WhiteBalance int
+
+// White balances
+const (
+	// AsShot the multipliers of the camera, daylight if the file does not
+	// have them
+	AsShot WhiteBalance = iota
+	// GrayWorld the multipliers that make the mean of the image gray, as
+	// dcraw -a
+	GrayWorld
+	// Custom the Multipliers of the pipeline
+	Custom
+)
+
+var whiteBalanceNames = []string{"as shot", "gray world", "custom"}
+
+func (w WhiteBalance) String() string {
+	if w < 0 || int(w) >= len(whiteBalanceNames) {
+		return fmt.Sprintf("WhiteBalance(%d)", int(w))
+	}
+	return whiteBalanceNames[w]
+}
+
+// Highlights handling of the saturated photosites, as dcraw -H
+type Highlights int
+
+// Highlights modes
+const (
+	// Clip the saturated colors to white
+	Clip Highlights = iota
+	// Unclip leaves the colors as scaled, keeping the details of the
+	// channels not saturated
+	Unclip
+	// Blend the clipped and unclipped colors
+	Blend
+)
+
+var highlightsNames = []string{"clip", "unclip", "blend"}
+
+func (h Highlights) String() string {
+	if h < 0 || int(h) >= len(highlightsNames) {
+		return fmt.Sprintf("Highlights(%d)", int(h))
+	}
+	return highlightsNames[h]
+}
+
+// Gamma curves, power and slope of the linear toe as dcraw -g
+var (
+	GammaBT709  = [2]float64{0.45, 4.5}
+	GammaSRGB   = [2]float64{1 / 2.4, 12.92}
+	GammaLinear = [2]float64{1, 1}
+)
+
+// Pipeline the settings of the stages that develop a raw image, applied in
+// order
+type Pipeline struct {
+	WhiteBalance WhiteBalance
+	// Multipliers R G B of the Custom white balance
+	Multipliers [3]float64
+	Demosaic    demosaic.Method
+	Highlights  Highlights
+	// Space of the output colors
+	Space colors.Space
+	// Gamma power and slope of the toe of the output curve
+	Gamma [2]float64
+	// Brightness multiplies the white point of the output
+	Brightness float64
+	// AutoBright puts the white point at the 99th percentile of the image,
+	// unless the highlights are unclipped
+	AutoBright bool
+	// Bits of the output samples, 8 or 16
+	Bits int
+}
+
+// NewPipeline returns the pipeline with the defaults of dcraw: as shot
+// white balance, AHD, clipped highlights, sRGB with the BT.709 curve and
+// 8 bits
+func NewPipeline() *Pipeline {
+	return &Pipeline{
+		WhiteBalance: AsShot,
+		Demosaic:     demosaic.AHD,
+		Highlights:   Clip,
+		Space:        colors.SRGB,
+		Gamma:        GammaBT709,
+		Brightness:   1,
+		AutoBright:   true,
+		Bits:         8,
+	}
+}
+
+// check returns an error if the settings are not valid or img is empty
+func (p *Pipeline) check(img *common.RawImage) error {
+	if p.Bits != 8 && p.Bits != 16 {
+		return fmt.Errorf("develop: %d bits output", p.Bits)
+	}
+	if p.Brightness <= 0 {
+		return errors.New("develop: the brightness is not positive")
+	}
+	if img.Width <= 0 || img.Height <= 0 || len(img.Pix) < img.Width*img.Height*img.PixelSize() {
+		return errors.New("develop: empty image")
+	}
+	return nil
+}
+
+// camera returns the colors of the camera of img, nil if not known
+func camera(img *common.RawImage) *colors.Camera {
+	if cam, ok := colors.LookupCamera(img.Metadata.Make, img.Metadata.Model); ok {
+		return cam
+	}
+	return nil
+}
+
+// Develop returns the RGB image of the raw image img, a *image.NRGBA of 8
+// bits or a *image.NRGBA64 of 16 bits. The image is reduced to the active
+// area and img is not modified
+func (p *Pipeline) Develop(img *common.RawImage) (image.Image, error) {
+	if err := p.check(img); err != nil {
+		return nil, err
+	}
+	img = img.Crop()
+	cam := camera(img)
+	black, white := colors.Levels(img, cam)
+	mul, err := p.multipliers(img, cam, black, white)
+	if err != nil {
+		return nil, err
+	}
+	rgb := p.scale(img, black, white, mul)
+	if rgb.PixelSize() == 1 {
+		if rgb, err = demosaic.Demosaic(rgb, p.Demosaic); err != nil {
+			return nil, err
+		}
+	}
+	if p.Highlights == Blend {
+		blendHighlights(rgb, p.normalized(mul))
+	}
+
+	m := p.Space.FromSRGB()
+	if cam != nil && img.PixelSize() == 1 {
+		m = cam.Transform(p.Space)
+	}
+	if err := colors.Convert(rgb, m); err != nil {
+		return nil, err
+	}
+	return p.output(rgb), nil
+}
+
+// Document returns the samples of img as they are, as dcraw -D: gray for a
+// CFA, RGB for the images without one. With scale the black is subtracted,
+// the white balance applied and the white level scaled as in Develop, as
+// dcraw -d. The output curve of the pipeline applies to the samples, the
+// linear curve without the automatic brightness keeps them
+func (p *Pipeline) Document(img *common.RawImage, scale bool) (image.Image, error) {
+	if err := p.check(img); err != nil {
+		return nil, err
+	}
+	img = img.Crop()
+	if scale {
+		cam := camera(img)
+		black, white := colors.Levels(img, cam)
+		mul, err := p.multipliers(img, cam, black, white)
+		if err != nil {
+			return nil, err
+		}
+		img = p.scale(img, black, white, mul)
+	}
+	return p.output(img), nil
+}
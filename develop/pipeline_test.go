@@ -0,0 +1,166 @@
+package develop
+
+import (
+	"image"
+	"testing"
+
+	"github.com/enricod/rawmgr/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testImage RGGB mosaic of gray levels, seen by a camera with the as shot
+// multipliers 2 1 1 1.5, and a saturated square at the center
+func testImage(width, height int) *common.RawImage {
+	img := &common.RawImage{Width: width, Height: height, Pix: make([]uint16, width*height),
+		CFA: common.CFARGGB, BlackLevel: 256, WhiteLevel: 4095,
+		WBMultipliers: [4]float64{2, 1, 1, 1.5}}
+	gain := [3]float64{0.5, 1, 1 / 1.5}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := 256 + int(float64(100+10*x+5*y)*gain[img.CFA.Color(y, x)])
+			if x >= width/2-2 && x < width/2+2 && y >= height/2-2 && y < height/2+2 {
+				v = 4095
+			}
+			img.Pix[y*width+x] = uint16(v)
+		}
+	}
+	return img
+}
+
+func TestGammaCurve(t *testing.T) {
+	assert := assert.New(t)
+
+	curve := gammaCurve(1, 1, 0x1000)
+	assert.Equal(uint16(0), curve[0])
+	assert.Equal(uint16(0x8000), curve[0x800])
+	assert.Equal(uint16(0xffff), curve[0x1000])
+	assert.Equal(uint16(0xffff), curve[0xffff])
+
+	curve = gammaCurve(GammaBT709[0], GammaBT709[1], 0xffff)
+	// the linear toe
+	assert.Equal(uint16(45), curve[10])
+	for i := 1; i < len(curve); i++ {
+		assert.True(curve[i] >= curve[i-1])
+	}
+	assert.InDelta(0x10000*0.7, int(curve[0x8000]), 600)
+}
+
+func TestDevelop(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	img := testImage(64, 48)
+	pix := append([]uint16(nil), img.Pix...)
+	p := NewPipeline()
+	p.Gamma = GammaLinear
+	p.AutoBright = false
+	for _, wb := range []WhiteBalance{AsShot, GrayWorld, Custom} {
+		p.WhiteBalance = wb
+		p.Multipliers = [3]float64{2, 1, 1.5}
+		out, err := p.Develop(img)
+		require.Nil(err)
+		rgba, ok := out.(*image.NRGBA)
+		require.True(ok)
+		assert.Equal(image.Rect(0, 0, 64, 48), rgba.Bounds())
+		// the grays are gray
+		for _, pt := range []image.Point{{10, 10}, {40, 5}, {20, 40}} {
+			c := rgba.NRGBAAt(pt.X, pt.Y)
+			assert.InDelta(int(c.G), int(c.R), 2, "%v %v", wb, pt)
+			assert.InDelta(int(c.G), int(c.B), 2, "%v %v", wb, pt)
+			assert.Equal(uint8(0xff), c.A)
+		}
+		// the saturated square is white
+		assert.Equal([]uint8{0xff, 0xff, 0xff, 0xff}, rgba.Pix[rgba.PixOffset(32, 24):rgba.PixOffset(33, 24)], wb)
+	}
+	assert.Equal(pix, img.Pix)
+
+	// the linear output of 16 bits
+	p.WhiteBalance = AsShot
+	p.Bits = 16
+	out, err := p.Develop(img)
+	require.Nil(err)
+	rgba64, ok := out.(*image.NRGBA64)
+	require.True(ok)
+	c := rgba64.NRGBA64At(10, 10)
+	expected := (100 + 10*10 + 5*10) * 65535 / (4095 - 256)
+	assert.InDelta(expected, int(c.G), 100)
+
+	// the automatic brightness puts the saturated square at the white
+	p.AutoBright = true
+	bright, err := p.Develop(img)
+	require.Nil(err)
+	assert.True(bright.(*image.NRGBA64).NRGBA64At(10, 10).G > c.G)
+
+	p.Bits = 12
+	_, err = p.Develop(img)
+	assert.NotNil(err)
+	p.Bits = 8
+	p.WhiteBalance, p.Multipliers = Custom, [3]float64{1, 0, 1}
+	_, err = p.Develop(img)
+	assert.NotNil(err)
+}
+
+func TestDocument(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	img := testImage(64, 48)
+	img.ActiveArea = image.Rect(2, 2, 62, 46)
+	p := NewPipeline()
+	p.Gamma = GammaLinear
+	p.AutoBright = false
+	p.Bits = 16
+
+	// -D -4: the samples of the active area as they are
+	out, err := p.Document(img, false)
+	require.Nil(err)
+	gray, ok := out.(*image.Gray16)
+	require.True(ok)
+	assert.Equal(image.Rect(0, 0, 60, 44), gray.Bounds())
+	for _, pt := range []image.Point{{0, 0}, {11, 7}, {59, 43}} {
+		assert.Equal(img.Pix[(pt.Y+2)*64+pt.X+2], gray.Gray16At(pt.X, pt.Y).Y, "%v", pt)
+	}
+
+	// -d -4: the black subtracted and the white at the top
+	out, err = p.Document(img, true)
+	require.Nil(err)
+	gray = out.(*image.Gray16)
+	// a green photosite, whose multiplier is 1
+	expected := (int(img.Pix[2*64+3]) - 256) * 0xffff / (4095 - 256)
+	assert.InDelta(expected, int(gray.Gray16At(1, 0).Y), 20)
+	assert.Equal(uint16(0xffff), gray.Gray16At(30, 22).Y)
+
+	// 8 bits with the output curve
+	p.Bits = 8
+	out, err = p.Document(img, false)
+	require.Nil(err)
+	_, ok = out.(*image.Gray)
+	assert.True(ok)
+}
+
+func TestBlendHighlights(t *testing.T) {
+	assert := assert.New(t)
+
+	img := &common.RawImage{Width: 2, Height: 1, Colors: 3, Pix: []uint16{60000, 40000, 30000, 1000, 2000, 3000}}
+	blendHighlights(img, [3]float64{1, 0.5, 0.8})
+	// the lightness is kept, the colors of the pixels below the clip level too
+	assert.InDelta(130000, int(img.Pix[0])+int(img.Pix[1])+int(img.Pix[2]), 3)
+	assert.NotEqual([]uint16{60000, 40000, 30000}, img.Pix[:3])
+	assert.Equal([]uint16{1000, 2000, 3000}, img.Pix[3:])
+}
+
+func TestICCProfile(t *testing.T) {
+	assert := assert.New(t)
+
+	p := NewPipeline()
+	p.Gamma = GammaLinear
+	curve := p.ICCProfile()[252+36+40+20+20:]
+	assert.Equal("curv", string(curve[:4]))
+	assert.Equal([]byte{1, 0}, curve[12:14])
+
+	// the mean gamma of the BT.709 curve, 1.93 as in the profiles of dcraw
+	p.Gamma = GammaBT709
+	curve = p.ICCProfile()[252+36+40+20+20:]
+	assert.InDelta(1.93*256, int(curve[12])<<8|int(curve[13]), 2)
+}
@@ -0,0 +1,218 @@
+package develop
+
+import (
+	"errors"
+	"math"
+	"sync"
+
+	"github.com/enricod/rawmgr/colors"
+	"github.com/enricod/rawmgr/common"
+)
+
+// channel returns the index of the photosite at x, y in R G G B order
+func channel(img *common.RawImage, x, y int) int {
+	if img.CFA.Width == 0 {
+		return 1
+	}
+	return img.Channel(x, y)
+}
+
+// multipliers returns the white balance multipliers R G B of img
+func (p *Pipeline) multipliers(img *common.RawImage, cam *colors.Camera, black [4]int, white int) ([3]float64, error) {
+	var mul [3]float64
+	switch p.WhiteBalance {
+	case AsShot:
+		// the RGB images are balanced by the camera
+		wb := img.WBMultipliers
+		if img.PixelSize() == 3 {
+			mul = [3]float64{1, 1, 1}
+		} else if wb[0] > 0 && wb[3] > 0 {
+			mul = [3]float64{wb[0], (wb[1] + wb[2]) / 2, wb[3]}
+			if mul[1] == 0 {
+				mul[1] = 1
+			}
+		} else if cam != nil {
+			_, mul = cam.CameraToRGB()
+		} else {
+			mul = [3]float64{1, 1, 1}
+		}
+	case GrayWorld:
+		mul = grayWorld(img, black, white)
+	case Custom:
+		mul = p.Multipliers
+	default:
+		return mul, errors.New("develop: unknown white balance")
+	}
+	for _, m := range mul {
+		if !(m > 0) || math.IsInf(m, 0) {
+			return mul, errors.New("develop: the white balance multipliers are not positive")
+		}
+	}
+	return mul, nil
+}
+
+// grayWorld returns the multipliers that make the mean of each color the
+// same, skipping the blocks of 8x8 pixels with saturated samples, as dcraw
+// -a
+func grayWorld(img *common.RawImage, black [4]int, white int) [3]float64 {
+	var sum, count [3]float64
+	var mutex sync.Mutex
+	size := img.PixelSize()
+	blocks := (img.Height + 7) / 8
+	common.ParallelRows(blocks, common.WorkersCount(), func(start, end int) {
+		var s, n [3]float64
+		for row := start * 8; row < end*8 && row < img.Height; row += 8 {
+		block:
+			for col := 0; col < img.Width; col += 8 {
+				var bs, bn [3]float64
+				for y := row; y < row+8 && y < img.Height; y++ {
+					for x := col; x < col+8 && x < img.Width; x++ {
+						for k := 0; k < size; k++ {
+							c, v := k, int(img.Pix[(y*img.Width+x)*size+k])
+							if size == 1 {
+								c = int(img.CFA.Color(y, x))
+							}
+							if v > white-25 {
+								continue block
+							}
+							if v -= black[channel(img, x, y)]; v < 0 {
+								v = 0
+							}
+							bs[c] += float64(v)
+							bn[c]++
+						}
+					}
+				}
+				for c := range s {
+					s[c] += bs[c]
+					n[c] += bn[c]
+				}
+			}
+		}
+		mutex.Lock()
+		for c := range sum {
+			sum[c] += s[c]
+			count[c] += n[c]
+		}
+		mutex.Unlock()
+	})
+	mul := [3]float64{1, 1, 1}
+	for c := range mul {
+		if sum[c] > 0 {
+			mul[c] = count[c] / sum[c]
+		}
+	}
+	return mul
+}
+
+// normalized returns the multipliers divided by the largest one, or by the
+// smallest one when the highlights are clipped
+func (p *Pipeline) normalized(mul [3]float64) [3]float64 {
+	d := mul[0]
+	for _, m := range mul[1:] {
+		if (p.Highlights == Clip && m < d) || (p.Highlights != Clip && m > d) {
+			d = m
+		}
+	}
+	for c := range mul {
+		mul[c] /= d
+	}
+	return mul
+}
+
+// scale returns img with the black subtracted, the white balance applied
+// and the white level scaled to 16 bits, as dcraw scale_colors
+func (p *Pipeline) scale(img *common.RawImage, black [4]int, white int, mul [3]float64) *common.RawImage {
+	mul = p.normalized(mul)
+	var scale [4]float32
+	for c := range scale {
+		scale[c] = float32(65535 / float64(white-black[c]))
+		if white <= black[c] {
+			scale[c] = 1
+		}
+	}
+	out := *img
+	out.Pix = make([]uint16, len(img.Pix))
+	out.BlackLevel, out.WhiteLevel, out.ChannelBlackLevel = 0, 0xffff, [4]uint16{}
+	size := img.PixelSize()
+	common.ParallelRows(img.Height, common.WorkersCount(), func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < img.Width; x++ {
+				ch := channel(img, x, y)
+				for k := 0; k < size; k++ {
+					i := (y*img.Width+x)*size + k
+					v := int(img.Pix[i])
+					if v == 0 {
+						continue
+					}
+					c := k
+					if size == 1 {
+						c = int(img.CFA.Color(y, x))
+					}
+					out.Pix[i] = clip(float32(v-black[ch]) * scale[ch] * float32(mul[c]))
+				}
+			}
+		}
+	})
+	return &out
+}
+
+// blendHighlights blends the colors of the pixels with a channel above the
+// clipping level with their clipped colors, keeping the lightness of the
+// first and the chroma of the second, as dcraw blend_highlights
+func blendHighlights(img *common.RawImage, mul [3]float64) {
+	trans := [3][3]float32{{1, 1, 1}, {1.7320508, -1.7320508, 0}, {-1, -1, 2}}
+	itrans := [3][3]float32{{1, 0.8660254, -0.5}, {1, -0.8660254, -0.5}, {1, 0, 1}}
+	level := math.MaxInt32
+	for _, m := range mul {
+		if l := int(65535 * m); l < level {
+			level = l
+		}
+	}
+	clipLevel := float32(level)
+	common.ParallelRows(img.Height, common.WorkersCount(), func(start, end int) {
+		pix := img.Pix[start*img.Width*3 : end*img.Width*3]
+		for i := 0; i < len(pix); i += 3 {
+			if float32(pix[i]) <= clipLevel && float32(pix[i+1]) <= clipLevel && float32(pix[i+2]) <= clipLevel {
+				continue
+			}
+			var cam, lab [2][3]float32
+			var sum [2]float32
+			for c := 0; c < 3; c++ {
+				cam[0][c] = float32(pix[i+c])
+				cam[1][c] = float32(math.Min(float64(cam[0][c]), float64(clipLevel)))
+			}
+			for k := range cam {
+				for c := 0; c < 3; c++ {
+					for j := 0; j < 3; j++ {
+						lab[k][c] += trans[c][j] * cam[k][j]
+					}
+				}
+				sum[k] = lab[k][1]*lab[k][1] + lab[k][2]*lab[k][2]
+			}
+			if sum[0] == 0 {
+				continue
+			}
+			ratio := float32(math.Sqrt(float64(sum[1] / sum[0])))
+			lab[0][1] *= ratio
+			lab[0][2] *= ratio
+			for c := 0; c < 3; c++ {
+				var v float32
+				for j := 0; j < 3; j++ {
+					v += itrans[c][j] * lab[0][j]
+				}
+				pix[i+c] = clip(v / 3)
+			}
+		}
+	})
+}
+
+func clip(v float32) uint16 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 0xffff {
+		return 0xffff
+	}
+	return uint16(v)
+}
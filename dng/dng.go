@@ -0,0 +1,343 @@
+// Package dng writes the raw images as Adobe DNG 1.4 files
+package dng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+	"math/bits"
+
+	"github.com/enricod/rawmgr/colors"
+	"github.com/enricod/rawmgr/common"
+	"github.com/enricod/rawmgr/ljpeg"
+)
+
+// DNG tags
+const (
+	TagCFARepeatPatternDim    = 0x828d
+	TagCFAPattern             = 0x828e
+	TagDNGVersion             = 0xc612
+	TagDNGBackwardVersion     = 0xc613
+	TagUniqueCameraModel      = 0xc614
+	TagCFAPlaneColor          = 0xc616
+	TagCFALayout              = 0xc617
+	TagBlackLevelRepeatDim    = 0xc619
+	TagBlackLevel             = 0xc61a
+	TagWhiteLevel             = 0xc61d
+	TagColorMatrix1           = 0xc621
+	TagAsShotNeutral          = 0xc628
+	TagDNGPrivateData         = 0xc634
+	TagCalibrationIlluminant1 = 0xc65a
+	TagActiveArea             = 0xc68d
+	TagPreviewColorSpace      = 0xc71a
+)
+
+// Values of the TIFF tags written
+const (
+	compressionNone      = 1
+	compressionJPEG      = 7
+	photometricYCbCr     = 6
+	photometricCFA       = 32803
+	illuminantD65        = 21
+	previewColorSpaceRGB = 2
+)
+
+const (
+	// tileSize width and height of the tiles of the compressed raw data
+	tileSize = 256
+	// stripSize bytes of the strips of the uncompressed raw data
+	stripSize = 1 << 16
+)
+
+// Options of Encode
+type Options struct {
+	// Compress codes the raw data as lossless JPEG, uncompressed if false
+	Compress bool
+	// Preview JPEG image stored in IFD0, with the raw image in its sub
+	// IFD. The raw image is in IFD0 if nil
+	Preview []byte
+	// Exif of the raw file, copied with the maker note moved to
+	// DNGPrivateData. The EXIF of the metadata of the image if nil
+	Exif *common.Exif
+}
+
+// Encode writes img as a DNG file, the whole sensor with its active area.
+// Only one calibration is written: the table of dcraw has a single matrix
+// for each camera, for the D65 illuminant, written as ColorMatrix1 with
+// CalibrationIlluminant1. The as shot neutral is the inverse of the white
+// balance of the shot, of daylight if not known
+func Encode(w io.Writer, img *common.RawImage, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	cfa := img.CFA
+	if img.PixelSize() != 1 || cfa.Width <= 0 || cfa.Height <= 0 || len(cfa.Pattern) != cfa.Width*cfa.Height {
+		return errors.New("dng: not the image of a CFA sensor")
+	}
+	if img.Width <= 0 || img.Height <= 0 {
+		return errors.New("dng: empty image")
+	}
+	cam, ok := colors.LookupCamera(img.Metadata.Make, img.Metadata.Model)
+	if !ok {
+		return fmt.Errorf("dng: color matrix of %s %s not known", img.Metadata.Make, img.Metadata.Model)
+	}
+
+	raw, err := rawIfd(img, cam, opts.Compress)
+	if err != nil {
+		return err
+	}
+	ifd0 := raw
+	if opts.Preview != nil {
+		if ifd0, err = previewIfd(opts.Preview); err != nil {
+			return err
+		}
+		ifd0.Entries = append(ifd0.Entries, common.IfdEntry{Tag: common.TagSubIFDs, Type: common.TypeLong,
+			Sub: []*common.Ifd{raw}})
+	}
+
+	maker, model := colors.NormalizeName(img.Metadata.Make, img.Metadata.Model)
+	entries, exif := img.Metadata.TiffEntries()
+	entries = append(entries,
+		common.IfdEntry{Tag: common.TagSoftware, Type: common.TypeASCII, Value: "rawmgr"},
+		common.IfdEntry{Tag: TagDNGVersion, Type: common.TypeByte, Value: []uint8{1, 4, 0, 0}},
+		common.IfdEntry{Tag: TagDNGBackwardVersion, Type: common.TypeByte, Value: []uint8{1, 1, 0, 0}},
+		common.IfdEntry{Tag: TagUniqueCameraModel, Type: common.TypeASCII, Value: maker + " " + model},
+		common.IfdEntry{Tag: TagColorMatrix1, Type: common.TypeSRational, Value: colorMatrix(cam.XYZToCamera)},
+		common.IfdEntry{Tag: TagCalibrationIlluminant1, Type: common.TypeShort, Value: []uint16{illuminantD65}},
+		common.IfdEntry{Tag: TagAsShotNeutral, Type: common.TypeRational, Value: asShotNeutral(img, cam)})
+	if opts.Exif != nil {
+		exif = exifEntries(opts.Exif.Ifd)
+		if data := privateData(opts.Exif); data != nil {
+			entries = append(entries, common.IfdEntry{Tag: TagDNGPrivateData, Type: common.TypeByte, Value: data})
+		}
+	}
+	if len(exif) > 0 {
+		entries = append(entries, common.IfdEntry{Tag: common.TagExifIFD, Type: common.TypeLong,
+			Sub: []*common.Ifd{{Entries: exif}}})
+	}
+	ifd0.Entries = append(ifd0.Entries, entries...)
+	return common.WriteTiff(w, binary.LittleEndian, []*common.Ifd{ifd0})
+}
+
+// rawIfd returns the IFD of the raw data of img, in tiles of lossless JPEG
+// with compress, in strips of 16 bits samples otherwise
+func rawIfd(img *common.RawImage, cam *colors.Camera, compress bool) (*common.Ifd, error) {
+	area := img.ActiveArea.Intersect(img.Bounds())
+	if area.Empty() {
+		area = img.Bounds()
+	}
+	// the patterns start at the top left of the active area
+	cfa := img.CFA.Offset(area.Min.X, area.Min.Y)
+	pattern := append([]uint8{}, cfa.Pattern...)
+	ifd := &common.Ifd{Entries: []common.IfdEntry{
+		{Tag: common.TagNewSubfileType, Type: common.TypeLong, Value: []uint32{0}},
+		{Tag: common.TagImageWidth, Type: common.TypeLong, Value: []uint32{uint32(img.Width)}},
+		{Tag: common.TagImageLength, Type: common.TypeLong, Value: []uint32{uint32(img.Height)}},
+		{Tag: common.TagBitsPerSample, Type: common.TypeShort, Value: []uint16{16}},
+		{Tag: common.TagPhotometricInterpretation, Type: common.TypeShort, Value: []uint16{photometricCFA}},
+		{Tag: common.TagSamplesPerPixel, Type: common.TypeShort, Value: []uint16{1}},
+		{Tag: common.TagPlanarConfiguration, Type: common.TypeShort, Value: []uint16{1}},
+		{Tag: TagCFARepeatPatternDim, Type: common.TypeShort, Value: []uint16{uint16(cfa.Height), uint16(cfa.Width)}},
+		{Tag: TagCFAPattern, Type: common.TypeByte, Value: pattern},
+		{Tag: TagCFAPlaneColor, Type: common.TypeByte, Value: []uint8{common.Red, common.Green, common.Blue}},
+		{Tag: TagCFALayout, Type: common.TypeShort, Value: []uint16{1}},
+	}}
+	if area != img.Bounds() {
+		ifd.Entries = append(ifd.Entries, common.IfdEntry{Tag: TagActiveArea, Type: common.TypeLong,
+			Value: []uint32{uint32(area.Min.Y), uint32(area.Min.X), uint32(area.Max.Y), uint32(area.Max.X)}})
+	}
+
+	black, white := colors.Levels(img, cam)
+	if black[0] == black[1] && black[0] == black[2] && black[0] == black[3] {
+		ifd.Entries = append(ifd.Entries, common.IfdEntry{Tag: TagBlackLevel, Type: common.TypeShort,
+			Value: []uint16{uint16(black[0])}})
+	} else {
+		levels := make([]uint16, 4)
+		for i := range levels {
+			levels[i] = uint16(black[img.Channel(area.Min.X+i%2, area.Min.Y+i/2)])
+		}
+		ifd.Entries = append(ifd.Entries,
+			common.IfdEntry{Tag: TagBlackLevelRepeatDim, Type: common.TypeShort, Value: []uint16{2, 2}},
+			common.IfdEntry{Tag: TagBlackLevel, Type: common.TypeShort, Value: levels})
+	}
+	ifd.Entries = append(ifd.Entries, common.IfdEntry{Tag: TagWhiteLevel, Type: common.TypeShort,
+		Value: []uint16{uint16(white)}})
+
+	if !compress {
+		rows := stripSize / (2 * img.Width)
+		if rows < 1 {
+			rows = 1
+		}
+		for y := 0; y < img.Height; y += rows {
+			end := y + rows
+			if end > img.Height {
+				end = img.Height
+			}
+			strip := make([]byte, 2*img.Width*(end-y))
+			for i, v := range img.Pix[y*img.Width : end*img.Width] {
+				binary.LittleEndian.PutUint16(strip[2*i:], v)
+			}
+			ifd.Strips = append(ifd.Strips, strip)
+		}
+		ifd.Entries = append(ifd.Entries,
+			common.IfdEntry{Tag: common.TagCompression, Type: common.TypeShort, Value: []uint16{compressionNone}},
+			common.IfdEntry{Tag: common.TagRowsPerStrip, Type: common.TypeLong, Value: []uint32{uint32(rows)}})
+		return ifd, nil
+	}
+
+	tiles, err := encodeTiles(img)
+	if err != nil {
+		return nil, err
+	}
+	ifd.Strips = tiles
+	ifd.Entries = append(ifd.Entries,
+		common.IfdEntry{Tag: common.TagCompression, Type: common.TypeShort, Value: []uint16{compressionJPEG}},
+		common.IfdEntry{Tag: common.TagTileWidth, Type: common.TypeLong, Value: []uint32{tileSize}},
+		common.IfdEntry{Tag: common.TagTileLength, Type: common.TypeLong, Value: []uint32{tileSize}})
+	return ifd, nil
+}
+
+// encodeTiles codes the tiles of img row by row as lossless JPEG, each
+// row of a tile as two interleaved components, as the DNG converter does,
+// so that the samples are predicted from the ones of the same color. The
+// tiles on the right and bottom borders are padded with zeros
+func encodeTiles(img *common.RawImage) ([][]byte, error) {
+	var max uint16
+	for _, v := range img.Pix {
+		if v > max {
+			max = v
+		}
+	}
+	precision := bits.Len16(max)
+	if precision < 2 {
+		precision = 2
+	}
+	across := (img.Width + tileSize - 1) / tileSize
+	down := (img.Height + tileSize - 1) / tileSize
+	tiles := make([][]byte, across*down)
+	errs := make([]error, down)
+	common.ParallelRows(down, common.WorkersCount(), func(start, end int) {
+		pix := make([]uint16, tileSize*tileSize)
+		for ty := start; ty < end; ty++ {
+			for tx := 0; tx < across; tx++ {
+				for i := range pix {
+					pix[i] = 0
+				}
+				x, width := tx*tileSize, tileSize
+				if x+width > img.Width {
+					width = img.Width - x
+				}
+				for y := 0; y < tileSize && ty*tileSize+y < img.Height; y++ {
+					row := (ty*tileSize + y) * img.Width
+					copy(pix[y*tileSize:y*tileSize+width], img.Pix[row+x:row+x+width])
+				}
+				var buf bytes.Buffer
+				if err := ljpeg.Encode(&buf, pix, tileSize/2, tileSize, 2, precision); err != nil {
+					errs[ty] = err
+					return
+				}
+				tiles[ty*across+tx] = buf.Bytes()
+			}
+		}
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tiles, nil
+}
+
+// previewIfd returns the IFD of the JPEG preview
+func previewIfd(preview []byte) (*common.Ifd, error) {
+	config, err := jpeg.DecodeConfig(bytes.NewReader(preview))
+	if err != nil {
+		return nil, fmt.Errorf("dng: preview: %v", err)
+	}
+	if config.ColorModel != color.YCbCrModel {
+		return nil, errors.New("dng: the preview is not a color JPEG")
+	}
+	return &common.Ifd{
+		Entries: []common.IfdEntry{
+			{Tag: common.TagNewSubfileType, Type: common.TypeLong, Value: []uint32{1}},
+			{Tag: common.TagImageWidth, Type: common.TypeLong, Value: []uint32{uint32(config.Width)}},
+			{Tag: common.TagImageLength, Type: common.TypeLong, Value: []uint32{uint32(config.Height)}},
+			{Tag: common.TagBitsPerSample, Type: common.TypeShort, Value: []uint16{8, 8, 8}},
+			{Tag: common.TagCompression, Type: common.TypeShort, Value: []uint16{compressionJPEG}},
+			{Tag: common.TagPhotometricInterpretation, Type: common.TypeShort, Value: []uint16{photometricYCbCr}},
+			{Tag: common.TagSamplesPerPixel, Type: common.TypeShort, Value: []uint16{3}},
+			{Tag: common.TagRowsPerStrip, Type: common.TypeLong, Value: []uint32{uint32(config.Height)}},
+			{Tag: common.TagPlanarConfiguration, Type: common.TypeShort, Value: []uint16{1}},
+			{Tag: TagPreviewColorSpace, Type: common.TypeLong, Value: []uint32{previewColorSpaceRGB}},
+		},
+		Strips: [][]byte{preview},
+	}, nil
+}
+
+// colorMatrix returns m as the values of ColorMatrix1
+func colorMatrix(m colors.Matrix) []common.SRational {
+	var result []common.SRational
+	for _, row := range m {
+		for _, v := range row {
+			result = append(result, common.SRational{Num: int32(math.Round(v * 10000)), Den: 10000})
+		}
+	}
+	return result
+}
+
+// asShotNeutral returns the camera values of the white of the shot, the
+// inverse of the multipliers of its white balance, of the daylight ones of
+// the camera if not known
+func asShotNeutral(img *common.RawImage, cam *colors.Camera) []common.Rational {
+	mul := [3]float64{img.WBMultipliers[0], img.WBMultipliers[1], img.WBMultipliers[3]}
+	if mul[0] <= 0 || mul[1] <= 0 || mul[2] <= 0 {
+		_, mul = cam.CameraToRGB()
+	}
+	result := make([]common.Rational, 3)
+	for c := range result {
+		result[c] = common.Rational{Num: uint32(math.Round(mul[1] / mul[c] * 1e6)), Den: 1e6}
+	}
+	return result
+}
+
+// exifEntries returns the entries of exif written in the DNG: the maker
+// note is in DNGPrivateData and the values of unknown types are dropped
+func exifEntries(exif *common.Ifd) []common.IfdEntry {
+	var result []common.IfdEntry
+	for _, e := range exif.Entries {
+		if e.Tag == common.TagMakerNote || e.Value == nil {
+			continue
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+// privateData returns the maker note of exif as DNGPrivateData, in the
+// format of Adobe: "Adobe", "MakN", the size, the byte order and the offset
+// of the maker note in the original file, and the maker note. nil if exif
+// has no maker note
+func privateData(exif *common.Exif) []byte {
+	e := exif.Ifd.Entry(common.TagMakerNote)
+	if e == nil {
+		return nil
+	}
+	note, ok := e.Value.([]byte)
+	if !ok || len(note) == 0 {
+		return nil
+	}
+	data := append([]byte("Adobe\x00MakN"), make([]byte, 10)...)
+	binary.BigEndian.PutUint32(data[10:], uint32(len(note)+6))
+	if exif.Order == binary.BigEndian {
+		copy(data[14:], "MM")
+	} else {
+		copy(data[14:], "II")
+	}
+	binary.BigEndian.PutUint32(data[16:], uint32(e.ValueOffset-exif.Base))
+	return append(data, note...)
+}
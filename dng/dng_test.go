@@ -0,0 +1,163 @@
+package dng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"github.com/enricod/rawmgr/colors"
+	"github.com/enricod/rawmgr/common"
+	"github.com/enricod/rawmgr/ljpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testImage RGGB mosaic of a Canon, larger than a tile, with a masked
+// border and the black level of each channel
+func testImage() *common.RawImage {
+	width, height := 300, 270
+	img := &common.RawImage{Width: width, Height: height, Pix: make([]uint16, width*height),
+		CFA: common.CFARGGB, ActiveArea: image.Rect(3, 2, 296, 268),
+		BlackLevel: 1024, WhiteLevel: 15000, ChannelBlackLevel: [4]uint16{1020, 1021, 1022, 1023},
+		WBMultipliers: [4]float64{2, 1, 1, 1.25},
+		Metadata:      common.Metadata{Make: "Canon", Model: "Canon EOS 5D Mark II", ISO: 400}}
+	for i := range img.Pix {
+		x, y := i%width, i/width
+		img.Pix[i] = uint16(1024 + (x*13+y*7)%3000 + (x*y)%17)
+	}
+	return img
+}
+
+// readDNG reads the IFD0 of data and the raw IFD
+func readDNG(t *testing.T, data []byte) (ifd0, raw *common.Ifd) {
+	r, err := common.NewTiffReader(bytes.NewReader(data), 0)
+	require.Nil(t, err)
+	ifds, err := r.ReadIfds()
+	require.Nil(t, err)
+	require.Len(t, ifds, 1)
+	ifd0 = ifds[0]
+	if sub := ifd0.Entry(common.TagSubIFDs); sub != nil {
+		require.Len(t, sub.Sub, 1)
+		return ifd0, sub.Sub[0]
+	}
+	return ifd0, ifd0
+}
+
+func TestEncode(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	img := testImage()
+	var preview bytes.Buffer
+	require.Nil(jpeg.Encode(&preview, image.NewRGBA(image.Rect(0, 0, 32, 24)), nil))
+	exif := &common.Exif{Ifd: &common.Ifd{Entries: []common.IfdEntry{
+		{Tag: common.TagISO, Type: common.TypeShort, Value: []uint16{400}},
+		{Tag: common.TagMakerNote, Type: common.TypeUndefined, Value: []byte("maker note"), ValueOffset: 1000},
+	}}, Order: binary.LittleEndian}
+
+	var buf bytes.Buffer
+	require.Nil(Encode(&buf, img, &Options{Compress: true, Preview: preview.Bytes(), Exif: exif}))
+	data := buf.Bytes()
+	ifd0, raw := readDNG(t, data)
+
+	// the preview
+	assert.Equal(int64(1), ifd0.Entry(common.TagNewSubfileType).Int(0))
+	assert.Equal(int64(32), ifd0.Entry(common.TagImageWidth).Int(0))
+	assert.Equal(int64(compressionJPEG), ifd0.Entry(common.TagCompression).Int(0))
+	offset, size := ifd0.Entry(common.TagStripOffsets).Int(0), ifd0.Entry(common.TagStripByteCounts).Int(0)
+	assert.Equal(preview.Bytes(), data[offset:offset+size])
+
+	assert.Equal([]uint8{1, 4, 0, 0}, ifd0.Entry(TagDNGVersion).Value)
+	assert.Equal("Canon EOS 5D Mark II", ifd0.Entry(TagUniqueCameraModel).String())
+	assert.Equal("Canon EOS 5D Mark II", ifd0.Entry(common.TagModel).String())
+	cam, ok := colors.LookupCamera("Canon", "Canon EOS 5D Mark II")
+	require.True(ok)
+	matrix := ifd0.Entry(TagColorMatrix1)
+	require.Equal(9, matrix.Len())
+	assert.InDelta(cam.XYZToCamera[1][2], matrix.Float(5), 1e-4)
+	assert.Equal(int64(illuminantD65), ifd0.Entry(TagCalibrationIlluminant1).Int(0))
+	neutral := ifd0.Entry(TagAsShotNeutral)
+	assert.InDelta(0.5, neutral.Float(0), 1e-6)
+	assert.InDelta(1, neutral.Float(1), 1e-6)
+	assert.InDelta(0.8, neutral.Float(2), 1e-6)
+
+	// the EXIF without the maker note, that is in the private data
+	exifIfd := ifd0.Entry(common.TagExifIFD)
+	require.Len(exifIfd.Sub, 1)
+	assert.Equal(int64(400), exifIfd.Sub[0].Entry(common.TagISO).Int(0))
+	assert.Nil(exifIfd.Sub[0].Entry(common.TagMakerNote))
+	private := ifd0.Entry(TagDNGPrivateData).Value.([]uint8)
+	assert.Equal("Adobe\x00MakN", string(private[:10]))
+	assert.Equal(uint32(16), binary.BigEndian.Uint32(private[10:]))
+	assert.Equal("II", string(private[14:16]))
+	assert.Equal(uint32(1000), binary.BigEndian.Uint32(private[16:]))
+	assert.Equal("maker note", string(private[20:]))
+
+	// the raw data
+	assert.Equal(int64(0), raw.Entry(common.TagNewSubfileType).Int(0))
+	assert.Equal(int64(photometricCFA), raw.Entry(common.TagPhotometricInterpretation).Int(0))
+	assert.Equal([]uint16{2, 2}, raw.Entry(TagCFARepeatPatternDim).Value)
+	// the active area starts on a green of the rows of red
+	assert.Equal([]uint8{common.Green, common.Red, common.Blue, common.Green}, raw.Entry(TagCFAPattern).Value)
+	assert.Equal([]uint32{2, 3, 268, 296}, raw.Entry(TagActiveArea).Value)
+	assert.Equal([]uint16{1021, 1020, 1023, 1022}, raw.Entry(TagBlackLevel).Value)
+	_, white := colors.Levels(img, cam)
+	assert.Equal(int64(white), raw.Entry(TagWhiteLevel).Int(0))
+
+	offsets, counts := raw.Entry(common.TagTileOffsets), raw.Entry(common.TagTileByteCounts)
+	require.Equal(4, offsets.Len())
+	pix := make([]uint16, len(img.Pix))
+	for i := 0; i < offsets.Len(); i++ {
+		tile, err := ljpeg.Decode(data[offsets.Int(i) : offsets.Int(i)+counts.Int(i)])
+		require.Nil(err)
+		require.Len(tile.Planes, 2)
+		for y := 0; y < tileSize; y++ {
+			for x := 0; x < tileSize; x++ {
+				px, py := i%2*tileSize+x, i/2*tileSize+y
+				if px < img.Width && py < img.Height {
+					pix[py*img.Width+px] = tile.Planes[x%2].Pix[y*tileSize/2+x/2]
+				}
+			}
+		}
+	}
+	assert.Equal(img.Pix, pix)
+}
+
+func TestEncodeUncompressed(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	img := testImage()
+	img.ChannelBlackLevel = [4]uint16{}
+	var buf bytes.Buffer
+	require.Nil(Encode(&buf, img, nil))
+	data := buf.Bytes()
+	ifd0, raw := readDNG(t, data)
+
+	// the raw data is in IFD0, with the EXIF of the metadata
+	assert.Equal(ifd0, raw)
+	assert.Equal(int64(compressionNone), raw.Entry(common.TagCompression).Int(0))
+	assert.Equal([]uint16{1024}, raw.Entry(TagBlackLevel).Value)
+	assert.Nil(raw.Entry(TagBlackLevelRepeatDim))
+	assert.Nil(raw.Entry(TagDNGPrivateData))
+	assert.Equal(int64(400), raw.Entry(common.TagExifIFD).Sub[0].Entry(common.TagISO).Int(0))
+
+	offsets, counts := raw.Entry(common.TagStripOffsets), raw.Entry(common.TagStripByteCounts)
+	var samples []byte
+	for i := 0; i < offsets.Len(); i++ {
+		samples = append(samples, data[offsets.Int(i):offsets.Int(i)+counts.Int(i)]...)
+	}
+	require.Equal(2*len(img.Pix), len(samples))
+	for i, v := range img.Pix {
+		require.Equal(v, binary.LittleEndian.Uint16(samples[2*i:]), "sample %d", i)
+	}
+
+	// not a camera of the table, not a CFA image
+	img.Metadata.Model = "Unknown"
+	assert.NotNil(Encode(&buf, img, nil))
+	img = testImage()
+	img.Colors, img.CFA = 3, common.CFA{}
+	assert.NotNil(Encode(&buf, img, nil))
+}
@@ -0,0 +1,544 @@
+package fuji
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/enricod/rawmgr/common"
+)
+
+// compressedSignature at the start of the lossless compressed raw data
+const compressedSignature = 0x4953
+
+// compressedHeaderSize bytes of the header of the compressed raw data
+const compressedHeaderSize = 16
+
+// compressedBlockSize width of the stripes of the compressed raw data
+const compressedBlockSize = 0x300
+
+// raw types of the compressed header
+const (
+	rawTypeBayer  = 0
+	rawTypeXTrans = 16
+)
+
+// compressedHeader of the lossless compressed raw data. The image is split
+// in vertical stripes of blockSize columns, coded independently, 6 rows at
+// a time
+type compressedHeader struct {
+	rawType      int
+	bits         int
+	height       int
+	roundedWidth int
+	width        int
+	blockSize    int
+	blocks       int
+	lines        int
+}
+
+// parseCompressedHeader reads the header at the start of the raw data, big
+// endian. Returns nil if the data is not compressed or the values are not
+// valid
+func parseCompressedHeader(b []byte) *compressedHeader {
+	if len(b) < compressedHeaderSize {
+		return nil
+	}
+	u16 := func(i int) int { return int(binary.BigEndian.Uint16(b[i:])) }
+	if u16(0) != compressedSignature || b[2] != 1 {
+		return nil
+	}
+	h := &compressedHeader{
+		rawType:      int(b[3]),
+		bits:         int(b[4]),
+		height:       u16(5),
+		roundedWidth: u16(7),
+		width:        u16(9),
+		blockSize:    u16(11),
+		blocks:       int(b[13]),
+		lines:        u16(14),
+	}
+	// as LibRaw
+	switch {
+	case h.rawType != rawTypeXTrans && h.rawType != rawTypeBayer,
+		h.bits != 12 && h.bits != 14,
+		h.height < 6 || h.height > 0x3000 || h.height%6 != 0,
+		h.width < 0x300 || h.width > 0x3000 || h.width%24 != 0,
+		h.blockSize != compressedBlockSize,
+		h.roundedWidth > 0x3000 || h.roundedWidth%h.blockSize != 0,
+		h.roundedWidth < h.width || h.roundedWidth-h.width >= h.blockSize,
+		h.blocks == 0 || h.blocks > 0x10 || h.blocks != h.roundedWidth/h.blockSize,
+		h.lines == 0 || h.lines > 0x800 || h.lines != h.height/6:
+		return nil
+	}
+	return h
+}
+
+// lines of the buffers of a stripe: the two lines of the previous block of 6
+// rows and the lines of the current one, for each color. The X-Trans
+// sensors have 2 red, 4 green and 2 blue samples in each 6x6 pattern row,
+// the Bayer ones 1 red, 2 green and 1 blue in 2 rows
+const (
+	lineR0 = iota
+	lineR1
+	lineR2
+	lineR3
+	lineR4
+	lineG0
+	lineG1
+	lineG2
+	lineG3
+	lineG4
+	lineG5
+	lineG6
+	lineG7
+	lineB0
+	lineB1
+	lineB2
+	lineB3
+	lineB4
+	linesCount
+)
+
+// evenMode how the samples at the even positions of a line are obtained:
+// coded, or interpolated from the previous lines
+type evenMode int
+
+const (
+	evenCoded evenMode = iota
+	evenInterpolated
+	// interpolated at the positions multiple of 4
+	evenInterpolated0
+	// interpolated at the positions 2 modulo 4
+	evenInterpolated2
+)
+
+// codingPass a pair of lines coded together, with the set of gradients used
+type codingPass struct {
+	lines [2]int
+	modes [2]evenMode
+	grads int
+}
+
+// xtransPasses the order of the lines of a block of 6 rows of X-Trans data
+var xtransPasses = [6]codingPass{
+	{[2]int{lineR2, lineG2}, [2]evenMode{evenInterpolated, evenCoded}, 0},
+	{[2]int{lineG3, lineB2}, [2]evenMode{evenCoded, evenInterpolated}, 1},
+	{[2]int{lineR3, lineG4}, [2]evenMode{evenInterpolated0, evenCoded}, 2},
+	{[2]int{lineG5, lineB3}, [2]evenMode{evenCoded, evenInterpolated2}, 0},
+	{[2]int{lineR4, lineG6}, [2]evenMode{evenInterpolated2, evenCoded}, 1},
+	{[2]int{lineG7, lineB4}, [2]evenMode{evenCoded, evenInterpolated0}, 2},
+}
+
+// bayerPasses the order of the lines of a block of 6 rows of Bayer data
+var bayerPasses = [6]codingPass{
+	{[2]int{lineR2, lineG2}, [2]evenMode{}, 0},
+	{[2]int{lineG3, lineB2}, [2]evenMode{}, 1},
+	{[2]int{lineR3, lineG4}, [2]evenMode{}, 2},
+	{[2]int{lineG5, lineB3}, [2]evenMode{}, 0},
+	{[2]int{lineR4, lineG6}, [2]evenMode{}, 1},
+	{[2]int{lineG7, lineB4}, [2]evenMode{}, 2},
+}
+
+// compressedParams values shared by the stripes, depending on the bits of
+// the samples
+type compressedParams struct {
+	*compressedHeader
+	lineWidth   int
+	maxValue    int
+	totalValues int
+	maxBits     int
+	maxDiff     int
+	// qTable quantized differences of the samples, from -maxValue
+	qTable []int8
+}
+
+// minGradientCount count of the samples of a gradient halving its values
+const minGradientCount = 0x40
+
+func newCompressedParams(h *compressedHeader) *compressedParams {
+	p := &compressedParams{compressedHeader: h}
+	if h.rawType == rawTypeXTrans {
+		p.lineWidth = h.blockSize * 2 / 3
+	} else {
+		p.lineWidth = h.blockSize / 2
+	}
+	p.maxValue = 1<<uint(h.bits) - 1
+	p.totalValues = 1 << uint(h.bits)
+	if h.bits == 14 {
+		p.maxBits, p.maxDiff = 56, 256
+	} else {
+		p.maxBits, p.maxDiff = 48, 64
+	}
+	points := [3]int{0x12, 0x43, 0x114}
+	p.qTable = make([]int8, 2*p.maxValue+1)
+	for i := range p.qTable {
+		v := abs(i - p.maxValue)
+		var q int8
+		switch {
+		case v >= points[2]:
+			q = 4
+		case v >= points[1]:
+			q = 3
+		case v >= points[0]:
+			q = 2
+		case v > 0:
+			q = 1
+		}
+		if i < p.maxValue {
+			q = -q
+		}
+		p.qTable[i] = q
+	}
+	return p
+}
+
+// quantGradient context of a sample from the differences of its neighbours,
+// -40 to 40
+func (p *compressedParams) quantGradient(v1, v2 int) int {
+	return 9*int(p.qTable[p.maxValue+v1]) + int(p.qTable[p.maxValue+v2])
+}
+
+// gradient adaptive statistics of the coded differences of a context
+type gradient struct {
+	sum   int
+	count int
+}
+
+// stripe decoder state of a vertical stripe
+type stripe struct {
+	p  *compressedParams
+	br *common.BitReader
+	// buf lines of lineWidth samples, with one more sample on each side
+	buf      []uint16
+	gradEven [3][41]gradient
+	gradOdd  [3][41]gradient
+}
+
+func newStripe(p *compressedParams, data []byte) *stripe {
+	s := &stripe{
+		p:   p,
+		br:  common.NewPlainBitReader(data),
+		buf: make([]uint16, linesCount*(p.lineWidth+2)),
+	}
+	for i := range s.gradEven {
+		for k := range s.gradEven[i] {
+			s.gradEven[i][k] = gradient{sum: p.maxDiff, count: 1}
+			s.gradOdd[i][k] = gradient{sum: p.maxDiff, count: 1}
+		}
+	}
+	return s
+}
+
+// index in buf of the sample at pos of line
+func (s *stripe) index(line, pos int) int {
+	return line*(s.p.lineWidth+2) + 1 + pos
+}
+
+// predictEven returns 4 times the prediction of the even sample at k from
+// the two previous lines, and its gradient context
+func (s *stripe) predictEven(k int) (int, int) {
+	w := s.p.lineWidth
+	rb := int(s.buf[k-2-w])
+	rc := int(s.buf[k-3-w])
+	rd := int(s.buf[k-1-w])
+	rf := int(s.buf[k-4-2*w])
+	grad := s.p.quantGradient(rb-rf, rc-rb)
+	diffRcRb, diffRfRb, diffRdRb := abs(rc-rb), abs(rf-rb), abs(rd-rb)
+	switch {
+	case diffRcRb > diffRfRb && diffRcRb > diffRdRb:
+		return rf + rd + 2*rb, grad
+	case diffRdRb > diffRcRb && diffRdRb > diffRfRb:
+		return rf + rc + 2*rb, grad
+	}
+	return rd + rc + 2*rb, grad
+}
+
+// predictOdd returns the prediction of the odd sample at k from its
+// neighbours on the line and the previous line, and its gradient context
+func (s *stripe) predictOdd(k int) (int, int) {
+	w := s.p.lineWidth
+	ra := int(s.buf[k-1])
+	rb := int(s.buf[k-2-w])
+	rc := int(s.buf[k-3-w])
+	rd := int(s.buf[k-1-w])
+	rg := int(s.buf[k+1])
+	grad := s.p.quantGradient(rb-rc, rc-ra)
+	if (rb > rc && rb > rd) || (rb < rc && rb < rd) {
+		return (rg + ra + 2*rb) >> 2, grad
+	}
+	return (ra + rg) >> 1, grad
+}
+
+// decodeSample reads the difference of the sample at k from the
+// prediction, updating the statistics of the gradient context
+func (s *stripe) decodeSample(k, predicted, grad int, grads *[41]gradient) error {
+	p := s.p
+	g := &grads[abs(grad)]
+	var code int
+	if zeros := s.br.ReadZeros(); zeros < p.maxBits-p.bits-1 {
+		n := bitDiff(g.sum, g.count)
+		code = int(s.br.ReadBits(uint(n))) + zeros<<uint(n)
+	} else {
+		code = int(s.br.ReadBits(uint(p.bits))) + 1
+	}
+	if code < 0 || code >= p.totalValues {
+		return common.NewFormatError(format, 0, "compressed sample code %d not valid", code)
+	}
+	if code&1 == 1 {
+		code = -1 - code/2
+	} else {
+		code /= 2
+	}
+	g.update(code)
+	if grad < 0 {
+		predicted -= code
+	} else {
+		predicted += code
+	}
+	s.buf[k] = p.wrap(predicted)
+	return nil
+}
+
+// update adds the difference coded to the statistics
+func (g *gradient) update(code int) {
+	g.sum += abs(code)
+	if g.count == minGradientCount {
+		g.sum >>= 1
+		g.count >>= 1
+	}
+	g.count++
+}
+
+// wrap brings back a decoded value in the range of the samples
+func (p *compressedParams) wrap(v int) uint16 {
+	if v < 0 {
+		v += p.totalValues
+	} else if v > p.maxValue {
+		v -= p.totalValues
+	}
+	if v < 0 {
+		return 0
+	}
+	if v > p.maxValue {
+		v = p.maxValue
+	}
+	return uint16(v)
+}
+
+// bitDiff bits of the remainder of a code, from the mean difference of the
+// gradient context
+func bitDiff(sum, count int) int {
+	n := 0
+	if count < sum {
+		for n <= 14 {
+			n++
+			if count<<uint(n) >= sum {
+				break
+			}
+		}
+	}
+	return n
+}
+
+// extend copies the samples at the borders of the previous line beyond the
+// borders of the lines of the color of line
+func (s *stripe) extend(line int) {
+	first, last := lineG2, lineG7
+	switch {
+	case line >= lineR2 && line <= lineR4:
+		first, last = lineR2, lineR4
+	case line >= lineB2 && line <= lineB4:
+		first, last = lineB2, lineB4
+	}
+	w := s.p.lineWidth
+	for i := first; i <= last; i++ {
+		s.buf[s.index(i, -1)] = s.buf[s.index(i-1, 0)]
+		s.buf[s.index(i, w)] = s.buf[s.index(i-1, w-1)]
+	}
+}
+
+// sampleFunc sets the sample at k from its prediction and gradient context
+type sampleFunc func(k, predicted, grad int, grads *[41]gradient) error
+
+// decodeBlock decodes the lines of a block of 6 rows with sample. The odd
+// samples of a line follow the even ones by a few positions, as they are
+// predicted from the even samples around them
+func (s *stripe) decodeBlock(passes *[6]codingPass, sample sampleFunc) error {
+	w := s.p.lineWidth
+	for _, pass := range passes {
+		grads := pass.grads
+		even, odd := 0, 1
+		for even < w || odd < w {
+			if even < w {
+				for i, line := range pass.lines {
+					k := s.index(line, even)
+					predicted, grad := s.predictEven(k)
+					if pass.modes[i].interpolated(even) {
+						s.buf[k] = uint16(predicted >> 2)
+						continue
+					}
+					if err := sample(k, predicted>>2, grad, &s.gradEven[grads]); err != nil {
+						return err
+					}
+				}
+				even += 2
+			}
+			if even > 8 {
+				for _, line := range pass.lines {
+					k := s.index(line, odd)
+					predicted, grad := s.predictOdd(k)
+					if err := sample(k, predicted, grad, &s.gradOdd[grads]); err != nil {
+						return err
+					}
+				}
+				odd += 2
+			}
+		}
+		for _, line := range pass.lines {
+			s.extend(line)
+		}
+	}
+	return nil
+}
+
+// interpolated reports whether the even sample at pos is not coded
+func (m evenMode) interpolated(pos int) bool {
+	switch m {
+	case evenInterpolated:
+		return true
+	case evenInterpolated0:
+		return pos&3 == 0
+	case evenInterpolated2:
+		return pos&3 == 2
+	}
+	return false
+}
+
+// nextBlock keeps the last two lines of each color of the block as the
+// previous lines of the next block, and clears the others
+func (s *stripe) nextBlock() {
+	size := s.p.lineWidth + 2
+	moves := [6][2]int{
+		{lineR0, lineR3}, {lineR1, lineR4},
+		{lineG0, lineG6}, {lineG1, lineG7},
+		{lineB0, lineB3}, {lineB1, lineB4},
+	}
+	for _, m := range moves {
+		copy(s.buf[m[0]*size:(m[0]+1)*size], s.buf[m[1]*size:(m[1]+1)*size])
+	}
+	zeroed := [3][2]int{{lineR2, 3}, {lineG2, 6}, {lineB2, 3}}
+	for _, c := range zeroed {
+		lines := s.buf[c[0]*size : (c[0]+c[1])*size]
+		for i := range lines {
+			lines[i] = 0
+		}
+		s.buf[s.index(c[0], -1)] = s.buf[s.index(c[0]-1, 0)]
+		s.buf[s.index(c[0], s.p.lineWidth)] = s.buf[s.index(c[0]-1, s.p.lineWidth-1)]
+	}
+}
+
+// lineOf returns the line and the position of the sample of the photosite
+// of color at row (0 to 5) and col of the block
+func (p *compressedParams) lineOf(color uint8, row, col int) (int, int) {
+	line := lineG2 + row
+	switch color {
+	case common.Red:
+		line = lineR2 + row>>1
+	case common.Blue:
+		line = lineB2 + row>>1
+	}
+	if p.rawType == rawTypeXTrans {
+		// 2 samples of each color line every 3 photosites
+		return line, (col*2/3)&^1 | (col%3)&1 + (col%3)>>1
+	}
+	return line, col >> 1
+}
+
+// copyBlock copies the 6 rows of the block to the columns [x, x+width) of
+// pix, starting at row y
+func (s *stripe) copyBlock(pix []uint16, stride, x, y, width int, cfa common.CFA) {
+	for row := 0; row < 6; row++ {
+		out := pix[(y+row)*stride+x:]
+		for col := 0; col < width; col++ {
+			line, pos := s.p.lineOf(cfa.Color(row, col), row, col)
+			out[col] = s.buf[s.index(line, pos)]
+		}
+	}
+}
+
+// decodeStripe decodes the stripe of columns of block to pix
+func (p *compressedParams) decodeStripe(data []byte, block int, pix []uint16, cfa common.CFA) error {
+	passes := &bayerPasses
+	if p.rawType == rawTypeXTrans {
+		passes = &xtransPasses
+	}
+	x := block * p.blockSize
+	width := p.blockSize
+	if block == p.blocks-1 {
+		width = p.width - x
+	}
+	// the decoder can read one byte after the end of the stripe
+	s := newStripe(p, append(data, 0))
+	for line := 0; line < p.lines; line++ {
+		if err := s.decodeBlock(passes, s.decodeSample); err != nil {
+			return err
+		}
+		s.copyBlock(pix, p.width, x, line*6, width, cfa)
+		s.nextBlock()
+	}
+	return s.br.Err()
+}
+
+// decodeCompressed reads the lossless compressed raw data: the header, the
+// sizes of the stripes, big endian, and the stripes, decoded in parallel.
+// The stripes must be in the length bytes of the raw data at offset, cfa is
+// the pattern at the origin of the raw data
+func decodeCompressed(r io.ReaderAt, offset, length int64, h *compressedHeader, cfa common.CFA) ([]uint16, error) {
+	p := newCompressedParams(h)
+	sizes, err := readBytes(r, offset+compressedHeaderSize, 4*h.blocks)
+	if err != nil {
+		return nil, err
+	}
+	// the stripes start 16 bytes aligned
+	start := int64(4 * h.blocks)
+	if start&0xc != 0 {
+		start += 0x10 - start&0xc
+	}
+	start += offset + compressedHeaderSize
+	offsets := make([]int64, h.blocks)
+	for i := range offsets {
+		offsets[i] = start
+		start += int64(binary.BigEndian.Uint32(sizes[4*i:]))
+	}
+	if start > offset+length {
+		return nil, common.WrapFormatError(format, offset, common.ErrTruncated, "stripes of %d bytes outside of the raw data (%d bytes)", start-offset, length)
+	}
+
+	pix := make([]uint16, h.width*h.height)
+	errs := make([]error, h.blocks)
+	common.ParallelRows(h.blocks, common.WorkersCount(), func(first, last int) {
+		for block := first; block < last; block++ {
+			size := int(binary.BigEndian.Uint32(sizes[4*block:]))
+			data, err := readBytes(r, offsets[block], size)
+			if err == nil {
+				err = p.decodeStripe(data, block, pix, cfa)
+			}
+			if err != nil {
+				errs[block] = common.WrapFormatError(format, offsets[block], err, "stripe %d", block)
+			}
+		}
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pix, nil
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,275 @@
+package fuji
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/enricod/rawmgr/common"
+	"github.com/enricod/rawmgr/common/commontest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encodeStripe codes the columns of block of pix as the camera, returning
+// the stripe and the pixels as decoded: the samples interpolated by the
+// decoder are not coded
+func encodeStripe(p *compressedParams, pix []uint16, block int, cfa common.CFA) ([]byte, []uint16) {
+	passes := &bayerPasses
+	if p.rawType == rawTypeXTrans {
+		passes = &xtransPasses
+	}
+	x := block * p.blockSize
+	width := p.blockSize
+	if block == p.blocks-1 {
+		width = p.width - x
+	}
+	s := newStripe(p, nil)
+	target := make([]uint16, len(s.buf))
+	bw := &commontest.BitWriter{}
+	encode := func(k, predicted, grad int, grads *[41]gradient) error {
+		d := int(target[k]) - predicted
+		if grad < 0 {
+			d = -d
+		}
+		if d >= p.totalValues/2 {
+			d -= p.totalValues
+		} else if d < -p.totalValues/2 {
+			d += p.totalValues
+		}
+		code := 2 * d
+		if d < 0 {
+			code = -2*d - 1
+		}
+		g := &grads[abs(grad)]
+		n := uint(bitDiff(g.sum, g.count))
+		if zeros := code >> n; zeros < p.maxBits-p.bits-1 {
+			bw.Write(1, uint(zeros)+1)
+			bw.Write(uint64(code)&(1<<n-1), n)
+		} else {
+			bw.Write(1, uint(p.maxBits-p.bits))
+			bw.Write(uint64(code-1), uint(p.bits))
+		}
+		g.update(d)
+		s.buf[k] = target[k]
+		return nil
+	}
+	decoded := make([]uint16, len(pix))
+	for line := 0; line < p.lines; line++ {
+		for row := 0; row < 6; row++ {
+			for col := 0; col < width; col++ {
+				l, pos := p.lineOf(cfa.Color(row, col), row, col)
+				target[s.index(l, pos)] = pix[(line*6+row)*p.width+x+col]
+			}
+		}
+		s.decodeBlock(passes, encode)
+		s.copyBlock(decoded, p.width, x, line*6, width, cfa)
+		s.nextBlock()
+	}
+	return bw.Bytes(), decoded
+}
+
+// testCompressed codes pix as the compressed raw data, returning the data
+// and the pixels as decoded
+func testCompressed(h *compressedHeader, pix []uint16, cfa common.CFA) ([]byte, []uint16) {
+	p := newCompressedParams(h)
+	data := []byte{0x49, 0x53, 1, byte(h.rawType), byte(h.bits)}
+	for _, v := range []int{h.height, h.roundedWidth, h.width, h.blockSize} {
+		data = append(data, byte(v>>8), byte(v))
+	}
+	data = append(data, byte(h.blocks), byte(h.lines>>8), byte(h.lines))
+	sizes := make([]byte, 16)
+	decoded := make([]uint16, len(pix))
+	var stripes []byte
+	for block := 0; block < h.blocks; block++ {
+		stripe, d := encodeStripe(p, pix, block, cfa)
+		binary.BigEndian.PutUint32(sizes[4*block:], uint32(len(stripe)))
+		stripes = append(stripes, stripe...)
+		for y := 0; y < h.height; y++ {
+			start, end := y*h.width+block*h.blockSize, y*h.width+(block+1)*h.blockSize
+			if end > (y+1)*h.width {
+				end = (y + 1) * h.width
+			}
+			copy(decoded[start:end], d[start:end])
+		}
+	}
+	return append(append(data, sizes...), stripes...), decoded
+}
+
+// testSensorLayout directory entry of the X-Trans layout of the cameras,
+// with green at the top left
+func testSensorLayout() testDirEntry {
+	rows := [6]string{"GGRGGB", "GGBGGR", "BRGRBG", "GGBGGR", "GGRGGB", "RBGBRG"}
+	colors := map[byte]byte{'R': 0, 'G': 1, 'B': 2}
+	layout := make([]byte, 36)
+	for i := range layout {
+		layout[35-i] = colors[rows[i/6][i%6]]
+	}
+	return testDirEntry{tag: 0x131, data: layout}
+}
+
+// testPixels gradients with noise and a few jumps
+func testPixels(width, height, max int) []uint16 {
+	pix := make([]uint16, width*height)
+	for y := 0; y < height; y++ {
+		for x := range pix[y*width : (y+1)*width] {
+			v := 200 + x*3 + y*50 + (x*7919+y*104729)%97
+			if x%100 > 90 {
+				v += 3000
+			}
+			pix[y*width+x] = uint16(v % max)
+		}
+	}
+	return pix
+}
+
+func TestParseCompressedHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	h := &compressedHeader{rawType: 16, bits: 14, height: 12, roundedWidth: 1536, width: 1512, blockSize: 768, blocks: 2, lines: 2}
+	data, _ := testCompressed(h, make([]uint16, 1512*12), common.CFARGGB)
+	assert.Equal(h, parseCompressedHeader(data))
+
+	data[13] = 3
+	assert.Nil(parseCompressedHeader(data))
+	assert.Nil(parseCompressedHeader(data[:10]))
+}
+
+func TestDecodeCompressed(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// X-Trans, one stripe
+	header := []testDirEntry{{tag: 0x100, value: []uint16{12, 768}}, testSensorLayout()}
+	h, err := ParseFuji(bytes.NewReader(testRAFFile(header, make([]byte, 4))))
+	require.Nil(err)
+	c := &compressedHeader{rawType: 16, bits: 14, height: 12, roundedWidth: 768, width: 768, blockSize: 768, blocks: 1, lines: 2}
+	pix := testPixels(768, 12, 1<<14)
+	raw, decoded := testCompressed(c, pix, h.cfa())
+	assert.Equal(pix, decoded)
+
+	data := testRAFFile(header, testFujiTiff(768, 12, 14, 1024, raw))
+	img, err := Decode(bytes.NewReader(data), int64(len(data)))
+	require.Nil(err)
+	assert.Equal(768, img.Width)
+	assert.Equal(12, img.Height)
+	assert.Equal(uint16(0x3fff), img.WhiteLevel)
+	assert.Equal(6, img.CFA.Width)
+	assert.Equal(decoded, img.Pix)
+
+	// Bayer, two stripes, the last one narrower
+	c = &compressedHeader{rawType: 0, bits: 12, height: 6, roundedWidth: 1536, width: 1512, blockSize: 768, blocks: 2, lines: 1}
+	pix = testPixels(1512, 6, 1<<12)
+	raw, decoded = testCompressed(c, pix, common.CFARGGB)
+	assert.Equal(pix, decoded)
+	header = []testDirEntry{{tag: 0x100, value: []uint16{6, 1512}}}
+	data = testRAFFile(header, testFujiTiff(1512, 6, 12, 256, raw))
+	img, err = Decode(bytes.NewReader(data), int64(len(data)))
+	require.Nil(err)
+	assert.Equal(uint16(0xfff), img.WhiteLevel)
+	assert.Equal(pix, img.Pix)
+
+	// the stripes are truncated
+	data = testRAFFile(header, testFujiTiff(1512, 6, 12, 256, raw[:len(raw)-500]))
+	_, err = Decode(bytes.NewReader(data), int64(len(data)))
+	assert.NotNil(err)
+}
+
+func TestPredict(t *testing.T) {
+	assert := assert.New(t)
+
+	p := newCompressedParams(&compressedHeader{rawType: 0, bits: 12, blockSize: 768})
+	s := newStripe(p, nil)
+	set := func(line, pos int, v uint16) { s.buf[s.index(line, pos)] = v }
+	set(lineG2, 4, 90)
+	set(lineG3, 3, 40)
+	set(lineG3, 4, 100)
+	set(lineG3, 5, 110)
+	set(lineG4, 3, 50)
+	set(lineG4, 5, 70)
+
+	// rc the farthest from rb: (rf + rd + 2 rb) / 4, gradient 9*1 - 2
+	predicted, grad := s.predictEven(s.index(lineG4, 4))
+	assert.Equal(400, predicted)
+	assert.Equal(7, grad)
+
+	// rb between rc and rd: (ra + rg) / 2, gradient 9*2 - 1
+	predicted, grad = s.predictOdd(s.index(lineG4, 4))
+	assert.Equal(60, predicted)
+	assert.Equal(17, grad)
+
+	// rb above rc and rd: (ra + rg + 2 rb) / 4
+	set(lineG3, 5, 80)
+	predicted, _ = s.predictOdd(s.index(lineG4, 4))
+	assert.Equal(80, predicted)
+}
+
+func TestDecodeStripeCodes(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// one block of 6 rows of zeros, but the last two samples. The codes of
+	// the zeros are a one and the remainder bits, 6 for the first sample of
+	// a context and fewer as its count grows
+	zeroBits := func(count int) uint {
+		switch {
+		case count == 1:
+			return 6
+		case count < 4:
+			return 5
+		case count < 8:
+			return 4
+		case count < 16:
+			return 3
+		case count < 32:
+			return 2
+		case count < 64:
+			return 1
+		}
+		return 0
+	}
+	// counts of the even and odd contexts of the 3 sets
+	var counts [2][3]int
+	bw := &commontest.BitWriter{}
+	zero := func(odd, set int) {
+		counts[odd][set]++
+		n := zeroBits(counts[odd][set])
+		bw.Write(1<<n, n+1)
+	}
+	// each pass codes two lines of 384 samples, the odd samples 5 even
+	// samples behind, up to the last two samples of the last pass
+	for pass := 0; pass < 6; pass++ {
+		set := pass % 3
+		for i := 0; i < 4; i++ {
+			zero(0, set)
+			zero(0, set)
+		}
+		for i := 0; i < 188; i++ {
+			zero(0, set)
+			zero(0, set)
+			zero(1, set)
+			zero(1, set)
+		}
+		for i := 0; i < 4; i++ {
+			if pass == 5 && i == 3 {
+				break
+			}
+			zero(1, set)
+			zero(1, set)
+		}
+	}
+	// the last two samples: G7 383, -2 coded 3 without remainder
+	bw.Write(1, 4)
+	// B4 383, 1234 coded 2468 with the escape code
+	bw.Write(1, 36)
+	bw.Write(2467, 12)
+
+	h := &compressedHeader{rawType: 0, bits: 12, height: 6, roundedWidth: 768, width: 768, blockSize: 768, blocks: 1, lines: 1}
+	pix := make([]uint16, 768*6)
+	require.Nil(newCompressedParams(h).decodeStripe(bw.Bytes(), 0, pix, common.CFARGGB))
+	want := make([]uint16, 768*6)
+	// -2 wrapped
+	want[5*768+766] = 4094
+	want[5*768+767] = 1234
+	assert.Equal(want, pix)
+}
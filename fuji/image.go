@@ -0,0 +1,52 @@
+package fuji
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+
+	"github.com/enricod/rawmgr/common"
+)
+
+// format name used in the errors
+const format = "RAF"
+
+func init() {
+	image.RegisterFormat("raf", "FUJIFILM", decodeReader, decodeConfigReader)
+}
+
+// DecodeConfig returns the size of the raw image of the RAF file in r, size
+// bytes long, reading only the RAF header
+func DecodeConfig(r io.ReaderAt, size int64) (image.Config, error) {
+	h, err := ParseFuji(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return image.Config{}, err
+	}
+	if h.RawWidth == 0 || h.RawHeight == 0 {
+		return image.Config{}, common.NewFormatError(format, h.Meta.Offset, "raw size not found")
+	}
+	return image.Config{ColorModel: color.Gray16Model, Width: h.RawWidth, Height: h.RawHeight}, nil
+}
+
+// decodeReader and decodeConfigReader are used by image.Decode
+func decodeReader(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	img, err := Decode(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+func decodeConfigReader(r io.Reader) (image.Config, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return DecodeConfig(bytes.NewReader(data), int64(len(data)))
+}
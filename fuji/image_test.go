@@ -0,0 +1,79 @@
+package fuji
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRAF RAF header with a directory holding the raw size
+func testRAF(rawWidth, rawHeight uint16) []byte {
+	data := make([]byte, 120)
+	copy(data, "FUJIFILMCCD-RAW 0201FF383501")
+	binary.BigEndian.PutUint32(data[92:], 120)
+
+	dir := make([]byte, 4+4+4)
+	binary.BigEndian.PutUint32(dir[0:], 1)
+	binary.BigEndian.PutUint16(dir[4:], 0x100)
+	binary.BigEndian.PutUint16(dir[6:], 4)
+	binary.BigEndian.PutUint16(dir[8:], rawHeight)
+	binary.BigEndian.PutUint16(dir[10:], rawWidth)
+	return append(data, dir...)
+}
+
+func TestImageDecodeConfig(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	data := testRAF(6160, 4032)
+	config, name, err := image.DecodeConfig(bytes.NewReader(data))
+	require.Nil(err)
+	assert.Equal("raf", name)
+	assert.Equal(6160, config.Width)
+	assert.Equal(4032, config.Height)
+
+	_, err = DecodeConfig(bytes.NewReader(data[:len(data)-2]), int64(len(data)-2))
+	assert.NotNil(err)
+
+	_, _, err = image.Decode(bytes.NewReader(data))
+	assert.NotNil(err)
+}
+
+func TestReadMetadata(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// JPEG preview with the EXIF: IFD0 with the model and an EXIF IFD with the ISO
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8,
+		0, 2,
+		0x01, 0x10, 0, 2, 0, 0, 0, 4, 'X', '-', 'T', 0,
+		0x87, 0x69, 0, 4, 0, 0, 0, 1, 0, 0, 0, 38,
+		0, 0, 0, 0,
+		0, 1,
+		0x88, 0x27, 0, 3, 0, 0, 0, 1, 0x0c, 0x80, 0, 0,
+		0, 0, 0, 0}
+	app1 := append([]byte{0xff, 0xd8, 0xff, 0xe1, 0, byte(len(tiff) + 8), 'E', 'x', 'i', 'f', 0, 0}, tiff...)
+
+	data := testRAF(6160, 4032)
+	binary.BigEndian.PutUint32(data[84:], uint32(len(data)))
+	binary.BigEndian.PutUint32(data[88:], uint32(len(app1)))
+	data = append(data, app1...)
+
+	m, err := ReadMetadata(bytes.NewReader(data))
+	require.Nil(err)
+	assert.Equal("X-T", m.Model)
+	assert.Equal(3200, m.ISO)
+
+	exif, err := ReadExif(bytes.NewReader(data))
+	require.Nil(err)
+	assert.Equal(binary.BigEndian, exif.Order)
+	assert.Equal(int64(len(data)-len(tiff)), exif.Base)
+	assert.Equal(int64(3200), exif.Ifd.Entry(0x8827).Int(0))
+
+	_, err = ReadMetadata(bytes.NewReader(data[:len(data)-10]))
+	assert.NotNil(err)
+}
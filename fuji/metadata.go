@@ -0,0 +1,64 @@
+package fuji
+
+import (
+	"io"
+
+	"github.com/enricod/rawmgr/common"
+)
+
+// ReadMetadata reads the EXIF of the RAF file in r, stored in the APP1
+// segment of the JPEG preview
+func ReadMetadata(r io.ReaderAt) (common.Metadata, error) {
+	_, ifd0, err := readExifIfd0(r)
+	if err != nil {
+		return common.Metadata{}, err
+	}
+	if e := ifd0.Entry(common.TagExifIFD); e != nil && e.SubErr != nil {
+		return common.Metadata{}, e.SubErr
+	}
+	return common.ReadMetadata(ifd0), nil
+}
+
+// ReadExif reads the EXIF IFD of the RAF file in r, with the maker note
+func ReadExif(r io.ReaderAt) (*common.Exif, error) {
+	reader, ifd0, err := readExifIfd0(r)
+	if err != nil {
+		return nil, err
+	}
+	return reader.Exif(ifd0)
+}
+
+// readExifIfd0 reads IFD0 of the EXIF in the JPEG preview, with the reader
+// of its TIFF structure
+func readExifIfd0(r io.ReaderAt) (*common.TiffReader, *common.Ifd, error) {
+	jpegOffset, _, err := common.GetUint32(r, 84)
+	if err != nil {
+		return nil, nil, err
+	}
+	// SOI, APP1 marker and length, "Exif\0\0"
+	app1, err := readBytes(r, int64(jpegOffset), 12)
+	if err != nil {
+		return nil, nil, err
+	}
+	if app1[0] != 0xff || app1[1] != 0xd8 || app1[2] != 0xff || app1[3] != 0xe1 || string(app1[6:10]) != "Exif" {
+		return nil, nil, common.NewFormatError(format, int64(jpegOffset), "EXIF not found in the JPEG preview")
+	}
+	reader, err := common.NewTiffReader(r, int64(jpegOffset)+12)
+	if err != nil {
+		return nil, nil, err
+	}
+	ifd0, err := reader.ReadIfd(reader.First)
+	if err != nil {
+		return nil, nil, err
+	}
+	return reader, ifd0, nil
+}
+
+// readBytes reads n bytes at offset
+func readBytes(r io.ReaderAt, offset int64, n int) ([]byte, error) {
+	b := make([]byte, n)
+	if err := common.ReadFull(format, r, offset, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
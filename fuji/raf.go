@@ -0,0 +1,279 @@
+package fuji
+
+import (
+	"encoding/binary"
+	"image"
+	"io"
+	"strings"
+
+	"github.com/enricod/rawmgr/common"
+)
+
+// RAF directory tags
+const (
+	tagRawImageFullSize    = 0x100
+	tagRawImageCropTopLeft = 0x110
+	tagRawImageCroppedSize = 0x111
+	tagRawImageAspectRatio = 0x115
+	tagRawImageSize        = 0x121
+	tagFujiLayout          = 0x130
+	tagXTransLayout        = 0x131
+	tagWBLevels            = 0x2ff0
+	tagRAFData             = 0xc000
+)
+
+// Fuji IFD tags, in the TIFF at the start of the CFA section
+const (
+	tagFujiIFD       = 0xf000
+	tagRawWidth      = 0xf001
+	tagRawHeight     = 0xf002
+	tagBitsPerSample = 0xf003
+	tagStripOffsets  = 0xf007
+	tagStripBytes    = 0xf008
+	tagBlackLevel    = 0xf00a
+	tagWBGRBLevels   = 0xf00e
+)
+
+// rafHeaderSize bytes of the header read, up to the CFA section position
+const rafHeaderSize = 108
+
+// maxDirectoryEntries directories with more entries are not valid
+const maxDirectoryEntries = 255
+
+// Section position of a part of the RAF file
+type Section struct {
+	Offset int64
+	Length int64
+}
+
+// RAFHeader values of the RAF header, of its directory and of the Fuji IFD
+// at the start of the CFA section
+type RAFHeader struct {
+	// FormatVersion of the file, as "0201"
+	FormatVersion string
+	// CameraID number of the camera model, as "FF383501"
+	CameraID string
+	// Model name of the camera
+	Model string
+	// Firmware version of the camera firmware, as "0100"
+	Firmware string
+	// Jpeg preview with the EXIF, Meta the RAF directory and CFA the raw data
+	Jpeg Section
+	Meta Section
+	CFA  Section
+
+	// RawWidth and RawHeight size of the raw data, tag 0x100
+	RawWidth  int
+	RawHeight int
+	// Crop visible part of the raw image, tags 0x110 and 0x111. Empty if
+	// not known
+	Crop image.Rectangle
+	// Width and Height of the image, tag 0x121
+	Width  int
+	Height int
+	// AspectWidth and AspectHeight aspect ratio of the image as 3:2, tag
+	// 0x115
+	AspectWidth  int
+	AspectHeight int
+	// FujiLayout and FujiWidth of the SuperCCD sensors, tag 0x130: as
+	// dcraw, FujiWidth is true if the sensor is rotated by 45 degrees
+	FujiLayout bool
+	FujiWidth  bool
+	// XTrans reports whether XTransLayout holds the 6x6 pattern of the
+	// sensor, tag 0x131, with the colors of common
+	XTrans       bool
+	XTransLayout [6][6]uint8
+	// WB white balance levels of the shot, R G G B, zero if not known
+	WB [4]int
+
+	// BitsPerSample and BlackLevel of the raw data, from the Fuji IFD.
+	// Zero and nil if the CFA section has no TIFF
+	BitsPerSample int
+	BlackLevel    []int
+	// Raw position of the raw data, from the Fuji IFD. The whole CFA
+	// section if the CFA section has no TIFF
+	Raw Section
+	// RawOrder byte order of the 16 bits samples: of the TIFF, big endian
+	// if the CFA section has no TIFF
+	RawOrder binary.ByteOrder
+}
+
+// ParseFuji reads the RAF header in r, with the directory and the Fuji IFD
+func ParseFuji(r io.ReaderAt) (*RAFHeader, error) {
+	head, err := readBytes(r, 0, rafHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	if string(head[:8]) != "FUJIFILM" {
+		return nil, common.NewFormatError(format, 0, "FUJIFILM header not found")
+	}
+	text := func(start, end int) string {
+		return strings.TrimSpace(strings.TrimRight(string(head[start:end]), "\x00"))
+	}
+	section := func(at int) Section {
+		return Section{
+			Offset: int64(binary.BigEndian.Uint32(head[at:])),
+			Length: int64(binary.BigEndian.Uint32(head[at+4:])),
+		}
+	}
+	h := &RAFHeader{
+		FormatVersion: text(16, 20),
+		CameraID:      text(20, 28),
+		Model:         text(28, 60),
+		Firmware:      text(60, 64),
+		Jpeg:          section(84),
+		Meta:          section(92),
+		CFA:           section(100),
+		RawOrder:      binary.BigEndian,
+	}
+	if err := h.readDirectory(r, h.Meta.Offset); err != nil {
+		return nil, err
+	}
+	if err := h.readFujiIFD(r); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// readDirectory reads the RAF directory at offset: the entries count, then
+// tag, length and value of each entry, big endian
+func (h *RAFHeader) readDirectory(r io.ReaderAt, offset int64) error {
+	b, err := readBytes(r, offset, 4)
+	if err != nil {
+		return err
+	}
+	entries := binary.BigEndian.Uint32(b)
+	if entries > maxDirectoryEntries {
+		return common.NewFormatError(format, offset, "%d directory entries not valid", entries)
+	}
+	pos := offset + 4
+	for i := 0; i < int(entries); i++ {
+		b, err := readBytes(r, pos, 4)
+		if err != nil {
+			return err
+		}
+		tag, length := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		value, err := readBytes(r, pos+4, length)
+		if err != nil {
+			return err
+		}
+		h.readEntry(tag, value)
+		pos += 4 + int64(length)
+	}
+	return nil
+}
+
+// readEntry sets the values of a directory entry, the entries too short are
+// ignored
+func (h *RAFHeader) readEntry(tag uint16, v []byte) {
+	u16 := func(i int) int { return int(binary.BigEndian.Uint16(v[2*i:])) }
+	switch {
+	case tag == tagRawImageFullSize && len(v) >= 4:
+		h.RawHeight, h.RawWidth = u16(0), u16(1)
+	case tag == tagRawImageCropTopLeft && len(v) >= 4:
+		min := image.Pt(u16(1), u16(0))
+		h.Crop = image.Rectangle{Min: min, Max: min.Add(h.Crop.Size())}
+	case tag == tagRawImageCroppedSize && len(v) >= 4:
+		h.Crop.Max = h.Crop.Min.Add(image.Pt(u16(1), u16(0)))
+	case tag == tagRawImageAspectRatio && len(v) >= 4:
+		h.AspectHeight, h.AspectWidth = u16(0), u16(1)
+	case tag == tagRawImageSize && len(v) >= 4:
+		h.Height, h.Width = u16(0), u16(1)
+		if h.Width == 4284 {
+			// as dcraw
+			h.Width += 3
+		}
+	case tag == tagFujiLayout && len(v) >= 2:
+		h.FujiLayout = v[0]>>7 != 0
+		h.FujiWidth = v[1]&8 == 0
+	case tag == tagXTransLayout && len(v) >= 36 && validLayout(v[:36]):
+		// stored from the last photosite, 0 red, 1 green and 2 blue
+		h.XTrans = true
+		for i := 0; i < 36; i++ {
+			h.XTransLayout[5-i/6][5-i%6] = v[i]
+		}
+	case tag == tagWBLevels && len(v) >= 8:
+		// G R G B
+		h.WB = [4]int{u16(1), u16(0), u16(2), u16(3)}
+	case tag == tagRAFData && len(v) > 20000:
+		// little endian: the width is the first value not greater than
+		// the raw width, followed by the height
+		for i := 0; i+8 <= len(v); i += 4 {
+			if w := int(binary.LittleEndian.Uint32(v[i:])); w <= h.RawWidth {
+				h.Width, h.Height = w, int(binary.LittleEndian.Uint32(v[i+4:]))
+				break
+			}
+		}
+	}
+}
+
+// validLayout checks the colors of the X-Trans layout, a layout with other
+// values is ignored
+func validLayout(v []byte) bool {
+	for _, c := range v {
+		if c > common.Blue {
+			return false
+		}
+	}
+	return true
+}
+
+// readFujiIFD reads the Fuji IFD of the TIFF at the start of the CFA
+// section, pointed by tag 0xf000 of IFD0. Older cameras have no TIFF and
+// the raw data is the whole CFA section
+func (h *RAFHeader) readFujiIFD(r io.ReaderAt) error {
+	h.Raw = h.CFA
+	head, err := readBytes(r, h.CFA.Offset, 4)
+	if err != nil {
+		return err
+	}
+	if s := string(head); s != "II*\x00" && s != "MM\x00*" {
+		return nil
+	}
+	reader, err := common.NewTiffReader(r, h.CFA.Offset)
+	if err != nil {
+		return err
+	}
+	reader.SubIfdTags[tagFujiIFD] = true
+	h.RawOrder = reader.Order
+	ifd0, err := reader.ReadIfd(reader.First)
+	if err != nil {
+		return err
+	}
+	e := ifd0.Entry(tagFujiIFD)
+	if e == nil {
+		return nil
+	}
+	for _, ifd := range e.Sub {
+		for i := range ifd.Entries {
+			e := &ifd.Entries[i]
+			if e.Len() == 0 {
+				continue
+			}
+			switch e.Tag {
+			case tagRawWidth:
+				h.RawWidth = int(e.Int(0))
+			case tagRawHeight:
+				h.RawHeight = int(e.Int(0))
+			case tagBitsPerSample:
+				h.BitsPerSample = int(e.Int(0))
+			case tagStripOffsets:
+				h.Raw.Offset = h.CFA.Offset + e.Int(0)
+			case tagStripBytes:
+				h.Raw.Length = e.Int(0)
+			case tagBlackLevel:
+				h.BlackLevel = make([]int, e.Len())
+				for k := range h.BlackLevel {
+					h.BlackLevel[k] = int(e.Int(k))
+				}
+			case tagWBGRBLevels:
+				if h.WB == [4]int{} && e.Len() >= 3 {
+					// G R B
+					g := int(e.Int(0))
+					h.WB = [4]int{int(e.Int(1)), g, g, int(e.Int(2))}
+				}
+			}
+		}
+	}
+	return nil
+}
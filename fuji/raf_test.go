@@ -0,0 +1,150 @@
+package fuji
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testDirEntry struct {
+	tag   uint16
+	value []uint16
+	data  []byte
+}
+
+// testDirectory RAF directory of the entries, big endian
+func testDirectory(entries []testDirEntry) []byte {
+	dir := make([]byte, 4)
+	binary.BigEndian.PutUint32(dir, uint32(len(entries)))
+	for _, e := range entries {
+		value := e.data
+		for _, v := range e.value {
+			value = append(value, byte(v>>8), byte(v))
+		}
+		dir = append(dir, byte(e.tag>>8), byte(e.tag), byte(len(value)>>8), byte(len(value)))
+		dir = append(dir, value...)
+	}
+	return dir
+}
+
+// testFujiTiff TIFF of the CFA section with IFD0 pointing to the Fuji IFD,
+// little endian, followed by the raw data
+func testFujiTiff(width, height, bits, black uint32, raw []byte) []byte {
+	entry := func(tag, typ uint16, count, value uint32) []byte {
+		b := make([]byte, 12)
+		binary.LittleEndian.PutUint16(b, tag)
+		binary.LittleEndian.PutUint16(b[2:], typ)
+		binary.LittleEndian.PutUint32(b[4:], count)
+		binary.LittleEndian.PutUint32(b[8:], value)
+		return b
+	}
+	// header, IFD0 at 8 with one entry, the Fuji IFD at 26 with 6 entries
+	data := []byte{'I', 'I', 42, 0, 8, 0, 0, 0, 1, 0}
+	data = append(data, entry(0xf000, 13, 1, 26)...)
+	data = append(data, 0, 0, 0, 0)
+	rawOffset := uint32(26 + 2 + 6*12 + 4 + 16)
+	data = append(data, 6, 0)
+	data = append(data, entry(0xf001, 4, 1, width)...)
+	data = append(data, entry(0xf002, 4, 1, height)...)
+	data = append(data, entry(0xf003, 4, 1, bits)...)
+	data = append(data, entry(0xf007, 4, 1, rawOffset)...)
+	data = append(data, entry(0xf008, 4, 1, uint32(len(raw)))...)
+	data = append(data, entry(0xf00a, 4, 4, 26+2+6*12+4)...)
+	data = append(data, 0, 0, 0, 0)
+	levels := make([]byte, 16)
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint32(levels[4*i:], black+uint32(i))
+	}
+	data = append(data, levels...)
+	return append(data, raw...)
+}
+
+// testRAFFile RAF with the preview, the directory and the CFA section
+func testRAFFile(entries []testDirEntry, cfa []byte) []byte {
+	data := make([]byte, 160)
+	copy(data, "FUJIFILMCCD-RAW 0201FF383501X-T2")
+	copy(data[60:], "0100")
+	jpeg := []byte{0xff, 0xd8, 0xff, 0xd9}
+	dir := testDirectory(entries)
+	put := func(at, offset, length int) {
+		binary.BigEndian.PutUint32(data[at:], uint32(offset))
+		binary.BigEndian.PutUint32(data[at+4:], uint32(length))
+	}
+	put(84, len(data), len(jpeg))
+	put(92, len(data)+len(jpeg), len(dir))
+	put(100, len(data)+len(jpeg)+len(dir), len(cfa))
+	data = append(append(append(data, jpeg...), dir...), cfa...)
+	return data
+}
+
+func testXTransEntries() []testDirEntry {
+	layout := []byte("\x01\x01\x00\x01\x01\x02" + "\x01\x01\x02\x01\x01\x00" + "\x02\x00\x01\x00\x02\x01" +
+		"\x01\x01\x02\x01\x01\x00" + "\x01\x01\x00\x01\x01\x02" + "\x00\x02\x01\x02\x00\x01")
+	return []testDirEntry{
+		{tag: 0x100, value: []uint16{4, 6}},
+		{tag: 0x110, value: []uint16{0, 1}},
+		{tag: 0x111, value: []uint16{4, 5}},
+		{tag: 0x115, value: []uint16{2, 3}},
+		{tag: 0x121, value: []uint16{4, 5}},
+		{tag: 0x130, data: []byte{0, 8, 0, 0}},
+		{tag: 0x131, data: layout},
+		{tag: 0x2ff0, value: []uint16{302, 500, 302, 700}},
+	}
+}
+
+func TestParseFuji(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	raw := make([]byte, 6*4*2)
+	data := testRAFFile(testXTransEntries(), testFujiTiff(6, 4, 14, 1024, raw))
+	h, err := ParseFuji(bytes.NewReader(data))
+	require.Nil(err)
+	assert.Equal("0201", h.FormatVersion)
+	assert.Equal("FF383501", h.CameraID)
+	assert.Equal("X-T2", h.Model)
+	assert.Equal("0100", h.Firmware)
+	assert.Equal(Section{Offset: 160, Length: 4}, h.Jpeg)
+	assert.Equal(int64(164), h.Meta.Offset)
+
+	assert.Equal(6, h.RawWidth)
+	assert.Equal(4, h.RawHeight)
+	assert.Equal(image.Rect(1, 0, 6, 4), h.Crop)
+	assert.Equal(5, h.Width)
+	assert.Equal(3, h.AspectWidth)
+	assert.Equal(2, h.AspectHeight)
+	assert.False(h.FujiLayout)
+	assert.False(h.FujiWidth)
+	require.True(h.XTrans)
+	// the layout is stored from the last photosite
+	assert.Equal([6]uint8{1, 0, 2, 1, 2, 0}, h.XTransLayout[0])
+	assert.Equal([6]uint8{2, 1, 1, 0, 1, 1}, h.XTransLayout[5])
+	assert.Equal([4]int{500, 302, 302, 700}, h.WB)
+
+	// a layout with a value that is not a color is ignored
+	entries := testXTransEntries()
+	entries[6].data = append([]byte{3}, entries[6].data[1:]...)
+	bad, err := ParseFuji(bytes.NewReader(testRAFFile(entries, testFujiTiff(6, 4, 14, 1024, raw))))
+	require.Nil(err)
+	assert.False(bad.XTrans)
+	assert.Equal([6][6]uint8{}, bad.XTransLayout)
+
+	assert.Equal(14, h.BitsPerSample)
+	assert.Equal([]int{1024, 1025, 1026, 1027}, h.BlackLevel)
+	assert.Equal(h.CFA.Offset+h.CFA.Length-int64(len(raw)), h.Raw.Offset)
+	assert.Equal(int64(len(raw)), h.Raw.Length)
+
+	// older files: no TIFF in the CFA section
+	data = testRAFFile(testXTransEntries()[:1], raw)
+	h, err = ParseFuji(bytes.NewReader(data))
+	require.Nil(err)
+	assert.Equal(h.CFA, h.Raw)
+	assert.Equal(0, h.BitsPerSample)
+
+	_, err = ParseFuji(bytes.NewReader(data[:170]))
+	assert.NotNil(err)
+}
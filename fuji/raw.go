@@ -0,0 +1,270 @@
+package fuji
+
+import (
+	"encoding/binary"
+	"image"
+	"io"
+	"math"
+
+	"github.com/enricod/rawmgr/common"
+)
+
+// defaultBitsPerSample of the raw data of the files with no Fuji IFD
+const defaultBitsPerSample = 14
+
+// Decode reads the raw image of the RAF file in r, size bytes long
+func Decode(r io.ReaderAt, size int64) (*common.RawImage, error) {
+	r = io.NewSectionReader(r, 0, size)
+	h, err := ParseFuji(r)
+	if err != nil {
+		return nil, err
+	}
+	img, err := decodeRaw(r, size, h)
+	if err != nil {
+		return nil, err
+	}
+	// the EXIF of the preview is optional
+	if m, err := ReadMetadata(r); err == nil {
+		img.Metadata = m
+	}
+	img.Orientation = img.Metadata.Orientation
+	if img.Orientation == 0 {
+		img.Orientation = 1
+	}
+	return img, nil
+}
+
+// decodeRaw reads the raw data of the CFA section: 16 bits samples, 14
+// bits packed or lossless compressed. The images of the rotated SuperCCD
+// sensors are turned back on the Bayer grid. size is the size of the file
+func decodeRaw(r io.ReaderAt, size int64, h *RAFHeader) (*common.RawImage, error) {
+	width, height := h.RawWidth, h.RawHeight
+	if width <= 0 || height <= 0 {
+		return nil, common.NewFormatError(format, h.Meta.Offset, "raw size not found")
+	}
+	if h.Raw.Offset < 0 || h.Raw.Length < 0 || h.Raw.Length > size-h.Raw.Offset {
+		return nil, common.WrapFormatError(format, h.Raw.Offset, common.ErrTruncated, "raw data of %d bytes outside of the file (%d bytes)", h.Raw.Length, size)
+	}
+	bits := h.BitsPerSample
+	if bits == 0 {
+		bits = defaultBitsPerSample
+	}
+	cfa := h.cfa()
+	samples := int64(width * height)
+	var pix []uint16
+	switch length := h.Raw.Length; {
+	case h.BitsPerSample == 0 || length == samples*2:
+		if samples*2 > length {
+			return nil, common.WrapFormatError(format, h.Raw.Offset, common.ErrTruncated, "%d bytes of raw data for %dx%d samples", length, width, height)
+		}
+		data, err := readBytes(r, h.Raw.Offset, int(samples*2))
+		if err != nil {
+			return nil, err
+		}
+		pix = unpackRaw16(data, h.RawOrder)
+	case bits == 14 && width%4 == 0 && length == samples*14/8:
+		// rows of whole bytes only, the unpacking reads 7 bytes for 4 samples
+		data, err := readBytes(r, h.Raw.Offset, int(length))
+		if err != nil {
+			return nil, err
+		}
+		pix = unpackRaw14(data, width, height)
+	default:
+		head, err := readBytes(r, h.Raw.Offset, compressedHeaderSize)
+		if err != nil {
+			return nil, err
+		}
+		c := parseCompressedHeader(head)
+		if c == nil {
+			return nil, common.NewFormatError(format, h.Raw.Offset, "raw data not supported, %d bytes for %dx%d samples", length, width, height)
+		}
+		if pix, err = decodeCompressed(r, h.Raw.Offset, length, c, cfa); err != nil {
+			return nil, err
+		}
+		width, height, bits = c.width, c.height, c.bits
+	}
+
+	img := &common.RawImage{
+		Width:      width,
+		Height:     height,
+		Pix:        pix,
+		CFA:        cfa,
+		Colors:     1,
+		WhiteLevel: uint16(1<<uint(bits) - 1),
+	}
+	img.ActiveArea = h.activeArea()
+	if h.FujiWidth {
+		left, top, width, height := h.margins()
+		if left < 0 || top < 0 || width <= 0 || height <= 0 || left+width > img.Width || top+height > img.Height {
+			return nil, common.NewFormatError(format, h.Meta.Offset, "image %dx%d at %d,%d outside of the raw data %dx%d", width, height, left, top, img.Width, img.Height)
+		}
+		img = h.rotateSuperCCD(img)
+	}
+	applyBlackLevels(img, h.BlackLevel)
+	if green := float64(h.WB[1]+h.WB[2]) / 2; green > 0 {
+		for c, level := range h.WB {
+			img.WBMultipliers[c] = float64(level) / green
+		}
+	}
+	return img, nil
+}
+
+// cfa returns the pattern of the photosites at the origin of the raw data:
+// the X-Trans layout, else Bayer with red at the top left of the image
+func (h *RAFHeader) cfa() common.CFA {
+	if !h.XTrans {
+		area := h.activeArea()
+		return common.CFARGGB.Offset(-area.Min.X, -area.Min.Y)
+	}
+	// the layout is of the raw data, not of the active area
+	cfa := common.CFA{Width: 6, Height: 6, Pattern: make([]uint8, 36)}
+	for row := range h.XTransLayout {
+		copy(cfa.Pattern[row*6:], h.XTransLayout[row][:])
+	}
+	return cfa
+}
+
+// unpackRaw16 samples of 16 bits, in the byte order of the file
+func unpackRaw16(data []byte, order binary.ByteOrder) []uint16 {
+	pix := make([]uint16, len(data)/2)
+	for i := range pix {
+		pix[i] = order.Uint16(data[2*i:])
+	}
+	return pix
+}
+
+// unpackRaw14 samples of 14 bits packed MSB first, each row stored as
+// little endian 32 bits words: the bytes of the words are swapped before
+// unpacking
+func unpackRaw14(data []byte, width, height int) []uint16 {
+	pix := make([]uint16, width*height)
+	rowBytes := width * 14 / 8
+	buf := make([]byte, rowBytes)
+	for y := 0; y < height; y++ {
+		copy(buf, data[y*rowBytes:])
+		for i := 0; i+4 <= len(buf); i += 4 {
+			buf[i], buf[i+1], buf[i+2], buf[i+3] = buf[i+3], buf[i+2], buf[i+1], buf[i]
+		}
+		row := pix[y*width : (y+1)*width]
+		var acc uint64
+		var n uint
+		k := 0
+		for x := range row {
+			for n < 14 {
+				acc = acc<<8 | uint64(buf[k])
+				k++
+				n += 8
+			}
+			n -= 14
+			row[x] = uint16(acc >> n & 0x3fff)
+		}
+	}
+	return pix
+}
+
+// margins returns the borders of the raw data around the image, as dcraw
+func (h *RAFHeader) margins() (left, top, width, height int) {
+	width, height = h.Width, h.Height
+	if h.FujiLayout {
+		width, height = width>>1, height<<1
+	}
+	top = (h.RawHeight - height) >> 2 << 1
+	left = (h.RawWidth - width) >> 2 << 1
+	switch width {
+	case 4032, 4952, 6032, 8280:
+		left = 0
+	case 3328:
+		width, left = width-66, 34
+	case 4936:
+		left = 4
+	}
+	return left, top, width, height
+}
+
+// activeArea returns the part of the raw data of the image: the crop of the
+// directory, else the borders as dcraw. Empty if not known
+func (h *RAFHeader) activeArea() image.Rectangle {
+	raw := image.Rect(0, 0, h.RawWidth, h.RawHeight)
+	if !h.Crop.Empty() && h.Crop.In(raw) {
+		return h.Crop
+	}
+	if h.Width == 0 || h.Height == 0 || h.FujiWidth {
+		return image.Rectangle{}
+	}
+	left, top, width, height := h.margins()
+	area := image.Rect(left, top, left+width, top+height)
+	if !area.In(raw) {
+		return image.Rectangle{}
+	}
+	return area
+}
+
+// rotateSuperCCD moves the photosites of a SuperCCD sensor, on a grid
+// rotated by 45 degrees, to a Bayer grid (dcraw crop_masked_pixels). The
+// corners outside of the sensor are black
+func (h *RAFHeader) rotateSuperCCD(img *common.RawImage) *common.RawImage {
+	left, top, width, height := h.margins()
+	layout := 0
+	if h.FujiLayout {
+		layout = 1
+	}
+	fujiWidth := width >> uint(1-layout)
+	outWidth := height>>uint(layout) + fujiWidth
+	outHeight := outWidth - 1
+
+	out := *img
+	out.Width, out.Height = outWidth, outHeight
+	out.Pix = make([]uint16, outWidth*outHeight)
+	out.ActiveArea = image.Rectangle{}
+	for row := 0; row < img.Height-top*2; row++ {
+		for col := 0; col < fujiWidth<<uint(1-layout) && col+left < img.Width; col++ {
+			var r, c int
+			if layout == 1 {
+				r = fujiWidth - 1 - col + row>>1
+				c = col + (row+1)>>1
+			} else {
+				r = fujiWidth - 1 + row - col>>1
+				c = row + (col+1)>>1
+			}
+			if r >= 0 && r < outHeight && c >= 0 && c < outWidth {
+				out.Pix[r*outWidth+c] = img.Pix[(row+top)*img.Width+col+left]
+			}
+		}
+	}
+	if fujiWidth&1 == 1 {
+		out.CFA = common.CFARGGB
+	} else {
+		out.CFA = common.CFARGGB.Offset(0, 1)
+	}
+	return &out
+}
+
+// applyBlackLevels sets the black levels of the repeated pattern of levels
+// of the Fuji IFD, a square of 1, 2x2 or 6x6 levels
+func applyBlackLevels(img *common.RawImage, levels []int) {
+	side := int(math.Sqrt(float64(len(levels))))
+	if side == 0 || side*side != len(levels) {
+		return
+	}
+	var sum, count [4]int
+	total := 0
+	for i, level := range levels {
+		c := img.Channel(i%side, i/side)
+		if side == 1 {
+			c = -1
+		}
+		for k := range sum {
+			if c < 0 || c == k {
+				sum[k] += level
+				count[k]++
+			}
+		}
+		total += level
+	}
+	for c := range sum {
+		if count[c] > 0 {
+			img.ChannelBlackLevel[c] = uint16((sum[c] + count[c]/2) / count[c])
+		}
+	}
+	img.BlackLevel = uint16((total + len(levels)/2) / len(levels))
+}
@@ -0,0 +1,129 @@
+package fuji
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"testing"
+
+	"github.com/enricod/rawmgr/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeUnpacked(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	raw := make([]byte, 6*4*2)
+	for i := 0; i < 24; i++ {
+		binary.LittleEndian.PutUint16(raw[2*i:], uint16(1000+i*100))
+	}
+	data := testRAFFile(testXTransEntries(), testFujiTiff(6, 4, 14, 1024, raw))
+	img, err := Decode(bytes.NewReader(data), int64(len(data)))
+	require.Nil(err)
+	assert.Equal(6, img.Width)
+	assert.Equal(4, img.Height)
+	assert.Equal(uint16(1000), img.Pix[0])
+	assert.Equal(uint16(3300), img.Pix[23])
+	assert.Equal(uint16(0x3fff), img.WhiteLevel)
+	assert.Equal(image.Rect(1, 0, 6, 4), img.ActiveArea)
+	assert.Equal(1, img.Orientation)
+	// the X-Trans layout of the directory
+	assert.Equal(6, img.CFA.Width)
+	assert.Equal(uint8(common.Blue), img.CFA.Color(0, 2))
+	assert.Equal(uint8(common.Red), img.CFA.Color(0, 1))
+	assert.Equal(1.0, img.WBMultipliers[1])
+	assert.InDelta(1.656, img.WBMultipliers[0], 0.001)
+	assert.Equal(uint16(1026), img.BlackLevel)
+
+	_, err = Decode(bytes.NewReader(data[:len(data)-2]), int64(len(data)-2))
+	assert.True(errors.Is(err, common.ErrTruncated), "%v", err)
+
+	// raw data larger than the file is not allocated
+	data = testRAFFile(testXTransEntries(), testFujiTiff(0x8000, 0x8000, 16, 1024, raw))
+	length := bytes.Index(data, []byte{0x08, 0xf0, 4, 0, 1, 0, 0, 0})
+	require.True(length > 0)
+	binary.LittleEndian.PutUint32(data[length+8:], 0x8000*0x8000*2)
+	_, err = Decode(bytes.NewReader(data), int64(len(data)))
+	assert.True(errors.Is(err, common.ErrTruncated), "%v", err)
+
+	// SuperCCD image wider than the raw data, negative margins
+	entries := []testDirEntry{{tag: tagRawImageSize, value: []uint16{4, 20}}, {tag: tagFujiLayout, value: []uint16{0}}}
+	data = testRAFFile(entries, testFujiTiff(6, 4, 16, 1024, raw))
+	_, err = Decode(bytes.NewReader(data), int64(len(data)))
+	var formatErr *common.FormatError
+	assert.True(errors.As(err, &formatErr), "%v", err)
+}
+
+// packRaw14 packs the samples MSB first, swapping the bytes of each 32 bits
+// word as the camera does
+func packRaw14(pix []uint16) []byte {
+	var data []byte
+	var acc uint64
+	var n uint
+	for _, v := range pix {
+		acc, n = acc<<14|uint64(v), n+14
+		for ; n >= 8; n -= 8 {
+			data = append(data, byte(acc>>(n-8)))
+		}
+	}
+	for i := 0; i+4 <= len(data); i += 4 {
+		data[i], data[i+1], data[i+2], data[i+3] = data[i+3], data[i+2], data[i+1], data[i]
+	}
+	return data
+}
+
+func TestDecodePacked(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	pix := make([]uint16, 16*2)
+	for i := range pix {
+		pix[i] = uint16(i*511) & 0x3fff
+	}
+	entries := []testDirEntry{{tag: 0x100, value: []uint16{2, 16}}, {tag: 0x121, value: []uint16{2, 16}}}
+	data := testRAFFile(entries, testFujiTiff(16, 2, 14, 256, packRaw14(pix)))
+	img, err := Decode(bytes.NewReader(data), int64(len(data)))
+	require.Nil(err)
+	assert.Equal(pix, img.Pix)
+	// Bayer, red at the top left
+	assert.Equal(uint8(common.Red), img.CFA.Color(0, 0))
+	assert.Equal(uint8(common.Blue), img.CFA.Color(1, 1))
+	// levels 256-259 in R G G B order
+	assert.Equal([4]uint16{256, 257, 258, 259}, img.ChannelBlackLevel)
+
+	// neither 16 bits nor packed: compressed
+	data = testRAFFile(entries, testFujiTiff(16, 2, 14, 256, make([]byte, 40)))
+	_, err = Decode(bytes.NewReader(data), int64(len(data)))
+	assert.NotNil(err)
+
+	// 5 samples do not fill whole bytes, not unpacked as 14 bits
+	entries = []testDirEntry{{tag: 0x100, value: []uint16{4, 5}}, {tag: 0x121, value: []uint16{4, 5}}}
+	data = testRAFFile(entries, testFujiTiff(5, 4, 14, 256, make([]byte, 35)))
+	_, err = Decode(bytes.NewReader(data), int64(len(data)))
+	assert.NotNil(err)
+}
+
+func TestRotateSuperCCD(t *testing.T) {
+	assert := assert.New(t)
+
+	h := &RAFHeader{RawWidth: 8, RawHeight: 4, Width: 8, Height: 4, FujiWidth: true}
+	img := &common.RawImage{Width: 8, Height: 4, Pix: make([]uint16, 32)}
+	for i := range img.Pix {
+		img.Pix[i] = uint16(i + 1)
+	}
+	out := h.rotateSuperCCD(img)
+	assert.Equal(8, out.Width)
+	assert.Equal(7, out.Height)
+	at := func(x, y int) uint16 { return out.Pix[y*out.Width+x] }
+	assert.Equal(uint16(1), at(0, 3))
+	assert.Equal(uint16(2), at(1, 3))
+	assert.Equal(uint16(3), at(1, 2))
+	assert.Equal(uint16(9), at(1, 4))
+	assert.Equal(uint16(0), at(0, 0))
+	// fujiWidth 4: green blue in the first row
+	assert.Equal(uint8(common.Green), out.CFA.Color(0, 0))
+	assert.Equal(uint8(common.Blue), out.CFA.Color(0, 1))
+}
@@ -1,94 +1,535 @@
 package fuji
 
 import (
-	"log"
-	"os"
+	"errors"
+	"math"
 
+	"github.com/enricod/rawmgr/colors"
 	"github.com/enricod/rawmgr/common"
 )
 
-func XtransInterpolate(passes int) {
+// xtransTileSize side of the tiles interpolated independently, overlapping
+// by 16 pixels
+const xtransTileSize = 512
 
+// xtransBorder pixels at the borders interpolated from their neighbours
+const xtransBorder = 8
+
+var (
+	xtransOrth = [12]int{1, 0, 0, 1, -1, 0, 0, -1, 1, 0, 0, 1}
+	xtransPatt = [2][16]int{
+		{0, 1, 0, -1, 2, 0, -1, 0, 1, 1, 1, -1, 0, 0, 0, 0},
+		{0, 1, 0, -2, 1, 0, -2, 0, 1, 1, -2, -2, 1, -1, -1, 1},
+	}
+	// xtransDir offsets in a tile of the directions of the derivatives
+	xtransDir = [4]int{1, xtransTileSize, xtransTileSize + 1, xtransTileSize - 1}
+)
+
+// XtransInterpolate demosaics the image of an X-Trans sensor with the
+// algorithm of Frank Markesteijn, as dcraw xtrans_interpolate: 1 pass, or 3
+// passes for a better quality. The tiles of the image are interpolated in
+// parallel. Returns a RGB image of 16 bits samples with the levels of img
+func XtransInterpolate(img *common.RawImage, passes int) (*common.RawImage, error) {
+	if img.PixelSize() != 1 || img.CFA.Width != 6 || img.CFA.Height != 6 || len(img.CFA.Pattern) != 36 {
+		return nil, errors.New("not the image of an X-Trans sensor")
+	}
+	if passes < 1 {
+		return nil, errors.New("at least one pass required")
+	}
+	x := newXtrans(img, passes)
+	x.greenLimits()
+
+	type tile struct{ top, left int }
+	var tiles []tile
+	for top := 3; top < x.height-19; top += xtransTileSize - 16 {
+		for left := 3; left < x.width-19; left += xtransTileSize - 16 {
+			tiles = append(tiles, tile{top, left})
+		}
+	}
+	common.ParallelRows(len(tiles), common.WorkersCount(), func(start, end int) {
+		buf := x.newTileBuffers()
+		for _, t := range tiles[start:end] {
+			x.interpolateTile(buf, t.top, t.left)
+		}
+	})
+	x.borderInterpolate(xtransBorder)
+
+	out := *img
+	out.Colors = 3
+	out.CFA = common.CFA{}
+	out.Pix = x.out
+	return &out, nil
+}
+
+// xtrans state of the interpolation of an image
+type xtrans struct {
+	width  int
+	height int
+	cfa    common.CFA
+	// image the sample of each pixel at its color, and for the pixels not
+	// green the limits of the green around them, at 1 and 3
+	image  [][4]uint16
+	passes int
+	// ndir directions interpolated, 4 for 1 pass and 8 for more passes
+	ndir int
+	// allhex offsets of the hexagon of greens around each non green pixel
+	// and vice versa, in the image and in a tile
+	allhex [3][3][2][8]int
+	// sgrow and sgcol position of a solitary green pixel
+	sgrow int
+	sgcol int
+	lab   *common.LabConverter
+	out   []uint16
 }
 
-func ParseFuji(f *os.File, offset int64) {
-
-	var tag, len uint16
-	var start, posizione int64
-	var rawHeight, rawWidth uint16
-	var height, fujiLayout uint16
-	//var width uint32
-	//var fujiWidth uint32
-	var filters int
-	var xtransAbs [6][6]uint16
-
-	start = offset
-	entries, start := common.GetUint32(f, start)
-
-	log.Printf("entries %d \n", entries)
-	if entries < 255 {
-
-		for i := 0; i < int(entries); i++ {
-			/*
-				tag = get2();
-				len = get2();
-				save = ftell(ifp);
-			*/
-			tag, start = common.GetUint16(f, start)
-			len, start = common.GetUint16(f, start)
-			posizione = start
-			log.Printf("posizione=%d, tag=%d, len=%d", posizione, tag, len)
-			switch tag {
-			case 0x100:
-				rawHeight, start = common.GetUint16(f, start)
-				rawWidth, start = common.GetUint16(f, start)
-				log.Printf("raw_width=%d, raw_height=%d", rawHeight, rawWidth)
-
-			case 0x121:
-				height, start = common.GetUint16(f, start)
-				log.Printf("height=%d", height)
-
-			case 0x130:
-				fujiLayout, start = common.GetUint16(f, start)
-				fujiLayout = fujiLayout >> 7
-				log.Printf("fujiLayout=%d", fujiLayout)
-			//fujiWidth = !(fgetc(ifp) & 8)
-			case 0x131:
-				filters = 9
-				var val uint16
-				for r := 5; r >= 0; r-- {
-					for c := 5; c >= 0; c-- {
-						val, start = common.Get1Byte(f, start)
-						xtransAbs[r][c] = val & 3
+func newXtrans(img *common.RawImage, passes int) *xtrans {
+	x := &xtrans{
+		width:  img.Width,
+		height: img.Height,
+		cfa:    img.CFA,
+		image:  make([][4]uint16, img.Width*img.Height),
+		passes: passes,
+		ndir:   4,
+		out:    make([]uint16, img.Width*img.Height*3),
+	}
+	if passes > 1 {
+		x.ndir = 8
+	}
+	for row := 0; row < x.height; row++ {
+		for col := 0; col < x.width; col++ {
+			i := row*x.width + col
+			x.image[i][x.fcol(row, col)] = img.Pix[i]
+		}
+	}
+
+	x.lab = common.NewLabConverter(img.WhiteLevel, colors.ImageToRGB(img))
+
+	// map a green hexagon around each non green pixel and vice versa
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			g := 0
+			if x.fcol(row, col) == common.Green {
+				g = 1
+			}
+			for ng, d := 0, 0; d < 10; d += 2 {
+				if x.fcol(row+xtransOrth[d], col+xtransOrth[d+2]) == common.Green {
+					ng = 0
+				} else {
+					ng++
+				}
+				if ng == 4 {
+					x.sgrow, x.sgcol = row, col
+				}
+				if ng == g+1 {
+					for c := 0; c < 8; c++ {
+						v := xtransOrth[d]*xtransPatt[g][c*2] + xtransOrth[d+1]*xtransPatt[g][c*2+1]
+						h := xtransOrth[d+2]*xtransPatt[g][c*2] + xtransOrth[d+3]*xtransPatt[g][c*2+1]
+						x.allhex[row][col][0][c^(g*2&d)] = h + v*x.width
+						x.allhex[row][col][1][c^(g*2&d)] = h + v*xtransTileSize
 					}
 				}
-				log.Printf("filters=%d, xtransAbs=%v", filters, xtransAbs)
-				/*
-					filters = 9;
-					FORC(36) xtrans_abs[0][35-c] = fgetc(ifp) & 3;
-				*/
+			}
+		}
+	}
+	return x
+}
 
-			case 0x2ff0:
-				log.Printf("WARN tag non ancora elaborato=%d", tag)
-			case 0xc000:
-				if len > 20000 {
-					/*
-						c = order;
-						order = 0x4949;
-						while ((tag = get4()) > raw_width);
-						width = tag;
-						height = get4();
-						order = c;
-					*/
+func (x *xtrans) fcol(row, col int) int {
+	return int(x.cfa.Color(row, col))
+}
 
+// greenLimits sets the green of the non green pixels to the minimum of the
+// greens of their hexagon, and the fourth value to the maximum
+func (x *xtrans) greenLimits() {
+	for row := 2; row < x.height-2; row++ {
+		min, max := uint16(0xffff), uint16(0)
+		for col := 2; col < x.width-2; col++ {
+			if x.fcol(row, col) == common.Green {
+				min, max = 0xffff, 0
+				continue
+			}
+			pix := row*x.width + col
+			hex := &x.allhex[row%3][col%3][0]
+			if max == 0 {
+				for c := 0; c < 6; c++ {
+					val := x.image[pix+hex[c]][1]
+					if min > val {
+						min = val
+					}
+					if max < val {
+						max = val
+					}
+				}
+			}
+			x.image[pix][1] = min
+			x.image[pix][3] = max
+			// the pairs of pixels of the same hexagon share the limits
+			switch (row - x.sgrow) % 3 {
+			case 1:
+				if row < x.height-3 {
+					row++
+					col--
+				}
+			case 2:
+				min, max = 0xffff, 0
+				if col += 2; col < x.width-3 && row > 2 {
+					row--
 				}
-			default:
+			}
+		}
+	}
+}
+
+// tileBuffers buffers of the interpolation of a tile, in each direction
+type tileBuffers struct {
+	rgb  []uint16
+	lab  []int16
+	drv  []float32
+	homo []uint8
+}
+
+func (x *xtrans) newTileBuffers() *tileBuffers {
+	size := xtransTileSize * xtransTileSize
+	return &tileBuffers{
+		rgb:  make([]uint16, x.ndir*size*3),
+		lab:  make([]int16, size*3),
+		drv:  make([]float32, x.ndir*size),
+		homo: make([]uint8, x.ndir*size),
+	}
+}
+
+// interpolateTile interpolates the tile at top, left in all the directions
+// and averages the most homogeneous ones in out
+func (x *xtrans) interpolateTile(buf *tileBuffers, top, left int) {
+	const ts = xtransTileSize
+	const dirSize = ts * ts
+	rgb := buf.rgb
+	image := x.image
+	width := x.width
+	// base first direction of rgb in use
+	base := 0
+	// at returns the index in rgb of color c of the pixel at offset i
+	at := func(i, c int) int {
+		return (base*dirSize+i)*3 + c
+	}
+	// solitary reports whether row is not a row of the 2x2 blocks of greens,
+	// as an offset of the directions
+	solitary := func(row int) int {
+		if (row-x.sgrow)%3 == 0 {
+			return 1
+		}
+		return 0
+	}
+
+	mrow := minInt(top+ts, x.height-3)
+	mcol := minInt(left+ts, x.width-3)
+	for row := top; row < mrow; row++ {
+		for col := left; col < mcol; col++ {
+			p := image[row*width+col]
+			copy(rgb[at((row-top)*ts+col-left, 0):], p[:3])
+		}
+	}
+	for d := 1; d < 4; d++ {
+		copy(rgb[d*dirSize*3:(d+1)*dirSize*3], rgb[:dirSize*3])
+	}
 
+	// interpolate green horizontally, vertically, and along both diagonals
+	for row := top; row < mrow; row++ {
+		for col := left; col < mcol; col++ {
+			f := x.fcol(row, col)
+			if f == common.Green {
+				continue
 			}
+			pix := row*width + col
+			hex := &x.allhex[row%3][col%3][0]
+			green := func(i int) int { return int(image[pix+i][1]) }
+			own := func(i int) int { return int(image[pix+i][f]) }
+			var color [4]int
+			color[0] = 174*(green(hex[1])+green(hex[0])) - 46*(green(2*hex[1])+green(2*hex[0]))
+			color[1] = 223*green(hex[3]) + green(hex[2])*33 + 92*(own(0)-own(-hex[2]))
+			for c := 0; c < 2; c++ {
+				color[2+c] = 164*green(hex[4+c]) + 92*green(-2*hex[4+c]) +
+					33*(2*own(0)-own(3*hex[4+c])-own(-3*hex[4+c]))
+			}
+			for c := 0; c < 4; c++ {
+				i := ((c^solitary(row))*dirSize+(row-top)*ts+col-left)*3 + 1
+				rgb[i] = uint16(limit(color[c]>>8, int(image[pix][1]), int(image[pix][3])))
+			}
+		}
+	}
 
-			start = posizione + int64(len)
+	for pass := 0; pass < x.passes; pass++ {
+		if pass == 1 {
+			copy(rgb[4*dirSize*3:], rgb[:4*dirSize*3])
+			base = 4
+		}
+		get := func(i, c int) int { return int(rgb[at(i, c)]) }
+
+		// recalculate green from interpolated values of closer pixels
+		if pass > 0 {
+			for row := top + 2; row < mrow-2; row++ {
+				for col := left + 2; col < mcol-2; col++ {
+					f := x.fcol(row, col)
+					if f == common.Green {
+						continue
+					}
+					pix := row*width + col
+					hex := &x.allhex[row%3][col%3][1]
+					for d := 3; d < 6; d++ {
+						rix := ((d-2)^solitary(row))*dirSize + (row-top)*ts + col - left
+						val := get(rix-2*hex[d], 1) + 2*get(rix+hex[d], 1) -
+							get(rix-2*hex[d], f) - 2*get(rix+hex[d], f) + 3*get(rix, f)
+						rgb[at(rix, 1)] = uint16(limit(val/3, int(image[pix][1]), int(image[pix][3])))
+					}
+				}
+			}
 		}
 
+		// interpolate red and blue values for solitary green pixels
+		for row := (top-x.sgrow+4)/3*3 + x.sgrow; row < mrow-2; row += 3 {
+			for col := (left-x.sgcol+4)/3*3 + x.sgcol; col < mcol-2; col += 3 {
+				rix := (row-top)*ts + col - left
+				h := x.fcol(row, col+1)
+				var diff [6]float64
+				var color [3][6]int
+				for i, d := 1, 0; d < 6; d++ {
+					for c := uint(0); c < 2; c++ {
+						g := 2*get(rix, 1) - get(rix+i<<c, 1) - get(rix-i<<c, 1)
+						color[h][d] = g + get(rix+i<<c, h) + get(rix-i<<c, h)
+						if d > 1 {
+							diff[d] += float64(sqr(get(rix+i<<c, 1)-get(rix-i<<c, 1)-get(rix+i<<c, h)+get(rix-i<<c, h)) + sqr(g))
+						}
+						h ^= 2
+					}
+					if d > 1 && d&1 == 1 && diff[d-1] < diff[d] {
+						for c := 0; c < 2; c++ {
+							color[c*2][d] = color[c*2][d-1]
+						}
+					}
+					if d < 2 || d&1 == 1 {
+						for c := 0; c < 2; c++ {
+							rgb[at(rix, c*2)] = clip16(color[c*2][d] / 2)
+						}
+						rix += dirSize
+					}
+					i ^= ts ^ 1
+					h ^= 2
+				}
+			}
+		}
+
+		// interpolate red for blue pixels and vice versa
+		for row := top + 3; row < mrow-3; row++ {
+			for col := left + 3; col < mcol-3; col++ {
+				f := 2 - x.fcol(row, col)
+				if f == common.Green {
+					continue
+				}
+				rix := (row-top)*ts + col - left
+				c := 1
+				if (row-x.sgrow)%3 != 0 {
+					c = ts
+				}
+				h := 3 * (c ^ ts ^ 1)
+				for d := 0; d < 4; d++ {
+					i := h
+					g := get(rix, 1)
+					if d > 1 || (d^c)&1 == 1 ||
+						abs(g-get(rix+c, 1))+abs(g-get(rix-c, 1)) < 2*(abs(g-get(rix+h, 1))+abs(g-get(rix-h, 1))) {
+						i = c
+					}
+					rgb[at(rix, f)] = clip16((get(rix+i, f) + get(rix-i, f) + 2*g - get(rix+i, 1) - get(rix-i, 1)) / 2)
+					rix += dirSize
+				}
+			}
+		}
+
+		// fill in red and blue for 2x2 blocks of green
+		for row := top + 2; row < mrow-2; row++ {
+			if (row-x.sgrow)%3 == 0 {
+				continue
+			}
+			for col := left + 2; col < mcol-2; col++ {
+				if (col-x.sgcol)%3 == 0 {
+					continue
+				}
+				rix := (row-top)*ts + col - left
+				hex := &x.allhex[row%3][col%3][1]
+				for d := 0; d < x.ndir; d += 2 {
+					if hex[d]+hex[d+1] != 0 {
+						g := 3*get(rix, 1) - 2*get(rix+hex[d], 1) - get(rix+hex[d+1], 1)
+						for c := 0; c < 4; c += 2 {
+							rgb[at(rix, c)] = clip16((g + 2*get(rix+hex[d], c) + get(rix+hex[d+1], c)) / 3)
+						}
+					} else {
+						g := 2*get(rix, 1) - get(rix+hex[d], 1) - get(rix+hex[d+1], 1)
+						for c := 0; c < 4; c += 2 {
+							rgb[at(rix, c)] = clip16((g + get(rix+hex[d], c) + get(rix+hex[d+1], c)) / 2)
+						}
+					}
+					rix += dirSize
+				}
+			}
+		}
 	}
+	mrow -= top
+	mcol -= left
 
+	// convert to CIELab and differentiate in all directions
+	lab := buf.lab
+	drv := buf.drv
+	for d := 0; d < x.ndir; d++ {
+		for row := 2; row < mrow-2; row++ {
+			for col := 2; col < mcol-2; col++ {
+				i := (d*dirSize + row*ts + col) * 3
+				l, a, b := x.lab.Lab(rgb[i], rgb[i+1], rgb[i+2])
+				k := (row*ts + col) * 3
+				lab[k], lab[k+1], lab[k+2] = l, a, b
+			}
+		}
+		f := xtransDir[d&3]
+		for row := 3; row < mrow-3; row++ {
+			for col := 3; col < mcol-3; col++ {
+				lix := row*ts + col
+				get := func(i, c int) int { return int(lab[i*3+c]) }
+				g := 2*get(lix, 0) - get(lix+f, 0) - get(lix-f, 0)
+				drv[d*dirSize+lix] = float32(sqr(g) +
+					sqr(2*get(lix, 1)-get(lix+f, 1)-get(lix-f, 1)+g*500/232) +
+					sqr(2*get(lix, 2)-get(lix+f, 2)-get(lix-f, 2)-g*500/580))
+			}
+		}
+	}
+
+	// build homogeneity maps from the derivatives
+	homo := buf.homo
+	for i := range homo {
+		homo[i] = 0
+	}
+	for row := 4; row < mrow-4; row++ {
+		for col := 4; col < mcol-4; col++ {
+			lix := row*ts + col
+			tr := float32(math.MaxFloat32)
+			for d := 0; d < x.ndir; d++ {
+				if tr > drv[d*dirSize+lix] {
+					tr = drv[d*dirSize+lix]
+				}
+			}
+			tr *= 8
+			for d := 0; d < x.ndir; d++ {
+				for v := -1; v <= 1; v++ {
+					for h := -1; h <= 1; h++ {
+						if drv[d*dirSize+lix+v*ts+h] <= tr {
+							homo[d*dirSize+lix]++
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// average the most homogeneous pixels for the final result
+	if x.height-top < ts+4 {
+		mrow = x.height - top + 2
+	}
+	if x.width-left < ts+4 {
+		mcol = x.width - left + 2
+	}
+	var hm [8]int
+	for row := minInt(top, 8); row < mrow-8; row++ {
+		for col := minInt(left, 8); col < mcol-8; col++ {
+			lix := row*ts + col
+			for d := 0; d < x.ndir; d++ {
+				hm[d] = 0
+				for v := -2; v <= 2; v++ {
+					for h := -2; h <= 2; h++ {
+						hm[d] += int(homo[d*dirSize+lix+v*ts+h])
+					}
+				}
+			}
+			for d := 0; d < x.ndir-4; d++ {
+				if hm[d] < hm[d+4] {
+					hm[d] = 0
+				} else if hm[d] > hm[d+4] {
+					hm[d+4] = 0
+				}
+			}
+			max := hm[0]
+			for d := 1; d < x.ndir; d++ {
+				if max < hm[d] {
+					max = hm[d]
+				}
+			}
+			max -= max >> 3
+			var avg [4]int
+			for d := 0; d < x.ndir; d++ {
+				if hm[d] >= max {
+					for c := 0; c < 3; c++ {
+						avg[c] += int(rgb[(d*dirSize+lix)*3+c])
+					}
+					avg[3]++
+				}
+			}
+			o := ((row+top)*width + col + left) * 3
+			for c := 0; c < 3; c++ {
+				x.out[o+c] = uint16(avg[c] / avg[3])
+			}
+		}
+	}
+}
+
+// borderInterpolate sets the colors of the pixels at the borders to the
+// mean of the samples of each color around them
+func (x *xtrans) borderInterpolate(border int) {
+	for row := 0; row < x.height; row++ {
+		for col := 0; col < x.width; col++ {
+			if col == border && row >= border && row < x.height-border {
+				col = x.width - border
+			}
+			var sum, count [3]int
+			for y := row - 1; y <= row+1; y++ {
+				for xx := col - 1; xx <= col+1; xx++ {
+					if y >= 0 && y < x.height && xx >= 0 && xx < x.width {
+						f := x.fcol(y, xx)
+						sum[f] += int(x.image[y*x.width+xx][f])
+						count[f]++
+					}
+				}
+			}
+			f := x.fcol(row, col)
+			o := (row*x.width + col) * 3
+			for c := range sum {
+				switch {
+				case c == f:
+					x.out[o+c] = x.image[row*x.width+col][f]
+				case count[c] > 0:
+					x.out[o+c] = uint16(sum[c] / count[c])
+				}
+			}
+		}
+	}
+}
+
+func limit(v, min, max int) int {
+	if v > max {
+		v = max
+	}
+	if v < min {
+		v = min
+	}
+	return v
+}
+
+func clip16(v int) uint16 {
+	return uint16(limit(v, 0, 0xffff))
+}
+
+func sqr(v int) int {
+	return v * v
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
 }
@@ -0,0 +1,61 @@
+package fuji
+
+import (
+	"testing"
+
+	"github.com/enricod/rawmgr/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testXTransImage mosaic of a scene of smooth colors, returning the colors
+func testXTransImage(width, height int) (*common.RawImage, []uint16) {
+	layout := testSensorLayout().data
+	cfa := common.CFA{Width: 6, Height: 6, Pattern: make([]uint8, 36)}
+	for i := range layout {
+		cfa.Pattern[35-i] = layout[i]
+	}
+	img := &common.RawImage{Width: width, Height: height, Pix: make([]uint16, width*height),
+		CFA: cfa, Colors: 1, WhiteLevel: 0x3fff}
+	rgb := make([]uint16, width*height*3)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			rgb[i*3] = uint16(2000 + 5*x)
+			rgb[i*3+1] = uint16(4000 + 20*y)
+			rgb[i*3+2] = uint16(3000 + 2*x + 10*y)
+			img.Pix[i] = rgb[i*3+int(cfa.Color(y, x))]
+		}
+	}
+	return img, rgb
+}
+
+func TestXtransInterpolate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	img, rgb := testXTransImage(600, 60)
+	for _, passes := range []int{1, 3} {
+		out, err := XtransInterpolate(img, passes)
+		require.Nil(err)
+		assert.Equal(3, out.Colors)
+		assert.Equal(uint16(0x3fff), out.WhiteLevel)
+		require.Len(out.Pix, len(rgb))
+		// the corners may miss a color around them
+		far := 0
+		for y := 1; y < img.Height-1; y++ {
+			for i := (y*img.Width + 1) * 3; i < ((y+1)*img.Width-1)*3; i++ {
+				if d := int(out.Pix[i]) - int(rgb[i]); d > 40 || d < -40 {
+					far++
+				}
+			}
+		}
+		assert.Zero(far, "%d passes", passes)
+		// the samples are kept
+		assert.Equal(img.Pix[0], out.Pix[int(img.CFA.Color(0, 0))])
+	}
+
+	img.CFA = common.CFARGGB
+	_, err := XtransInterpolate(img, 1)
+	assert.NotNil(err)
+}
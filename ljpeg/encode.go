@@ -0,0 +1,225 @@
+package ljpeg
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// Encode writes pix as a lossless JPEG of width x height pixels of
+// components interleaved samples of precision bits, as the DNG files: one
+// scan with predictor 1 and a Huffman table built for the image, shared by
+// the components
+func Encode(w io.Writer, pix []uint16, width, height, components, precision int) error {
+	switch {
+	case width <= 0 || height <= 0 || width > 0xffff || height > 0xffff:
+		return errors.New("ljpeg: image size not valid")
+	case components < 1 || components > 4:
+		return errors.New("ljpeg: components not valid")
+	case precision < 2 || precision > 16:
+		return errors.New("ljpeg: precision not valid")
+	case len(pix) < width*height*components:
+		return errors.New("ljpeg: not enough samples")
+	}
+	e := &encoder{pix: pix, rowSize: width * components, height: height, components: components, precision: precision}
+
+	var freq [17]int
+	e.diffs(func(n uint, _ int32) { freq[n]++ })
+	counts, values := huffLengths(freq)
+	codes, lengths := e.codes(counts, values)
+
+	out := []byte{0xff, 0xd8}
+	sof := []byte{byte(precision), byte(height >> 8), byte(height), byte(width >> 8), byte(width), byte(components)}
+	for c := 0; c < components; c++ {
+		sof = append(sof, byte(c+1), 0x11, 0)
+	}
+	out = appendSegment(out, markerSOF3, sof)
+	out = appendSegment(out, markerDHT, append(append([]byte{0}, counts[:]...), values...))
+	sos := []byte{byte(components)}
+	for c := 0; c < components; c++ {
+		sos = append(sos, byte(c+1), 0)
+	}
+	// predictor 1, no point transform
+	out = appendSegment(out, markerSOS, append(sos, 1, 0, 0))
+
+	e.out = out
+	e.diffs(func(n uint, diff int32) {
+		e.write(codes[n], lengths[n])
+		if n == 0 || n == 16 {
+			return
+		}
+		if diff < 0 {
+			diff--
+		}
+		e.write(uint32(diff)&(1<<n-1), n)
+	})
+	e.flush()
+	e.out = append(e.out, 0xff, 0xd9)
+	_, err := w.Write(e.out)
+	return err
+}
+
+// encoder state of Encode: the samples and the entropy coded data
+type encoder struct {
+	pix        []uint16
+	rowSize    int
+	height     int
+	components int
+	precision  int
+
+	out   []byte
+	acc   uint64
+	nbits uint
+}
+
+// diffs calls fn with the length and the value of the difference of each
+// sample from its prediction, in the order of the scan
+func (e *encoder) diffs(fn func(n uint, diff int32)) {
+	for y := 0; y < e.height; y++ {
+		row := e.pix[y*e.rowSize : (y+1)*e.rowSize]
+		for x, v := range row {
+			var pred int32
+			switch {
+			case y == 0 && x < e.components:
+				pred = 1 << uint(e.precision-1)
+			case x < e.components:
+				pred = int32(e.pix[(y-1)*e.rowSize+x])
+			default:
+				pred = int32(row[x-e.components])
+			}
+			// the differences are modulo 2^16
+			diff := int32(int16(int32(v) - pred))
+			if diff == -32768 {
+				fn(16, diff)
+				continue
+			}
+			a := diff
+			if a < 0 {
+				a = -a
+			}
+			fn(uint(bits.Len32(uint32(a))), diff)
+		}
+	}
+}
+
+// codes returns the canonical Huffman codes of the table, indexed by the
+// difference length
+func (e *encoder) codes(counts [16]byte, values []byte) (codes [17]uint32, lengths [17]uint) {
+	code, k := uint32(0), 0
+	for l := 1; l <= 16; l++ {
+		for i := 0; i < int(counts[l-1]); i++ {
+			codes[values[k]], lengths[values[k]] = code, uint(l)
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return codes, lengths
+}
+
+// write appends the n low bits of v, stuffing a zero after each 0xff byte
+func (e *encoder) write(v uint32, n uint) {
+	e.acc = e.acc<<n | uint64(v)&(1<<n-1)
+	e.nbits += n
+	for e.nbits >= 8 {
+		e.nbits -= 8
+		b := byte(e.acc >> e.nbits)
+		e.out = append(e.out, b)
+		if b == 0xff {
+			e.out = append(e.out, 0)
+		}
+	}
+}
+
+// flush pads the last byte with ones
+func (e *encoder) flush() {
+	if e.nbits > 0 {
+		e.write(1<<(8-e.nbits)-1, 8-e.nbits)
+	}
+}
+
+// appendSegment appends the marker and the segment with its length
+func appendSegment(out []byte, marker uint16, payload []byte) []byte {
+	l := len(payload) + 2
+	out = append(out, byte(marker>>8), byte(marker), byte(l>>8), byte(l))
+	return append(out, payload...)
+}
+
+// huffLengths returns the number of codes of each length and the symbols
+// sorted by length of the Huffman table of the frequencies, with codes up to
+// 16 bits and none made only of ones (ITU T.81, K.2)
+func huffLengths(freq [17]int) (counts [16]byte, values []byte) {
+	// the last symbol is reserved, so that no code is all ones
+	var f [18]int
+	copy(f[:], freq[:])
+	f[17] = 1
+	var size [18]int
+	var others [18]int
+	for i := range others {
+		others[i] = -1
+	}
+	for {
+		// the two least frequent, the greatest symbol on ties
+		v1, v2 := -1, -1
+		for i := range f {
+			if f[i] > 0 && (v1 < 0 || f[i] <= f[v1]) {
+				v1 = i
+			}
+		}
+		for i := range f {
+			if f[i] > 0 && i != v1 && (v2 < 0 || f[i] <= f[v2]) {
+				v2 = i
+			}
+		}
+		if v2 < 0 {
+			break
+		}
+		f[v1] += f[v2]
+		f[v2] = 0
+		for size[v1]++; others[v1] >= 0; size[v1]++ {
+			v1 = others[v1]
+		}
+		others[v1] = v2
+		for size[v2]++; others[v2] >= 0; size[v2]++ {
+			v2 = others[v2]
+		}
+	}
+
+	var lengths [33]int
+	for _, s := range size {
+		if s > 0 {
+			lengths[s]++
+		}
+	}
+	// the codes longer than 16 bits are moved up the tree
+	for i := 32; i > 16; i-- {
+		for lengths[i] > 0 {
+			j := i - 2
+			for lengths[j] == 0 {
+				j--
+			}
+			lengths[i] -= 2
+			lengths[i-1]++
+			lengths[j+1] += 2
+			lengths[j]--
+		}
+	}
+	// the reserved code is the longest
+	i := 16
+	for lengths[i] == 0 {
+		i--
+	}
+	lengths[i]--
+	for l := 1; l <= 16; l++ {
+		counts[l-1] = byte(lengths[l])
+	}
+
+	for l := 1; l <= 32; l++ {
+		for s := 0; s < 17; s++ {
+			if size[s] == l {
+				values = append(values, byte(s))
+			}
+		}
+	}
+	return counts, values
+}
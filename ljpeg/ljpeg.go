@@ -0,0 +1,716 @@
+// Package ljpeg decodes lossless JPEG images (ITU T.81, process 14), as
+// found in CR2, DNG, Nikon and Pentax raw files
+package ljpeg
+
+import (
+	"fmt"
+
+	"github.com/enricod/rawmgr/common"
+)
+
+// format name used in the errors
+const format = "LJPEG"
+
+// JPEG markers
+const (
+	markerSOF3 = 0xffc3
+	markerDHT  = 0xffc4
+	markerRST0 = 0xffd0
+	markerRST7 = 0xffd7
+	markerSOI  = 0xffd8
+	markerEOI  = 0xffd9
+	markerSOS  = 0xffda
+	markerDRI  = 0xffdd
+)
+
+// Component frame component, from SOF3
+type Component struct {
+	Identifier               uint8
+	HorizontalSamplingFactor uint8
+	VerticalSamplingFactor   uint8
+}
+
+// Frame - start of frame header (SOF3)
+type Frame struct {
+	SamplePrecision  uint8
+	NrLines          uint16
+	NrSamplesPerLine uint16
+	Components       []Component
+}
+
+// ScanComponent component selector and table of the scan
+type ScanComponent struct {
+	Selector uint8
+	DCTable  uint8
+}
+
+// Scan - start of scan header (SOS)
+type Scan struct {
+	Components []ScanComponent
+	// Predictor selection value, 1-7
+	Predictor uint8
+	// PointTransform the samples are shifted right by PointTransform bits before coding
+	PointTransform uint8
+}
+
+// Decoder lossless JPEG decoder, NewDecoder parses the headers up to the first scan
+type Decoder struct {
+	Frame Frame
+	Scan  Scan
+	// RestartInterval number of MCUs between restart markers, 0 if not used
+	RestartInterval int
+	// CanonSRAW decodes the sampled frames as the Canon sRAW and mRAW, that
+	// predict the samples in a different way, see decodeSRawRows
+	CanonSRAW bool
+
+	data       []byte
+	huffTables [4]*common.HuffTable
+	// offset of the entropy coded data of the current scan
+	offset int
+	hmax   int
+	vmax   int
+}
+
+// Plane samples of a component
+type Plane struct {
+	Width  int
+	Height int
+	Pix    []uint16
+}
+
+// Image decoded lossless JPEG image, with a plane for each frame component
+type Image struct {
+	Width     int
+	Height    int
+	Precision int
+	Planes    []Plane
+}
+
+// NewDecoder parses the markers of the lossless JPEG starting at data[0]
+// (SOI) until the first scan header
+func NewDecoder(data []byte) (*Decoder, error) {
+	d := &Decoder{data: data}
+	marker, _, err := d.readMarker(0)
+	if err != nil {
+		return nil, err
+	}
+	if marker != markerSOI {
+		return nil, common.NewFormatError(format, 0, "SOI marker not valid %x", marker)
+	}
+	if err := d.parseSegments(2); err != nil {
+		return nil, err
+	}
+	if d.Frame.Components == nil {
+		return nil, common.NewFormatError(format, int64(d.offset), "SOF3 not found before the scan")
+	}
+	return d, nil
+}
+
+// Decode decodes a lossless JPEG image starting at data[0]
+func Decode(data []byte) (*Image, error) {
+	d, err := NewDecoder(data)
+	if err != nil {
+		return nil, err
+	}
+	return d.Decode()
+}
+
+// readMarker returns the marker at offset, skipping fill bytes
+func (d *Decoder) readMarker(offset int) (uint16, int, error) {
+	for offset+1 < len(d.data) && d.data[offset] == 0xff && d.data[offset+1] == 0xff {
+		offset++
+	}
+	if offset+1 >= len(d.data) {
+		return 0, offset, common.WrapFormatError(format, int64(offset), common.ErrTruncated, "marker expected")
+	}
+	marker, next := common.ReadUint16(d.data, int64(offset))
+	if marker>>8 != 0xff {
+		return 0, offset, common.NewFormatError(format, int64(offset), "marker expected, found %x", marker)
+	}
+	return marker, int(next), nil
+}
+
+// parseSegments reads the segments starting at offset, until a scan header
+func (d *Decoder) parseSegments(offset int) error {
+	for {
+		marker, next, err := d.readMarker(offset)
+		if err != nil {
+			return err
+		}
+		if marker == markerEOI {
+			return common.NewFormatError(format, int64(offset), "EOI found, no scan to decode")
+		}
+		if next+2 > len(d.data) {
+			return common.WrapFormatError(format, int64(offset), common.ErrTruncated, "segment %x", marker)
+		}
+		length, _ := common.ReadUint16(d.data, int64(next))
+		end := next + int(length)
+		if length < 2 || end > len(d.data) {
+			return common.NewFormatError(format, int64(offset), "segment %x, length %d not valid", marker, length)
+		}
+		segment := d.data[next+2 : end]
+
+		switch {
+		case marker == markerSOF3:
+			err = d.parseSOF3(segment)
+		case marker == markerDHT:
+			err = d.parseDHT(segment)
+		case marker == markerDRI:
+			if len(segment) < 2 {
+				err = fmt.Errorf("DRI too short")
+			} else {
+				d.RestartInterval = int(segment[0])<<8 | int(segment[1])
+			}
+		case marker == markerSOS:
+			if err = d.parseSOS(segment); err == nil {
+				d.offset = end
+				return nil
+			}
+		case marker >= 0xffc0 && marker <= 0xffcf && marker != 0xffc4 && marker != 0xffc8 && marker != 0xffcc:
+			err = fmt.Errorf("frame type %x not supported, only lossless (SOF3)", marker)
+		default:
+			// APPn, COM, DQT ... not needed
+		}
+		if err != nil {
+			return common.WrapFormatError(format, int64(offset), err, "segment %x", marker)
+		}
+		offset = end
+	}
+}
+
+func (d *Decoder) parseSOF3(segment []byte) error {
+	if len(segment) < 6 {
+		return fmt.Errorf("SOF3 too short")
+	}
+	f := Frame{
+		SamplePrecision:  segment[0],
+		NrLines:          uint16(segment[1])<<8 | uint16(segment[2]),
+		NrSamplesPerLine: uint16(segment[3])<<8 | uint16(segment[4]),
+	}
+	nrComponents := int(segment[5])
+	if f.SamplePrecision < 2 || f.SamplePrecision > 16 {
+		return fmt.Errorf("sample precision %d not valid", f.SamplePrecision)
+	}
+	if nrComponents == 0 || len(segment) < 6+3*nrComponents {
+		return fmt.Errorf("%d components not valid", nrComponents)
+	}
+	if f.NrLines == 0 || f.NrSamplesPerLine == 0 {
+		return fmt.Errorf("image size %dx%d not valid", f.NrSamplesPerLine, f.NrLines)
+	}
+	d.hmax, d.vmax = 1, 1
+	for i := 0; i < nrComponents; i++ {
+		samplingByte := segment[7+3*i]
+		c := Component{Identifier: segment[6+3*i],
+			HorizontalSamplingFactor: samplingByte >> 4,
+			VerticalSamplingFactor:   samplingByte & 0x0f,
+		}
+		if c.HorizontalSamplingFactor < 1 || c.HorizontalSamplingFactor > 4 ||
+			c.VerticalSamplingFactor < 1 || c.VerticalSamplingFactor > 4 {
+			return fmt.Errorf("component %d sampling factors %dx%d not valid", c.Identifier,
+				c.HorizontalSamplingFactor, c.VerticalSamplingFactor)
+		}
+		if int(c.HorizontalSamplingFactor) > d.hmax {
+			d.hmax = int(c.HorizontalSamplingFactor)
+		}
+		if int(c.VerticalSamplingFactor) > d.vmax {
+			d.vmax = int(c.VerticalSamplingFactor)
+		}
+		f.Components = append(f.Components, c)
+	}
+	d.Frame = f
+	return nil
+}
+
+// parseDHT builds every table of the segment, a later table with the same
+// index replaces the previous one
+func (d *Decoder) parseDHT(segment []byte) error {
+	offset := 0
+	for offset < len(segment) {
+		if offset+17 > len(segment) {
+			return fmt.Errorf("huffman table truncated")
+		}
+		class, index := segment[offset]>>4, segment[offset]&0x0f
+		if index > 3 {
+			return fmt.Errorf("huffman table index %d not valid", index)
+		}
+		totValues := 0
+		for _, n := range segment[offset+1 : offset+17] {
+			totValues += int(n)
+		}
+		if offset+17+totValues > len(segment) {
+			return fmt.Errorf("huffman table %d truncated", index)
+		}
+		huffItems, next := common.GetHuffItems(segment, int64(offset+1))
+		if class == 0 {
+			h, err := common.NewHuffTable(huffItems)
+			if err != nil {
+				return err
+			}
+			d.huffTables[index] = h
+		}
+		offset = int(next)
+	}
+	return nil
+}
+
+func (d *Decoder) parseSOS(segment []byte) error {
+	if len(segment) < 1 {
+		return fmt.Errorf("SOS too short")
+	}
+	nrComponents := int(segment[0])
+	if nrComponents == 0 || nrComponents > 4 || len(segment) < 4+2*nrComponents {
+		return fmt.Errorf("%d scan components not valid", nrComponents)
+	}
+	s := Scan{}
+	for i := 0; i < nrComponents; i++ {
+		c := ScanComponent{Selector: segment[1+2*i], DCTable: segment[2+2*i] >> 4}
+		if d.componentIndex(c.Selector) < 0 {
+			return fmt.Errorf("scan component %d not in frame", c.Selector)
+		}
+		if c.DCTable > 3 || d.huffTables[c.DCTable] == nil {
+			return fmt.Errorf("huffman table %d not defined", c.DCTable)
+		}
+		s.Components = append(s.Components, c)
+	}
+	s.Predictor = segment[1+2*nrComponents]
+	s.PointTransform = segment[3+2*nrComponents] & 0x0f
+	if s.Predictor < 1 || s.Predictor > 7 {
+		return fmt.Errorf("predictor %d not valid", s.Predictor)
+	}
+	if s.PointTransform >= d.Frame.SamplePrecision {
+		return fmt.Errorf("point transform %d not valid", s.PointTransform)
+	}
+	d.Scan = s
+	return nil
+}
+
+func (d *Decoder) componentIndex(selector uint8) int {
+	for i, c := range d.Frame.Components {
+		if c.Identifier == selector {
+			return i
+		}
+	}
+	return -1
+}
+
+// scanComponent state of a component while decoding a scan
+type scanComponent struct {
+	table *common.HuffTable
+	h, v  int
+	// lines of the component in the current MCU row, the plane width is padded to whole MCUs
+	lines [][]uint16
+	// last line of the previous MCU row, nil at the start of the scan and of each restart interval
+	prev []uint16
+}
+
+// McuRowSize number of samples passed to the DecodeRows callback: for every
+// MCU of the row, HorizontalSamplingFactor x VerticalSamplingFactor samples of
+// each component. With all sampling factors 1 it is a row of interleaved samples
+func (d *Decoder) McuRowSize() int {
+	size := 0
+	for _, c := range d.scanComponents() {
+		size += c.h * c.v
+	}
+	return size * d.mcusPerRow()
+}
+
+// McuRows number of MCU rows of the current scan
+func (d *Decoder) McuRows() int {
+	if len(d.Scan.Components) == 1 {
+		c := d.Frame.Components[d.componentIndex(d.Scan.Components[0].Selector)]
+		return ceilDiv(int(d.Frame.NrLines)*int(c.VerticalSamplingFactor), d.vmax)
+	}
+	return ceilDiv(int(d.Frame.NrLines), d.vmax)
+}
+
+func (d *Decoder) mcusPerRow() int {
+	if len(d.Scan.Components) == 1 {
+		// not interleaved, a MCU is a single sample
+		c := d.Frame.Components[d.componentIndex(d.Scan.Components[0].Selector)]
+		return ceilDiv(int(d.Frame.NrSamplesPerLine)*int(c.HorizontalSamplingFactor), d.hmax)
+	}
+	return ceilDiv(int(d.Frame.NrSamplesPerLine), d.hmax)
+}
+
+func (d *Decoder) scanComponents() []scanComponent {
+	result := make([]scanComponent, len(d.Scan.Components))
+	for i, sc := range d.Scan.Components {
+		c := d.Frame.Components[d.componentIndex(sc.Selector)]
+		result[i] = scanComponent{table: d.huffTables[sc.DCTable], h: 1, v: 1}
+		if len(d.Scan.Components) > 1 {
+			result[i].h = int(c.HorizontalSamplingFactor)
+			result[i].v = int(c.VerticalSamplingFactor)
+		}
+	}
+	return result
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
+
+// DecodeRows decodes the current scan, calling fn for every MCU row with the
+// samples in MCU order (see McuRowSize). The samples slice is reused by the
+// next call. Returns the first error returned by fn
+func (d *Decoder) DecodeRows(fn func(mcuRow int, samples []uint16) error) error {
+	comps := d.scanComponents()
+	mcusPerRow := d.mcusPerRow()
+	mcuRows := d.McuRows()
+	rowsPerInterval := mcuRows
+	if d.RestartInterval > 0 {
+		if d.RestartInterval%mcusPerRow != 0 {
+			return common.NewFormatError(format, int64(d.offset), "restart interval %d not multiple of the MCU row (%d)", d.RestartInterval, mcusPerRow)
+		}
+		rowsPerInterval = d.RestartInterval / mcusPerRow
+	}
+	simple := true
+	for _, c := range comps {
+		simple = simple && c.h == 1 && c.v == 1
+	}
+	if simple {
+		return d.decodeInterleavedRows(comps, mcusPerRow, mcuRows, rowsPerInterval, fn)
+	}
+	if d.CanonSRAW {
+		return d.decodeSRawRows(comps, mcusPerRow, mcuRows, rowsPerInterval, fn)
+	}
+
+	for i := range comps {
+		comps[i].lines = make([][]uint16, comps[i].v)
+		for v := range comps[i].lines {
+			comps[i].lines[v] = make([]uint16, mcusPerRow*comps[i].h)
+		}
+	}
+
+	precision := uint(d.Frame.SamplePrecision - d.Scan.PointTransform)
+	initialPred := int32(1) << (precision - 1)
+	pt := uint(d.Scan.PointTransform)
+	psv := d.Scan.Predictor
+	samples := make([]uint16, d.McuRowSize())
+
+	br := common.NewBitReader(d.data[d.offset:])
+	for mcuRow := 0; mcuRow < mcuRows; mcuRow++ {
+		if mcuRow > 0 && mcuRow%rowsPerInterval == 0 {
+			if m := br.Reset(); m < markerRST0 || m > markerRST7 {
+				return common.NewFormatError(format, int64(d.offset+br.Pos()), "restart marker expected at MCU row %d, found %x", mcuRow, m)
+			}
+			for i := range comps {
+				comps[i].prev = nil
+			}
+		}
+
+		out := 0
+		for mcu := 0; mcu < mcusPerRow; mcu++ {
+			for i := range comps {
+				c := &comps[i]
+				for v := 0; v < c.v; v++ {
+					line := c.lines[v]
+					above := c.prev
+					if v > 0 {
+						above = c.lines[v-1]
+					}
+					for h := 0; h < c.h; h++ {
+						x := mcu*c.h + h
+						diff, err := c.table.DecodeDiff(br)
+						if err != nil {
+							return common.WrapFormatError(format, int64(d.offset+br.Pos()), err, "MCU row %d, MCU %d", mcuRow, mcu)
+						}
+
+						var pred int32
+						switch {
+						case above == nil && x == 0:
+							pred = initialPred
+						case above == nil:
+							pred = int32(line[x-1])
+						case x == 0:
+							pred = int32(above[0])
+						default:
+							pred = predict(psv, int32(line[x-1]), int32(above[x]), int32(above[x-1]))
+						}
+						line[x] = uint16(pred + diff)
+						samples[out] = line[x] << pt
+						out++
+					}
+				}
+			}
+		}
+		for i := range comps {
+			c := &comps[i]
+			last := c.lines[c.v-1]
+			if c.prev == nil {
+				c.prev = make([]uint16, len(last))
+			}
+			// the oldest line is reused, prev keeps the last decoded one
+			c.lines[c.v-1], c.prev = c.prev, last
+		}
+		if err := br.Err(); err != nil {
+			return common.WrapFormatError(format, int64(d.offset+br.Pos()), err, "MCU row %d", mcuRow)
+		}
+		if err := fn(mcuRow, samples); err != nil {
+			return err
+		}
+	}
+	d.offset += br.Pos()
+	return nil
+}
+
+// decodeInterleavedRows is DecodeRows when all sampling factors are 1, as in
+// the CR2 and DNG files: the samples of a MCU row are a row of interleaved
+// components and every sample is predicted from the same component
+// ncomps samples on the left and on the row above
+func (d *Decoder) decodeInterleavedRows(comps []scanComponent, mcusPerRow, mcuRows, rowsPerInterval int, fn func(mcuRow int, samples []uint16) error) error {
+	ncomps := len(comps)
+	width := mcusPerRow * ncomps
+	line := make([]uint16, width)
+	var prev []uint16
+	samples := line
+	pt := uint(d.Scan.PointTransform)
+	if pt > 0 {
+		samples = make([]uint16, width)
+	}
+	tables := make([]*common.HuffTable, ncomps)
+	for i, c := range comps {
+		tables[i] = c.table
+	}
+	precision := uint(d.Frame.SamplePrecision - d.Scan.PointTransform)
+	psv := d.Scan.Predictor
+
+	br := common.NewBitReader(d.data[d.offset:])
+	for mcuRow := 0; mcuRow < mcuRows; mcuRow++ {
+		if mcuRow > 0 && mcuRow%rowsPerInterval == 0 {
+			if m := br.Reset(); m < markerRST0 || m > markerRST7 {
+				return common.NewFormatError(format, int64(d.offset+br.Pos()), "restart marker expected at MCU row %d, found %x", mcuRow, m)
+			}
+			prev = nil
+		}
+
+		for x := 0; x < width; x += ncomps {
+			for c, table := range tables {
+				diff, err := table.DecodeDiff(br)
+				if err != nil {
+					return common.WrapFormatError(format, int64(d.offset+br.Pos()), err, "MCU row %d, MCU %d", mcuRow, x/ncomps)
+				}
+
+				i := x + c
+				var pred int32
+				switch {
+				case x > 0 && (prev == nil || psv == 1):
+					pred = int32(line[i-ncomps])
+				case x > 0:
+					pred = predict(psv, int32(line[i-ncomps]), int32(prev[i]), int32(prev[i-ncomps]))
+				case prev == nil:
+					pred = int32(1) << (precision - 1)
+				default:
+					pred = int32(prev[i])
+				}
+				line[i] = uint16(pred + diff)
+			}
+		}
+		if err := br.Err(); err != nil {
+			return common.WrapFormatError(format, int64(d.offset+br.Pos()), err, "MCU row %d", mcuRow)
+		}
+		if pt > 0 {
+			for i, v := range line {
+				samples[i] = v << pt
+			}
+		}
+		if err := fn(mcuRow, samples); err != nil {
+			return err
+		}
+		if prev == nil {
+			prev = make([]uint16, width)
+		}
+		line, prev = prev, line
+		if pt == 0 {
+			samples = line
+		}
+	}
+	d.offset += br.Pos()
+	return nil
+}
+
+// decodeSRawRows is DecodeRows for the Canon sRAW and mRAW, that do not
+// follow the standard: the samples of the first component in a MCU are
+// predicted each from the one decoded before, the first of the row from the
+// first of the row above. The other components are predicted from the same
+// component in the MCU on the left, or above at the start of the row. Only
+// predictor 1 is used by the cameras
+func (d *Decoder) decodeSRawRows(comps []scanComponent, mcusPerRow, mcuRows, rowsPerInterval int, fn func(mcuRow int, samples []uint16) error) error {
+	if d.Scan.Predictor != 1 {
+		return common.NewFormatError(format, int64(d.offset), "predictor %d not supported for sRAW", d.Scan.Predictor)
+	}
+	// a table for each sample of the MCU, the first luma ones are chained
+	var tables []*common.HuffTable
+	for _, c := range comps {
+		for i := 0; i < c.h*c.v; i++ {
+			tables = append(tables, c.table)
+		}
+	}
+	luma := comps[0].h * comps[0].v
+	n := len(tables)
+	width := mcusPerRow * n
+	line := make([]uint16, width)
+	var prev []uint16
+	samples := line
+	pt := uint(d.Scan.PointTransform)
+	if pt > 0 {
+		samples = make([]uint16, width)
+	}
+	initialPred := int32(1) << uint(d.Frame.SamplePrecision-d.Scan.PointTransform-1)
+
+	br := common.NewBitReader(d.data[d.offset:])
+	for mcuRow := 0; mcuRow < mcuRows; mcuRow++ {
+		if mcuRow > 0 && mcuRow%rowsPerInterval == 0 {
+			if m := br.Reset(); m < markerRST0 || m > markerRST7 {
+				return common.NewFormatError(format, int64(d.offset+br.Pos()), "restart marker expected at MCU row %d, found %x", mcuRow, m)
+			}
+			prev = nil
+		}
+
+		for x := 0; x < width; x += n {
+			for k, table := range tables {
+				diff, err := table.DecodeDiff(br)
+				if err != nil {
+					return common.WrapFormatError(format, int64(d.offset+br.Pos()), err, "MCU row %d, MCU %d", mcuRow, x/n)
+				}
+
+				i := x + k
+				var pred int32
+				switch {
+				case k > 0 && k < luma:
+					pred = int32(line[i-1])
+				case k == 0 && x > 0:
+					pred = int32(line[i-n+luma-1])
+				case x > 0:
+					pred = int32(line[i-n])
+				case prev == nil:
+					pred = initialPred
+				default:
+					pred = int32(prev[i])
+				}
+				line[i] = uint16(pred + diff)
+			}
+		}
+		if err := br.Err(); err != nil {
+			return common.WrapFormatError(format, int64(d.offset+br.Pos()), err, "MCU row %d", mcuRow)
+		}
+		if pt > 0 {
+			for i, v := range line {
+				samples[i] = v << pt
+			}
+		}
+		if err := fn(mcuRow, samples); err != nil {
+			return err
+		}
+		if prev == nil {
+			prev = make([]uint16, width)
+		}
+		line, prev = prev, line
+		if pt == 0 {
+			samples = line
+		}
+	}
+	d.offset += br.Pos()
+	return nil
+}
+
+// predict computes the prediction from the sample on the left (ra), the one
+// above (rb) and the one above on the left (rc), ITU T.81 table H.1
+func predict(psv uint8, ra, rb, rc int32) int32 {
+	switch psv {
+	case 1:
+		return ra
+	case 2:
+		return rb
+	case 3:
+		return rc
+	case 4:
+		return ra + rb - rc
+	case 5:
+		return ra + ((rb - rc) >> 1)
+	case 6:
+		return rb + ((ra - rc) >> 1)
+	default:
+		return (ra + rb) >> 1
+	}
+}
+
+// Decode decodes every scan of the image, in planes
+func (d *Decoder) Decode() (*Image, error) {
+	img := &Image{
+		Width:     int(d.Frame.NrSamplesPerLine),
+		Height:    int(d.Frame.NrLines),
+		Precision: int(d.Frame.SamplePrecision),
+	}
+	for _, c := range d.Frame.Components {
+		w := ceilDiv(img.Width*int(c.HorizontalSamplingFactor), d.hmax)
+		h := ceilDiv(img.Height*int(c.VerticalSamplingFactor), d.vmax)
+		img.Planes = append(img.Planes, Plane{Width: w, Height: h, Pix: make([]uint16, w*h)})
+	}
+
+	for {
+		comps := d.scanComponents()
+		planes := make([]*Plane, len(comps))
+		for i, sc := range d.Scan.Components {
+			planes[i] = &img.Planes[d.componentIndex(sc.Selector)]
+		}
+		err := d.DecodeRows(func(mcuRow int, samples []uint16) error {
+			i := 0
+			for mcu := 0; i < len(samples); mcu++ {
+				for k, c := range comps {
+					p := planes[k]
+					for v := 0; v < c.v; v++ {
+						y := mcuRow*c.v + v
+						for h := 0; h < c.h; h++ {
+							x := mcu*c.h + h
+							if x < p.Width && y < p.Height {
+								p.Pix[y*p.Width+x] = samples[i]
+							}
+							i++
+						}
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		more, err := d.nextScan()
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			return img, nil
+		}
+	}
+}
+
+// nextScan looks for the marker after the entropy coded data of the current
+// scan and parses the headers of the next scan, if any
+func (d *Decoder) nextScan() (bool, error) {
+	offset := d.offset
+	for offset+1 < len(d.data) {
+		if d.data[offset] == 0xff {
+			m := d.data[offset+1]
+			if m != 0x00 && m != 0xff && (m < 0xd0 || m > 0xd7) {
+				break
+			}
+		}
+		offset++
+	}
+	if offset+1 >= len(d.data) {
+		// no EOI, nothing else to decode
+		return false, nil
+	}
+	marker, _, err := d.readMarker(offset)
+	if err != nil {
+		return false, err
+	}
+	if marker == markerEOI {
+		return false, nil
+	}
+	if err := d.parseSegments(offset); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,487 @@
+package ljpeg
+
+import (
+	"bytes"
+	"errors"
+	"math/bits"
+	"math/rand"
+	"testing"
+
+	"github.com/enricod/rawmgr/common"
+	"github.com/enricod/rawmgr/common/commontest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// code lengths of the test tables, symbols are the difference lengths 0..16
+var testTables = [][16]byte{
+	{0, 0, 0, 0, 17},
+	{0, 1, 5, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+}
+
+type testComponent struct {
+	h, v  int
+	table int
+}
+
+type testScan struct {
+	components []int
+	predictor  int
+	pt         int
+}
+
+type testImage struct {
+	width, height int
+	precision     int
+	components    []testComponent
+	scans         []testScan
+	restart       int
+	planes        []Plane
+}
+
+func newTestImage(width, height, precision int, components []testComponent, seed int64) *testImage {
+	img := &testImage{width: width, height: height, precision: precision, components: components}
+	hmax, vmax := 1, 1
+	for _, c := range components {
+		if c.h > hmax {
+			hmax = c.h
+		}
+		if c.v > vmax {
+			vmax = c.v
+		}
+	}
+	r := rand.New(rand.NewSource(seed))
+	for _, c := range components {
+		p := Plane{Width: ceilDiv(width*c.h, hmax), Height: ceilDiv(height*c.v, vmax)}
+		p.Pix = make([]uint16, p.Width*p.Height)
+		for i := range p.Pix {
+			// smooth gradient with some noise, as a photo
+			x, y := i%p.Width, i/p.Width
+			v := (x*37+y*11)%(1<<uint(precision)) + r.Intn(64)
+			p.Pix[i] = uint16(v % (1 << uint(precision)))
+		}
+		img.planes = append(img.planes, p)
+	}
+	return img
+}
+
+// huffCodes canonical codes of a table, indexed by symbol
+func huffCodes(counts [16]byte) (codes []uint32, lengths []uint) {
+	code := uint32(0)
+	for l := 1; l <= 16; l++ {
+		for i := 0; i < int(counts[l-1]); i++ {
+			codes = append(codes, code)
+			lengths = append(lengths, uint(l))
+			code++
+		}
+		code <<= 1
+	}
+	return codes, lengths
+}
+
+func segment(marker uint16, payload []byte) []byte {
+	l := len(payload) + 2
+	return append([]byte{byte(marker >> 8), byte(marker), byte(l >> 8), byte(l)}, payload...)
+}
+
+func dhtSegment(tables ...int) []byte {
+	var payload []byte
+	for _, t := range tables {
+		payload = append(payload, byte(t))
+		payload = append(payload, testTables[t][:]...)
+		for s := 0; s <= 16; s++ {
+			payload = append(payload, byte(s))
+		}
+	}
+	return segment(markerDHT, payload)
+}
+
+// encode writes img as lossless JPEG, mirroring the decoder layout
+func (img *testImage) encode() []byte {
+	out := []byte{0xff, 0xd8}
+	out = append(out, dhtSegment(0)...)
+
+	sof := []byte{byte(img.precision), byte(img.height >> 8), byte(img.height), byte(img.width >> 8), byte(img.width), byte(len(img.components))}
+	hmax, vmax := 1, 1
+	for i, c := range img.components {
+		sof = append(sof, byte(i+1), byte(c.h<<4|c.v), 0)
+		if c.h > hmax {
+			hmax = c.h
+		}
+		if c.v > vmax {
+			vmax = c.v
+		}
+	}
+	out = append(out, segment(markerSOF3, sof)...)
+	if img.restart > 0 {
+		out = append(out, segment(markerDRI, []byte{byte(img.restart >> 8), byte(img.restart)})...)
+	}
+
+	for si, scan := range img.scans {
+		// the second table is defined right before the scan using it
+		if si == len(img.scans)-1 {
+			out = append(out, dhtSegment(1)...)
+		}
+		sos := []byte{byte(len(scan.components))}
+		for _, ci := range scan.components {
+			sos = append(sos, byte(ci+1), byte(img.components[ci].table<<4))
+		}
+		sos = append(sos, byte(scan.predictor), 0, byte(scan.pt))
+		out = append(out, segment(markerSOS, sos)...)
+
+		type comp struct {
+			h, v   int
+			plane  Plane
+			width  int
+			codes  []uint32
+			length []uint
+		}
+		comps := []comp{}
+		for _, ci := range scan.components {
+			c := comp{h: 1, v: 1, plane: img.planes[ci]}
+			if len(scan.components) > 1 {
+				c.h, c.v = img.components[ci].h, img.components[ci].v
+			}
+			c.codes, c.length = huffCodes(testTables[img.components[ci].table])
+			comps = append(comps, c)
+		}
+		mcusPerRow := ceilDiv(img.width, hmax)
+		mcuRows := ceilDiv(img.height, vmax)
+		if len(comps) == 1 {
+			mcusPerRow, mcuRows = comps[0].plane.Width, comps[0].plane.Height
+		}
+		// padded planes of reduced samples, as seen by the decoder
+		padded := make([][]int32, len(comps))
+		for k := range comps {
+			c := &comps[k]
+			c.width = mcusPerRow * c.h
+			padded[k] = make([]int32, c.width*mcuRows*c.v)
+			for y := 0; y < mcuRows*c.v; y++ {
+				for x := 0; x < c.width; x++ {
+					sx, sy := x, y
+					if sx >= c.plane.Width {
+						sx = c.plane.Width - 1
+					}
+					if sy >= c.plane.Height {
+						sy = c.plane.Height - 1
+					}
+					padded[k][y*c.width+x] = int32(c.plane.Pix[sy*c.plane.Width+sx] >> uint(scan.pt))
+				}
+			}
+		}
+
+		w := &commontest.BitWriter{Stuff: true}
+		precision := uint(img.precision - scan.pt)
+		rowsPerInterval := mcuRows
+		if img.restart > 0 {
+			rowsPerInterval = img.restart / mcusPerRow
+		}
+		for mcuRow := 0; mcuRow < mcuRows; mcuRow++ {
+			firstRow := mcuRow % rowsPerInterval
+			if mcuRow > 0 && firstRow == 0 {
+				n := mcuRow/rowsPerInterval - 1
+				w.Append(0xff, byte(markerRST0+n%8))
+			}
+			for mcu := 0; mcu < mcusPerRow; mcu++ {
+				for k, c := range comps {
+					p := padded[k]
+					for v := 0; v < c.v; v++ {
+						for h := 0; h < c.h; h++ {
+							x, y := mcu*c.h+h, mcuRow*c.v+v
+							firstLine := firstRow == 0 && v == 0
+							var pred int32
+							switch {
+							case firstLine && x == 0:
+								pred = 1 << (precision - 1)
+							case firstLine:
+								pred = p[y*c.width+x-1]
+							case x == 0:
+								pred = p[(y-1)*c.width]
+							default:
+								pred = predict(uint8(scan.predictor), p[y*c.width+x-1], p[(y-1)*c.width+x], p[(y-1)*c.width+x-1])
+							}
+							diff := (p[y*c.width+x] - pred) & 0xffff
+							if diff > 32768 {
+								diff -= 65536
+							}
+							abs := diff
+							if abs < 0 {
+								abs = -abs
+							}
+							n := uint(bits.Len32(uint32(abs)))
+							w.Write(uint64(c.codes[n]), c.length[n])
+							if n < 16 {
+								if diff < 0 {
+									diff += 1<<n - 1
+								}
+								w.Write(uint64(diff), n)
+							}
+						}
+					}
+				}
+			}
+		}
+		out = append(out, w.Bytes()...)
+	}
+	return append(out, 0xff, 0xd9)
+}
+
+func assertPlanes(t *testing.T, img *testImage, decoded *Image) {
+	require.Equal(t, len(img.planes), len(decoded.Planes))
+	for i, p := range img.planes {
+		expected := make([]uint16, len(p.Pix))
+		pt := uint(img.scans[0].pt)
+		for j, v := range p.Pix {
+			expected[j] = v >> pt << pt
+		}
+		assert.Equal(t, p.Width, decoded.Planes[i].Width, "plane %d", i)
+		assert.Equal(t, p.Height, decoded.Planes[i].Height, "plane %d", i)
+		assert.Equal(t, expected, decoded.Planes[i].Pix, "plane %d", i)
+	}
+}
+
+func TestDecodePredictors(t *testing.T) {
+	for predictor := 1; predictor <= 7; predictor++ {
+		img := newTestImage(37, 11, 14, []testComponent{{1, 1, 0}, {1, 1, 1}}, int64(predictor))
+		img.scans = []testScan{{components: []int{0, 1}, predictor: predictor}}
+
+		decoded, err := Decode(img.encode())
+		require.Nil(t, err, "predictor %d", predictor)
+		assert.Equal(t, 37, decoded.Width)
+		assert.Equal(t, 11, decoded.Height)
+		assert.Equal(t, 14, decoded.Precision)
+		assertPlanes(t, img, decoded)
+	}
+}
+
+func TestDecodePointTransform(t *testing.T) {
+	img := newTestImage(20, 9, 16, []testComponent{{1, 1, 1}}, 7)
+	img.scans = []testScan{{components: []int{0}, predictor: 6, pt: 3}}
+
+	decoded, err := Decode(img.encode())
+	require.Nil(t, err)
+	assertPlanes(t, img, decoded)
+}
+
+func TestDecodeRestartInterval(t *testing.T) {
+	img := newTestImage(16, 21, 12, []testComponent{{1, 1, 0}, {1, 1, 0}, {1, 1, 1}}, 3)
+	img.scans = []testScan{{components: []int{0, 1, 2}, predictor: 4}}
+	img.restart = 2 * 16
+
+	decoded, err := Decode(img.encode())
+	require.Nil(t, err)
+	assertPlanes(t, img, decoded)
+
+	d, err := NewDecoder(img.encode())
+	require.Nil(t, err)
+	assert.Equal(t, 32, d.RestartInterval)
+
+	// restart interval must cover whole MCU rows
+	img.restart = 24
+	_, err = Decode(img.encode())
+	assert.NotNil(t, err)
+}
+
+func TestDecodeSamplingFactors(t *testing.T) {
+	img := newTestImage(15, 7, 15, []testComponent{{2, 2, 0}, {1, 1, 1}, {1, 1, 1}}, 11)
+	img.scans = []testScan{{components: []int{0, 1, 2}, predictor: 7}}
+
+	decoded, err := Decode(img.encode())
+	require.Nil(t, err)
+	assert.Equal(t, 15, decoded.Planes[0].Width)
+	assert.Equal(t, 8, decoded.Planes[1].Width)
+	assert.Equal(t, 4, decoded.Planes[1].Height)
+	assertPlanes(t, img, decoded)
+}
+
+func TestDecodeRowsMcuOrder(t *testing.T) {
+	img := newTestImage(8, 2, 14, []testComponent{{2, 1, 0}, {1, 1, 0}, {1, 1, 0}}, 5)
+	img.scans = []testScan{{components: []int{0, 1, 2}, predictor: 1}}
+
+	d, err := NewDecoder(img.encode())
+	require.Nil(t, err)
+	assert.Equal(t, 16, d.McuRowSize())
+	assert.Equal(t, 2, d.McuRows())
+
+	rows := 0
+	err = d.DecodeRows(func(mcuRow int, samples []uint16) error {
+		y := img.planes[0].Pix[mcuRow*8:]
+		cb := img.planes[1].Pix[mcuRow*4:]
+		for mcu := 0; mcu < 4; mcu++ {
+			// Y Y Cb Cr
+			assert.Equal(t, y[2*mcu], samples[4*mcu])
+			assert.Equal(t, y[2*mcu+1], samples[4*mcu+1])
+			assert.Equal(t, cb[mcu], samples[4*mcu+2])
+		}
+		rows++
+		return nil
+	})
+	require.Nil(t, err)
+	assert.Equal(t, 2, rows)
+}
+
+// encodeSRaw encodes the MCU rows of a 2x2 sampled frame predicting the
+// samples as the Canon sRAW, with table 0
+func encodeSRaw(rows [][]uint16, width, height, precision int) []byte {
+	const n, luma = 6, 4
+	out := []byte{0xff, 0xd8}
+	out = append(out, dhtSegment(0)...)
+	out = append(out, segment(markerSOF3, []byte{byte(precision), byte(height >> 8), byte(height), byte(width >> 8), byte(width), 3,
+		1, 0x22, 0, 2, 0x11, 0, 3, 0x11, 0})...)
+	out = append(out, segment(markerSOS, []byte{3, 1, 0, 2, 0, 3, 0, 1, 0, 0})...)
+
+	codes, lengths := huffCodes(testTables[0])
+	w := &commontest.BitWriter{Stuff: true}
+	for r, line := range rows {
+		for i, s := range line {
+			k := i % n
+			var pred int32
+			switch {
+			case k > 0 && k < luma:
+				pred = int32(line[i-1])
+			case k == 0 && i > 0:
+				pred = int32(line[i-n+luma-1])
+			case i >= n:
+				pred = int32(line[i-n])
+			case r == 0:
+				pred = 1 << uint(precision-1)
+			default:
+				pred = int32(rows[r-1][i])
+			}
+			diff := int32(s) - pred
+			abs := diff
+			if abs < 0 {
+				abs = -abs
+			}
+			l := uint(bits.Len32(uint32(abs)))
+			w.Write(uint64(codes[l]), lengths[l])
+			if diff < 0 {
+				diff += 1<<l - 1
+			}
+			w.Write(uint64(diff), l)
+		}
+	}
+	return append(append(out, w.Bytes()...), 0xff, 0xd9)
+}
+
+func TestDecodeCanonSRAW(t *testing.T) {
+	r := rand.New(rand.NewSource(17))
+	// 4 MCUs of Y Y Y Y Cb Cr for each row
+	rows := make([][]uint16, 3)
+	for i := range rows {
+		rows[i] = make([]uint16, 24)
+		for j := range rows[i] {
+			rows[i][j] = uint16(8000 + r.Intn(4000))
+		}
+	}
+	data := encodeSRaw(rows, 8, 6, 15)
+
+	d, err := NewDecoder(data)
+	require.Nil(t, err)
+	d.CanonSRAW = true
+	assert.Equal(t, 24, d.McuRowSize())
+	assert.Equal(t, 3, d.McuRows())
+	err = d.DecodeRows(func(mcuRow int, samples []uint16) error {
+		assert.Equal(t, rows[mcuRow], samples, "row %d", mcuRow)
+		return nil
+	})
+	require.Nil(t, err)
+
+	// the standard prediction gives different samples
+	d, err = NewDecoder(data)
+	require.Nil(t, err)
+	decoded, err := d.Decode()
+	require.Nil(t, err)
+	assert.NotEqual(t, rows[0][2], decoded.Planes[0].Pix[8])
+}
+
+func TestDecodeMultipleScans(t *testing.T) {
+	img := newTestImage(13, 6, 8, []testComponent{{1, 1, 0}, {2, 1, 0}, {1, 1, 1}}, 13)
+	img.scans = []testScan{
+		{components: []int{0}, predictor: 2},
+		{components: []int{1}, predictor: 5},
+		{components: []int{2}, predictor: 3},
+	}
+
+	decoded, err := Decode(img.encode())
+	require.Nil(t, err)
+	assertPlanes(t, img, decoded)
+}
+
+func TestDecodeErrors(t *testing.T) {
+	img := newTestImage(24, 24, 14, []testComponent{{1, 1, 0}, {1, 1, 1}}, 17)
+	img.scans = []testScan{{components: []int{0, 1}, predictor: 1}}
+	data := img.encode()
+
+	_, err := Decode(data[:len(data)/2])
+	assert.NotNil(t, err, "truncated scan")
+	assert.True(t, errors.Is(err, common.ErrBitStreamExhausted), "truncated scan")
+
+	_, err = Decode(data[:40])
+	assert.NotNil(t, err, "truncated headers")
+	var formatErr *common.FormatError
+	assert.True(t, errors.As(err, &formatErr), "truncated headers")
+	assert.Equal(t, "LJPEG", formatErr.Format)
+
+	_, err = Decode(data[2:])
+	assert.NotNil(t, err, "SOI missing")
+
+	img.scans[0].predictor = 8
+	_, err = Decode(img.encode())
+	assert.NotNil(t, err, "predictor not valid")
+}
+
+func TestEncode(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	for _, tc := range []struct{ width, height, components, precision int }{
+		{37, 11, 2, 14},
+		{16, 5, 1, 12},
+		{9, 7, 3, 16},
+	} {
+		img := newTestImage(tc.width*tc.components, tc.height, tc.precision, []testComponent{{1, 1, 0}}, 5)
+		pix := img.planes[0].Pix
+		if tc.precision == 16 {
+			// a difference of 32768 in the second component
+			pix[1], pix[4] = 0, 0x8000
+		}
+		var buf bytes.Buffer
+		require.Nil(Encode(&buf, pix, tc.width, tc.height, tc.components, tc.precision))
+
+		decoded, err := Decode(buf.Bytes())
+		require.Nil(err, "%+v", tc)
+		assert.Equal(tc.width, decoded.Width)
+		assert.Equal(tc.precision, decoded.Precision)
+		require.Len(decoded.Planes, tc.components)
+		for c, p := range decoded.Planes {
+			for i, v := range p.Pix {
+				require.Equal(pix[i*tc.components+c], v, "%+v component %d sample %d", tc, c, i)
+			}
+		}
+	}
+
+	// a flat image has a single difference length
+	var buf bytes.Buffer
+	require.Nil(Encode(&buf, make([]uint16, 64), 8, 8, 1, 8))
+	decoded, err := Decode(buf.Bytes())
+	require.Nil(err)
+	assert.Equal(make([]uint16, 64), decoded.Planes[0].Pix)
+
+	assert.NotNil(Encode(&buf, make([]uint16, 10), 8, 8, 1, 8))
+	assert.NotNil(Encode(&buf, make([]uint16, 64), 8, 8, 1, 17))
+}
+
+func BenchmarkDecodeRows(b *testing.B) {
+	img := newTestImage(2000, 200, 14, []testComponent{{1, 1, 0}, {1, 1, 1}}, 1)
+	img.scans = []testScan{{components: []int{0, 1}, predictor: 1}}
+	data := img.encode()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		d, _ := NewDecoder(data)
+		d.DecodeRows(func(mcuRow int, samples []uint16) error {
+			return nil
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -8,78 +9,132 @@ import (
 
 	"log"
 	"os"
-	"strings"
 
 	"github.com/enricod/rawmgr/canon"
 	"github.com/enricod/rawmgr/common"
+	"github.com/enricod/rawmgr/demosaic"
 	"github.com/enricod/rawmgr/fuji"
+	"github.com/enricod/rawmgr/raw"
 )
 
 type imageInfo struct {
 	make string
+	// raf header of the Fuji files
+	raf *fuji.RAFHeader
 }
 
-func xtransInterpolate(passes int) {
-	fuji.XtransInterpolate(passes)
+// saveDemosaiced writes the image of the file demosaiced with method, as a
+// PPM of the samples R G B of each pixel
+func saveDemosaiced(data []byte, rawfile string, method demosaic.Method) error {
+	img, err := raw.Decode(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	if img, err = demosaic.Demosaic(img.Crop(), method); err != nil {
+		return err
+	}
+	if *common.Verbose {
+		log.Printf("demosaiced %dx%d with %v", img.Width, img.Height, method)
+	}
+	f, err := os.Create(rawfile + ".ppm")
+	if err != nil {
+		return err
+	}
+	if err := common.EncodeRawPNM(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
-func fujiXtransRead(inputFile *os.File) {
-
+// saveRaw writes the raw data of the file, cropped to the image, as a PGM
+func saveRaw(data []byte, rawfile string) error {
+	img, err := raw.Decode(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(rawfile + ".pgm")
+	if err != nil {
+		return err
+	}
+	if err := common.EncodeRawPNM(f, img.Crop()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
 // identify identifies the file maker
-func identify(inputFile *os.File) imageInfo {
+func identify(inputFile *os.File) (imageInfo, error) {
 
 	var start int64
 	var hlen uint32
 	var order uint16
 
-	order, start = common.GetUint16(inputFile, 0)
-	hlen, start = common.GetUint32(inputFile, start)
+	result := imageInfo{make: "UNDEF"}
+	order, start, err := common.GetUint16(inputFile, 0)
+	if err != nil {
+		return result, err
+	}
+	hlen, start, err = common.GetUint32(inputFile, start)
+	if err != nil {
+		return result, err
+	}
 
 	if *common.Verbose {
 		fmt.Printf("order=%d, hlen=%d, start=%d\n", order, hlen, start)
 	}
-	result := imageInfo{make: "UNDEF"}
 	head := make([]byte, 32)
-	_, err := inputFile.ReadAt(head, 0)
-	check(err)
+	if _, err := inputFile.ReadAt(head, 0); err != nil {
+		return result, err
+	}
 	//fmt.Printf("%d bytes : %s\n", n1, string(head))
-	var tiffIfsArray []common.TiffIfd
-	tiffIfsArray = make([]common.TiffIfd, 0)
 
-	if strings.HasPrefix(string(head), "FUJIFILM") {
+	switch raw.Format(head) {
+	case "RAF":
 		result.make = "FUJIFILM"
-		var thumbOffset, thumbLength uint32
-		var start int64
-		start = 84
-		thumbOffset, start = common.GetUint32(inputFile, start)
-		thumbLength, start = common.GetUint32(inputFile, start)
+		if result.raf, err = fuji.ParseFuji(inputFile); err != nil {
+			return result, err
+		}
 		if *common.Verbose {
-			log.Printf("thumbOffset=%d, thumbLength=%d\n", thumbOffset, thumbLength)
+			log.Printf("RAF header %+v", result.raf)
 		}
-		startParse, _ := common.GetUint32(inputFile, 92)
-		fuji.ParseFuji(inputFile, int64(startParse))
-
-		dataOffset, _ := common.GetUint32(inputFile, 100)
 
-		common.ParseTiff(inputFile, int64(dataOffset), tiffIfsArray)
-
-	} else if order == 0x4949 || order == 0x4d4d {
+	case "CR2":
+		result.make = "CANON"
 
 		data, err := ioutil.ReadFile(inputFile.Name())
-		check(err)
-		canon.ProcessCR2(data, "PPP")
+		if err != nil {
+			return result, err
+		}
+		if _, err := canon.Decode(bytes.NewReader(data), int64(len(data))); err != nil {
+			return result, err
+		}
 
-	}
+	case "CR3":
+		result.make = "CANON"
 
-	return result
-}
+		info, err := inputFile.Stat()
+		if err != nil {
+			return result, err
+		}
+		if err := canon.ProcessCR3(inputFile, info.Size(), inputFile.Name()); err != nil {
+			return result, err
+		}
+
+	case "CRW":
+		result.make = "CANON"
 
-func check(e error) {
-	if e != nil {
-		panic(e)
+		info, err := inputFile.Stat()
+		if err != nil {
+			return result, err
+		}
+		if err := canon.ProcessCRW(inputFile, info.Size(), inputFile.Name()); err != nil {
+			return result, err
+		}
 	}
+
+	return result, nil
 }
 
 func main() {
@@ -90,6 +145,18 @@ func main() {
 		fmt.Printf("input file not specified \n")
 		return
 	}
+	if argsWithoutProg[0] == "develop" {
+		if err := developCommand(argsWithoutProg[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if argsWithoutProg[0] == "convert" {
+		if err := convertCommand(argsWithoutProg[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	//defaultFileName := "images/Canon/Canon_001.CR2"
 	rawfile := argsWithoutProg[len(argsWithoutProg)-1]
@@ -97,6 +164,8 @@ func main() {
 	var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 	common.ShowInfo = flag.Bool("i", false, "show image info")
 	common.ExtractJpegs = flag.Bool("j", false, "extract jpegs")
+	common.Workers = flag.Int("workers", 0, "number of goroutines used in decoding, 0 for one for each CPU")
+	quality := flag.Int("q", -1, "demosaic quality: 0 bilinear, 1 VNG, 2 PPG, 3 AHD")
 
 	flag.Parse()
 	log.Println("reading file " + rawfile)
@@ -112,26 +181,43 @@ func main() {
 
 	/*
 		inputFile, err := os.Open(*rawfile)
-		check(err)
+		if err != nil {
+			log.Fatal(err)
+		}
 
 		imageInfo := identify(inputFile)
 
 		log.Printf("Make: %s\n", imageInfo.make)
 	*/
 	data, err := ioutil.ReadFile(rawfile)
-	check(err)
-
-	canon.ProcessCR2(data, rawfile)
-	/*
-			outputFile, err := os.Create(strings.Replace(rawfile, ".CR2", ".png", 1))
-			if err != nil {
-				// Handle error
-			}
-
-			png.Encode(outputFile, imageRGBA)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-		// Don't forget to close files
-		outputFile.Close()
-	*/
+	switch raw.Format(data) {
+	case "CR3":
+		if err := canon.ProcessCR3(bytes.NewReader(data), int64(len(data)), rawfile); err != nil {
+			log.Fatal(err)
+		}
+	case "CRW":
+		if err := canon.ProcessCRW(bytes.NewReader(data), int64(len(data)), rawfile); err != nil {
+			log.Fatal(err)
+		}
+	case "CR2":
+		if err := canon.ProcessCR2(data, rawfile); err != nil {
+			log.Fatal(err)
+		}
+	case "RAF":
+		if err := saveRaw(data, rawfile); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatal(raw.ErrUnknownFormat)
+	}
 
+	if *quality >= 0 {
+		if err := saveDemosaiced(data, rawfile, demosaic.Method(*quality)); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
@@ -0,0 +1,122 @@
+// Package raw decodes the raw files of the supported cameras, choosing the
+// decoder from the file header
+package raw
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+
+	"github.com/enricod/rawmgr/canon"
+	"github.com/enricod/rawmgr/common"
+	"github.com/enricod/rawmgr/fuji"
+)
+
+// ErrUnknownFormat the file is not of a supported format
+var ErrUnknownFormat = errors.New("raw: unknown format")
+
+// Format returns the format of the raw file from its first bytes: "CR2",
+// "CR3", "CRW", "RAF" or "" if unknown
+func Format(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("FUJIFILM")):
+		return "RAF"
+	case canon.IsCR3(head):
+		return "CR3"
+	case canon.IsCRW(head):
+		return "CRW"
+	case len(head) >= 10 && (bytes.HasPrefix(head, []byte("II*\x00")) || bytes.HasPrefix(head, []byte("MM\x00*"))) &&
+		string(head[8:10]) == "CR":
+		return "CR2"
+	}
+	return ""
+}
+
+// readFormat returns the format of the file in r
+func readFormat(r io.ReaderAt) (string, error) {
+	head := make([]byte, 16)
+	n, err := r.ReadAt(head, 0)
+	if n < len(head) && err != nil && err != io.EOF {
+		return "", err
+	}
+	return Format(head[:n]), nil
+}
+
+// Decode reads the raw image of the file in r, size bytes long
+func Decode(r io.ReaderAt, size int64) (*common.RawImage, error) {
+	f, err := readFormat(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch f {
+	case "CR2":
+		return canon.Decode(r, size)
+	case "CR3":
+		return canon.DecodeCR3(r, size)
+	case "CRW":
+		return canon.DecodeCRW(r, size)
+	case "RAF":
+		return fuji.Decode(r, size)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// DecodeConfig returns the size of the raw image of the file in r, without
+// decoding it
+func DecodeConfig(r io.ReaderAt, size int64) (image.Config, error) {
+	f, err := readFormat(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	switch f {
+	case "CR2":
+		return canon.DecodeConfig(r, size)
+	case "CR3":
+		return canon.DecodeCR3Config(r, size)
+	case "CRW":
+		return canon.DecodeCRWConfig(r, size)
+	case "RAF":
+		return fuji.DecodeConfig(r, size)
+	}
+	return image.Config{}, ErrUnknownFormat
+}
+
+// ReadMetadata reads the EXIF of the file in r, without decoding the raw data
+func ReadMetadata(r io.ReaderAt) (common.Metadata, error) {
+	f, err := readFormat(r)
+	if err != nil {
+		return common.Metadata{}, err
+	}
+
+	switch f {
+	case "CR2":
+		return canon.ReadMetadata(r)
+	case "CR3":
+		return canon.ReadCR3Metadata(r)
+	case "CRW":
+		return canon.ReadCRWMetadata(r)
+	case "RAF":
+		return fuji.ReadMetadata(r)
+	}
+	return common.Metadata{}, ErrUnknownFormat
+}
+
+// ReadExif reads the EXIF IFD of the file in r, with the maker note. Only
+// the CR2 and RAF files are supported
+func ReadExif(r io.ReaderAt) (*common.Exif, error) {
+	f, err := readFormat(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch f {
+	case "CR2":
+		return canon.ReadExif(r)
+	case "RAF":
+		return fuji.ReadExif(r)
+	}
+	return nil, ErrUnknownFormat
+}
@@ -0,0 +1,23 @@
+package raw
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("CR2", Format([]byte("II*\x00\x10\x00\x00\x00CR\x02\x00")))
+	assert.Equal("RAF", Format([]byte("FUJIFILMCCD-RAW 0201")))
+	assert.Equal("CR3", Format([]byte("\x00\x00\x00\x18ftypcrx \x00\x00\x00\x01")))
+	assert.Equal("CRW", Format([]byte("II\x1a\x00\x00\x00HEAPCCDR")))
+	assert.Equal("", Format([]byte("II*\x00\x10\x00\x00\x00")))
+	assert.Equal("", Format(nil))
+
+	data := []byte("not a raw file")
+	_, err := Decode(bytes.NewReader(data), int64(len(data)))
+	assert.Equal(ErrUnknownFormat, err)
+}